@@ -0,0 +1,21 @@
+package api
+
+import "time"
+
+// AccountDeletionConfirmation is the token that confirms an account deletion
+type AccountDeletionConfirmation struct {
+	ConfirmationToken string    `json:"confirmationToken"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+// AccountDeletionRequest is the body of POST /api/account/deletion. Accounts with a
+// password must send it; accounts that only sign in through OIDC must have signed in
+// within the last few minutes instead.
+type AccountDeletionRequest struct {
+	Password string `json:"password,omitempty"`
+}
+
+// DeleteAccountRequest is the body of DELETE /api/account
+type DeleteAccountRequest struct {
+	ConfirmationToken string `json:"confirmationToken" binding:"required"`
+}
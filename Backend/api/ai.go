@@ -0,0 +1,37 @@
+package api
+
+// UPDATED: Added Filename field for better language context
+type GenerateRequest struct {
+	Prompt   string `json:"prompt"`
+	Language string `json:"language,omitempty"`
+	Filename string `json:"filename,omitempty"`
+
+	// Mode selects generate (default), explain, review or tests; the last three require LogID
+	Mode  string `json:"mode,omitempty"`
+	LogID string `json:"logId,omitempty"`
+
+	// SpaceID attributes generate-mode usage to a space for accounting and budgets
+	SpaceID string `json:"spaceId,omitempty"`
+
+	// Agentic mode runs the generated code and asks the provider to repair failures
+	Agentic    bool `json:"agentic,omitempty"`
+	MaxRepairs int  `json:"maxRepairs,omitempty"`
+}
+
+type GenerateResponse struct {
+	Code     string              `json:"code"`
+	Provider string              `json:"provider"` // "openai" or "gemini"
+	Passed   *bool               `json:"passed,omitempty"`
+	Attempts []GenerationAttempt `json:"attempts,omitempty"`
+	Stopped  string              `json:"stopped,omitempty"` // Why agentic mode stopped repairing early, e.g. a quota
+}
+
+// GenerationAttempt records one generate-and-run iteration in agentic mode
+type GenerationAttempt struct {
+	Code     string `json:"code"`
+	Provider string `json:"provider"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"` // Set when the code couldn't be run, which ends the loop
+}
@@ -0,0 +1,39 @@
+package api
+
+// AI modes supported by /api/ai/generate
+const (
+	ModeGenerate = "generate"
+	ModeExplain  = "explain"
+	ModeReview   = "review"
+	ModeTests    = "tests"
+)
+
+// LineRange is an inclusive 1-based range of lines in a log
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type ExplainResponse struct {
+	Explanation string      `json:"explanation"`
+	References  []LineRange `json:"references"`
+	Provider    string      `json:"provider"`
+}
+
+// ReviewFinding is a single issue reported by a code review
+type ReviewFinding struct {
+	Severity   string    `json:"severity"` // "critical", "major", "minor" or "info"
+	Lines      LineRange `json:"lines"`
+	Message    string    `json:"message"`
+	Suggestion string    `json:"suggestion,omitempty"`
+}
+
+type ReviewResponse struct {
+	Findings []ReviewFinding `json:"findings"`
+	Provider string          `json:"provider"`
+}
+
+type TestsResponse struct {
+	Log      Log    `json:"log"`
+	Provider string `json:"provider"`
+}
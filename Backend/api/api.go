@@ -0,0 +1,37 @@
+// Package api defines the request and response bodies of the HTTP API. The
+// server and the Go client both use it, so it depends on nothing but the
+// models and a few standard-library-only packages.
+package api
+
+import (
+	"codeflow-backend/internal/collab"
+	"codeflow-backend/internal/graphql"
+	"codeflow-backend/internal/models"
+)
+
+// Resources as stored and returned by the API
+type (
+	User            = models.User
+	APIToken        = models.APIToken
+	Space           = models.Space
+	SpaceMember     = models.SpaceMember
+	SpaceInvitation = models.SpaceInvitation
+	Vault           = models.Vault
+	Log             = models.Log
+	Secret          = models.Secret
+	ShareLink       = models.ShareLink
+	ChatSession     = models.ChatSession
+	ChatMessage     = models.ChatMessage
+	AuditEntry      = models.AuditEntry
+	Webhook         = models.Webhook
+	WebhookDelivery = models.WebhookDelivery
+)
+
+// TextOperation is an ot.js text operation, as sent in CollabRequest and CollabEvent
+type TextOperation = collab.Operation
+
+// GraphQL request and error bodies for POST /api/graphql
+type (
+	GraphQLRequest = graphql.Request
+	GraphQLError   = graphql.Error
+)
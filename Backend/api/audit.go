@@ -0,0 +1,7 @@
+package api
+
+// AuditPage is a page of audit entries; nextCursor is the before= value for the next page
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"nextCursor"`
+}
@@ -0,0 +1,23 @@
+package api
+
+import "time"
+
+// RegisterRequest is the body of POST /api/auth/register
+type RegisterRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	Name     string `json:"name,omitempty"`
+}
+
+// LoginRequest is the body of POST /api/auth/login
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// SessionResponse is returned when a session starts. The token is also set as a cookie.
+type SessionResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	User      User      `json:"user"`
+}
@@ -0,0 +1,35 @@
+package api
+
+// BatchRequest is the body of POST /api/batch
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations" binding:"required"`
+}
+
+// BatchResponse lists the results of a batch that applied in full
+type BatchResponse struct {
+	Atomic  bool          `json:"atomic"` // Whether the batch ran in a transaction
+	Results []BatchResult `json:"results"`
+}
+
+// BatchOperation is one step of a batch. Any ID may be "$N" to refer to the
+// vault or log created by operation N earlier in the same batch.
+type BatchOperation struct {
+	Op        string  `json:"op"`                  // create, update, move or delete
+	Type      string  `json:"type"`                // vault or log
+	ID        string  `json:"id,omitempty"`        // Target of update, move and delete
+	SpaceID   string  `json:"spaceId,omitempty"`   // Space of a new top-level vault
+	VaultID   string  `json:"vaultId,omitempty"`   // Vault of a new log, or where a log moves to
+	ParentID  *string `json:"parentId,omitempty"`  // Parent of a new vault, or where a vault moves to ("" for the top level)
+	Name      *string `json:"name,omitempty"`      // New name for create and update
+	Language  *string `json:"language,omitempty"`  // Log language for create and update
+	Code      *string `json:"code,omitempty"`      // Log code for create and update
+	IfVersion *int64  `json:"ifVersion,omitempty"` // Fail with 412 unless the target is at this version, like If-Match
+}
+
+// BatchResult is the outcome of one operation
+type BatchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"` // The status the operation would have had as its own request
+	ID     string      `json:"id"`
+	Data   interface{} `json:"data,omitempty"` // The vault or log after the operation; omitted for deletes
+}
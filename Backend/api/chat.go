@@ -0,0 +1,13 @@
+package api
+
+// CreateChatSessionRequest is the body of POST /api/ai/sessions
+type CreateChatSessionRequest struct {
+	SpaceID string `json:"spaceId,omitempty"`
+	LogID   string `json:"logId,omitempty"`
+	Title   string `json:"title,omitempty"`
+}
+
+// ChatMessageRequest is the body of POST /api/ai/sessions/:id/messages
+type ChatMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
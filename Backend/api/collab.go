@@ -0,0 +1,42 @@
+package api
+
+// CollabRequest is a message from a client editing a log
+type CollabRequest struct {
+	Type      string         `json:"type"`
+	SessionID string         `json:"sessionId,omitempty"`
+	Revision  int            `json:"revision"`
+	OpID      string         `json:"opId,omitempty"`
+	Op        *TextOperation `json:"op,omitempty"`
+	Cursor    *CollabCursor  `json:"cursor,omitempty"`
+}
+
+// CollabEvent is a message to a client editing a log
+type CollabEvent struct {
+	Type      string         `json:"type"`
+	SessionID string         `json:"sessionId,omitempty"`
+	Revision  int            `json:"revision"`
+	ClientID  string         `json:"clientId,omitempty"` // The peer it concerns; in init and resume, the receiving client
+	OpID      string         `json:"opId,omitempty"`
+	Op        *TextOperation `json:"op,omitempty"`
+	Code      *string        `json:"code,omitempty"`
+	Version   int64          `json:"version,omitempty"` // The log's version in the database
+	Peers     []CollabPeer   `json:"peers,omitempty"`
+	Peer      *CollabPeer    `json:"peer,omitempty"`
+	Cursor    *CollabCursor  `json:"cursor,omitempty"`
+	Error     *Error         `json:"error,omitempty"`
+}
+
+// CollabPeer is someone connected to a document
+type CollabPeer struct {
+	ClientID string        `json:"clientId"`
+	UserID   string        `json:"userId"`
+	Name     string        `json:"name"`
+	CanEdit  bool          `json:"canEdit"`
+	Cursor   *CollabCursor `json:"cursor,omitempty"`
+}
+
+// CollabCursor is a caret and the other end of its selection, in UTF-16 offsets
+type CollabCursor struct {
+	Position     int `json:"position"`
+	SelectionEnd int `json:"selectionEnd"`
+}
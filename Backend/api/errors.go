@@ -0,0 +1,97 @@
+package api
+
+import "net/http"
+
+// ErrorCode is a machine-readable error code
+type ErrorCode string
+
+// The error catalogue. Each code has a default HTTP status, shown alongside.
+const (
+	InvalidRequest       ErrorCode = "INVALID_REQUEST"        // 400 malformed body, query or parameter
+	InvalidID            ErrorCode = "INVALID_ID"             // 400 a path or query ID is not a valid ObjectID
+	Unauthorized         ErrorCode = "UNAUTHORIZED"           // 401 missing, invalid or expired credentials
+	Forbidden            ErrorCode = "FORBIDDEN"              // 403 authenticated but not allowed
+	InsufficientScope    ErrorCode = "INSUFFICIENT_SCOPE"     // 403 access token lacks the required scope
+	NotFound             ErrorCode = "NOT_FOUND"              // 404 resource or route doesn't exist, or isn't visible to the caller
+	MethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"     // 405 route exists for other methods
+	Conflict             ErrorCode = "CONFLICT"               // 409 duplicate name or conflicting state
+	PreconditionFailed   ErrorCode = "PRECONDITION_FAILED"    // 412 If-Match is stale; details holds the current version
+	PayloadTooLarge      ErrorCode = "PAYLOAD_TOO_LARGE"      // 413 the request body is over the endpoint's limit
+	UnsupportedMediaType ErrorCode = "UNSUPPORTED_MEDIA_TYPE" // 415 the Content-Type isn't accepted
+	PreconditionRequired ErrorCode = "PRECONDITION_REQUIRED"  // 428 the request only makes sense with If-Match
+	QuotaExceeded        ErrorCode = "QUOTA_EXCEEDED"         // 403 storage quota, or 429 daily quota; details holds the quota
+	RateLimited          ErrorCode = "RATE_LIMITED"           // 429 too many requests, see Retry-After
+	AIBudgetExceeded     ErrorCode = "AI_BUDGET_EXCEEDED"     // 429 monthly AI spend limit reached
+	APIKeyInvalid        ErrorCode = "API_KEY_INVALID"        // 400 no AI provider key is configured
+	ProviderUnavailable  ErrorCode = "PROVIDER_UNAVAILABLE"   // 400 every AI provider failed
+	Internal             ErrorCode = "INTERNAL_ERROR"         // 500 unexpected server or database failure
+	UpstreamError        ErrorCode = "UPSTREAM_ERROR"         // 502 a dependency such as the code runner failed
+	NotConfigured        ErrorCode = "NOT_CONFIGURED"         // 503 the feature needs server configuration
+)
+
+var defaultStatus = map[ErrorCode]int{
+	InvalidRequest:       http.StatusBadRequest,
+	InvalidID:            http.StatusBadRequest,
+	Unauthorized:         http.StatusUnauthorized,
+	Forbidden:            http.StatusForbidden,
+	InsufficientScope:    http.StatusForbidden,
+	NotFound:             http.StatusNotFound,
+	MethodNotAllowed:     http.StatusMethodNotAllowed,
+	Conflict:             http.StatusConflict,
+	PreconditionFailed:   http.StatusPreconditionFailed,
+	PayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	UnsupportedMediaType: http.StatusUnsupportedMediaType,
+	PreconditionRequired: http.StatusPreconditionRequired,
+	QuotaExceeded:        http.StatusForbidden,
+	RateLimited:          http.StatusTooManyRequests,
+	AIBudgetExceeded:     http.StatusTooManyRequests,
+	APIKeyInvalid:        http.StatusBadRequest,
+	ProviderUnavailable:  http.StatusBadRequest,
+	Internal:             http.StatusInternalServerError,
+	UpstreamError:        http.StatusBadGateway,
+	NotConfigured:        http.StatusServiceUnavailable,
+}
+
+// Status returns the code's default HTTP status
+func (c ErrorCode) Status() int {
+	if status, ok := defaultStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is the error envelope returned by every endpoint:
+//
+//	{"status": 404, "code": "NOT_FOUND", "message": "Space not found", "details": ..., "requestId": "..."}
+//
+// Status repeats the HTTP status, Code is the field clients should branch on,
+// Message is for people, Details is optional structured context and RequestID
+// matches the X-Request-ID response header.
+type Error struct {
+	Status    int         `json:"status"`
+	Code      ErrorCode   `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// NewError returns an error with the code's default status
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Status: code.Status(), Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithStatus overrides the HTTP status
+func (e *Error) WithStatus(status int) *Error {
+	e.Status = status
+	return e
+}
+
+// WithDetails attaches structured context
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
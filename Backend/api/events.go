@@ -0,0 +1,47 @@
+package api
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event types
+const (
+	SpaceUpdated = "space.updated"
+	SpaceDeleted = "space.deleted" // The last event of a space's feed
+	VaultCreated = "vault.created"
+	VaultUpdated = "vault.updated"
+	VaultMoved   = "vault.moved"
+	VaultDeleted = "vault.deleted"
+	LogCreated   = "log.created"
+	LogUpdated   = "log.updated"
+	LogMoved     = "log.moved"
+	LogDeleted   = "log.deleted"
+	RunCompleted = "run.completed" // Only reported by the instance that ran the code
+)
+
+// Event is a change to a space or something in it. Renaming or moving a vault
+// also updates the paths of everything nested under it, and deleting one
+// deletes its contents; those changes have events of their own. Clients should
+// treat events for IDs they don't know, or at versions they already have, as
+// no-ops.
+type Event struct {
+	Type    string             `json:"type"`
+	SpaceID primitive.ObjectID `json:"spaceId"`
+	ID      primitive.ObjectID `json:"id"` // The space, vault or log that changed
+	Version int64              `json:"version,omitempty"`
+	Space   *Space             `json:"space,omitempty"` // After the change; omitted for deletes
+	Vault   *Vault             `json:"vault,omitempty"`
+	Log     *Log               `json:"log,omitempty"` // Without its code; fetch the log if the version is newer than yours
+	Run     *RunSummary        `json:"run,omitempty"`
+	At      time.Time          `json:"at"`
+}
+
+// RunSummary summarises a finished code run
+type RunSummary struct {
+	LogID    *primitive.ObjectID `json:"logId,omitempty"`
+	UserID   string              `json:"userId,omitempty"` // Empty for runs through a share link
+	Language string              `json:"language"`
+	ExitCode int                 `json:"exitCode"`
+}
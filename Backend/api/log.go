@@ -0,0 +1,24 @@
+package api
+
+// CreateLogRequest is the body of POST /api/logs
+type CreateLogRequest struct {
+	SpaceID  string `json:"spaceId" binding:"required"`
+	VaultID  string `json:"vaultId" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Code     string `json:"code"`
+	Language string `json:"language,omitempty"` // Inferred from the name when empty
+}
+
+// UpdateLogRequest is the body of PUT /api/logs/:id. Empty fields are left
+// unchanged; use PATCH to clear a log's code.
+type UpdateLogRequest struct {
+	Name string `json:"name,omitempty"`
+	Code string `json:"code,omitempty"`
+}
+
+// LogChanges is a partial update of a log; nil fields are left unchanged
+type LogChanges struct {
+	Name     *string `json:"name,omitempty"`
+	Language *string `json:"language,omitempty"`
+	Code     *string `json:"code,omitempty"`
+}
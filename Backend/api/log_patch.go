@@ -0,0 +1,25 @@
+package api
+
+import "encoding/json"
+
+// TextEdit replaces the code between Start and End with Text. Offsets count
+// UTF-16 code units, the same as JavaScript string indices in the editor.
+type TextEdit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// LogPatch is a merge-style partial update. Present fields are applied even when
+// empty, and null is refused; edits are small range replacements applied to the current code.
+type LogPatch struct {
+	LogChanges
+	Edits []TextEdit `json:"edits,omitempty"`
+}
+
+// JSONPatchOp is one RFC 6902 operation
+type JSONPatchOp struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
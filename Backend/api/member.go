@@ -0,0 +1,36 @@
+package api
+
+import "time"
+
+// SpaceMemberView is a member of a space with their account details
+type SpaceMemberView struct {
+	UserID    string    `json:"userId"`
+	Name      string    `json:"name,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UpdateSpaceMemberRequest is the body of PUT /api/spaces/:id/members/:userId
+type UpdateSpaceMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// CreateSpaceInvitationRequest is the body of POST /api/spaces/:id/invitations
+type CreateSpaceInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// CreatedInvitation is returned once when an invitation is created; the token
+// isn't stored and must reach the invitee for them to accept
+type CreatedInvitation struct {
+	Token      string          `json:"token"`
+	Invitation SpaceInvitation `json:"invitation"`
+}
+
+// InvitationTokenRequest is the body of POST /api/invitations/lookup and
+// /api/invitations/:id/accept and /decline
+type InvitationTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
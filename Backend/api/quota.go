@@ -0,0 +1,24 @@
+package api
+
+import "time"
+
+// QuotaUsage is one limit and the current usage against it
+type QuotaUsage struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"` // "user", "space" or "log"
+	Limit int    `json:"limit"` // 0 means unlimited
+	Used  int    `json:"used"`
+}
+
+// Exceeded reports whether adding n more would go over the limit
+func (q QuotaUsage) Exceeded(n int) bool {
+	return q.Limit > 0 && q.Used+n > q.Limit
+}
+
+// QuotaReport is the usage against every limit, as returned by GET /api/quotas
+type QuotaReport struct {
+	User     []QuotaUsage `json:"user"`
+	Log      QuotaUsage   `json:"log"`
+	Space    []QuotaUsage `json:"space,omitempty"` // With ?spaceId=
+	ResetsAt time.Time    `json:"resetsAt"`        // When the daily quotas reset
+}
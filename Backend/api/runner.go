@@ -0,0 +1,17 @@
+package api
+
+type RunRequest struct {
+	Language string   `json:"language" binding:"required"`
+	Code     string   `json:"code" binding:"required"`
+	LogID    string   `json:"logId,omitempty"`   // Log being run; requires the editor role in its space
+	SpaceID  string   `json:"spaceId,omitempty"` // Space to take secrets from when no log is given
+	Secrets  []string `json:"secrets,omitempty"` // Names of space secrets to inject as environment variables
+}
+
+// RunResult is the output of running code; code is the exit code
+type RunResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Code   int    `json:"code"`
+	Output string `json:"output"`
+}
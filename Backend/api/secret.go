@@ -0,0 +1,12 @@
+package api
+
+// CreateSecretRequest is the body of POST /api/spaces/:id/secrets
+type CreateSecretRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Value string `json:"value" binding:"required"`
+}
+
+// UpdateSecretRequest is the body of PUT /api/spaces/:id/secrets/:name
+type UpdateSecretRequest struct {
+	Value string `json:"value" binding:"required"`
+}
@@ -0,0 +1,35 @@
+package api
+
+import "time"
+
+// SharedLog is the read-only view of a log served through a share link
+type SharedLog struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Language  string    `json:"language"`
+	Code      string    `json:"code"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateShareLinkRequest is the body of POST /api/shares
+type CreateShareLinkRequest struct {
+	TargetType     string `json:"targetType" binding:"required,oneof=log vault"`
+	TargetID       string `json:"targetId" binding:"required"`
+	ExpiresInHours int    `json:"expiresInHours,omitempty"` // 0 means the link never expires
+	AllowRun       bool   `json:"allowRun,omitempty"`
+}
+
+// SharedContent is what a share link points to: a log, or a vault's subtree
+type SharedContent struct {
+	TargetType string     `json:"targetType"`
+	AllowRun   bool       `json:"allowRun"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	Log        *SharedLog `json:"log,omitempty"`  // For log shares
+	Tree       *TreeNode  `json:"tree,omitempty"` // For vault shares
+}
+
+// RunSharedLogRequest is the body of POST /api/public/shares/:token/run
+type RunSharedLogRequest struct {
+	LogID string `json:"logId,omitempty"` // Required for vault shares
+}
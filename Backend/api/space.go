@@ -0,0 +1,6 @@
+package api
+
+// SpaceRequest is the body for creating or renaming a space
+type SpaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
@@ -0,0 +1,14 @@
+package api
+
+// CreateAPITokenRequest is the body of POST /api/tokens
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expiresInDays,omitempty"` // 0 means the token never expires
+}
+
+// CreatedAPIToken is a new token with its plaintext, which is never shown again
+type CreatedAPIToken struct {
+	Token    string   `json:"token"`
+	APIToken APIToken `json:"apiToken"`
+}
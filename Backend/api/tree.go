@@ -0,0 +1,10 @@
+package api
+
+type TreeNode struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Type     string     `json:"type"` // "space", "vault", or "log"
+	Language string     `json:"language,omitempty"`
+	Path     string     `json:"path"`
+	Children []TreeNode `json:"children,omitempty"`
+}
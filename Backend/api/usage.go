@@ -0,0 +1,29 @@
+package api
+
+// UsageTotals is one row of an AI usage summary
+type UsageTotals struct {
+	Key              string  `bson:"_id" json:"key"`
+	Calls            int     `bson:"calls" json:"calls"`
+	Failures         int     `bson:"failures" json:"failures"`
+	PromptTokens     int     `bson:"promptTokens" json:"promptTokens"`
+	CompletionTokens int     `bson:"completionTokens" json:"completionTokens"`
+	TotalTokens      int     `bson:"totalTokens" json:"totalTokens"`
+	CostUSD          float64 `bson:"costUsd" json:"costUsd"`
+	AvgLatencyMs     float64 `bson:"avgLatencyMs" json:"avgLatencyMs"`
+}
+
+// BudgetStatus compares current monthly usage against a configured budget
+type BudgetStatus struct {
+	Tokens      int     `json:"tokens"`
+	CostUSD     float64 `json:"costUsd"`
+	TokenBudget int     `json:"tokenBudget,omitempty"` // 0 means unlimited
+	CostBudget  float64 `json:"costBudget,omitempty"`  // 0 means unlimited
+	Exceeded    bool    `json:"exceeded"`
+}
+
+// BudgetReport is this month's AI usage against the user's and a space's budgets
+type BudgetReport struct {
+	Month string        `json:"month"` // YYYY-MM
+	User  BudgetStatus  `json:"user"`
+	Space *BudgetStatus `json:"space,omitempty"` // With ?spaceId=
+}
@@ -0,0 +1,13 @@
+package api
+
+// CreateVaultRequest is the body of POST /api/vaults
+type CreateVaultRequest struct {
+	SpaceID  string  `json:"spaceId" binding:"required"`
+	Name     string  `json:"name" binding:"required"`
+	ParentID *string `json:"parentId,omitempty"`
+}
+
+// UpdateVaultRequest is the body of PUT /api/vaults/:id
+type UpdateVaultRequest struct {
+	Name string `json:"name" binding:"required"`
+}
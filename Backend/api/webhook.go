@@ -0,0 +1,22 @@
+package api
+
+// WebhookRequest is the body of POST /api/spaces/:id/webhooks and
+// PUT /api/spaces/:id/webhooks/:webhookId
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events" binding:"required,min=1"` // Event types, or "*" for all
+	Active *bool    `json:"active,omitempty"`                // Defaults to true on create, unchanged on update
+	Secret string   `json:"secret,omitempty"`                // Generated on create if empty; replaced on update if set
+}
+
+// CreatedWebhook is a new webhook with its signing secret, which is never shown again
+type CreatedWebhook struct {
+	Secret  string  `json:"secret"`
+	Webhook Webhook `json:"webhook"`
+}
+
+// WebhookDeliveryPage is a page of deliveries; nextCursor is the before= value for the next page
+type WebhookDeliveryPage struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	NextCursor string            `json:"nextCursor"`
+}
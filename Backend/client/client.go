@@ -0,0 +1,177 @@
+// Package client is a typed Go client for the API. It has one method per
+// operation in the OpenAPI document served at /api/openapi.json, named after the
+// operation ID, and uses the request and response types in package api that the
+// handlers use, without depending on the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+)
+
+// Client calls the API as the owner of Token
+type Client struct {
+	BaseURL    string       // e.g. http://localhost:8080
+	Token      string       // Personal access token or session token, sent as a bearer token
+	HTTPClient *http.Client // http.DefaultClient when nil
+}
+
+// New returns a client for the API at baseURL
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token}
+}
+
+// ListOptions are the paging, sorting and filtering options of list endpoints
+type ListOptions struct {
+	Limit        int
+	Cursor       string // NextCursor of the previous page
+	Sort         string // name, createdAt or updatedAt; prefix with - for descending
+	NamePrefix   string
+	UpdatedSince time.Time // Zero for no filter
+}
+
+// Page is one page of a list; NextCursor is empty on the last page
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+func (o ListOptions) values() url.Values {
+	q := url.Values{}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	setIf(q, "cursor", o.Cursor)
+	setIf(q, "sort", o.Sort)
+	setIf(q, "namePrefix", o.NamePrefix)
+	if !o.UpdatedSince.IsZero() {
+		q.Set("updatedSince", o.UpdatedSince.Format(time.RFC3339Nano))
+	}
+	return q
+}
+
+// request is one API call
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        interface{}
+	contentType string // application/json when empty
+	ifMatch     int64  // Sent as If-Match when positive
+}
+
+// do sends req and decodes a JSON response into out. Error responses are
+// returned as *api.Error.
+func (c *Client) do(ctx context.Context, req request, out interface{}) (*http.Response, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("decoding %s %s response: %w", req.method, req.path, err)
+		}
+	}
+	return resp, nil
+}
+
+// send sends req and returns the response for the caller to read and close
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	target := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var body io.Reader
+	if req.body != nil {
+		encoded, err := json.Marshal(req.body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if req.body != nil {
+		contentType := req.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if req.ifMatch > 0 {
+		httpReq.Header.Set("If-Match", `"`+strconv.FormatInt(req.ifMatch, 10)+`"`)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		apiErr := &api.Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Code == "" {
+			apiErr.Code = api.Internal
+			apiErr.Message = resp.Status
+		}
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = resp.Header.Get("X-Request-ID")
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// list fetches one page of a list endpoint
+func list[T any](ctx context.Context, c *Client, path string, query url.Values, opts ListOptions) (Page[T], error) {
+	for key, values := range opts.values() {
+		query[key] = values
+	}
+
+	var page Page[T]
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: path, query: query}, &page.Items)
+	if err != nil {
+		return page, err
+	}
+	page.NextCursor = resp.Header.Get("X-Next-Cursor")
+	return page, nil
+}
+
+// IsCode reports whether err is an API error with the given code
+func IsCode(err error, code api.ErrorCode) bool {
+	apiErr, ok := err.(*api.Error)
+	return ok && apiErr.Code == code
+}
+
+func setIf(q url.Values, key, value string) {
+	if value != "" {
+		q.Set(key, value)
+	}
+}
+
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
@@ -0,0 +1,303 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db/dbtest"
+	"codeflow-backend/internal/handler"
+	"codeflow-backend/internal/ratelimit"
+	"codeflow-backend/internal/server"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	os.Setenv("JWT_SECRET", "client-test-secret")
+	auth.Init()
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// fakeAPI serves handle and returns a client for it
+func fakeAPI(t *testing.T, handle http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handle)
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", "secret-token")
+}
+
+// serveAPI runs the real API against an empty database
+func serveAPI(t *testing.T) string {
+	t.Helper()
+	dbtest.Setup(t)
+	ratelimit.Default = ratelimit.NewMemoryStore()
+	engine, err := server.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestMethodsCoverOperations(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handler.GetOpenAPI(c)
+
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The health check has no operation ID, and the OIDC redirects are for browsers
+	skipped := map[string]bool{"": true, "OIDCLogin": true, "OIDCCallback": true}
+	client := reflect.TypeOf(&Client{})
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			if skipped[op.OperationID] {
+				continue
+			}
+			if _, ok := client.MethodByName(op.OperationID); !ok {
+				t.Errorf("%s %s: no Client.%s", strings.ToUpper(method), path, op.OperationID)
+			}
+		}
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	out, err := exec.Command("go", "list", "-deps", ".").Output()
+	if err != nil {
+		t.Skipf("go list: %v", err)
+	}
+	for _, dep := range strings.Fields(string(out)) {
+		for _, server := range []string{"codeflow-backend/internal/handler", "github.com/gin-gonic/gin", "go.mongodb.org/mongo-driver/mongo"} {
+			if dep == server {
+				t.Errorf("the client depends on %s", dep)
+			}
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header string // X-Request-ID
+		body   string
+		want   api.Error
+	}{
+		{"envelope", 412, "", `{"status": 412, "code": "PRECONDITION_FAILED", "message": "Log has changed", "requestId": "abc", "details": {"version": 3}}`,
+			api.Error{Status: 412, Code: api.PreconditionFailed, Message: "Log has changed", RequestID: "abc"}},
+		{"request ID from the header", 404, "def", `{"code": "NOT_FOUND", "message": "Log not found"}`,
+			api.Error{Status: 404, Code: api.NotFound, Message: "Log not found", RequestID: "def"}},
+		{"not JSON", 502, "ghi", "<html>Bad gateway</html>",
+			api.Error{Status: 502, Code: api.Internal, Message: "502 Bad Gateway", RequestID: "ghi"}},
+		{"JSON without a code", 500, "", `{"error": "boom"}`,
+			api.Error{Status: 500, Code: api.Internal, Message: "500 Internal Server Error"}},
+	}
+	for _, tt := range tests {
+		c := fakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			if tt.header != "" {
+				w.Header().Set("X-Request-ID", tt.header)
+			}
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		})
+
+		_, err := c.GetLog(testContext(t), "x")
+		apiErr, ok := err.(*api.Error)
+		if !ok {
+			t.Errorf("%s: error = %v, want an *api.Error", tt.name, err)
+			continue
+		}
+		apiErr.Details = nil
+		if *apiErr != tt.want {
+			t.Errorf("%s: error = %+v, want %+v", tt.name, *apiErr, tt.want)
+		}
+		if !IsCode(err, tt.want.Code) {
+			t.Errorf("%s: IsCode(%s) = false", tt.name, tt.want.Code)
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	var got *http.Request
+	var body string
+	c := fakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, body = r, string(data)
+		w.Header().Set("X-Next-Cursor", "next")
+		io.WriteString(w, "[]")
+	})
+	ctx := testContext(t)
+
+	c.PatchLogWithJSONPatch(ctx, "a/b", []api.JSONPatchOp{{Op: "test", Path: "/name"}}, 7)
+	if got.URL.EscapedPath() != "/api/logs/a%2Fb" || got.Method != http.MethodPatch {
+		t.Errorf("request = %s %s", got.Method, got.URL.EscapedPath())
+	}
+	if got.Header.Get("If-Match") != `"7"` || got.Header.Get("Content-Type") != "application/json-patch+json" || got.Header.Get("Authorization") != "Bearer secret-token" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	if body != `[{"op":"test","path":"/name"}]` {
+		t.Errorf("body = %s", body)
+	}
+
+	c.DeleteLog(ctx, "x", 0)
+	if got.Header.Get("If-Match") != "" || got.Header.Get("Content-Type") != "" {
+		t.Errorf("headers without a version or body = %v", got.Header)
+	}
+
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	page, err := c.GetLogs(ctx, LogFilter{VaultID: "v", OmitCode: true}, ListOptions{Limit: 2, Cursor: "c", Sort: "-name", UpdatedSince: since})
+	if err != nil || page.NextCursor != "next" {
+		t.Errorf("page = %+v, %v", page, err)
+	}
+	if want := "cursor=c&limit=2&omitCode=true&sort=-name&updatedSince=2024-01-02T03%3A04%3A05Z&vaultId=v"; got.URL.RawQuery != want {
+		t.Errorf("query = %s, want %s", got.URL.RawQuery, want)
+	}
+}
+
+func TestClientAgainstAPI(t *testing.T) {
+	baseURL := serveAPI(t)
+	ctx := testContext(t)
+
+	session, err := New(baseURL, "").Register(ctx, api.RegisterRequest{Email: "ada@example.com", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(baseURL, session.Token)
+
+	space, err := c.CreateSpace(ctx, "Space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := c.UpdateSpace(ctx, space.ID.Hex(), "Renamed", space.Version)
+	if err != nil || renamed.Name != "Renamed" {
+		t.Fatalf("UpdateSpace = %+v, %v", renamed, err)
+	}
+	if _, err := c.UpdateSpace(ctx, space.ID.Hex(), "Stale", space.Version); !IsCode(err, api.PreconditionFailed) {
+		t.Errorf("UpdateSpace with a stale version: %v, want PRECONDITION_FAILED", err)
+	}
+
+	vault, err := c.CreateVault(ctx, api.CreateVaultRequest{SpaceID: space.ID.Hex(), Name: "src"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.py", "b.py", "c.py"} {
+		if _, err := c.CreateLog(ctx, api.CreateLogRequest{SpaceID: space.ID.Hex(), VaultID: vault.ID.Hex(), Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	opts := ListOptions{Limit: 2, Sort: "name"}
+	for {
+		page, err := c.GetLogs(ctx, LogFilter{VaultID: vault.ID.Hex()}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, log := range page.Items {
+			names = append(names, log.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if strings.Join(names, ",") != "a.py,b.py,c.py" {
+		t.Errorf("pages = %v", names)
+	}
+
+	var data struct {
+		Space struct{ Name, Role string }
+	}
+	if err := c.GraphQL(ctx, "query($id: ID!) { space(id: $id) { name role } }", map[string]interface{}{"id": space.ID.Hex()}, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Space.Name != "Renamed" || data.Space.Role != "owner" {
+		t.Errorf("GraphQL space = %+v", data.Space)
+	}
+	if err := c.GraphQL(ctx, "{ nope }", nil, nil); err == nil {
+		t.Error("GraphQL returned no error for an unknown field")
+	}
+
+	if err := c.DeleteSpace(ctx, space.ID.Hex(), renamed.Version); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSpace(ctx, space.ID.Hex()); !IsCode(err, api.NotFound) {
+		t.Errorf("GetSpace after deleting: %v, want NOT_FOUND", err)
+	}
+}
+
+func TestStreamSpaceEvents(t *testing.T) {
+	baseURL := serveAPI(t)
+	ctx := testContext(t)
+
+	session, err := New(baseURL, "").Register(ctx, api.RegisterRequest{Email: "ada@example.com", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(baseURL, session.Token)
+	space, err := c.CreateSpace(ctx, "Space")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan api.Event, 1)
+	streamCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StreamSpaceEvents(streamCtx, space.ID.Hex(), func(event api.Event) error {
+			received <- event
+			return nil
+		})
+	}()
+
+	// Rename until the feed is connected and sees the change
+	var event api.Event
+	for event.Type == "" {
+		if _, err := c.UpdateSpace(ctx, space.ID.Hex(), "Renamed", 0); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case event = <-received:
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("no event before the deadline")
+		}
+	}
+	if event.SpaceID != space.ID {
+		t.Errorf("event = %+v", event)
+	}
+
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("StreamSpaceEvents after cancelling = %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"codeflow-backend/api"
+
+	"golang.org/x/net/websocket"
+)
+
+// CollabConn is a connection for editing a log together with others. Send a
+// join first, then operations and cursor moves; Receive returns the server's
+// events in order. Keeping client state, transforming incoming operations
+// against unacknowledged ones, is up to the caller.
+type CollabConn struct {
+	ws *websocket.Conn
+}
+
+// CollaborateOnLog connects to a log's collaborative editing session
+func (c *Client) CollaborateOnLog(ctx context.Context, logID string) (*CollabConn, error) {
+	ws, err := c.dial(ctx, "/api/logs/"+escape(logID)+"/collab")
+	if err != nil {
+		return nil, fmt.Errorf("opening collaborative session: %w", err)
+	}
+	return &CollabConn{ws: ws}, nil
+}
+
+// Send sends a message to the session
+func (c *CollabConn) Send(request api.CollabRequest) error {
+	return websocket.JSON.Send(c.ws, request)
+}
+
+// Receive waits for the next message from the session
+func (c *CollabConn) Receive() (api.CollabEvent, error) {
+	var event api.CollabEvent
+	err := websocket.JSON.Receive(c.ws, &event)
+	return event, err
+}
+
+// Close leaves the session
+func (c *CollabConn) Close() error {
+	return c.ws.Close()
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"codeflow-backend/api"
+
+	"golang.org/x/net/websocket"
+)
+
+// StreamSpaceEvents watches a space, calling handle for each change until ctx is
+// done, handle returns an error, or the server ends the feed. A nil error means
+// the server closed the feed; reconnect and reload to pick up where it left off.
+func (c *Client) StreamSpaceEvents(ctx context.Context, spaceID string, handle func(api.Event) error) error {
+	ws, err := c.dial(ctx, "/api/spaces/"+escape(spaceID)+"/events")
+	if err != nil {
+		return fmt.Errorf("opening event feed: %w", err)
+	}
+	defer ws.Close()
+
+	// Unblock the receive below when the caller gives up
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	for {
+		var event api.Event
+		if err := websocket.JSON.Receive(ws, &event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+}
+
+// dial opens a WebSocket to an API path
+func (c *Client) dial(ctx context.Context, path string) (*websocket.Conn, error) {
+	location := "ws" + strings.TrimPrefix(c.BaseURL, "http") + path
+	config, err := websocket.NewConfig(location, c.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		config.Header = http.Header{"Authorization": {"Bearer " + c.Token}}
+	}
+	return config.DialContext(ctx)
+}
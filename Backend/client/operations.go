@@ -0,0 +1,566 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"codeflow-backend/api"
+)
+
+// Methods that take ifVersion send it as If-Match when it is positive, so the
+// call fails with PRECONDITION_FAILED instead of overwriting a newer version.
+// The browser-only OIDC redirects have no methods.
+
+// GetOpenAPI fetches the OpenAPI document
+func (c *Client) GetOpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var doc json.RawMessage
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/openapi.json"}, &doc)
+	return doc, err
+}
+
+// Accounts
+
+func (c *Client) Register(ctx context.Context, req api.RegisterRequest) (api.SessionResponse, error) {
+	var out api.SessionResponse
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/auth/register", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) Login(ctx context.Context, req api.LoginRequest) (api.SessionResponse, error) {
+	var out api.SessionResponse
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/auth/login", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/auth/logout"}, nil)
+	return err
+}
+
+func (c *Client) GetCurrentUser(ctx context.Context) (api.User, error) {
+	var out api.User
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/auth/me"}, &out)
+	return out, err
+}
+
+func (c *Client) GetAPITokens(ctx context.Context) ([]api.APIToken, error) {
+	var out []api.APIToken
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/tokens"}, &out)
+	return out, err
+}
+
+func (c *Client) CreateAPIToken(ctx context.Context, req api.CreateAPITokenRequest) (api.CreatedAPIToken, error) {
+	var out api.CreatedAPIToken
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/tokens", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteAPIToken(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/tokens/" + escape(id)}, nil)
+	return err
+}
+
+func (c *Client) RequestAccountDeletion(ctx context.Context, password string) (api.AccountDeletionConfirmation, error) {
+	var out api.AccountDeletionConfirmation
+	body := api.AccountDeletionRequest{Password: password}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/account/deletion", body: body}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteAccount(ctx context.Context, confirmationToken string) error {
+	body := api.DeleteAccountRequest{ConfirmationToken: confirmationToken}
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/account", body: body}, nil)
+	return err
+}
+
+// ExportAccount returns the zip archive of the account's data; close it when done
+func (c *Client) ExportAccount(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/api/account/export"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Spaces
+
+func (c *Client) GetSpaces(ctx context.Context, opts ListOptions) (Page[api.Space], error) {
+	return list[api.Space](ctx, c, "/api/spaces", url.Values{}, opts)
+}
+
+func (c *Client) GetSpace(ctx context.Context, id string) (api.Space, error) {
+	var out api.Space
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/spaces/" + escape(id)}, &out)
+	return out, err
+}
+
+func (c *Client) CreateSpace(ctx context.Context, name string) (api.Space, error) {
+	var out api.Space
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/spaces", body: api.SpaceRequest{Name: name}}, &out)
+	return out, err
+}
+
+func (c *Client) UpdateSpace(ctx context.Context, id, name string, ifVersion int64) (api.Space, error) {
+	var out api.Space
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/api/spaces/" + escape(id), body: api.SpaceRequest{Name: name}, ifMatch: ifVersion}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteSpace(ctx context.Context, id string, ifVersion int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/spaces/" + escape(id), ifMatch: ifVersion}, nil)
+	return err
+}
+
+func (c *Client) GetTree(ctx context.Context, spaceID string) ([]api.TreeNode, error) {
+	var out []api.TreeNode
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/tree", query: url.Values{"spaceId": {spaceID}}}, &out)
+	return out, err
+}
+
+// Vaults
+
+func (c *Client) GetVaults(ctx context.Context, spaceID string, opts ListOptions) (Page[api.Vault], error) {
+	return list[api.Vault](ctx, c, "/api/vaults", url.Values{"spaceId": {spaceID}}, opts)
+}
+
+func (c *Client) GetVault(ctx context.Context, id string) (api.Vault, error) {
+	var out api.Vault
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/vaults/" + escape(id)}, &out)
+	return out, err
+}
+
+func (c *Client) CreateVault(ctx context.Context, req api.CreateVaultRequest) (api.Vault, error) {
+	var out api.Vault
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/vaults", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) UpdateVault(ctx context.Context, id, name string, ifVersion int64) (api.Vault, error) {
+	var out api.Vault
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/api/vaults/" + escape(id), body: api.UpdateVaultRequest{Name: name}, ifMatch: ifVersion}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteVault(ctx context.Context, id string, ifVersion int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/vaults/" + escape(id), ifMatch: ifVersion}, nil)
+	return err
+}
+
+// Logs
+
+// LogFilter narrows GetLogs; without a space or vault it lists every accessible log
+type LogFilter struct {
+	SpaceID  string
+	VaultID  string
+	Language string
+	OmitCode bool
+}
+
+func (c *Client) GetLogs(ctx context.Context, filter LogFilter, opts ListOptions) (Page[api.Log], error) {
+	q := url.Values{}
+	setIf(q, "spaceId", filter.SpaceID)
+	setIf(q, "vaultId", filter.VaultID)
+	setIf(q, "language", filter.Language)
+	if filter.OmitCode {
+		q.Set("omitCode", "true")
+	}
+	return list[api.Log](ctx, c, "/api/logs", q, opts)
+}
+
+func (c *Client) GetLog(ctx context.Context, id string) (api.Log, error) {
+	var out api.Log
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/logs/" + escape(id)}, &out)
+	return out, err
+}
+
+func (c *Client) CreateLog(ctx context.Context, req api.CreateLogRequest) (api.Log, error) {
+	var out api.Log
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/logs", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) UpdateLog(ctx context.Context, id string, req api.UpdateLogRequest, ifVersion int64) (api.Log, error) {
+	var out api.Log
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/api/logs/" + escape(id), body: req, ifMatch: ifVersion}, &out)
+	return out, err
+}
+
+// PatchLog applies a partial update; edits require ifVersion
+func (c *Client) PatchLog(ctx context.Context, id string, patch api.LogPatch, ifVersion int64) (api.Log, error) {
+	var out api.Log
+	_, err := c.do(ctx, request{method: http.MethodPatch, path: "/api/logs/" + escape(id), body: patch, contentType: "application/merge-patch+json", ifMatch: ifVersion}, &out)
+	return out, err
+}
+
+// PatchLogWithJSONPatch applies an RFC 6902 JSON Patch to a log
+func (c *Client) PatchLogWithJSONPatch(ctx context.Context, id string, ops []api.JSONPatchOp, ifVersion int64) (api.Log, error) {
+	var out api.Log
+	_, err := c.do(ctx, request{method: http.MethodPatch, path: "/api/logs/" + escape(id), body: ops, contentType: "application/json-patch+json", ifMatch: ifVersion}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteLog(ctx context.Context, id string, ifVersion int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/logs/" + escape(id), ifMatch: ifVersion}, nil)
+	return err
+}
+
+// ExecuteBatch runs operations in order. When an operation fails the error's
+// details say which one, and on a standalone server which ones were applied.
+func (c *Client) ExecuteBatch(ctx context.Context, operations []api.BatchOperation) (api.BatchResponse, error) {
+	var out api.BatchResponse
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/batch", body: api.BatchRequest{Operations: operations}}, &out)
+	return out, err
+}
+
+// GraphQL runs a query or mutation and decodes its data into out. Errors in
+// the response are returned as a *api.GraphQLError, along with any partial data.
+func (c *Client) GraphQL(ctx context.Context, query string, variables map[string]interface{}, out interface{}) error {
+	var resp struct {
+		Data   json.RawMessage     `json:"data"`
+		Errors []*api.GraphQLError `json:"errors"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/graphql", body: api.GraphQLRequest{Query: query, Variables: variables}}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Data) > 0 && out != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			return err
+		}
+	}
+	if len(resp.Errors) > 0 {
+		return resp.Errors[0]
+	}
+	return nil
+}
+
+// Members and invitations
+
+func (c *Client) GetSpaceMembers(ctx context.Context, spaceID string) ([]api.SpaceMemberView, error) {
+	var out []api.SpaceMemberView
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/spaces/" + escape(spaceID) + "/members"}, &out)
+	return out, err
+}
+
+func (c *Client) UpdateSpaceMember(ctx context.Context, spaceID, userID, role string) (api.SpaceMember, error) {
+	var out api.SpaceMember
+	path := "/api/spaces/" + escape(spaceID) + "/members/" + escape(userID)
+	_, err := c.do(ctx, request{method: http.MethodPut, path: path, body: api.UpdateSpaceMemberRequest{Role: role}}, &out)
+	return out, err
+}
+
+func (c *Client) RemoveSpaceMember(ctx context.Context, spaceID, userID string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/spaces/" + escape(spaceID) + "/members/" + escape(userID)}, nil)
+	return err
+}
+
+func (c *Client) GetSpaceInvitations(ctx context.Context, spaceID string) ([]api.SpaceInvitation, error) {
+	var out []api.SpaceInvitation
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/spaces/" + escape(spaceID) + "/invitations"}, &out)
+	return out, err
+}
+
+func (c *Client) CreateSpaceInvitation(ctx context.Context, spaceID, email, role string) (api.CreatedInvitation, error) {
+	var out api.CreatedInvitation
+	body := api.CreateSpaceInvitationRequest{Email: email, Role: role}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/spaces/" + escape(spaceID) + "/invitations", body: body}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteSpaceInvitation(ctx context.Context, spaceID, invitationID string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/spaces/" + escape(spaceID) + "/invitations/" + escape(invitationID)}, nil)
+	return err
+}
+
+func (c *Client) GetMyInvitations(ctx context.Context, token string) ([]api.SpaceInvitation, error) {
+	var out []api.SpaceInvitation
+	body := api.InvitationTokenRequest{Token: token}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/invitations/lookup", body: body}, &out)
+	return out, err
+}
+
+func (c *Client) AcceptInvitation(ctx context.Context, id, token string) (api.SpaceMember, error) {
+	var out api.SpaceMember
+	body := api.InvitationTokenRequest{Token: token}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/invitations/" + escape(id) + "/accept", body: body}, &out)
+	return out, err
+}
+
+func (c *Client) DeclineInvitation(ctx context.Context, id, token string) error {
+	body := api.InvitationTokenRequest{Token: token}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/invitations/" + escape(id) + "/decline", body: body}, nil)
+	return err
+}
+
+// Secrets
+
+func (c *Client) GetSecrets(ctx context.Context, spaceID string) ([]api.Secret, error) {
+	var out []api.Secret
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/spaces/" + escape(spaceID) + "/secrets"}, &out)
+	return out, err
+}
+
+func (c *Client) CreateSecret(ctx context.Context, spaceID, name, value string) (api.Secret, error) {
+	var out api.Secret
+	body := api.CreateSecretRequest{Name: name, Value: value}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/spaces/" + escape(spaceID) + "/secrets", body: body}, &out)
+	return out, err
+}
+
+func (c *Client) UpdateSecret(ctx context.Context, spaceID, name, value string) (api.Secret, error) {
+	var out api.Secret
+	path := "/api/spaces/" + escape(spaceID) + "/secrets/" + escape(name)
+	_, err := c.do(ctx, request{method: http.MethodPut, path: path, body: api.UpdateSecretRequest{Value: value}}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteSecret(ctx context.Context, spaceID, name string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/spaces/" + escape(spaceID) + "/secrets/" + escape(name)}, nil)
+	return err
+}
+
+// Share links
+
+// GetShareLinks lists the caller's share links, or with a space ID every link in the space
+func (c *Client) GetShareLinks(ctx context.Context, spaceID string) ([]api.ShareLink, error) {
+	var out []api.ShareLink
+	q := url.Values{}
+	setIf(q, "spaceId", spaceID)
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/shares", query: q}, &out)
+	return out, err
+}
+
+func (c *Client) CreateShareLink(ctx context.Context, req api.CreateShareLinkRequest) (api.ShareLink, error) {
+	var out api.ShareLink
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/shares", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteShareLink(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/shares/" + escape(id)}, nil)
+	return err
+}
+
+func (c *Client) GetSharedContent(ctx context.Context, token string) (api.SharedContent, error) {
+	var out api.SharedContent
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/public/shares/" + escape(token)}, &out)
+	return out, err
+}
+
+func (c *Client) GetSharedLog(ctx context.Context, token, logID string) (api.SharedLog, error) {
+	var out api.SharedLog
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/public/shares/" + escape(token) + "/logs/" + escape(logID)}, &out)
+	return out, err
+}
+
+// RunSharedLog runs shared code; logID is only needed for vault shares
+func (c *Client) RunSharedLog(ctx context.Context, token, logID string) (api.RunResult, error) {
+	var out api.RunResult
+	body := api.RunSharedLogRequest{LogID: logID}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/public/shares/" + escape(token) + "/run", body: body}, &out)
+	return out, err
+}
+
+// Webhooks
+
+func (c *Client) GetWebhooks(ctx context.Context, spaceID string) ([]api.Webhook, error) {
+	var out []api.Webhook
+	_, err := c.do(ctx, request{method: http.MethodGet, path: webhooksPath(spaceID)}, &out)
+	return out, err
+}
+
+// CreateWebhook subscribes a URL to a space's events; the response holds the signing secret
+func (c *Client) CreateWebhook(ctx context.Context, spaceID string, req api.WebhookRequest) (api.CreatedWebhook, error) {
+	var out api.CreatedWebhook
+	_, err := c.do(ctx, request{method: http.MethodPost, path: webhooksPath(spaceID), body: req}, &out)
+	return out, err
+}
+
+func (c *Client) UpdateWebhook(ctx context.Context, spaceID, webhookID string, req api.WebhookRequest) (api.Webhook, error) {
+	var out api.Webhook
+	_, err := c.do(ctx, request{method: http.MethodPut, path: webhooksPath(spaceID) + "/" + escape(webhookID), body: req}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, spaceID, webhookID string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: webhooksPath(spaceID) + "/" + escape(webhookID)}, nil)
+	return err
+}
+
+// PingWebhook queues a ping delivery
+func (c *Client) PingWebhook(ctx context.Context, spaceID, webhookID string) (api.WebhookDelivery, error) {
+	var out api.WebhookDelivery
+	_, err := c.do(ctx, request{method: http.MethodPost, path: webhooksPath(spaceID) + "/" + escape(webhookID) + "/ping"}, &out)
+	return out, err
+}
+
+// DeliveryFilter selects a page of webhook deliveries
+type DeliveryFilter struct {
+	Status string // pending, succeeded or failed
+	Before string // NextCursor of the previous page
+	Limit  int
+}
+
+func (c *Client) GetWebhookDeliveries(ctx context.Context, spaceID, webhookID string, filter DeliveryFilter) (api.WebhookDeliveryPage, error) {
+	var out api.WebhookDeliveryPage
+	q := url.Values{}
+	setIf(q, "status", filter.Status)
+	setIf(q, "before", filter.Before)
+	if filter.Limit > 0 {
+		q.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	path := webhooksPath(spaceID) + "/" + escape(webhookID) + "/deliveries"
+	_, err := c.do(ctx, request{method: http.MethodGet, path: path, query: q}, &out)
+	return out, err
+}
+
+// RedeliverWebhookDelivery queues a delivery's payload again
+func (c *Client) RedeliverWebhookDelivery(ctx context.Context, spaceID, webhookID, deliveryID string) (api.WebhookDelivery, error) {
+	var out api.WebhookDelivery
+	path := webhooksPath(spaceID) + "/" + escape(webhookID) + "/deliveries/" + escape(deliveryID) + "/redeliver"
+	_, err := c.do(ctx, request{method: http.MethodPost, path: path}, &out)
+	return out, err
+}
+
+func webhooksPath(spaceID string) string {
+	return "/api/spaces/" + escape(spaceID) + "/webhooks"
+}
+
+// Quotas and audit
+
+// GetQuotas reports usage for the user and, with a space ID, the space
+func (c *Client) GetQuotas(ctx context.Context, spaceID string) (api.QuotaReport, error) {
+	var out api.QuotaReport
+	q := url.Values{}
+	setIf(q, "spaceId", spaceID)
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/quotas", query: q}, &out)
+	return out, err
+}
+
+// AuditFilter selects audit entries; dates are YYYY-MM-DD
+type AuditFilter struct {
+	SpaceID  string
+	Actor    string
+	Action   string
+	TargetID string
+	From     string
+	To       string
+	Limit    int
+	Before   string // NextCursor of the previous page
+}
+
+func (c *Client) GetAuditLog(ctx context.Context, filter AuditFilter) (api.AuditPage, error) {
+	var out api.AuditPage
+	q := url.Values{"spaceId": {filter.SpaceID}}
+	setIf(q, "actor", filter.Actor)
+	setIf(q, "action", filter.Action)
+	setIf(q, "targetId", filter.TargetID)
+	setIf(q, "from", filter.From)
+	setIf(q, "to", filter.To)
+	setIf(q, "before", filter.Before)
+	if filter.Limit > 0 {
+		q.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/audit", query: q}, &out)
+	return out, err
+}
+
+// Running code and AI
+
+func (c *Client) RunCode(ctx context.Context, req api.RunRequest) (api.RunResult, error) {
+	var out api.RunResult
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/run", body: req}, &out)
+	return out, err
+}
+
+// GenerateCode generates code; use ExplainLog, ReviewLog or GenerateTests for the other modes
+func (c *Client) GenerateCode(ctx context.Context, req api.GenerateRequest) (api.GenerateResponse, error) {
+	var out api.GenerateResponse
+	req.Mode = api.ModeGenerate
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/ai/generate", body: req}, &out)
+	return out, err
+}
+
+func (c *Client) ExplainLog(ctx context.Context, logID string) (api.ExplainResponse, error) {
+	var out api.ExplainResponse
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/ai/generate", body: api.GenerateRequest{Mode: api.ModeExplain, LogID: logID}}, &out)
+	return out, err
+}
+
+func (c *Client) ReviewLog(ctx context.Context, logID string) (api.ReviewResponse, error) {
+	var out api.ReviewResponse
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/ai/generate", body: api.GenerateRequest{Mode: api.ModeReview, LogID: logID}}, &out)
+	return out, err
+}
+
+// GenerateTests writes tests for a log and saves them as a new log
+func (c *Client) GenerateTests(ctx context.Context, logID string) (api.TestsResponse, error) {
+	var out api.TestsResponse
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/ai/generate", body: api.GenerateRequest{Mode: api.ModeTests, LogID: logID}}, &out)
+	return out, err
+}
+
+// UsageFilter selects AI usage; dates are YYYY-MM-DD and GroupBy is user, space or day
+type UsageFilter struct {
+	GroupBy string
+	From    string
+	To      string
+	SpaceID string
+}
+
+func (c *Client) GetAIUsage(ctx context.Context, filter UsageFilter) ([]api.UsageTotals, error) {
+	var out []api.UsageTotals
+	q := url.Values{}
+	setIf(q, "groupBy", filter.GroupBy)
+	setIf(q, "from", filter.From)
+	setIf(q, "to", filter.To)
+	setIf(q, "spaceId", filter.SpaceID)
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/ai/usage", query: q}, &out)
+	return out, err
+}
+
+func (c *Client) GetAIBudget(ctx context.Context, spaceID string) (api.BudgetReport, error) {
+	var out api.BudgetReport
+	q := url.Values{}
+	setIf(q, "spaceId", spaceID)
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/ai/budget", query: q}, &out)
+	return out, err
+}
+
+// GetChatSessions lists chat sessions, optionally for one space or log
+func (c *Client) GetChatSessions(ctx context.Context, spaceID, logID string) ([]api.ChatSession, error) {
+	var out []api.ChatSession
+	q := url.Values{}
+	setIf(q, "spaceId", spaceID)
+	setIf(q, "logId", logID)
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/ai/sessions", query: q}, &out)
+	return out, err
+}
+
+func (c *Client) GetChatSession(ctx context.Context, id string) (api.ChatSession, error) {
+	var out api.ChatSession
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/api/ai/sessions/" + escape(id)}, &out)
+	return out, err
+}
+
+func (c *Client) CreateChatSession(ctx context.Context, req api.CreateChatSessionRequest) (api.ChatSession, error) {
+	var out api.ChatSession
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/ai/sessions", body: req}, &out)
+	return out, err
+}
+
+// PostChatMessage sends a message and returns the assistant's reply
+func (c *Client) PostChatMessage(ctx context.Context, sessionID, content string) (api.ChatMessage, error) {
+	var out api.ChatMessage
+	body := api.ChatMessageRequest{Content: content}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/api/ai/sessions/" + escape(sessionID) + "/messages", body: body}, &out)
+	return out, err
+}
+
+func (c *Client) DeleteChatSession(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/ai/sessions/" + escape(id)}, nil)
+	return err
+}
@@ -4,11 +4,14 @@ import (
 	"log"
 	"os"
 
+	"codeflow-backend/internal/auth"
 	"codeflow-backend/internal/db"
-	"codeflow-backend/internal/handler"
-	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/ratelimit"
+	"codeflow-backend/internal/secrets"
+	"codeflow-backend/internal/server"
+	"codeflow-backend/internal/webhooks"
 
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
@@ -22,50 +25,25 @@ func main() {
 	db.ConnectMongoDB()
 	defer db.DisconnectMongoDB()
 
-	// Setup Gin router
-	r := gin.Default()
+	// Load the session signing secret
+	auth.Init()
 
-	// CORS middleware
-	r.Use(middleware.CORSMiddleware())
+	// Load the master key for encrypted space secrets
+	secrets.Init()
 
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
-	})
+	// Select the rate limit backend (in-memory or shared via MongoDB)
+	ratelimit.Init(db.Database)
 
-	// API routes with optional admin token middleware
-	api := r.Group("/api")
-	api.Use(middleware.AdminTokenMiddleware())
-	{
-		// Spaces
-		api.GET("/spaces", handler.GetSpaces)
-		api.GET("/spaces/:id", handler.GetSpace)
-		api.POST("/spaces", handler.CreateSpace)
-		api.PUT("/spaces/:id", handler.UpdateSpace)
-		api.DELETE("/spaces/:id", handler.DeleteSpace)
+	// Feed space change events from change streams on a replica set, or in-process
+	events.Init(db.Database, db.SupportsTransactions)
 
-		// Vaults
-		api.GET("/vaults", handler.GetVaults) // Query: ?spaceId=xxx
-		api.GET("/vaults/:id", handler.GetVault)
-		api.POST("/vaults", handler.CreateVault)
-		api.PUT("/vaults/:id", handler.UpdateVault)
-		api.DELETE("/vaults/:id", handler.DeleteVault)
+	// Post space events to webhooks, retrying failed deliveries in the background
+	webhooks.Start(db.Database)
 
-		// Logs
-		api.GET("/logs", handler.GetLogs) // Query: ?spaceId=xxx or ?vaultId=xxx
-		api.GET("/logs/:id", handler.GetLog)
-		api.POST("/logs", handler.CreateLog)
-		api.PUT("/logs/:id", handler.UpdateLog)
-		api.DELETE("/logs/:id", handler.DeleteLog)
-
-		// Tree
-		api.GET("/tree", handler.GetTree) // Query: ?spaceId=xxx
-
-		// Run code
-		api.POST("/run", handler.RunCode)
-
-		// AI generation
-		api.POST("/ai/generate", handler.GenerateCode)
+	// Routes, middleware and the handlers behind them
+	r, err := server.New()
+	if err != nil {
+		log.Fatalf("Failed to set up router: %v", err)
 	}
 
 	// Start server
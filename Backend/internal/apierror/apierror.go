@@ -0,0 +1,79 @@
+// Package apierror writes the error envelope returned by every endpoint:
+//
+//	{"status": 404, "code": "NOT_FOUND", "message": "Space not found", "details": ..., "requestId": "..."}
+//
+// The envelope and the code catalogue are defined in package api, so clients can
+// use them without importing the server; this package aliases them for handlers.
+package apierror
+
+import (
+	"codeflow-backend/api"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Code is a machine-readable error code
+type Code = api.ErrorCode
+
+// The error catalogue, defined in package api
+const (
+	InvalidRequest       = api.InvalidRequest
+	InvalidID            = api.InvalidID
+	Unauthorized         = api.Unauthorized
+	Forbidden            = api.Forbidden
+	InsufficientScope    = api.InsufficientScope
+	NotFound             = api.NotFound
+	MethodNotAllowed     = api.MethodNotAllowed
+	Conflict             = api.Conflict
+	PreconditionFailed   = api.PreconditionFailed
+	PayloadTooLarge      = api.PayloadTooLarge
+	UnsupportedMediaType = api.UnsupportedMediaType
+	PreconditionRequired = api.PreconditionRequired
+	QuotaExceeded        = api.QuotaExceeded
+	RateLimited          = api.RateLimited
+	AIBudgetExceeded     = api.AIBudgetExceeded
+	APIKeyInvalid        = api.APIKeyInvalid
+	ProviderUnavailable  = api.ProviderUnavailable
+	Internal             = api.Internal
+	UpstreamError        = api.UpstreamError
+	NotConfigured        = api.NotConfigured
+)
+
+// Error is the error envelope
+type Error = api.Error
+
+// New returns an error with the code's default status
+func New(code Code, message string) *Error {
+	return api.NewError(code, message)
+}
+
+// Respond writes err and aborts the request. The request ID is taken from the
+// X-Request-ID response header set by the request ID middleware.
+func Respond(c *gin.Context, err *Error) {
+	if err.RequestID == "" {
+		err.RequestID = c.Writer.Header().Get("X-Request-ID")
+	}
+	c.AbortWithStatusJSON(err.Status, err)
+}
+
+// Abort writes an error with the code's default status and aborts the request
+func Abort(c *gin.Context, code Code, message string) {
+	Respond(c, New(code, message))
+}
+
+// NoRoute answers requests for unknown routes
+func NoRoute(c *gin.Context) {
+	Abort(c, NotFound, "Route not found")
+}
+
+// NoMethod answers requests for known routes with an unsupported method
+func NoMethod(c *gin.Context) {
+	Abort(c, MethodNotAllowed, "Method not allowed")
+}
+
+// Recovery turns panics into an INTERNAL_ERROR response
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		Abort(c, Internal, "Internal server error")
+	})
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const confirmationIssuer = "codeflow-confirm"
+
+// IssueConfirmationToken signs a short-lived token that confirms a destructive
+// action. The purpose is checked on use so a token can't confirm anything else.
+func IssueConfirmationToken(userID, purpose string, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{purpose},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		Issuer:    confirmationIssuer,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(sessionSecret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseConfirmationToken verifies a confirmation token for purpose and returns the user ID
+func ParseConfirmationToken(tokenString, purpose string) (string, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return sessionSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(confirmationIssuer), jwt.WithAudience(purpose))
+	if err != nil {
+		return "", err
+	}
+
+	if claims.Subject == "" {
+		return "", fmt.Errorf("confirmation token has no subject")
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCStateCookieName is the cookie that carries the login state between the redirect and the callback
+const OIDCStateCookieName = "codeflow_oidc"
+
+// OIDCStateTTL is how long a user has to complete a login at the identity provider
+const OIDCStateTTL = 10 * time.Minute
+
+const (
+	oidcStateIssuer  = "codeflow-oidc"
+	jwksRefreshDelay = time.Minute
+)
+
+// ErrOIDCNotConfigured is returned when OIDC_ISSUER or OIDC_CLIENT_ID is not set
+var ErrOIDCNotConfigured = errors.New("OIDC login is not configured")
+
+// OIDCConfig describes the OpenID Connect client registration
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCConfigFromEnv reads the client registration from the OIDC_* variables
+func OIDCConfigFromEnv() (OIDCConfig, error) {
+	cfg := OIDCConfig{
+		Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return cfg, ErrOIDCNotConfigured
+	}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	return cfg, nil
+}
+
+// OIDCProvider is an OpenID Connect identity provider found through discovery
+type OIDCProvider struct {
+	Config                OIDCConfig
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	client *http.Client
+
+	mu            sync.Mutex
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// IDTokenClaims are the ID token claims used to sign a user in
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *OIDCProvider
+)
+
+// OIDC returns the configured identity provider, running discovery on first use.
+// A failed discovery is retried on the next call.
+func OIDC(ctx context.Context) (*OIDCProvider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+
+	cfg, err := OIDCConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := DiscoverOIDC(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return provider, nil
+}
+
+// DiscoverOIDC loads the provider metadata from the issuer's discovery document
+func DiscoverOIDC(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := getJSON(client, req, &metadata); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", metadata.Issuer, cfg.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+
+	// ID tokens must carry the issuer exactly as the provider spells it
+	cfg.Issuer = metadata.Issuer
+
+	return &OIDCProvider{
+		Config:                cfg,
+		AuthorizationEndpoint: metadata.AuthorizationEndpoint,
+		TokenEndpoint:         metadata.TokenEndpoint,
+		JWKSURI:               metadata.JWKSURI,
+		client:                client,
+	}, nil
+}
+
+// AuthCodeURL returns the provider URL that starts an authorization-code login with PKCE
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(p.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange trades an authorization code for the raw ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := getJSON(p.client, req, &tokens); err != nil {
+		return "", fmt.Errorf("OIDC token exchange failed: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("OIDC token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token signature against the provider's JWKS
+// and validates the issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	var claims IDTokenClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(p.Config.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("invalid ID token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid ID token: missing subject")
+	}
+	return &claims, nil
+}
+
+// publicKey returns the signing key with the given ID, refreshing the JWKS when the key is unknown
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	// Providers rotate keys, but don't let unknown key IDs hammer the JWKS endpoint
+	if time.Since(p.keysFetchedAt) < jwksRefreshDelay && p.keys != nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a key by ID; tokens without a key ID match when the JWKS has a single key
+func (p *OIDCProvider) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetchKeys downloads the provider's RSA signing keys
+func (p *OIDCProvider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(p.client, req, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// OIDCState is what the login redirect remembers for the callback
+type OIDCState struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	ReturnTo     string `json:"returnTo,omitempty"`
+}
+
+type oidcStateClaims struct {
+	jwt.RegisteredClaims
+	OIDCState
+}
+
+// IssueOIDCState signs the login state so it can be kept in a cookie.
+// It uses its own issuer so it can never be accepted as a session token.
+func IssueOIDCState(state OIDCState) (string, error) {
+	claims := oidcStateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(OIDCStateTTL)),
+			Issuer:    oidcStateIssuer,
+		},
+		OIDCState: state,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(sessionSecret)
+}
+
+// ParseOIDCState verifies a login state cookie
+func ParseOIDCState(tokenString string) (OIDCState, error) {
+	var claims oidcStateClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return sessionSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(oidcStateIssuer))
+	if err != nil {
+		return OIDCState{}, err
+	}
+	return claims.OIDCState, nil
+}
+
+// NewOIDCState generates a fresh state, nonce and PKCE verifier
+func NewOIDCState(returnTo string) (OIDCState, error) {
+	values := make([]string, 3)
+	for i := range values {
+		value, err := randomURLString(32)
+		if err != nil {
+			return OIDCState{}, err
+		}
+		values[i] = value
+	}
+
+	return OIDCState{
+		State:        values[0],
+		Nonce:        values[1],
+		CodeVerifier: values[2],
+		ReturnTo:     returnTo,
+	}, nil
+}
+
+// PKCEChallenge derives the S256 code challenge for a verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getJSON sends a request and decodes a successful JSON response
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d: %s", req.URL.Redacted(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// randomURLString returns n random bytes encoded as URL-safe base64
+func randomURLString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"codeflow-backend/internal/auth/oidctest"
+)
+
+// startProvider serves a fake identity provider and discovers it
+func startProvider(t *testing.T) (*oidctest.Provider, *OIDCProvider) {
+	t.Helper()
+	fake, server, err := oidctest.StartServer("codeflow", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	provider, err := DiscoverOIDC(context.Background(), OIDCConfig{
+		Issuer:       fake.Issuer,
+		ClientID:     "codeflow",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://app.example.com/api/auth/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatalf("DiscoverOIDC: %v", err)
+	}
+	return fake, provider
+}
+
+// authorize follows the login redirect to the fake provider and returns the code it issues
+func authorize(t *testing.T, provider *OIDCProvider, state OIDCState) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(provider.AuthCodeURL(state.State, state.Nonce, PKCEChallenge(state.CodeVerifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Query().Get("state") != state.State || location.Query().Get("code") == "" {
+		t.Fatalf("authorize redirected to %q", resp.Header.Get("Location"))
+	}
+	return location.Query().Get("code")
+}
+
+func newState(t *testing.T) OIDCState {
+	t.Helper()
+	state, err := NewOIDCState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	fake, provider := startProvider(t)
+	fake.SetUser(oidctest.User{Subject: "sub-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+	ctx := context.Background()
+
+	if provider.TokenEndpoint != fake.Issuer+"/token" || provider.JWKSURI != fake.Issuer+"/jwks" {
+		t.Errorf("discovered endpoints %q and %q", provider.TokenEndpoint, provider.JWKSURI)
+	}
+
+	state := newState(t)
+	rawIDToken, err := provider.Exchange(ctx, authorize(t, provider, state), state.CodeVerifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Subject != "sub-1" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, rawIDToken, "another-nonce"); err == nil {
+		t.Error("an ID token was accepted with the wrong nonce")
+	}
+}
+
+func TestOIDCExchangeChecksPKCEAndCodes(t *testing.T) {
+	_, provider := startProvider(t)
+	ctx := context.Background()
+
+	state := newState(t)
+	code := authorize(t, provider, state)
+	if _, err := provider.Exchange(ctx, code, state.CodeVerifier+"x"); err == nil {
+		t.Error("a code was redeemed with the wrong PKCE verifier")
+	}
+	// Codes are single use, even after a failed redemption
+	if _, err := provider.Exchange(ctx, code, state.CodeVerifier); err == nil {
+		t.Error("a code was redeemed twice")
+	}
+
+	provider.Config.ClientSecret = "wrong"
+	state = newState(t)
+	if _, err := provider.Exchange(ctx, authorize(t, provider, state), state.CodeVerifier); err == nil {
+		t.Error("a code was redeemed with the wrong client secret")
+	}
+}
+
+func TestOIDCRejectsTokensSignedByOtherKeys(t *testing.T) {
+	_, provider := startProvider(t)
+	_, other := startProvider(t)
+	ctx := context.Background()
+
+	// The other provider signs with its own key under the same key ID
+	state := newState(t)
+	rawIDToken, err := other.Exchange(ctx, authorize(t, other, state), state.CodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Even when the issuer matches, the signature has to come from the provider's JWKS
+	provider.Config.Issuer = other.Config.Issuer
+	if _, err := provider.VerifyIDToken(ctx, rawIDToken, state.Nonce); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("VerifyIDToken of a token signed by another key: err = %v", err)
+	}
+}
+
+func TestDiscoverOIDCChecksTheIssuer(t *testing.T) {
+	fake, server, err := oidctest.StartServer("codeflow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	_, err = DiscoverOIDC(context.Background(), OIDCConfig{Issuer: fake.Issuer + "/tenant", ClientID: "codeflow"})
+	if err == nil {
+		t.Error("discovery succeeded for a different issuer")
+	}
+	_, err = DiscoverOIDC(context.Background(), OIDCConfig{Issuer: "http://127.0.0.1:1", ClientID: "codeflow"})
+	if err == nil {
+		t.Error("discovery succeeded without a provider")
+	}
+}
@@ -0,0 +1,306 @@
+// Package oidctest is a small in-process OpenID Connect provider for exercising
+// the OIDC login flow without a real identity provider. It supports discovery,
+// the authorization-code flow with S256 PKCE, and RS256 ID tokens served through a JWKS.
+// The authorize endpoint signs in the configured user immediately, without a login page.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "oidctest"
+
+// User is the identity the fake provider signs in
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is a fake identity provider; it implements http.Handler
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty means the client is public and needs no secret
+	TokenTTL     time.Duration
+
+	key *rsa.PrivateKey
+	mux *http.ServeMux
+
+	mu    sync.Mutex
+	user  User
+	codes map[string]authRequest
+}
+
+// authRequest is what an issued authorization code was granted for
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	user          User
+	expiresAt     time.Time
+}
+
+// NewProvider creates a provider that will be served at issuer
+func NewProvider(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenTTL:     time.Hour,
+		key:          key,
+		mux:          http.NewServeMux(),
+		user: User{
+			Subject:       "oidctest-user",
+			Email:         "user@example.com",
+			EmailVerified: true,
+			Name:          "Test User",
+		},
+		codes: make(map[string]authRequest),
+	}
+
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	p.mux.HandleFunc("/authorize", p.handleAuthorize)
+	p.mux.HandleFunc("/token", p.handleToken)
+	p.mux.HandleFunc("/jwks", p.handleJWKS)
+
+	return p, nil
+}
+
+// StartServer starts a provider on a local test server; the caller must Close the server
+func StartServer(clientID, clientSecret string) (*Provider, *httptest.Server, error) {
+	server := httptest.NewUnstartedServer(nil)
+
+	p, err := NewProvider("http://"+server.Listener.Addr().String(), clientID, clientSecret)
+	if err != nil {
+		server.Listener.Close()
+		return nil, nil, err
+	}
+
+	server.Config.Handler = p
+	server.Start()
+	return p, server, nil
+}
+
+// SetUser changes the identity signed in by later authorization requests
+func (p *Provider) SetUser(user User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = user
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+// handleAuthorize approves the request for the configured user and redirects back with a code
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case q.Get("client_id") != p.ClientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code":
+		redirectError(w, r, redirectURI, q.Get("state"), "unsupported_response_type")
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		redirectError(w, r, redirectURI, q.Get("state"), "invalid_request")
+		return
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		clientID:      p.ClientID,
+		redirectURI:   redirectURI.String(),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		user:          p.user,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// handleToken redeems a code for an ID token after checking the client and PKCE verifier
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	// Codes are single use, even when redemption fails
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	req, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !found || time.Now().After(req.expiresAt) || req.clientID != clientID || req.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := p.signIDToken(req)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	accessToken, err := randomString()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(p.TokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// signIDToken issues the RS256 ID token for an authorization request
+func (p *Provider) signIDToken(req authRequest) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            req.user.Subject,
+		"aud":            req.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(p.TokenTTL).Unix(),
+		"email":          req.user.Email,
+		"email_verified": req.user.EmailVerified,
+		"name":           req.user.Name,
+	}
+	if req.nonce != "" {
+		claims["nonce"] = req.nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(p.key)
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI *url.URL, state, code string) {
+	params := redirectURI.Query()
+	params.Set("error", code)
+	if state != "" {
+		params.Set("state", state)
+	}
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
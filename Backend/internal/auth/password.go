@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted at registration
+const MinPasswordLength = 8
+
+// HashPassword hashes a password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyHash stands in for accounts that don't exist or have no password
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("no account has this password")
+	return hash
+})
+
+// CheckPassword reports whether password matches the bcrypt hash. An empty hash
+// never matches, but is checked against a dummy hash so that unknown emails and
+// passwordless accounts take as long to refuse as a wrong password.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyHash()), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,16 @@
+package auth
+
+import "testing"
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "correct horse") || CheckPassword(hash, "wrong horse") {
+		t.Error("CheckPassword doesn't tell the right password from a wrong one")
+	}
+	if CheckPassword("", "") || CheckPassword("", "correct horse") {
+		t.Error("an empty hash matched")
+	}
+}
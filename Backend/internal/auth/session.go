@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SessionCookieName is the cookie that carries the session token for browser clients
+const SessionCookieName = "codeflow_session"
+
+const defaultSessionTTL = 7 * 24 * time.Hour
+
+var sessionSecret []byte
+
+// Init loads the session signing secret from JWT_SECRET.
+// Without it a random secret is generated, so sessions end when the server restarts.
+func Init() {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		sessionSecret = []byte(secret)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate session secret: %v", err)
+	}
+	sessionSecret = []byte(hex.EncodeToString(buf))
+	log.Println("JWT_SECRET not set, using a random secret; sessions will not survive restarts")
+}
+
+// SessionTTL returns how long sessions last, from SESSION_TTL (e.g. "24h")
+func SessionTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultSessionTTL
+}
+
+// sessionClaims are the claims of a session JWT. Generation is the user's
+// session generation when it was issued; bumping the stored generation ends
+// every session issued before.
+type sessionClaims struct {
+	jwt.RegisteredClaims
+	Generation int `json:"gen,omitempty"`
+}
+
+// IssueSessionToken signs a session JWT for the user at their current session generation
+func IssueSessionToken(userID string, generation int) (string, time.Time, error) {
+	expiresAt := time.Now().Add(SessionTTL())
+	claims := sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			Issuer:    "codeflow",
+		},
+		Generation: generation,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(sessionSecret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseSessionToken verifies a session JWT and returns the user ID and session
+// generation it was issued for. Callers must check the generation is still the user's.
+func ParseSessionToken(tokenString string) (string, int, error) {
+	var claims sessionClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return sessionSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("codeflow"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	if claims.Subject == "" {
+		return "", 0, fmt.Errorf("session token has no subject")
+	}
+	return claims.Subject, claims.Generation, nil
+}
+
+// SessionIssuedAt verifies a session JWT and returns when it was issued, for actions
+// that need a recent login
+func SessionIssuedAt(tokenString string) (time.Time, error) {
+	var claims sessionClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return sessionSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("codeflow"), jwt.WithIssuedAt())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if claims.IssuedAt == nil {
+		return time.Time{}, fmt.Errorf("session token has no issue time")
+	}
+	return claims.IssuedAt.Time, nil
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionTokenRoundTrip(t *testing.T) {
+	sessionSecret = []byte("test-secret")
+
+	token, expiresAt, err := IssueSessionToken("user-1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > SessionTTL() {
+		t.Errorf("expires in %v, want within %v", until, SessionTTL())
+	}
+
+	userID, generation, err := ParseSessionToken(token)
+	if err != nil || userID != "user-1" || generation != 3 {
+		t.Errorf("ParseSessionToken = %q, %d, %v; want user-1, 3", userID, generation, err)
+	}
+}
+
+func TestParseSessionTokenRejectsOtherTokens(t *testing.T) {
+	sessionSecret = []byte("test-secret")
+
+	confirmation, _, err := IssueConfirmationToken("user-1", "account.delete", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseSessionToken(confirmation); err == nil {
+		t.Error("a confirmation token was accepted as a session")
+	}
+
+	token, _, err := IssueSessionToken("user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionSecret = []byte("another-secret")
+	if _, _, err := ParseSessionToken(token); err == nil {
+		t.Error("a token signed with another secret was accepted")
+	}
+
+	t.Setenv("SESSION_TTL", "-1h")
+	if SessionTTL() != defaultSessionTTL {
+		t.Errorf("SessionTTL() = %v for a negative SESSION_TTL", SessionTTL())
+	}
+}
+
+func TestSessionIssuedAt(t *testing.T) {
+	sessionSecret = []byte("test-secret")
+
+	token, _, err := IssueSessionToken("user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuedAt, err := SessionIssuedAt(token); err != nil || time.Since(issuedAt) > time.Minute {
+		t.Errorf("SessionIssuedAt = %v, %v; want about now", issuedAt, err)
+	}
+
+	confirmation, _, err := IssueConfirmationToken("user-1", "account.delete", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SessionIssuedAt(confirmation); err == nil {
+		t.Error("a confirmation token was accepted as a session")
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// APITokenPrefix marks personal access tokens so they can be told apart from session tokens
+const APITokenPrefix = "cfp_"
+
+// Scopes that can be granted to personal access tokens
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeRun   = "run"
+	ScopeAI    = "ai"
+)
+
+// ValidScopes lists every scope a token may carry
+var ValidScopes = []string{ScopeRead, ScopeWrite, ScopeRun, ScopeAI}
+
+// GenerateAPIToken creates a new personal access token, returning the plaintext
+// (shown to the user once), its hash for storage and a short display prefix
+func GenerateAPIToken() (plain, hash, prefix string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	plain = APITokenPrefix + hex.EncodeToString(buf)
+	return plain, HashAPIToken(plain), plain[:len(APITokenPrefix)+8], nil
+}
+
+// HashAPIToken returns the SHA-256 hash used to look up a token
+func HashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether a bearer token is a personal access token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// IsValidScope reports whether scope is one of ValidScopes
+func IsValidScope(scope string) bool {
+	for _, s := range ValidScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
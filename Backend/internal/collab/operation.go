@@ -0,0 +1,383 @@
+// Package collab implements operational transformation for plain text, the
+// basis of collaborative editing. An Operation is a sequence of retains, inserts
+// and deletes that walks the whole document, in the format of the ot.js
+// library: a JSON array where a positive integer retains that many characters,
+// a negative integer deletes that many, and a string inserts itself. Lengths
+// count UTF-16 code units, the same as JavaScript string indices.
+package collab
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf16"
+
+	"codeflow-backend/internal/openapi"
+)
+
+// Operation is an edit from a document of BaseLength to one of TargetLength
+type Operation struct {
+	ops          []component
+	baseLength   int
+	targetLength int
+}
+
+// component is one step of an operation; exactly one field is set
+type component struct {
+	retain int
+	insert []uint16
+	delete int
+}
+
+func (c component) isRetain() bool { return c.retain > 0 }
+func (c component) isInsert() bool { return len(c.insert) > 0 }
+func (c component) isDelete() bool { return c.delete > 0 }
+
+// BaseLength is the length of the documents the operation applies to
+func (o Operation) BaseLength() int { return o.baseLength }
+
+// TargetLength is the length of the documents the operation produces
+func (o Operation) TargetLength() int { return o.targetLength }
+
+// IsNoop reports whether the operation leaves the document unchanged
+func (o Operation) IsNoop() bool {
+	return len(o.ops) == 0 || len(o.ops) == 1 && o.ops[0].isRetain()
+}
+
+// Retain keeps the next n characters
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	o.targetLength += n
+	if last := len(o.ops) - 1; last >= 0 && o.ops[last].isRetain() {
+		o.ops[last].retain += n
+	} else {
+		o.ops = append(o.ops, component{retain: n})
+	}
+	return o
+}
+
+// Insert inserts text at the current position
+func (o *Operation) Insert(text []uint16) *Operation {
+	if len(text) == 0 {
+		return o
+	}
+	o.targetLength += len(text)
+	last := len(o.ops) - 1
+
+	switch {
+	case last >= 0 && o.ops[last].isInsert():
+		o.ops[last].insert = concat(o.ops[last].insert, text)
+	case last >= 0 && o.ops[last].isDelete():
+		// Inserts go before deletes at the same position, so equal operations
+		// have a single representation
+		if last >= 1 && o.ops[last-1].isInsert() {
+			o.ops[last-1].insert = concat(o.ops[last-1].insert, text)
+		} else {
+			o.ops = append(o.ops, o.ops[last])
+			o.ops[last] = component{insert: concat(nil, text)}
+		}
+	default:
+		o.ops = append(o.ops, component{insert: concat(nil, text)})
+	}
+	return o
+}
+
+// Delete removes the next n characters
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	if last := len(o.ops) - 1; last >= 0 && o.ops[last].isDelete() {
+		o.ops[last].delete += n
+	} else {
+		o.ops = append(o.ops, component{delete: n})
+	}
+	return o
+}
+
+// Apply returns the result of applying the operation to doc. Retains and
+// deletes may not end between the halves of a surrogate pair.
+func (o Operation) Apply(doc []uint16) ([]uint16, error) {
+	if len(doc) != o.baseLength {
+		return nil, fmt.Errorf("operation applies to a document of length %d, not %d", o.baseLength, len(doc))
+	}
+
+	out := make([]uint16, 0, o.targetLength)
+	pos := 0
+	for _, c := range o.ops {
+		switch {
+		case c.isRetain():
+			out = append(out, doc[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.isInsert():
+			out = append(out, c.insert...)
+			continue
+		default:
+			pos += c.delete
+		}
+		if splitsSurrogatePair(doc, pos) {
+			return nil, fmt.Errorf("operation splits a character at offset %d", pos)
+		}
+	}
+	return out, nil
+}
+
+// Identity is the operation that retains a whole document of length n
+func Identity(n int) Operation {
+	var o Operation
+	o.Retain(n)
+	return o
+}
+
+// Diff is a single-range operation turning before into after
+func Diff(before, after []uint16) Operation {
+	start := 0
+	for start < len(before) && start < len(after) && before[start] == after[start] {
+		start++
+	}
+	endBefore, endAfter := len(before), len(after)
+	for endBefore > start && endAfter > start && before[endBefore-1] == after[endAfter-1] {
+		endBefore--
+		endAfter--
+	}
+
+	// Keep surrogate pairs whole
+	if splitsSurrogatePair(before, start) {
+		start--
+	}
+	if splitsSurrogatePair(before, endBefore) {
+		endBefore++
+		endAfter++
+	}
+
+	var o Operation
+	o.Retain(start)
+	o.Insert(after[start:endAfter])
+	o.Delete(endBefore - start)
+	o.Retain(len(before) - endBefore)
+	return o
+}
+
+// Compose returns a single operation with the effect of a followed by b
+func Compose(a, b Operation) (Operation, error) {
+	var out Operation
+	if a.targetLength != b.baseLength {
+		return out, fmt.Errorf("can't compose an operation producing length %d with one applying to length %d", a.targetLength, b.baseLength)
+	}
+
+	i1, i2 := 0, 0
+	op1, ok1 := next(a.ops, &i1)
+	op2, ok2 := next(b.ops, &i2)
+	for ok1 || ok2 {
+		if ok1 && op1.isDelete() {
+			out.Delete(op1.delete)
+			op1, ok1 = next(a.ops, &i1)
+			continue
+		}
+		if ok2 && op2.isInsert() {
+			out.Insert(op2.insert)
+			op2, ok2 = next(b.ops, &i2)
+			continue
+		}
+		if !ok1 || !ok2 {
+			return Operation{}, fmt.Errorf("operation lengths don't line up")
+		}
+
+		switch {
+		case op1.isRetain() && op2.isRetain():
+			n := min(op1.retain, op2.retain)
+			out.Retain(n)
+			op1.retain -= n
+			op2.retain -= n
+		case op1.isInsert() && op2.isDelete():
+			n := min(len(op1.insert), op2.delete)
+			op1.insert = op1.insert[n:]
+			op2.delete -= n
+		case op1.isInsert() && op2.isRetain():
+			n := min(len(op1.insert), op2.retain)
+			out.Insert(op1.insert[:n])
+			op1.insert = op1.insert[n:]
+			op2.retain -= n
+		case op1.isRetain() && op2.isDelete():
+			n := min(op1.retain, op2.delete)
+			out.Delete(n)
+			op1.retain -= n
+			op2.delete -= n
+		}
+
+		if op1.empty() {
+			op1, ok1 = next(a.ops, &i1)
+		}
+		if op2.empty() {
+			op2, ok2 = next(b.ops, &i2)
+		}
+	}
+	return out, nil
+}
+
+// Transform takes concurrent operations a and b on the same document and
+// returns a' and b' such that applying a then b' gives the same result as
+// applying b then a'. When both insert at the same position, a's text comes first.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	var aPrime, bPrime Operation
+	if a.baseLength != b.baseLength {
+		return aPrime, bPrime, fmt.Errorf("can't transform operations on documents of lengths %d and %d", a.baseLength, b.baseLength)
+	}
+
+	i1, i2 := 0, 0
+	op1, ok1 := next(a.ops, &i1)
+	op2, ok2 := next(b.ops, &i2)
+	for ok1 || ok2 {
+		if ok1 && op1.isInsert() {
+			aPrime.Insert(op1.insert)
+			bPrime.Retain(len(op1.insert))
+			op1, ok1 = next(a.ops, &i1)
+			continue
+		}
+		if ok2 && op2.isInsert() {
+			aPrime.Retain(len(op2.insert))
+			bPrime.Insert(op2.insert)
+			op2, ok2 = next(b.ops, &i2)
+			continue
+		}
+		if !ok1 || !ok2 {
+			return Operation{}, Operation{}, fmt.Errorf("operation lengths don't line up")
+		}
+
+		switch {
+		case op1.isRetain() && op2.isRetain():
+			n := min(op1.retain, op2.retain)
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+			op1.retain -= n
+			op2.retain -= n
+		case op1.isDelete() && op2.isDelete():
+			// Both deleted the same text
+			n := min(op1.delete, op2.delete)
+			op1.delete -= n
+			op2.delete -= n
+		case op1.isDelete() && op2.isRetain():
+			n := min(op1.delete, op2.retain)
+			aPrime.Delete(n)
+			op1.delete -= n
+			op2.retain -= n
+		case op1.isRetain() && op2.isDelete():
+			n := min(op1.retain, op2.delete)
+			bPrime.Delete(n)
+			op1.retain -= n
+			op2.delete -= n
+		}
+
+		if op1.empty() {
+			op1, ok1 = next(a.ops, &i1)
+		}
+		if op2.empty() {
+			op2, ok2 = next(b.ops, &i2)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+// TransformIndex moves a position in the document before the operation to the
+// matching position after it. Text inserted at the position pushes it along.
+func TransformIndex(o Operation, index int) int {
+	moved, pos := index, 0
+	for _, c := range o.ops {
+		if pos > index {
+			break
+		}
+		switch {
+		case c.isRetain():
+			pos += c.retain
+		case c.isInsert():
+			moved += len(c.insert)
+		default:
+			moved -= min(c.delete, index-pos)
+			pos += c.delete
+		}
+	}
+	return moved
+}
+
+// MarshalJSON encodes the operation in the ot.js format
+func (o Operation) MarshalJSON() ([]byte, error) {
+	out := make([]interface{}, 0, len(o.ops))
+	for _, c := range o.ops {
+		switch {
+		case c.isRetain():
+			out = append(out, c.retain)
+		case c.isInsert():
+			out = append(out, string(utf16.Decode(c.insert)))
+		default:
+			out = append(out, -c.delete)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes an operation in the ot.js format
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("an operation is an array of numbers and strings")
+	}
+
+	*o = Operation{}
+	for _, item := range items {
+		var text string
+		if err := json.Unmarshal(item, &text); err == nil {
+			if text == "" {
+				return fmt.Errorf("operation inserts an empty string")
+			}
+			o.Insert(utf16.Encode([]rune(text)))
+			continue
+		}
+
+		var n int
+		if err := json.Unmarshal(item, &n); err != nil || n == 0 {
+			return fmt.Errorf("operation components must be non-zero integers or strings")
+		}
+		if n > 0 {
+			o.Retain(n)
+		} else {
+			o.Delete(-n)
+		}
+	}
+	return nil
+}
+
+// OpenAPISchema describes the ot.js format
+func (Operation) OpenAPISchema() *openapi.Schema {
+	return &openapi.Schema{
+		Type:        "array",
+		Description: "ot.js text operation: positive integers retain, negative integers delete and strings insert; lengths in UTF-16 code units",
+		Items:       &openapi.Schema{OneOf: []*openapi.Schema{{Type: "integer"}, {Type: "string"}}},
+	}
+}
+
+// next returns ops[*i] and advances i
+func next(ops []component, i *int) (component, bool) {
+	if *i >= len(ops) {
+		return component{}, false
+	}
+	c := ops[*i]
+	*i++
+	return c, true
+}
+
+func (c component) empty() bool {
+	return c.retain == 0 && len(c.insert) == 0 && c.delete == 0
+}
+
+func concat(a, b []uint16) []uint16 {
+	out := make([]uint16, 0, len(a)+len(b))
+	return append(append(out, a...), b...)
+}
+
+// splitsSurrogatePair reports whether offset falls between the halves of a surrogate pair
+func splitsSurrogatePair(units []uint16, offset int) bool {
+	return offset > 0 && offset < len(units) && units[offset] >= 0xDC00 && units[offset] <= 0xDFFF
+}
@@ -0,0 +1,263 @@
+package collab
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func units(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+// parse decodes an operation in the ot.js format
+func parse(t *testing.T, ops string) Operation {
+	t.Helper()
+	var o Operation
+	if err := json.Unmarshal([]byte(ops), &o); err != nil {
+		t.Fatalf("Failed to parse %s: %v", ops, err)
+	}
+	return o
+}
+
+func apply(t *testing.T, o Operation, doc string) string {
+	t.Helper()
+	out, err := o.Apply(units(doc))
+	if err != nil {
+		t.Fatalf("Failed to apply %v to %q: %v", o, doc, err)
+	}
+	return string(utf16.Decode(out))
+}
+
+func encode(o Operation) string {
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, op, want string
+		problem       string // Empty when the operation applies
+	}{
+		{"hello", `[5, " world"]`, "hello world", ""},
+		{"hello", `["say ", 5]`, "say hello", ""},
+		{"hello", `[1, -3, 1]`, "ho", ""},
+		{"hello", `[-5, "bye"]`, "bye", ""},
+		{"a😀b", `[1, -2, "🎉", 1]`, "a🎉b", ""},            // The emoji is two UTF-16 units
+		{"a😀b", `[2, "x", 2]`, "", "splits a character"}, // Between the halves of the pair
+		{"a😀b", `[1, -1, 2]`, "", "splits a character"},
+		{"hello", `[4]`, "", "length 4, not 5"},
+	}
+	for _, tt := range tests {
+		out, err := parse(t, tt.op).Apply(units(tt.doc))
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("%s on %q: %v", tt.op, tt.doc, err)
+		case tt.problem == "" && string(utf16.Decode(out)) != tt.want:
+			t.Errorf("%s on %q = %q, want %q", tt.op, tt.doc, string(utf16.Decode(out)), tt.want)
+		case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+			t.Errorf("%s on %q: error %v, want %q", tt.op, tt.doc, err, tt.problem)
+		}
+	}
+}
+
+func TestLengthsCountUTF16(t *testing.T) {
+	o := parse(t, `[1, "😀é", -2, 3]`)
+	if o.BaseLength() != 6 || o.TargetLength() != 7 {
+		t.Errorf("lengths = %d, %d; want 6, 7", o.BaseLength(), o.TargetLength())
+	}
+}
+
+func TestBuilderNormalises(t *testing.T) {
+	var o Operation
+	o.Retain(2).Retain(1).Delete(1).Insert(units("a")).Delete(2).Insert(units("b")).Retain(0).Insert(nil)
+	if got := encode(o); got != `[3,"ab",-3]` {
+		t.Errorf("operation = %s; inserts go before deletes and neighbours merge", got)
+	}
+	if !Identity(4).IsNoop() || !(&Operation{}).IsNoop() || o.IsNoop() {
+		t.Error("IsNoop is wrong")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	for _, ops := range []string{`[]`, `[3,"😀",-2]`, `["x"]`} {
+		if got := encode(parse(t, ops)); got != ops {
+			t.Errorf("%s round trips as %s", ops, got)
+		}
+	}
+	for _, bad := range []string{`{}`, `[0]`, `[""]`, `[1.5]`, `[true]`} {
+		var o Operation
+		if err := json.Unmarshal([]byte(bad), &o); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct{ before, after, want string }{
+		{"hello", "hello", `[5]`},
+		{"hello", "help", `[3,"p",-2]`},
+		{"", "abc", `["abc"]`},
+		{"abc", "", `[-3]`},
+		{"a😀b", "a😃b", `[1,"😃",-2,1]`}, // The pairs share a high surrogate, but stay whole
+		{"😀", "😀😀", `[2,"😀"]`},
+	}
+	for _, tt := range tests {
+		o := Diff(units(tt.before), units(tt.after))
+		if got := encode(o); got != tt.want {
+			t.Errorf("Diff(%q, %q) = %s, want %s", tt.before, tt.after, got, tt.want)
+		}
+		if got := apply(t, o, tt.before); got != tt.after {
+			t.Errorf("Diff(%q, %q) applies as %q", tt.before, tt.after, got)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name, doc, a, b, want string
+	}{
+		{"independent", "abcdef", `["X", 6]`, `[6, "Y"]`, "XabcdefY"},
+		{"same position", "ab", `[1, "A", 1]`, `[1, "B", 1]`, "aABb"}, // a's text goes first
+		{"insert inside a delete", "abcdef", `[1, -4, 1]`, `[3, "X", 3]`, "aXf"},
+		{"overlapping deletes", "abcdef", `[1, -3, 2]`, `[2, -3, 1]`, "af"},
+		{"same delete", "abc", `[-3]`, `[-3]`, ""},
+		{"emoji", "😀😀", `[2, "x", 2]`, `[-2, 2]`, "x😀"},
+	}
+	for _, tt := range tests {
+		a, b := parse(t, tt.a), parse(t, tt.b)
+		aPrime, bPrime, err := Transform(a, b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		ab := apply(t, bPrime, apply(t, a, tt.doc))
+		ba := apply(t, aPrime, apply(t, b, tt.doc))
+		if ab != tt.want || ba != tt.want {
+			t.Errorf("%s: a then b' = %q, b then a' = %q; want %q", tt.name, ab, ba, tt.want)
+		}
+	}
+
+	if _, _, err := Transform(Identity(2), Identity(3)); err == nil {
+		t.Error("Transform accepted operations on different lengths")
+	}
+}
+
+func TestCompose(t *testing.T) {
+	tests := []struct{ doc, a, b, want string }{
+		{"abc", `[3, "d"]`, `[-1, 3]`, `[-1,2,"d"]`},
+		{"abc", `["xy", 3]`, `[1, -1, 3]`, `["x",3]`}, // b deletes what a inserted
+		{"abc", `[-3]`, `["new"]`, `["new",-3]`},
+		{"a😀", `[3, "b"]`, `[1, -2, 1]`, `[1,"b",-2]`}, // Inserts go before deletes
+	}
+	for _, tt := range tests {
+		a, b := parse(t, tt.a), parse(t, tt.b)
+		ab, err := Compose(a, b)
+		if err != nil {
+			t.Errorf("Compose(%s, %s): %v", tt.a, tt.b, err)
+			continue
+		}
+		if got := encode(ab); got != tt.want {
+			t.Errorf("Compose(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+		if got, want := apply(t, ab, tt.doc), apply(t, b, apply(t, a, tt.doc)); got != want {
+			t.Errorf("Compose(%s, %s) gives %q, applying both gives %q", tt.a, tt.b, got, want)
+		}
+	}
+
+	if _, err := Compose(Identity(2), Identity(3)); err == nil {
+		t.Error("Compose accepted operations that don't line up")
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	o := parse(t, `[2, "xy", -2, 2]`) // abcdef -> abxyef
+	for index, want := range map[int]int{0: 0, 1: 1, 2: 4, 3: 4, 4: 4, 5: 5, 6: 6} {
+		if got := TransformIndex(o, index); got != want {
+			t.Errorf("TransformIndex(%d) = %d, want %d", index, got, want)
+		}
+	}
+}
+
+// alphabet mixes one- and two-unit characters so random operations cross surrogate pairs
+var alphabet = []rune("ab é😀🎉\n")
+
+func randomText(r *rand.Rand, maxRunes int) []uint16 {
+	runes := make([]rune, r.Intn(maxRunes+1))
+	for i := range runes {
+		runes[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return utf16.Encode(runes)
+}
+
+// randomOperation edits doc at character boundaries, as an editor would
+func randomOperation(r *rand.Rand, doc []uint16) Operation {
+	var o Operation
+	for pos := 0; pos < len(doc); {
+		// Length in units of the next few characters
+		n := 0
+		for chars := 1 + r.Intn(3); chars > 0 && pos+n < len(doc); chars-- {
+			n++
+			if splitsSurrogatePair(doc, pos+n) {
+				n++
+			}
+		}
+		switch r.Intn(4) {
+		case 0:
+			o.Delete(n)
+		case 1:
+			o.Insert(randomText(r, 3))
+			o.Retain(n)
+		default:
+			o.Retain(n)
+		}
+		pos += n
+	}
+	if r.Intn(3) == 0 {
+		o.Insert(randomText(r, 3))
+	}
+	return o
+}
+
+// TestConvergence checks transform and compose on random concurrent edits
+func TestConvergence(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		doc := randomText(r, 12)
+		a, b := randomOperation(r, doc), randomOperation(r, doc)
+
+		aPrime, bPrime, err := Transform(a, b)
+		if err != nil {
+			t.Fatalf("Transform(%s, %s) on %q: %v", encode(a), encode(b), string(utf16.Decode(doc)), err)
+		}
+		afterA, err1 := a.Apply(doc)
+		afterB, err2 := b.Apply(doc)
+		ab, err3 := bPrime.Apply(afterA)
+		ba, err4 := aPrime.Apply(afterB)
+		for _, err := range []error{err1, err2, err3, err4} {
+			if err != nil {
+				t.Fatalf("a = %s, b = %s on %q: %v", encode(a), encode(b), string(utf16.Decode(doc)), err)
+			}
+		}
+		if string(utf16.Decode(ab)) != string(utf16.Decode(ba)) {
+			t.Fatalf("a = %s, b = %s on %q: a then b' = %q, b then a' = %q",
+				encode(a), encode(b), string(utf16.Decode(doc)), string(utf16.Decode(ab)), string(utf16.Decode(ba)))
+		}
+
+		composed, err := Compose(a, bPrime)
+		if err != nil {
+			t.Fatalf("Compose(%s, %s): %v", encode(a), encode(bPrime), err)
+		}
+		if out, err := composed.Apply(doc); err != nil || string(utf16.Decode(out)) != string(utf16.Decode(ab)) {
+			t.Fatalf("Compose(%s, %s) on %q = %q, %v; want %q",
+				encode(a), encode(bPrime), string(utf16.Decode(doc)), string(utf16.Decode(out)), err, string(utf16.Decode(ab)))
+		}
+
+		diff := Diff(doc, ab)
+		if out, err := diff.Apply(doc); err != nil || string(utf16.Decode(out)) != string(utf16.Decode(ab)) {
+			t.Fatalf("Diff(%q, %q) = %s applies as %q, %v", string(utf16.Decode(doc)), string(utf16.Decode(ab)), encode(diff), string(utf16.Decode(out)), err)
+		}
+	}
+}
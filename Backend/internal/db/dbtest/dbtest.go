@@ -0,0 +1,56 @@
+// Package dbtest runs tests against a throwaway MongoDB database. Set
+// MONGO_TEST_URI to a server the tests may create and drop databases on;
+// without it, tests that need MongoDB are skipped.
+package dbtest
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	connectOnce sync.Once
+	client      *mongo.Client
+	connectErr  error
+)
+
+// Setup points the db package at a new, empty database that is dropped when
+// the test ends. Tests using it must not run in parallel.
+func Setup(t testing.TB) {
+	t.Helper()
+
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+
+	connectOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		client, connectErr = mongo.Connect(ctx, options.Client().ApplyURI(uri).SetServerSelectionTimeout(5*time.Second))
+		if connectErr == nil {
+			connectErr = client.Ping(ctx, nil)
+		}
+	})
+	if connectErr != nil {
+		t.Fatalf("Failed to connect to MONGO_TEST_URI: %v", connectErr)
+	}
+
+	db.Use(client, "codeflow_test_"+primitive.NewObjectID().Hex())
+	database := db.Database
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		database.Drop(ctx)
+	})
+}
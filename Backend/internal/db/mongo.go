@@ -6,6 +6,9 @@ import (
 	"os"
 	"time"
 
+	"codeflow-backend/internal/auth"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -13,6 +16,10 @@ import (
 var Client *mongo.Client
 var Database *mongo.Database
 
+// SupportsTransactions reports whether the deployment is a replica set or sharded
+// cluster; standalone servers can't run multi-document transactions
+var SupportsTransactions bool
+
 // ConnectMongoDB initializes the MongoDB connection
 func ConnectMongoDB() {
 	mongoURI := os.Getenv("MONGO_URI")
@@ -43,8 +50,31 @@ func ConnectMongoDB() {
 		log.Fatalf("Failed to ping MongoDB: %v", err)
 	}
 
-	Database = Client.Database(dbName)
+	Use(Client, dbName)
 	log.Printf("Connected to MongoDB database: %s", dbName)
+	log.Println("Database indexes created successfully")
+	if !SupportsTransactions {
+		log.Println("MongoDB is a standalone server, batch operations will not be atomic")
+	}
+}
+
+// Use points the package at the named database on a connected client and
+// creates its indexes. Tests use it to switch to a throwaway database.
+func Use(client *mongo.Client, dbName string) {
+	Client = client
+	Database = client.Database(dbName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	SupportsTransactions = false
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err == nil {
+		SupportsTransactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	}
 
 	// Create indexes
 	createIndexes()
@@ -54,12 +84,85 @@ func ConnectMongoDB() {
 func createIndexes() {
 	ctx := context.Background()
 
+	// Users collection indexes
+	usersCollection := Database.Collection("users")
+	usersCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: map[string]interface{}{"email": 1}, Options: options.Index().SetUnique(true)},
+		{
+			Keys: bson.D{{Key: "oidcIssuer", Value: 1}, {Key: "oidcSubject", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"oidcSubject": bson.M{"$exists": true}}),
+		},
+	})
+
+	// API tokens collection indexes
+	apiTokensCollection := Database.Collection("api_tokens")
+	apiTokensCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: map[string]interface{}{"tokenHash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: map[string]interface{}{"userId": 1}},
+	})
+
 	// Spaces collection indexes
 	spacesCollection := Database.Collection("spaces")
 	spacesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: map[string]interface{}{"userId": 1},
 	})
 
+	// Space members and invitations collection indexes
+	spaceMembersCollection := Database.Collection("space_members")
+	spaceMembersCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "spaceId", Value: 1}, {Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: map[string]interface{}{"userId": 1}},
+	})
+	spaceInvitationsCollection := Database.Collection("space_invitations")
+	spaceInvitationsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "spaceId", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: map[string]interface{}{"tokenHash": 1}},
+		{Keys: map[string]interface{}{"expiresAt": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	// Secrets collection indexes
+	secretsCollection := Database.Collection("secrets")
+	secretsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "spaceId", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	// Webhooks collection indexes; due deliveries are found by status and time
+	webhooksCollection := Database.Collection("webhooks")
+	webhooksCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{"spaceId": 1},
+	})
+	webhookDeliveriesCollection := Database.Collection("webhook_deliveries")
+	webhookDeliveriesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		{Keys: map[string]interface{}{"spaceId": 1}},
+	})
+
+	// Daily quota counters expire the day after they stop counting
+	quotaCountersCollection := Database.Collection("quota_counters")
+	quotaCountersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    map[string]interface{}{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	// Audit collection indexes
+	auditCollection := Database.Collection("audit")
+	auditCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "spaceId", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+
+	// Shares collection indexes, after replacing stored tokens with their hashes
+	sharesCollection := Database.Collection("shares")
+	hashShareTokens(ctx, sharesCollection)
+	sharesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: map[string]interface{}{"tokenHash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: map[string]interface{}{"spaceId": 1}},
+		{Keys: map[string]interface{}{"createdBy": 1}},
+	})
+
 	// Vaults collection indexes
 	vaultsCollection := Database.Collection("vaults")
 	vaultsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
@@ -77,7 +180,49 @@ func createIndexes() {
 		{Keys: map[string]interface{}{"path": 1}},
 	})
 
-	log.Println("Database indexes created successfully")
+	// Chat sessions collection indexes
+	chatSessionsCollection := Database.Collection("chat_sessions")
+	chatSessionsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: map[string]interface{}{"userId": 1}},
+		{Keys: map[string]interface{}{"spaceId": 1}},
+		{Keys: map[string]interface{}{"logId": 1}},
+	})
+
+	// AI usage collection indexes
+	aiUsageCollection := Database.Collection("ai_usage")
+	aiUsageCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "spaceId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+
+}
+
+// hashShareTokens replaces the plaintext tokens that share links used to be
+// stored with by their hashes, so the links keep working
+func hashShareTokens(ctx context.Context, shares *mongo.Collection) {
+	// The unique index on the plaintext would reject a second link without one
+	shares.Indexes().DropOne(ctx, "token_1")
+
+	cursor, err := shares.Find(ctx, bson.M{"token": bson.M{"$exists": true}}, options.Find().SetProjection(bson.M{"token": 1}))
+	if err != nil {
+		log.Printf("Failed to find share links to hash: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var share struct {
+			ID    interface{} `bson:"_id"`
+			Token string      `bson:"token"`
+		}
+		if err := cursor.Decode(&share); err != nil {
+			continue
+		}
+		update := bson.M{"$set": bson.M{"tokenHash": auth.HashAPIToken(share.Token)}, "$unset": bson.M{"token": ""}}
+		if _, err := shares.UpdateOne(ctx, bson.M{"_id": share.ID}, update); err != nil {
+			log.Printf("Failed to hash share link token: %v", err)
+		}
+	}
 }
 
 // DisconnectMongoDB closes the MongoDB connection
@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/db/dbtest"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestUseHashesPlaintextShareTokens(t *testing.T) {
+	dbtest.Setup(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	shares := db.Database.Collection("shares")
+	shares.Indexes().DropOne(ctx, "tokenHash_1")
+	unique := mongo.IndexModel{Keys: bson.M{"token": 1}, Options: options.Index().SetUnique(true)}
+	if _, err := shares.Indexes().CreateOne(ctx, unique); err != nil {
+		t.Fatal(err)
+	}
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	for i, token := range []string{"first", "second"} {
+		if _, err := shares.InsertOne(ctx, bson.M{"_id": ids[i], "token": token}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Connecting again migrates the links stored before tokens were hashed
+	db.Use(db.Client, db.Database.Name())
+
+	for i, token := range []string{"first", "second"} {
+		var share bson.M
+		if err := shares.FindOne(ctx, bson.M{"_id": ids[i]}).Decode(&share); err != nil {
+			t.Fatal(err)
+		}
+		if share["tokenHash"] != auth.HashAPIToken(token) || share["token"] != nil {
+			t.Errorf("share %d = %v, want the token replaced by its hash", i, share)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// watchedCollections hold the documents the change feed reports on
+var watchedCollections = []string{"spaces", "vaults", "logs"}
+
+// changeEvent is the part of a change stream document the feed uses
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	NS            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument             bson.Raw `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.Raw `bson:"fullDocumentBeforeChange"`
+	UpdateDescription        struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// watchDatabase opens a change stream on the watched collections and feeds bus
+// from it in the background. Deletes only carry the document's ID, so the
+// collections record pre-images to find the space of a deleted vault or log;
+// that needs MongoDB 6.0 or later.
+func watchDatabase(database *mongo.Database, bus *Bus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, name := range watchedCollections {
+		command := bson.D{
+			{Key: "collMod", Value: name},
+			{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+		}
+		if err := database.RunCommand(ctx, command).Err(); err != nil {
+			return err
+		}
+	}
+
+	stream, err := openStream(ctx, database, nil)
+	if err != nil {
+		return err
+	}
+
+	go feed(database, stream, bus)
+	return nil
+}
+
+func openStream(ctx context.Context, database *mongo.Database, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"ns.coll":       bson.M{"$in": watchedCollections},
+		"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}},
+	}}}}
+
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+	return database.Watch(ctx, pipeline, opts)
+}
+
+// feed publishes the stream's changes until the process exits, reopening the
+// stream where it left off after errors
+func feed(database *mongo.Database, stream *mongo.ChangeStream, bus *Bus) {
+	ctx := context.Background()
+	backoff := time.Second
+
+	for {
+		for stream.Next(ctx) {
+			backoff = time.Second
+
+			var change changeEvent
+			if err := stream.Decode(&change); err != nil {
+				log.Printf("Failed to decode change event: %v", err)
+				continue
+			}
+			if event, ok := toEvent(change); ok {
+				bus.Publish(event)
+			}
+		}
+
+		resumeToken := stream.ResumeToken()
+		log.Printf("Change stream stopped: %v; reopening in %s", stream.Err(), backoff)
+		stream.Close(ctx)
+
+		for {
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+
+			openCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			var err error
+			stream, err = openStream(openCtx, database, resumeToken)
+			if err != nil && resumeToken != nil {
+				// The token may have fallen off the oplog; changes in between are lost
+				log.Printf("Failed to resume change stream (%v), starting from now", err)
+				resumeToken = nil
+				stream, err = openStream(openCtx, database, nil)
+			}
+			cancel()
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to reopen change stream: %v", err)
+		}
+	}
+}
+
+// toEvent converts a change to a feed event; ok is false for changes the feed
+// doesn't report, such as new spaces, which nobody can be watching yet
+func toEvent(change changeEvent) (Event, bool) {
+	if change.OperationType == "delete" {
+		return deleteEvent(change)
+	}
+
+	// The document was deleted before the update could be looked up; its delete follows
+	if change.FullDocument == nil {
+		return Event{}, false
+	}
+
+	switch change.NS.Coll {
+	case "spaces":
+		var space models.Space
+		if change.OperationType == "insert" || bson.Unmarshal(change.FullDocument, &space) != nil {
+			return Event{}, false
+		}
+		return ForSpace(SpaceUpdated, space), true
+
+	case "vaults":
+		var vault models.Vault
+		if bson.Unmarshal(change.FullDocument, &vault) != nil {
+			return Event{}, false
+		}
+		eventType := VaultUpdated
+		switch {
+		case change.OperationType == "insert":
+			eventType = VaultCreated
+		case change.changed("parentId"):
+			eventType = VaultMoved
+		}
+		return ForVault(eventType, vault), true
+
+	case "logs":
+		var log models.Log
+		if bson.Unmarshal(change.FullDocument, &log) != nil {
+			return Event{}, false
+		}
+		eventType := LogUpdated
+		switch {
+		case change.OperationType == "insert":
+			eventType = LogCreated
+		case change.changed("vaultId"):
+			eventType = LogMoved
+		}
+		return ForLog(eventType, log), true
+	}
+	return Event{}, false
+}
+
+func deleteEvent(change changeEvent) (Event, bool) {
+	if change.NS.Coll == "spaces" {
+		return Deleted(SpaceDeleted, change.DocumentKey.ID, change.DocumentKey.ID), true
+	}
+
+	var before struct {
+		SpaceID primitive.ObjectID `bson:"spaceId"`
+	}
+	if change.FullDocumentBeforeChange == nil || bson.Unmarshal(change.FullDocumentBeforeChange, &before) != nil {
+		log.Printf("No pre-image for deleted %s %s, skipping its event", change.NS.Coll, change.DocumentKey.ID.Hex())
+		return Event{}, false
+	}
+
+	eventType := VaultDeleted
+	if change.NS.Coll == "logs" {
+		eventType = LogDeleted
+	}
+	return Deleted(eventType, before.SpaceID, change.DocumentKey.ID), true
+}
+
+// changed reports whether an update set or removed field
+func (c changeEvent) changed(field string) bool {
+	if c.UpdateDescription.UpdatedFields != nil {
+		if _, err := c.UpdateDescription.UpdatedFields.LookupErr(field); err == nil {
+			return true
+		}
+	}
+	for _, removed := range c.UpdateDescription.RemovedFields {
+		if removed == field {
+			return true
+		}
+	}
+	return false
+}
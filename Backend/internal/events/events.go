@@ -0,0 +1,195 @@
+// Package events delivers changes to spaces, vaults and logs to the clients
+// watching a space. On a replica set the changes come from MongoDB change
+// streams, so every API instance sees writes made by the others; on a
+// standalone server handlers publish their own writes to an in-process bus.
+package events
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Event types
+const (
+	SpaceUpdated = api.SpaceUpdated
+	SpaceDeleted = api.SpaceDeleted
+	VaultCreated = api.VaultCreated
+	VaultUpdated = api.VaultUpdated
+	VaultMoved   = api.VaultMoved
+	VaultDeleted = api.VaultDeleted
+	LogCreated   = api.LogCreated
+	LogUpdated   = api.LogUpdated
+	LogMoved     = api.LogMoved
+	LogDeleted   = api.LogDeleted
+	RunCompleted = api.RunCompleted
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 256
+
+// Event is a change to a space or something in it
+type Event = api.Event
+
+// Run summarises a finished code run
+type Run = api.RunSummary
+
+// ForSpace is an event carrying a space after the change
+func ForSpace(eventType string, space models.Space) Event {
+	return Event{Type: eventType, SpaceID: space.ID, ID: space.ID, Version: space.Version, Space: &space, At: time.Now()}
+}
+
+// ForVault is an event carrying a vault after the change
+func ForVault(eventType string, vault models.Vault) Event {
+	return Event{Type: eventType, SpaceID: vault.SpaceID, ID: vault.ID, Version: vault.Version, Vault: &vault, At: time.Now()}
+}
+
+// ForLog is an event carrying a log after the change, without its code
+func ForLog(eventType string, log models.Log) Event {
+	log.Code = ""
+	return Event{Type: eventType, SpaceID: log.SpaceID, ID: log.ID, Version: log.Version, Log: &log, At: time.Now()}
+}
+
+// ForRun is the event for a finished run in a space; its ID is the log that ran, or the space
+func ForRun(spaceID primitive.ObjectID, run Run) Event {
+	id := spaceID
+	if run.LogID != nil {
+		id = *run.LogID
+	}
+	return Event{Type: RunCompleted, SpaceID: spaceID, ID: id, Run: &run, At: time.Now()}
+}
+
+// Deleted is the event for a deleted space, vault or log
+func Deleted(eventType string, spaceID, id primitive.ObjectID) Event {
+	return Event{Type: eventType, SpaceID: spaceID, ID: id, At: time.Now()}
+}
+
+// Subscription receives the events of one space until it is closed
+type Subscription struct {
+	Events <-chan Event // Closed when the subscriber falls too far behind or is closed
+
+	bus     *Bus
+	spaceID primitive.ObjectID
+	events  chan Event
+	closed  bool
+}
+
+// Close stops delivery and releases the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// Bus fans events out to the subscribers of each space
+type Bus struct {
+	mu   sync.Mutex
+	subs map[primitive.ObjectID]map[*Subscription]struct{}
+}
+
+// NewBus returns an empty bus
+func NewBus() *Bus {
+	return &Bus{subs: map[primitive.ObjectID]map[*Subscription]struct{}{}}
+}
+
+// Subscribe starts receiving the events of a space
+func (b *Bus) Subscribe(spaceID primitive.ObjectID) *Subscription {
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: events, bus: b, spaceID: spaceID, events: events}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[spaceID] == nil {
+		b.subs[spaceID] = map[*Subscription]struct{}{}
+	}
+	b.subs[spaceID][sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers an event to the space's subscribers without blocking. A
+// subscriber whose buffer is full is dropped, so it can reconnect and reload
+// rather than silently miss changes.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[event.SpaceID] {
+		select {
+		case sub.events <- event:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// remove unsubscribes sub; the caller holds b.mu
+func (b *Bus) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+
+	delete(b.subs[sub.spaceID], sub)
+	if len(b.subs[sub.spaceID]) == 0 {
+		delete(b.subs, sub.spaceID)
+	}
+}
+
+// Default is the bus the API's feeds subscribe to
+var Default = NewBus()
+
+// watching is set while change streams feed Default
+var watching atomic.Bool
+
+// listeners are called with every event this instance publishes
+var listeners []func(Event)
+
+// Init picks where events come from. With changeStreams (a replica set or
+// sharded cluster) they are read from MongoDB unless EVENTS_BACKEND is
+// "memory"; otherwise Publish feeds the bus directly.
+func Init(database *mongo.Database, changeStreams bool) {
+	if !changeStreams || os.Getenv("EVENTS_BACKEND") == "memory" {
+		log.Println("Change feed using the in-process event bus")
+		return
+	}
+
+	if err := watchDatabase(database, Default); err != nil {
+		log.Printf("Change streams unavailable (%v), change feed using the in-process event bus", err)
+		return
+	}
+	watching.Store(true)
+	log.Println("Change feed using MongoDB change streams")
+}
+
+// Listen calls fn with every event this instance publishes, once per change
+// whatever feeds the bus. fn must not block. Register listeners before serving.
+func Listen(fn func(Event)) {
+	listeners = append(listeners, fn)
+}
+
+// Publish announces a change made by this instance to listeners and to the
+// space's subscribers. While change streams feed the bus, stored changes reach
+// subscribers from the stream instead, since it reports them to every instance.
+func Publish(event Event) {
+	for _, listen := range listeners {
+		listen(event)
+	}
+	if watching.Load() && event.Type != RunCompleted {
+		return
+	}
+	Default.Publish(event)
+}
+
+// Subscribe starts receiving the events of a space from the default bus
+func Subscribe(spaceID primitive.ObjectID) *Subscription {
+	return Default.Subscribe(spaceID)
+}
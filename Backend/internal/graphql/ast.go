@@ -0,0 +1,164 @@
+package graphql
+
+// Location is a line and column in the query, both starting at 1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// document is a parsed query document
+type document struct {
+	operations []*operationDefinition
+	fragments  []*fragmentDefinition
+}
+
+// operationDefinition is a query, mutation or subscription
+type operationDefinition struct {
+	kind         string // "query", "mutation" or "subscription"
+	name         string
+	variables    []*variableDefinition
+	directives   []*directive
+	selectionSet []selection
+	loc          Location
+}
+
+type variableDefinition struct {
+	name         string
+	typ          *typeRef
+	defaultValue *value
+	loc          Location
+}
+
+// typeRef is a type as written in a variable definition
+type typeRef struct {
+	name    string   // Set for named types
+	elem    *typeRef // Set for list types
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// selection is a *field, *fragmentSpread or *inlineFragment
+type selection interface {
+	location() Location
+	directiveList() []*directive
+}
+
+type field struct {
+	alias        string
+	name         string
+	arguments    []*argument
+	directives   []*directive
+	selectionSet []selection
+	loc          Location
+}
+
+// responseKey is the key the field's value is returned under
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	typeCondition string // Empty when omitted
+	directives    []*directive
+	selectionSet  []selection
+	loc           Location
+}
+
+func (f *field) location() Location          { return f.loc }
+func (f *fragmentSpread) location() Location { return f.loc }
+func (f *inlineFragment) location() Location { return f.loc }
+
+func (f *field) directiveList() []*directive          { return f.directives }
+func (f *fragmentSpread) directiveList() []*directive { return f.directives }
+func (f *inlineFragment) directiveList() []*directive { return f.directives }
+
+type fragmentDefinition struct {
+	name          string
+	typeCondition string
+	directives    []*directive
+	selectionSet  []selection
+	loc           Location
+}
+
+type directive struct {
+	name      string
+	arguments []*argument
+	loc       Location
+}
+
+type argument struct {
+	name  string
+	value *value
+	loc   Location
+}
+
+// valueKind is the kind of a literal value
+type valueKind int
+
+const (
+	variableValue valueKind = iota
+	intValue
+	floatValue
+	stringValue
+	booleanValue
+	nullValue
+	enumValue
+	listValue
+	objectValue
+)
+
+// value is a literal or variable in the query. raw holds the variable name,
+// number, decoded string, "true"/"false" or enum name.
+type value struct {
+	kind   valueKind
+	raw    string
+	list   []*value
+	fields []*objectField
+	loc    Location
+}
+
+type objectField struct {
+	name  string
+	value *value
+	loc   Location
+}
+
+// hasVariables reports whether the value refers to a variable anywhere
+func (v *value) hasVariables() bool {
+	switch v.kind {
+	case variableValue:
+		return true
+	case listValue:
+		for _, item := range v.list {
+			if item.hasVariables() {
+				return true
+			}
+		}
+	case objectValue:
+		for _, f := range v.fields {
+			if f.value.hasVariables() {
+				return true
+			}
+		}
+	}
+	return false
+}
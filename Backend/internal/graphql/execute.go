@@ -0,0 +1,638 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ResolveFunc resolves a field for a batch of parents. It returns one value per
+// source, in order; a value that is an error fails that source's field alone.
+// Returning an error fails the field for every source.
+type ResolveFunc func(p ResolveParams) ([]interface{}, error)
+
+// ResolveParams is what a resolver receives
+type ResolveParams struct {
+	Context context.Context
+	Sources []interface{} // The parent objects; nil for root fields
+	Args    map[string]interface{}
+
+	selections func() map[string]bool
+}
+
+// Source returns the only source of a root field
+func (p ResolveParams) Source() interface{} {
+	if len(p.Sources) == 0 {
+		return nil
+	}
+	return p.Sources[0]
+}
+
+// Selects reports whether the query asks for the named field of the value
+// being resolved, so a resolver can skip loading data nobody asked for
+func (p ResolveParams) Selects(name string) bool {
+	return p.selections != nil && p.selections()[name]
+}
+
+// Each adapts a resolver for one parent at a time
+func Each(resolve func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)) ResolveFunc {
+	return func(p ResolveParams) ([]interface{}, error) {
+		values := make([]interface{}, len(p.Sources))
+		for i, source := range p.Sources {
+			value, err := resolve(p.Context, source, p.Args)
+			if err != nil {
+				values[i] = err
+			} else {
+				values[i] = value
+			}
+		}
+		return values, nil
+	}
+}
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Operation is a validated operation with its variables, ready to execute
+type Operation struct {
+	schema    *Schema
+	def       *operationDefinition
+	fragments map[string]*fragmentDefinition
+	variables map[string]interface{}
+}
+
+// Prepare parses and validates a request and picks the operation to run
+func (s *Schema) Prepare(req Request) (*Operation, []*Error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, []*Error{{Message: "Must provide query string."}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return nil, []*Error{asError(err)}
+	}
+	if errs := s.validate(doc); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var def *operationDefinition
+	for _, op := range doc.operations {
+		if req.OperationName == "" || op.name == req.OperationName {
+			if def != nil {
+				return nil, []*Error{{Message: "Must provide operation name if query contains multiple operations."}}
+			}
+			def = op
+		}
+	}
+	if def == nil {
+		return nil, []*Error{{Message: fmt.Sprintf("Unknown operation named \"%s\".", req.OperationName)}}
+	}
+
+	variables, errs := s.coerceVariables(def.variables, req.Variables)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	fragments := map[string]*fragmentDefinition{}
+	for _, fragment := range doc.fragments {
+		fragments[fragment.name] = fragment
+	}
+	return &Operation{schema: s, def: def, fragments: fragments, variables: variables}, nil
+}
+
+// Kind returns "query" or "mutation"
+func (o *Operation) Kind() string {
+	return o.def.kind
+}
+
+// Execute runs the operation. Root fields run one after another, each
+// completed before the next starts, so mutations apply in order.
+func (o *Operation) Execute(ctx context.Context) *Response {
+	root := o.schema.query
+	if o.def.kind == "mutation" {
+		root = o.schema.mutation
+	}
+
+	e := &executor{operation: o, ctx: ctx}
+	data := e.executeObjects(root, []interface{}{nil}, []*path{nil}, o.def.selectionSet)[0]
+
+	response := &Response{Errors: e.errors}
+	if m, ok := data.(*orderedMap); ok {
+		response.Data = m
+	} else {
+		response.Data = json.RawMessage("null")
+	}
+	return response
+}
+
+// path is the response path of a value, as a linked list
+type path struct {
+	parent *path
+	key    interface{} // A response key or a list index
+}
+
+func (p *path) with(key interface{}) *path {
+	return &path{parent: p, key: key}
+}
+
+func (p *path) slice() []interface{} {
+	var keys []interface{}
+	for ; p != nil; p = p.parent {
+		keys = append([]interface{}{p.key}, keys...)
+	}
+	return keys
+}
+
+// Markers in completed values
+type (
+	// failed is a resolved value whose error has been recorded
+	failed struct{}
+	// erroredNull is a null caused by an error that has been recorded
+	erroredNull struct{}
+	// propagate is a null in a non-null position, which nulls the parent
+	propagate struct{}
+)
+
+type executor struct {
+	operation *Operation
+	ctx       context.Context
+
+	mu     sync.Mutex
+	errors []*Error
+}
+
+// fail records an error at a path
+func (e *executor) fail(err error, p *path, loc Location) {
+	gqlErr := asError(err)
+	copied := *gqlErr
+	copied.Path = p.slice()
+	if copied.Locations == nil {
+		copied.Locations = []Location{loc}
+	}
+	e.mu.Lock()
+	e.errors = append(e.errors, &copied)
+	e.mu.Unlock()
+}
+
+func asError(err error) *Error {
+	if gqlErr, ok := err.(*Error); ok {
+		return gqlErr
+	}
+	return &Error{Message: err.Error()}
+}
+
+// executeObjects resolves a selection set on a batch of objects of one type.
+// Each result is an *orderedMap, or erroredNull if a non-null field failed.
+func (e *executor) executeObjects(t *Object, sources []interface{}, paths []*path, selections []selection) []interface{} {
+	keys, groups := e.collectFields(t, selections, map[string]bool{})
+
+	results := make([]interface{}, len(sources))
+	objects := make([]*orderedMap, len(sources))
+	for i := range sources {
+		objects[i] = &orderedMap{values: make(map[string]interface{}, len(keys))}
+		results[i] = objects[i]
+	}
+
+	for _, key := range keys {
+		fields := groups[key]
+		definition := e.operation.schema.fieldDefinition(t, fields[0].name)
+
+		// Skip objects already nulled by an earlier field
+		var live []int
+		for i := range sources {
+			if results[i] == (erroredNull{}) {
+				continue
+			}
+			live = append(live, i)
+		}
+		if len(live) == 0 {
+			break
+		}
+		liveSources := make([]interface{}, len(live))
+		livePaths := make([]*path, len(live))
+		for j, i := range live {
+			liveSources[j] = sources[i]
+			livePaths[j] = paths[i].with(key)
+		}
+
+		values := e.resolveField(t, definition, fields, liveSources, livePaths)
+		completed := e.completeValues(t, definition, definition.Type, fields, values, livePaths)
+		for j, i := range live {
+			switch value := completed[j].(type) {
+			case propagate:
+				results[i] = erroredNull{}
+			case erroredNull:
+				objects[i].set(key, nil)
+			default:
+				objects[i].set(key, value)
+			}
+		}
+	}
+	return results
+}
+
+// collectFields groups the fields to resolve on t by response key, applying
+// @skip and @include and following fragments
+func (e *executor) collectFields(t *Object, selections []selection, visited map[string]bool) ([]string, map[string][]*field) {
+	var keys []string
+	groups := map[string][]*field{}
+	var collect func([]selection)
+	collect = func(selections []selection) {
+		for _, s := range selections {
+			if !e.included(s.directiveList()) {
+				continue
+			}
+			switch s := s.(type) {
+			case *field:
+				key := s.responseKey()
+				if groups[key] == nil {
+					keys = append(keys, key)
+				}
+				groups[key] = append(groups[key], s)
+			case *fragmentSpread:
+				fragment := e.operation.fragments[s.name]
+				if visited[s.name] || fragment.typeCondition != t.Name {
+					continue
+				}
+				visited[s.name] = true
+				collect(fragment.selectionSet)
+			case *inlineFragment:
+				if s.typeCondition == "" || s.typeCondition == t.Name {
+					collect(s.selectionSet)
+				}
+			}
+		}
+	}
+	collect(selections)
+	return keys, groups
+}
+
+// included applies @skip and @include
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		args, err := coerceArguments(conditionArgs, d.arguments, e.operation.variables)
+		if err != nil {
+			continue
+		}
+		condition, _ := args["if"].(bool)
+		if d.name == "skip" && condition || d.name == "include" && !condition {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveField calls the field's resolver once for every source. Values that
+// failed are replaced with failed{}.
+func (e *executor) resolveField(t *Object, definition *Field, fields []*field, sources []interface{}, paths []*path) []interface{} {
+	loc := fields[0].loc
+	values := make([]interface{}, len(sources))
+	failAll := func(err error) []interface{} {
+		for i := range values {
+			e.fail(err, paths[i], loc)
+			values[i] = failed{}
+		}
+		return values
+	}
+
+	switch fields[0].name {
+	case "__typename":
+		for i := range values {
+			values[i] = t.Name
+		}
+		return values
+	case "__schema":
+		values[0] = e.operation.schema
+		return values
+	}
+
+	args, err := coerceArguments(definition.Args, fields[0].arguments, e.operation.variables)
+	if err != nil {
+		return failAll(err)
+	}
+
+	if fields[0].name == "__type" {
+		name, _ := args["name"].(string)
+		if t, ok := e.operation.schema.types[name]; ok {
+			values[0] = t
+		}
+		return values
+	}
+
+	resolve := definition.Resolve
+	if resolve == nil {
+		resolve = defaultResolve(definition.Name)
+	}
+	params := ResolveParams{Context: e.ctx, Sources: sources, Args: args}
+	if object, ok := namedType(definition.Type).(*Object); ok {
+		var once sync.Once
+		var selected map[string]bool
+		params.selections = func() map[string]bool {
+			once.Do(func() {
+				keys, groups := e.collectFields(object, mergedSelections(fields), map[string]bool{})
+				selected = make(map[string]bool, len(keys))
+				for _, key := range keys {
+					selected[groups[key][0].name] = true
+				}
+			})
+			return selected
+		}
+	}
+
+	resolved, err := e.call(resolve, params)
+	if err != nil {
+		return failAll(err)
+	}
+	if len(resolved) != len(sources) {
+		return failAll(fmt.Errorf("Resolver for %s.%s returned %d values for %d sources", t.Name, definition.Name, len(resolved), len(sources)))
+	}
+	for i, value := range resolved {
+		if err, ok := value.(error); ok {
+			e.fail(err, paths[i], loc)
+			value = failed{}
+		}
+		values[i] = value
+	}
+	return values
+}
+
+// call runs a resolver, turning a panic into an error
+func (e *executor) call(resolve ResolveFunc, params ResolveParams) (values []interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("graphql: resolver panicked: %v", recovered)
+			values, err = nil, &Error{Message: "Internal server error"}
+		}
+	}()
+	return resolve(params)
+}
+
+func mergedSelections(fields []*field) []selection {
+	if len(fields) == 1 {
+		return fields[0].selectionSet
+	}
+	var merged []selection
+	for _, f := range fields {
+		merged = append(merged, f.selectionSet...)
+	}
+	return merged
+}
+
+// completeValues turns resolved values into response values of type t. Nested
+// objects and list items of every value are resolved together.
+func (e *executor) completeValues(parent *Object, definition *Field, t Type, fields []*field, values []interface{}, paths []*path) []interface{} {
+	completed := make([]interface{}, len(values))
+	loc := fields[0].loc
+
+	if nonNull, ok := t.(*NonNull); ok {
+		inner := e.completeValues(parent, definition, nonNull.OfType, fields, values, paths)
+		for i, value := range inner {
+			switch value {
+			case nil:
+				e.fail(fmt.Errorf("Cannot return null for non-nullable field %s.%s.", parent.Name, definition.Name), paths[i], loc)
+				completed[i] = propagate{}
+			case erroredNull{}:
+				completed[i] = propagate{}
+			default:
+				completed[i] = value
+			}
+		}
+		return completed
+	}
+
+	// Nulls and failures stay null; the rest are completed below
+	var live []int
+	for i, value := range values {
+		switch {
+		case value == (failed{}):
+			completed[i] = erroredNull{}
+		case isNil(value):
+			completed[i] = nil
+		default:
+			live = append(live, i)
+		}
+	}
+	if len(live) == 0 {
+		return completed
+	}
+
+	switch t := t.(type) {
+	case *List:
+		var items []interface{}
+		var itemPaths []*path
+		counts := map[int]int{}
+		for _, i := range live {
+			rv := reflect.ValueOf(deref(values[i]))
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				e.fail(fmt.Errorf("Expected Iterable, but did not find one for field \"%s.%s\".", parent.Name, definition.Name), paths[i], loc)
+				completed[i] = erroredNull{}
+				continue
+			}
+			counts[i] = rv.Len()
+			for j := 0; j < rv.Len(); j++ {
+				items = append(items, rv.Index(j).Interface())
+				itemPaths = append(itemPaths, paths[i].with(j))
+			}
+		}
+
+		completedItems := e.completeValues(parent, definition, t.OfType, fields, items, itemPaths)
+		offset := 0
+		for _, i := range live {
+			count, ok := counts[i]
+			if !ok {
+				continue
+			}
+			list := make([]interface{}, count)
+			for j := range list {
+				switch item := completedItems[offset+j]; item {
+				case propagate{}:
+					completed[i] = erroredNull{}
+				case erroredNull{}:
+					list[j] = nil
+				default:
+					list[j] = item
+				}
+			}
+			if completed[i] == nil {
+				completed[i] = list
+			}
+			offset += count
+		}
+	case *Object:
+		sources := make([]interface{}, len(live))
+		objectPaths := make([]*path, len(live))
+		for j, i := range live {
+			sources[j] = values[i]
+			objectPaths[j] = paths[i]
+		}
+		objects := e.executeObjects(t, sources, objectPaths, mergedSelections(fields))
+		for j, i := range live {
+			completed[i] = objects[j]
+		}
+	case *Scalar:
+		for _, i := range live {
+			serialized, err := t.Serialize(deref(values[i]))
+			if err != nil {
+				e.fail(err, paths[i], loc)
+				completed[i] = erroredNull{}
+				continue
+			}
+			completed[i] = serialized
+		}
+	case *Enum:
+		for _, i := range live {
+			completed[i] = erroredNull{}
+			value := deref(values[i])
+			for _, enumValue := range t.Values {
+				if reflect.DeepEqual(enumValue.goValue(), value) {
+					completed[i] = enumValue.Name
+					break
+				}
+			}
+			if completed[i] == (erroredNull{}) {
+				e.fail(fmt.Errorf("Enum \"%s\" cannot represent value: %s", t.Name, describe(value)), paths[i], loc)
+			}
+		}
+	}
+	return completed
+}
+
+// isNil reports whether v is nil or a nil pointer
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// deref follows pointers to the value they point to
+func deref(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
+
+// defaultResolve reads a field of each source: a map key, or a struct field
+// whose JSON name or Go name matches
+func defaultResolve(name string) ResolveFunc {
+	return func(p ResolveParams) ([]interface{}, error) {
+		values := make([]interface{}, len(p.Sources))
+		for i, source := range p.Sources {
+			values[i] = readField(source, name)
+		}
+		return values, nil
+	}
+}
+
+var fieldIndexes sync.Map // map[fieldKey][]int
+
+type fieldKey struct {
+	t    reflect.Type
+	name string
+}
+
+func readField(source interface{}, name string) interface{} {
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		value := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !value.IsValid() {
+			return nil
+		}
+		return value.Interface()
+	case reflect.Struct:
+		key := fieldKey{rv.Type(), name}
+		index, ok := fieldIndexes.Load(key)
+		if !ok {
+			index = structField(rv.Type(), name)
+			fieldIndexes.Store(key, index)
+		}
+		if index.([]int) == nil {
+			return nil
+		}
+		value, err := rv.FieldByIndexErr(index.([]int))
+		if err != nil {
+			return nil
+		}
+		return value.Interface()
+	}
+	return nil
+}
+
+// structField finds the index of the exported field named name in JSON, or nil
+func structField(t reflect.Type, name string) []int {
+	var byGoName []int
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == name {
+			return f.Index
+		}
+		if tag == "" && strings.EqualFold(f.Name, name) && byGoName == nil {
+			byGoName = f.Index
+		}
+	}
+	return byGoName
+}
+
+// orderedMap is a JSON object that keeps its keys in insertion order
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,327 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type testPet struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+var testPets = []testPet{{"Rex", "DOG"}, {"Tom", "CAT"}, {"Fido", "DOG"}}
+
+// testCalls records what the resolvers of a test schema were asked
+type testCalls struct {
+	friends      int // Calls of Pet.friends
+	friendsFor   []int
+	selectsOwner bool
+	total        int // Running total of Mutation.add
+}
+
+// newTestSchema returns a schema of pets, with the calls its resolvers record
+func newTestSchema(t *testing.T) (*Schema, *testCalls) {
+	t.Helper()
+	calls := &testCalls{}
+
+	petKind := &Enum{Name: "PetKind", Values: []*EnumValue{{Name: "DOG"}, {Name: "CAT"}}}
+	petFilter := &InputObject{Name: "PetFilter", Fields: []*Argument{
+		{Name: "kind", Type: NewNonNull(petKind)},
+		{Name: "limit", Type: Int, Default: 10},
+	}}
+	pet := &Object{Name: "Pet"}
+	pet.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "kind", Type: NewNonNull(petKind)},
+		{Name: "owner", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if source.(testPet).Name == "Tom" {
+				return nil, &Error{Message: "Owner is private", Extensions: map[string]interface{}{"code": "FORBIDDEN"}}
+			}
+			return "Ada", nil
+		})},
+		{Name: "badKind", Type: petKind, Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return "BIRD", nil
+		})},
+		{Name: "friends", Type: NewNonNull(NewList(NewNonNull(pet))), Resolve: func(p ResolveParams) ([]interface{}, error) {
+			calls.friends++
+			calls.friendsFor = append(calls.friendsFor, len(p.Sources))
+			values := make([]interface{}, len(p.Sources))
+			for i, source := range p.Sources {
+				var friends []testPet
+				for _, other := range testPets {
+					if other.Kind == source.(testPet).Kind && other != source {
+						friends = append(friends, other)
+					}
+				}
+				values[i] = friends
+			}
+			return values, nil
+		}},
+	}
+
+	petsByKind := func(kind interface{}, limit int) []testPet {
+		pets := []testPet{}
+		for _, p := range testPets {
+			if (kind == nil || p.Kind == kind) && len(pets) < limit {
+				pets = append(pets, p)
+			}
+		}
+		return pets
+	}
+
+	query := &Object{Name: "Query", Fields: []*Field{
+		{Name: "hello", Type: NewNonNull(String), Args: []*Argument{{Name: "name", Type: String, Default: "world"}}, Resolve: Each(func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			return "hello " + args["name"].(string), nil
+		})},
+		{Name: "pets", Type: NewNonNull(NewList(NewNonNull(pet))), Args: []*Argument{{Name: "kind", Type: petKind}, {Name: "filter", Type: petFilter}}, Resolve: func(p ResolveParams) ([]interface{}, error) {
+			calls.selectsOwner = p.Selects("owner")
+			if filter, ok := p.Args["filter"].(map[string]interface{}); ok {
+				return []interface{}{petsByKind(filter["kind"], filter["limit"].(int))}, nil
+			}
+			return []interface{}{petsByKind(p.Args["kind"], len(testPets))}, nil
+		}},
+		{Name: "pet", Type: pet, Args: []*Argument{{Name: "name", Type: NewNonNull(String)}}, Resolve: Each(func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			for _, p := range testPets {
+				if p.Name == args["name"] {
+					return p, nil
+				}
+			}
+			return nil, nil
+		})},
+		{Name: "ids", Type: NewList(ID), Args: []*Argument{{Name: "ids", Type: NewList(NewNonNull(ID))}}, Resolve: Each(func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			return args["ids"], nil
+		})},
+		{Name: "fail", Type: String, Resolve: func(ResolveParams) ([]interface{}, error) {
+			return nil, errors.New("Everything failed")
+		}},
+		{Name: "missing", Type: NewNonNull(pet), Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})},
+		{Name: "panics", Type: String, Resolve: func(ResolveParams) ([]interface{}, error) {
+			panic("boom")
+		}},
+	}}
+
+	mutation := &Object{Name: "Mutation", Fields: []*Field{
+		{Name: "add", Type: NewNonNull(Int), Args: []*Argument{{Name: "n", Type: NewNonNull(Int)}}, Resolve: Each(func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			calls.total += args["n"].(int)
+			return calls.total, nil
+		})},
+	}}
+
+	schema, err := NewSchema(SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema, calls
+}
+
+// run prepares and executes a request, returning the response as JSON
+func run(t *testing.T, schema *Schema, req Request) string {
+	t.Helper()
+	response := &Response{}
+	if op, errs := schema.Prepare(req); errs != nil {
+		response.Errors = errs
+	} else {
+		response = op.Execute(context.Background())
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// variables decodes JSON variables the way the handler does
+func variables(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestExecute(t *testing.T) {
+	schema, _ := newTestSchema(t)
+	tests := []struct {
+		name, query, variables, want string
+	}{
+		{"default argument", `{ hello }`, ``, `{"data":{"hello":"hello world"}}`},
+		{"aliases", `{ a: hello(name: "a") b: hello(name: "b") }`, ``, `{"data":{"a":"hello a","b":"hello b"}}`},
+		{"variables", `query($name: String) { hello(name: $name) }`, `{"name": "Ada"}`, `{"data":{"hello":"hello Ada"}}`},
+		{"variable default", `query($name: String = "you") { hello(name: $name) }`, `{}`, `{"data":{"hello":"hello you"}}`},
+		{"enum argument", `{ pets(kind: CAT) { name kind } }`, ``, `{"data":{"pets":[{"name":"Tom","kind":"CAT"}]}}`},
+		{"enum variable", `query($kind: PetKind) { pets(kind: $kind) { name } }`, `{"kind": "DOG"}`, `{"data":{"pets":[{"name":"Rex"},{"name":"Fido"}]}}`},
+		{"input object", `{ pets(filter: {kind: DOG, limit: 1}) { name } }`, ``, `{"data":{"pets":[{"name":"Rex"}]}}`},
+		{"input object default", `query($f: PetFilter) { pets(filter: $f) { name } }`, `{"f": {"kind": "CAT"}}`, `{"data":{"pets":[{"name":"Tom"}]}}`},
+		{"list coercion", `{ ids(ids: "a") }`, ``, `{"data":{"ids":["a"]}}`},
+		{"list variable", `query($ids: [ID!]) { ids(ids: $ids) }`, `{"ids": ["a", 7]}`, `{"data":{"ids":["a","7"]}}`},
+		{"null object", `{ pet(name: "Nobody") { name } }`, ``, `{"data":{"pet":null}}`},
+		{"fragments and typename",
+			`{ pet(name: "Rex") { ...Names ... on Pet { kind } ... { __typename } } } fragment Names on Pet { name n: name }`, ``,
+			`{"data":{"pet":{"name":"Rex","n":"Rex","kind":"DOG","__typename":"Pet"}}}`},
+		{"merged fields", `{ pet(name: "Rex") { name } pet(name: "Rex") { kind } }`, ``, `{"data":{"pet":{"name":"Rex","kind":"DOG"}}}`},
+		{"skip and include",
+			`query($yes: Boolean!) { a: hello @skip(if: $yes) b: hello @include(if: $yes) c: hello @include(if: false) ... @skip(if: true) { d: hello } }`, `{"yes": true}`,
+			`{"data":{"b":"hello world"}}`},
+		{"nested lists", `{ pet(name: "Rex") { friends { name friends { name } } } }`, ``,
+			`{"data":{"pet":{"friends":[{"name":"Fido","friends":[{"name":"Rex"}]}]}}}`},
+		{"introspection", `{ __schema { queryType { name } mutationType { name } } __type(name: "PetFilter") { kind inputFields { name defaultValue } } }`, ``,
+			`{"data":{"__schema":{"queryType":{"name":"Query"},"mutationType":{"name":"Mutation"}},"__type":{"kind":"INPUT_OBJECT","inputFields":[{"name":"kind","defaultValue":null},{"name":"limit","defaultValue":"10"}]}}}`},
+	}
+	for _, tt := range tests {
+		req := Request{Query: tt.query}
+		if tt.variables != "" {
+			req.Variables = variables(t, tt.variables)
+		}
+		if got := run(t, schema, req); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	schema, _ := newTestSchema(t)
+	tests := []struct {
+		name, query, want string
+	}{
+		{"resolver error", `{ hello fail }`,
+			`{"data":{"hello":"hello world","fail":null},"errors":[{"message":"Everything failed","locations":[{"line":1,"column":9}],"path":["fail"]}]}`},
+		{"error for one source", `{ pets { name owner } }`,
+			`{"data":{"pets":[{"name":"Rex","owner":"Ada"},{"name":"Tom","owner":null},{"name":"Fido","owner":"Ada"}]},"errors":[{"message":"Owner is private","locations":[{"line":1,"column":15}],"path":["pets",1,"owner"],"extensions":{"code":"FORBIDDEN"}}]}`},
+		{"null in a non-null field nulls the parent", `{ hello missing { name } }`,
+			`{"data":null,"errors":[{"message":"Cannot return null for non-nullable field Query.missing.","locations":[{"line":1,"column":9}],"path":["missing"]}]}`},
+		{"panic", `{ panics }`,
+			`{"data":{"panics":null},"errors":[{"message":"Internal server error","locations":[{"line":1,"column":3}],"path":["panics"]}]}`},
+		{"enum value out of range", `{ pet(name: "Rex") { badKind } }`,
+			`{"data":{"pet":{"badKind":null}},"errors":[{"message":"Enum \"PetKind\" cannot represent value: \"BIRD\"","locations":[{"line":1,"column":22}],"path":["pet","badKind"]}]}`},
+	}
+	for _, tt := range tests {
+		if got := run(t, schema, Request{Query: tt.query}); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	schema, _ := newTestSchema(t)
+	tests := []struct {
+		name      string
+		req       Request
+		variables string
+		want      string // The first error
+	}{
+		{"no query", Request{Query: "  "}, ``, "Must provide query string."},
+		{"syntax error", Request{Query: "{"}, ``, "Syntax Error: Expected name, found <EOF>"},
+		{"operation name needed", Request{Query: "query A { hello } query B { hello }"}, ``, "Must provide operation name if query contains multiple operations."},
+		{"unknown operation", Request{Query: "query A { hello }", OperationName: "B"}, ``, "Unknown operation named \"B\"."},
+		{"missing variable", Request{Query: "query($n: String!) { hello(name: $n) }"}, ``, "Variable \"$n\" of required type \"String!\" was not provided."},
+		{"null variable", Request{Query: "query($n: String!) { hello(name: $n) }"}, `{"n": null}`, "Variable \"$n\" got invalid value null; Expected non-nullable type \"String!\" not to be null."},
+		{"wrong variable type", Request{Query: "query($n: Int!) { add: hello(name: \"x\") p: pets(filter: {kind: DOG, limit: $n}) { name } }"}, `{"n": 1.5}`, "Variable \"$n\" got invalid value 1.5; Int cannot represent non-integer value: 1.5"},
+		{"unknown enum value", Request{Query: "query($k: PetKind) { pets(kind: $k) { name } }"}, `{"k": "BIRD"}`, "Variable \"$k\" got invalid value \"BIRD\"; Value \"BIRD\" does not exist in \"PetKind\" enum."},
+		{"unknown input field", Request{Query: "query($f: PetFilter) { pets(filter: $f) { name } }"}, `{"f": {"kind": "DOG", "colour": "red"}}`, "Field \"colour\" is not defined by type \"PetFilter\"."},
+	}
+	for _, tt := range tests {
+		if tt.variables != "" {
+			tt.req.Variables = variables(t, tt.variables)
+		}
+		_, errs := schema.Prepare(tt.req)
+		if len(errs) == 0 || !strings.Contains(errs[0].Message, tt.want) {
+			t.Errorf("%s: errors = %v, want %q", tt.name, errs, tt.want)
+		}
+	}
+
+	op, errs := schema.Prepare(Request{Query: "query A { hello } mutation B { add(n: 1) }", OperationName: "B"})
+	if errs != nil || op.Kind() != "mutation" {
+		t.Errorf("picking B gave %v, %v", op, errs)
+	}
+}
+
+func TestResolversAreBatched(t *testing.T) {
+	schema, calls := newTestSchema(t)
+	got := run(t, schema, Request{Query: `{ pets { friends { friends { name } } } }`})
+	if !strings.HasPrefix(got, `{"data":{"pets":[{"friends":[{"friends":[{"name":"Rex"}]}]}`) {
+		t.Errorf("response = %s", got)
+	}
+	// One call for the three pets, then one for the two friends they have
+	if calls.friends != 2 || fmt.Sprint(calls.friendsFor) != "[3 2]" {
+		t.Errorf("friends resolved %d times, for %v sources", calls.friends, calls.friendsFor)
+	}
+}
+
+func TestSelects(t *testing.T) {
+	schema, calls := newTestSchema(t)
+	for query, want := range map[string]bool{
+		`{ pets { name } }`:                             false,
+		`{ pets { name owner } }`:                       true,
+		`{ pets { ...F } } fragment F on Pet { owner }`: true,
+		`{ pets { owner @skip(if: true) } }`:            false,
+	} {
+		run(t, schema, Request{Query: query})
+		if calls.selectsOwner != want {
+			t.Errorf("%s: Selects(\"owner\") = %v", query, calls.selectsOwner)
+		}
+	}
+}
+
+func TestMutationsRunInOrder(t *testing.T) {
+	schema, _ := newTestSchema(t)
+	got := run(t, schema, Request{Query: `mutation { a: add(n: 1) b: add(n: 2) c: add(n: 3) }`})
+	if got != `{"data":{"a":1,"b":3,"c":6}}` {
+		t.Errorf("response = %s", got)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var batches [][]int
+	loader := NewLoader(func(_ context.Context, keys []int) (map[int]string, error) {
+		batches = append(batches, keys)
+		values := map[int]string{}
+		for _, key := range keys {
+			if key > 0 {
+				values[key] = fmt.Sprint("value ", key)
+			}
+		}
+		return values, nil
+	})
+	ctx := context.Background()
+
+	values, err := loader.LoadMany(ctx, []int{1, 2, 2, -1})
+	if err != nil || len(values) != 2 || values[2] != "value 2" {
+		t.Errorf("LoadMany = %v, %v", values, err)
+	}
+	// Cached keys, found or not, aren't fetched again
+	loader.Prime(3, "primed")
+	values, _ = loader.LoadMany(ctx, []int{1, -1, 3, 4})
+	if values[3] != "primed" || values[4] != "value 4" {
+		t.Errorf("LoadMany = %v", values)
+	}
+	if _, found, _ := loader.Load(ctx, -1); found {
+		t.Error("Load found a missing key")
+	}
+	if fmt.Sprint(batches) != "[[1 2 -1] [4]]" {
+		t.Errorf("batches = %v", batches)
+	}
+
+	loader.Clear()
+	loader.Load(ctx, 1)
+	if len(batches) != 3 {
+		t.Error("Clear kept the cache")
+	}
+}
@@ -0,0 +1,284 @@
+package graphql
+
+import (
+	"context"
+)
+
+// introspectionTypes are the reserved type names the schema may contain
+var introspectionTypes = map[string]bool{
+	"__Schema": true, "__Type": true, "__TypeKind": true, "__Field": true, "__InputValue": true,
+	"__EnumValue": true, "__Directive": true, "__DirectiveLocation": true,
+}
+
+// conditionArgs are the arguments of @include and @skip
+var conditionArgs = []*Argument{{Name: "if", Type: NewNonNull(Boolean)}}
+
+// directiveDefinition describes a directive the server understands
+type directiveDefinition struct {
+	name        string
+	description string
+	locations   []string
+	args        []*Argument
+}
+
+var directiveDefinitions = []*directiveDefinition{
+	{
+		name:        "include",
+		description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		locations:   executableDirectiveLocations,
+		args:        []*Argument{{Name: "if", Description: "Included when true.", Type: NewNonNull(Boolean)}},
+	},
+	{
+		name:        "skip",
+		description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		locations:   executableDirectiveLocations,
+		args:        []*Argument{{Name: "if", Description: "Skipped when true.", Type: NewNonNull(Boolean)}},
+	},
+	{
+		name:        "deprecated",
+		description: "Marks an element of a GraphQL schema as no longer supported.",
+		locations:   []string{"FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INPUT_FIELD_DEFINITION", "ENUM_VALUE"},
+		args:        []*Argument{{Name: "reason", Description: "Explains why this element was deprecated.", Type: String, Default: "No longer supported"}},
+	},
+}
+
+// The meta fields available on every object, or on the query root
+var (
+	typenameField = &Field{Name: "__typename", Type: NewNonNull(String)}
+	schemaField   = &Field{Name: "__schema", Type: NewNonNull(schemaType)}
+	typeField     = &Field{Name: "__type", Type: typeType, Args: []*Argument{{Name: "name", Type: NewNonNull(String)}}}
+)
+
+// fieldDefinition returns the named field of t, including meta fields, or nil
+func (s *Schema) fieldDefinition(t *Object, name string) *Field {
+	switch {
+	case name == "__typename":
+		return typenameField
+	case name == "__schema" && t == s.query:
+		return schemaField
+	case name == "__type" && t == s.query:
+		return typeField
+	}
+	return t.field(name)
+}
+
+var (
+	schemaType            = &Object{Name: "__Schema", Description: "A GraphQL Schema defines the capabilities of a GraphQL server. It exposes all available types and directives on the server, as well as the entry points for query, mutation, and subscription operations."}
+	typeType              = &Object{Name: "__Type", Description: "The fundamental unit of any GraphQL Schema is the type. There are many kinds of types in GraphQL as represented by the `__TypeKind` enum."}
+	fieldType             = &Object{Name: "__Field", Description: "Object and Interface types are described by a list of Fields, each of which has a name, potentially a list of arguments, and a return type."}
+	inputValueType        = &Object{Name: "__InputValue", Description: "Arguments provided to Fields or Directives and the input fields of an InputObject are represented as Input Values which describe their type and optionally a default value."}
+	enumValueType         = &Object{Name: "__EnumValue", Description: "One possible value for a given Enum. Enum values are unique values, not a placeholder for a string or numeric value."}
+	directiveType         = &Object{Name: "__Directive", Description: "A Directive provides a way to describe alternate runtime execution and type validation behavior in a GraphQL document."}
+	typeKindType          = &Enum{Name: "__TypeKind", Description: "An enum describing what kind of type a given `__Type` is."}
+	directiveLocationType = &Enum{Name: "__DirectiveLocation", Description: "A Directive can be adjacent to many parts of the GraphQL language, a __DirectiveLocation describes one such possible adjacencies."}
+)
+
+func init() {
+	for _, kind := range []string{"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL"} {
+		typeKindType.Values = append(typeKindType.Values, &EnumValue{Name: kind})
+	}
+	for _, location := range []string{
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT",
+		"VARIABLE_DEFINITION", "SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE",
+		"UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION",
+	} {
+		directiveLocationType.Values = append(directiveLocationType.Values, &EnumValue{Name: location})
+	}
+
+	includeDeprecated := []*Argument{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+	typeList := NewNonNull(NewList(NewNonNull(typeType)))
+
+	schemaType.Fields = []*Field{
+		{Name: "description", Type: String, Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})},
+		{Name: "types", Description: "A list of all types supported by this server.", Type: typeList, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Schema).typeList(), nil
+		})},
+		{Name: "queryType", Description: "The type that query operations will be rooted at.", Type: NewNonNull(typeType), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Schema).query, nil
+		})},
+		{Name: "mutationType", Description: "If this server supports mutation, the type that mutation operations will be rooted at.", Type: typeType, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if mutation := source.(*Schema).mutation; mutation != nil {
+				return mutation, nil
+			}
+			return nil, nil
+		})},
+		{Name: "subscriptionType", Description: "If this server support subscription, the type that subscription operations will be rooted at.", Type: typeType, Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})},
+		{Name: "directives", Description: "A list of all directives supported by this server.", Type: NewNonNull(NewList(NewNonNull(directiveType))), Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return directiveDefinitions, nil
+		})},
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NewNonNull(typeKindType), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			switch source.(type) {
+			case *Scalar:
+				return "SCALAR", nil
+			case *Object:
+				return "OBJECT", nil
+			case *Enum:
+				return "ENUM", nil
+			case *InputObject:
+				return "INPUT_OBJECT", nil
+			case *List:
+				return "LIST", nil
+			}
+			return "NON_NULL", nil
+		})},
+		{Name: "name", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if name := typeName(source.(Type)); name != "" {
+				return name, nil
+			}
+			return nil, nil
+		})},
+		{Name: "description", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(typeDescription(source.(Type))), nil
+		})},
+		{Name: "specifiedByURL", Type: String, Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})},
+		{Name: "fields", Type: NewList(NewNonNull(fieldType)), Args: includeDeprecated, Resolve: Each(func(_ context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			object, ok := source.(*Object)
+			if !ok {
+				return nil, nil
+			}
+			fields := []*Field{}
+			for _, f := range object.Fields {
+				if f.DeprecationReason == "" || args["includeDeprecated"] == true {
+					fields = append(fields, f)
+				}
+			}
+			return fields, nil
+		})},
+		{Name: "interfaces", Type: NewList(NewNonNull(typeType)), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if _, ok := source.(*Object); ok {
+				return []Type{}, nil
+			}
+			return nil, nil
+		})},
+		{Name: "possibleTypes", Type: NewList(NewNonNull(typeType)), Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})},
+		{Name: "enumValues", Type: NewList(NewNonNull(enumValueType)), Args: includeDeprecated, Resolve: Each(func(_ context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			enum, ok := source.(*Enum)
+			if !ok {
+				return nil, nil
+			}
+			values := []*EnumValue{}
+			for _, v := range enum.Values {
+				if v.DeprecationReason == "" || args["includeDeprecated"] == true {
+					values = append(values, v)
+				}
+			}
+			return values, nil
+		})},
+		{Name: "inputFields", Type: NewList(NewNonNull(inputValueType)), Args: includeDeprecated, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if input, ok := source.(*InputObject); ok {
+				return input.Fields, nil
+			}
+			return nil, nil
+		})},
+		{Name: "ofType", Type: typeType, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			switch t := source.(type) {
+			case *List:
+				return t.OfType, nil
+			case *NonNull:
+				return t.OfType, nil
+			}
+			return nil, nil
+		})},
+		{Name: "isOneOf", Type: Boolean, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if _, ok := source.(*InputObject); ok {
+				return false, nil
+			}
+			return nil, nil
+		})},
+	}
+
+	fieldType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "description", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Field).Description), nil
+		})},
+		{Name: "args", Type: NewNonNull(NewList(NewNonNull(inputValueType))), Args: includeDeprecated, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if args := source.(*Field).Args; args != nil {
+				return args, nil
+			}
+			return []*Argument{}, nil
+		})},
+		{Name: "type", Type: NewNonNull(typeType), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Field).Type, nil
+		})},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Field).DeprecationReason != "", nil
+		})},
+		{Name: "deprecationReason", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Field).DeprecationReason), nil
+		})},
+	}
+
+	inputValueType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "description", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Argument).Description), nil
+		})},
+		{Name: "type", Type: NewNonNull(typeType), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Argument).Type, nil
+		})},
+		{Name: "defaultValue", Description: "A GraphQL-formatted string representing the default value for this input value.", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			arg := source.(*Argument)
+			if arg.Default == nil {
+				return nil, nil
+			}
+			return printValue(arg.Type, arg.Default), nil
+		})},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return false, nil
+		})},
+		{Name: "deprecationReason", Type: String, Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		})},
+	}
+
+	enumValueType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String)},
+		{Name: "description", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*EnumValue).Description), nil
+		})},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*EnumValue).DeprecationReason != "", nil
+		})},
+		{Name: "deprecationReason", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*EnumValue).DeprecationReason), nil
+		})},
+	}
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*directiveDefinition).name, nil
+		})},
+		{Name: "description", Type: String, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*directiveDefinition).description), nil
+		})},
+		{Name: "isRepeatable", Type: NewNonNull(Boolean), Resolve: Each(func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return false, nil
+		})},
+		{Name: "locations", Type: NewNonNull(NewList(NewNonNull(directiveLocationType))), Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*directiveDefinition).locations, nil
+		})},
+		{Name: "args", Type: NewNonNull(NewList(NewNonNull(inputValueType))), Args: includeDeprecated, Resolve: Each(func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*directiveDefinition).args, nil
+		})},
+	}
+}
+
+// optional returns nil for an empty string, so it's null in the response
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,357 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind is the kind of a lexical token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// token is one lexical token; value is the punctuator, name, number or decoded string
+type token struct {
+	kind  tokenKind
+	value string
+	loc   Location
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "<EOF>"
+	case tokenString:
+		return strconv.Quote(t.value)
+	}
+	return t.value
+}
+
+// lexer splits a document into tokens, skipping whitespace, commas and comments
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int // Byte offset of the start of the current line
+
+	// Characters between the start of the line and counted, so a long line
+	// isn't counted again for every token on it
+	counted int
+	runes   int
+}
+
+func newLexer(src string) *lexer {
+	l := &lexer{src: src, line: 1}
+	if strings.HasPrefix(src, "\uFEFF") {
+		l.pos = len("\uFEFF")
+		l.col = l.pos
+	}
+	return l
+}
+
+func (l *lexer) location(pos int) Location {
+	if l.counted < l.col || l.counted > pos {
+		l.counted, l.runes = l.col, 0
+	}
+	l.runes += utf8.RuneCountInString(l.src[l.counted:pos])
+	l.counted = pos
+	return Location{Line: l.line, Column: l.runes + 1}
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	return &Error{Message: "Syntax Error: " + fmt.Sprintf(format, args...), Locations: []Location{l.location(pos)}}
+}
+
+func (l *lexer) newline(next int) {
+	l.line++
+	l.col = next
+}
+
+// next returns the next token
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	start := l.pos
+	loc := l.location(start)
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, loc: loc}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunctuator, value: string(c), loc: loc}, nil
+	case c == '.':
+		if strings.HasPrefix(l.src[l.pos:], "...") {
+			l.pos += 3
+			return token{kind: tokenPunctuator, value: "...", loc: loc}, nil
+		}
+		return token{}, l.errorf(start, "Unexpected \".\"")
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString(loc)
+		}
+		return l.string(loc)
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, l.errorf(start, "Unexpected character %q", r)
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case ' ', '\t', ',':
+			l.pos++
+		case '\n':
+			l.pos++
+			l.newline(l.pos)
+		case '\r':
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newline(l.pos)
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+				l.pos += len("\uFEFF")
+				continue
+			}
+			return
+		}
+	}
+}
+
+// number reads an IntValue or FloatValue
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	kind := tokenInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '0' {
+		l.pos++
+		if l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			return token{}, l.errorf(l.pos, "Invalid number, unexpected digit after 0")
+		}
+	} else if !l.digits() {
+		return token{}, l.errorf(l.pos, "Invalid number, expected digit")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if !l.digits() {
+			return token{}, l.errorf(l.pos, "Invalid number, expected digit after \".\"")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !l.digits() {
+			return token{}, l.errorf(l.pos, "Invalid number, expected digit in exponent")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == '.' || l.src[l.pos] == '_' || isLetter(l.src[l.pos])) {
+		return token{}, l.errorf(l.pos, "Invalid number, unexpected %q", l.src[l.pos])
+	}
+	return token{kind: kind, value: l.src[start:l.pos], loc: loc}, nil
+}
+
+func (l *lexer) digits() bool {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos > start
+}
+
+// string reads a quoted string with escapes
+func (l *lexer) string(loc Location) (token, error) {
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' || l.src[l.pos] == '\r' {
+			return token{}, l.errorf(l.pos, "Unterminated string")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, value: b.String(), loc: loc}, nil
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, l.errorf(l.pos, "Unterminated string")
+			}
+			escape := l.src[l.pos+1]
+			l.pos += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				r, err := l.unicodeEscape()
+				if err != nil {
+					return token{}, err
+				}
+				b.WriteRune(r)
+			default:
+				return token{}, l.errorf(l.pos-2, "Invalid escape sequence \\%c", escape)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if r == utf8.RuneError && size == 1 {
+				return token{}, l.errorf(l.pos, "Invalid UTF-8")
+			}
+			b.WriteString(l.src[l.pos : l.pos+size])
+			l.pos += size
+		}
+	}
+}
+
+// unicodeEscape reads the hex digits of \uXXXX or \u{X...}, joining surrogate pairs
+func (l *lexer) unicodeEscape() (rune, error) {
+	start := l.pos - 2
+	if l.pos < len(l.src) && l.src[l.pos] == '{' {
+		end := strings.IndexByte(l.src[l.pos:], '}')
+		if end < 0 {
+			return 0, l.errorf(start, "Invalid Unicode escape")
+		}
+		n, err := strconv.ParseUint(l.src[l.pos+1:l.pos+end], 16, 32)
+		l.pos += end + 1
+		if err != nil || n > utf8.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+			return 0, l.errorf(start, "Invalid Unicode escape")
+		}
+		return rune(n), nil
+	}
+
+	hex4 := func() (rune, bool) {
+		if l.pos+4 > len(l.src) {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+		if err != nil {
+			return 0, false
+		}
+		l.pos += 4
+		return rune(n), true
+	}
+
+	r, ok := hex4()
+	if !ok {
+		return 0, l.errorf(start, "Invalid Unicode escape")
+	}
+	if r >= 0xD800 && r <= 0xDBFF && strings.HasPrefix(l.src[l.pos:], `\u`) {
+		l.pos += 2
+		low, ok := hex4()
+		if !ok || low < 0xDC00 || low > 0xDFFF {
+			return 0, l.errorf(start, "Invalid Unicode escape")
+		}
+		return (r-0xD800)<<10 + (low - 0xDC00) + 0x10000, nil
+	}
+	if r >= 0xD800 && r <= 0xDFFF {
+		return 0, l.errorf(start, "Invalid Unicode escape")
+	}
+	return r, nil
+}
+
+// blockString reads a """block string""", removing common indentation
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.pos += 3
+	var raw strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return token{}, l.errorf(l.pos, "Unterminated string")
+		}
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return token{kind: tokenString, value: blockStringValue(raw.String()), loc: loc}, nil
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			raw.WriteString(`"""`)
+			l.pos += 4
+		case l.src[l.pos] == '\n':
+			raw.WriteByte('\n')
+			l.pos++
+			l.newline(l.pos)
+		case l.src[l.pos] == '\r':
+			raw.WriteByte('\n')
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newline(l.pos)
+		default:
+			raw.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+}
+
+// blockStringValue applies the block string indentation rules of the spec
+func blockStringValue(raw string) string {
+	lines := strings.Split(raw, "\n")
+
+	indent := -1
+	for i, line := range lines {
+		if i == 0 {
+			continue
+		}
+		width := len(line) - len(strings.TrimLeft(line, " \t"))
+		if width < len(line) && (indent < 0 || width < indent) {
+			indent = width
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+
+	blank := func(line string) bool { return strings.Trim(line, " \t") == "" }
+	for len(lines) > 0 && blank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && blank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
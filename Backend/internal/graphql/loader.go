@@ -0,0 +1,83 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchFunc loads the values for a set of keys. Keys without a value are left
+// out of the map.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Loader caches values by key for the length of one request, loading the
+// missing keys of each call with a single batch. Keys without a value are
+// remembered too, so they aren't looked up again.
+type Loader[K comparable, V any] struct {
+	batch BatchFunc[K, V]
+
+	mu    sync.Mutex
+	cache map[K]*V // nil for keys known to have no value
+}
+
+// NewLoader returns a loader that fetches with batch
+func NewLoader[K comparable, V any](batch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{batch: batch, cache: map[K]*V{}}
+}
+
+// LoadMany returns the values for keys, fetching those not yet cached
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) (map[K]V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var missing []K
+	queued := map[K]bool{}
+	for _, key := range keys {
+		if _, ok := l.cache[key]; !ok && !queued[key] {
+			missing = append(missing, key)
+			queued[key] = true
+		}
+	}
+
+	if len(missing) > 0 {
+		fetched, err := l.batch(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range missing {
+			if value, ok := fetched[key]; ok {
+				l.cache[key] = &value
+			} else {
+				l.cache[key] = nil
+			}
+		}
+	}
+
+	values := make(map[K]V, len(keys))
+	for _, key := range keys {
+		if value := l.cache[key]; value != nil {
+			values[key] = *value
+		}
+	}
+	return values, nil
+}
+
+// Load returns the value for one key
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, bool, error) {
+	values, err := l.LoadMany(ctx, []K{key})
+	value, ok := values[key]
+	return value, ok, err
+}
+
+// Prime caches a value loaded some other way
+func (l *Loader[K, V]) Prime(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cache[key] = &value
+}
+
+// Clear forgets every cached value, after a write
+func (l *Loader[K, V]) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cache = map[K]*V{}
+}
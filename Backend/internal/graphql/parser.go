@@ -0,0 +1,463 @@
+package graphql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxTokens bounds the size of a query document
+const maxTokens = 20000
+
+// parser builds a document from tokens, one token of lookahead at a time
+type parser struct {
+	lexer  *lexer
+	tok    token
+	tokens int
+}
+
+// parse parses an executable document
+func parse(query string) (*document, error) {
+	p := &parser{lexer: newLexer(query)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{}
+	if p.tok.kind == tokenEOF {
+		return nil, p.unexpected()
+	}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operationDefinition{kind: "query", selectionSet: selections, loc: selections[0].location()})
+		case p.tok.kind == tokenName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments = append(doc.fragments, fragment)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	p.tokens++
+	if p.tokens > maxTokens {
+		return &Error{Message: "Syntax Error: Document contains more than " + strconv.Itoa(maxTokens) + " tokens", Locations: []Location{p.tok.loc}}
+	}
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	return &Error{Message: "Syntax Error: Unexpected " + p.tok.String(), Locations: []Location{p.tok.loc}}
+}
+
+// peek reports whether the current token is the given punctuator
+func (p *parser) peek(punctuator string) bool {
+	return p.tok.kind == tokenPunctuator && p.tok.value == punctuator
+}
+
+// skip consumes the given punctuator if it's next
+func (p *parser) skip(punctuator string) (bool, error) {
+	if !p.peek(punctuator) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expect consumes the given punctuator
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return &Error{Message: "Syntax Error: Expected \"" + punctuator + "\", found " + p.tok.String(), Locations: []Location{p.tok.loc}}
+	}
+	return p.advance()
+}
+
+// name consumes a name
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", &Error{Message: "Syntax Error: Expected name, found " + p.tok.String(), Locations: []Location{p.tok.loc}}
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+// keyword consumes the given name
+func (p *parser) keyword(keyword string) error {
+	if p.tok.kind != tokenName || p.tok.value != keyword {
+		return &Error{Message: "Syntax Error: Expected \"" + keyword + "\", found " + p.tok.String(), Locations: []Location{p.tok.loc}}
+	}
+	return p.advance()
+}
+
+func (p *parser) operation() (*operationDefinition, error) {
+	op := &operationDefinition{kind: p.tok.value, loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.tok.kind == tokenName {
+		if op.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if op.variables, err = p.variableDefinitions(); err != nil {
+		return nil, err
+	}
+	if op.directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if op.selectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) variableDefinitions() ([]*variableDefinition, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+
+	var definitions []*variableDefinition
+	for {
+		definition := &variableDefinition{loc: p.tok.loc}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		var err error
+		if definition.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if definition.typ, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if definition.defaultValue, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.directives(true); err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, definition)
+
+		if ok, err := p.skip(")"); ok || err != nil {
+			return definitions, err
+		}
+	}
+}
+
+func (p *parser) typeRef() (*typeRef, error) {
+	t := &typeRef{}
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		if t.elem, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else if t.name, err = p.name(); err != nil {
+		return nil, err
+	}
+
+	nonNull, err := p.skip("!")
+	t.nonNull = nonNull
+	return t, err
+}
+
+func (p *parser) directives(constant bool) ([]*directive, error) {
+	var directives []*directive
+	for p.peek("@") {
+		d := &directive{loc: p.tok.loc}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.arguments, err = p.arguments(constant); err != nil {
+			return nil, err
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *parser) arguments(constant bool) ([]*argument, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+
+	var arguments []*argument
+	for {
+		arg := &argument{loc: p.tok.loc}
+		var err error
+		if arg.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.value, err = p.value(constant); err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, arg)
+
+		if ok, err := p.skip(")"); ok || err != nil {
+			return arguments, err
+		}
+	}
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []selection
+	for {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+
+		if ok, err := p.skip("}"); ok || err != nil {
+			return selections, err
+		}
+	}
+}
+
+func (p *parser) selection() (selection, error) {
+	if p.peek("...") {
+		return p.fragmentSelection()
+	}
+
+	f := &field{loc: p.tok.loc}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.alias = f.name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.arguments, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.selectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// fragmentSelection parses a fragment spread or an inline fragment
+func (p *parser) fragmentSelection() (selection, error) {
+	loc := p.tok.loc
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		spread := &fragmentSpread{loc: loc}
+		var err error
+		if spread.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if spread.directives, err = p.directives(false); err != nil {
+			return nil, err
+		}
+		return spread, nil
+	}
+
+	inline := &inlineFragment{loc: loc}
+	var err error
+	if p.tok.kind == tokenName {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if inline.typeCondition, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if inline.directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if inline.selectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return inline, nil
+}
+
+func (p *parser) fragment() (*fragmentDefinition, error) {
+	fragment := &fragmentDefinition{loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.tok.kind == tokenName && p.tok.value == "on" {
+		return nil, p.unexpected()
+	}
+	if fragment.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.keyword("on"); err != nil {
+		return nil, err
+	}
+	if fragment.typeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if fragment.directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if fragment.selectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+// value parses a value; constant values can't contain variables
+func (p *parser) value(constant bool) (*value, error) {
+	v := &value{loc: p.tok.loc, raw: p.tok.value}
+	switch p.tok.kind {
+	case tokenInt:
+		v.kind = intValue
+	case tokenFloat:
+		v.kind = floatValue
+	case tokenString:
+		v.kind = stringValue
+	case tokenName:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = booleanValue
+		case "null":
+			v.kind = nullValue
+		default:
+			v.kind = enumValue
+		}
+	case tokenPunctuator:
+		switch {
+		case p.peek("$") && !constant:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			v.kind, v.raw = variableValue, name
+			return v, nil
+		case p.peek("["):
+			return p.listValue(v, constant)
+		case p.peek("{"):
+			return p.objectValue(v, constant)
+		}
+		return nil, p.unexpected()
+	default:
+		return nil, p.unexpected()
+	}
+	return v, p.advance()
+}
+
+func (p *parser) listValue(v *value, constant bool) (*value, error) {
+	v.kind, v.raw = listValue, ""
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.skip("]"); ok || err != nil {
+			return v, err
+		}
+		item, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		v.list = append(v.list, item)
+	}
+}
+
+func (p *parser) objectValue(v *value, constant bool) (*value, error) {
+	v.kind, v.raw = objectValue, ""
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.skip("}"); ok || err != nil {
+			return v, err
+		}
+		f := &objectField{loc: p.tok.loc}
+		var err error
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if f.value, err = p.value(constant); err != nil {
+			return nil, err
+		}
+		v.fields = append(v.fields, f)
+	}
+}
+
+// String prints the value as it would appear in a query
+func (v *value) String() string {
+	switch v.kind {
+	case variableValue:
+		return "$" + v.raw
+	case stringValue:
+		return strconv.Quote(v.raw)
+	case nullValue:
+		return "null"
+	case listValue:
+		items := make([]string, len(v.list))
+		for i, item := range v.list {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case objectValue:
+		fields := make([]string, len(v.fields))
+		for i, f := range v.fields {
+			fields[i] = f.name + ": " + f.value.String()
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return v.raw
+}
@@ -0,0 +1,222 @@
+package graphql
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokens lexes src to the end
+func tokens(src string) ([]token, error) {
+	l := newLexer(src)
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil || tok.kind == tokenEOF {
+			return tokens, err
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestLexStrings(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{`"simple"`, "simple"},
+		{`"quote \" slash \\ \/ tab \t newline \n"`, "quote \" slash \\ / tab \t newline \n"},
+		{`"é\u{1F600}😀"`, "é😀😀"},
+		{`"unicode é 😀"`, "unicode é 😀"},
+		{`""`, ""},
+		{`"""block"""`, "block"},
+		{"\"\"\"\n    first\n      indented\n\n    last\n  \"\"\"", "first\n  indented\n\nlast"},
+		{`"""escaped \""" and \n kept raw"""`, `escaped """ and \n kept raw`},
+		{"\"\"\"windows\r\n  line\"\"\"", "windows\nline"},
+	}
+	for _, tt := range tests {
+		toks, err := tokens(tt.src)
+		if err != nil || len(toks) != 1 || toks[0].kind != tokenString {
+			t.Errorf("%s lexes as %v, %v", tt.src, toks, err)
+			continue
+		}
+		if toks[0].value != tt.want {
+			t.Errorf("%s = %q, want %q", tt.src, toks[0].value, tt.want)
+		}
+	}
+}
+
+func TestLexNumbers(t *testing.T) {
+	tests := []struct {
+		src  string
+		kind tokenKind
+	}{
+		{"0", tokenInt},
+		{"-0", tokenInt},
+		{"1234", tokenInt},
+		{"-12", tokenInt},
+		{"1.5", tokenFloat},
+		{"-0.25", tokenFloat},
+		{"1e10", tokenFloat},
+		{"6.02E+23", tokenFloat},
+		{"1e-3", tokenFloat},
+	}
+	for _, tt := range tests {
+		toks, err := tokens(tt.src)
+		if err != nil || len(toks) != 1 || toks[0].kind != tt.kind || toks[0].value != tt.src {
+			t.Errorf("%s lexes as %v, %v", tt.src, toks, err)
+		}
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	tests := []struct {
+		src, message string
+		loc          Location
+	}{
+		{"01", "Invalid number, unexpected digit after 0", Location{1, 2}},
+		{"1.", "Invalid number, expected digit after \".\"", Location{1, 3}},
+		{"1e", "Invalid number, expected digit in exponent", Location{1, 3}},
+		{"12a", "Invalid number, unexpected 'a'", Location{1, 3}},
+		{"1.5.2", "Invalid number, unexpected '.'", Location{1, 4}},
+		{"-", "Invalid number, expected digit", Location{1, 2}},
+		{"..", "Unexpected \".\"", Location{1, 1}},
+		{"\n  ?", "Unexpected character '?'", Location{2, 3}},
+		{`"open`, "Unterminated string", Location{1, 6}},
+		{"\"line\nbreak\"", "Unterminated string", Location{1, 6}},
+		{`"""open`, "Unterminated string", Location{1, 8}},
+		{`"\x"`, "Invalid escape sequence \\x", Location{1, 2}},
+		{`"\u12"`, "Invalid Unicode escape", Location{1, 2}},
+		{`"\uD83D"`, "Invalid Unicode escape", Location{1, 2}},
+		{`"\u{D800}"`, "Invalid Unicode escape", Location{1, 2}},
+		{`"é\q"`, "Invalid escape sequence \\q", Location{1, 3}}, // Columns count characters
+		{"\"\xff\"", "Invalid UTF-8", Location{1, 2}},
+	}
+	for _, tt := range tests {
+		_, err := tokens(tt.src)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: error = %v", tt.src, err)
+			continue
+		}
+		if e.Message != "Syntax Error: "+tt.message || len(e.Locations) != 1 || e.Locations[0] != tt.loc {
+			t.Errorf("%q: error %q at %v, want %q at %v", tt.src, e.Message, e.Locations, tt.message, tt.loc)
+		}
+	}
+}
+
+func TestLexIgnored(t *testing.T) {
+	toks, err := tokens("\uFEFF{ a, # comment }\r\n\tb\r...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tok := range toks {
+		got = append(got, tok.value)
+	}
+	if strings.Join(got, " ") != "{ a b ... }" {
+		t.Errorf("tokens = %v", got)
+	}
+	if toks[2].loc != (Location{2, 2}) || toks[3].loc != (Location{3, 1}) {
+		t.Errorf("locations = %v, %v", toks[2].loc, toks[3].loc)
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc, err := parse(`
+		query Named($id: ID! = "x", $list: [[Int]!]) @include(if: true) {
+			alias: field(a: 1, b: -1.5, c: "s", d: true, e: null, f: ENUM, g: [1, [$list]], h: {x: {y: $id}}) {
+				...Spread @skip(if: false)
+				... on Type { inner }
+				... @include(if: true) { bare }
+			}
+		}
+		fragment Spread on Type { x }
+		mutation { m }
+		{ shorthand }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 3 || len(doc.fragments) != 1 {
+		t.Fatalf("document has %d operations and %d fragments", len(doc.operations), len(doc.fragments))
+	}
+
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Named" || len(op.directives) != 1 || op.loc != (Location{2, 3}) {
+		t.Errorf("operation = %+v", op)
+	}
+	if len(op.variables) != 2 || op.variables[0].typ.String() != "ID!" || op.variables[0].defaultValue.String() != `"x"` || op.variables[1].typ.String() != "[[Int]!]" {
+		t.Errorf("variables = %+v, %+v", op.variables[0], op.variables[1])
+	}
+
+	f := op.selectionSet[0].(*field)
+	if f.alias != "alias" || f.name != "field" || f.responseKey() != "alias" {
+		t.Errorf("field = %+v", f)
+	}
+	want := `a:1,b:-1.5,c:"s",d:true,e:null,f:ENUM,g:[1, [$list]],h:{x: {y: $id}}`
+	if got := printArguments(f.arguments); got != want {
+		t.Errorf("arguments = %s, want %s", got, want)
+	}
+	if !f.arguments[6].value.hasVariables() || f.arguments[0].value.hasVariables() {
+		t.Error("hasVariables is wrong")
+	}
+
+	spread, ok := f.selectionSet[0].(*fragmentSpread)
+	if !ok || spread.name != "Spread" || len(spread.directives) != 1 {
+		t.Errorf("spread = %+v", f.selectionSet[0])
+	}
+	if inline, ok := f.selectionSet[1].(*inlineFragment); !ok || inline.typeCondition != "Type" {
+		t.Errorf("inline fragment = %+v", f.selectionSet[1])
+	}
+	if inline, ok := f.selectionSet[2].(*inlineFragment); !ok || inline.typeCondition != "" || len(inline.directives) != 1 {
+		t.Errorf("inline fragment without a type = %+v", f.selectionSet[2])
+	}
+	if doc.operations[1].kind != "mutation" || doc.operations[2].kind != "query" || doc.operations[2].name != "" {
+		t.Errorf("operations = %+v, %+v", doc.operations[1], doc.operations[2])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src, message string
+		loc          Location
+	}{
+		{"", "Unexpected <EOF>", Location{1, 1}},
+		{"{", "Expected name, found <EOF>", Location{1, 2}},
+		{"{}", "Expected name, found }", Location{1, 2}},
+		{"{ a(b: ) }", "Unexpected )", Location{1, 8}},
+		{"{ a(b: 1 }", "Expected name, found }", Location{1, 10}},
+		{"query ($a: Int = $b) { a }", "Unexpected $", Location{1, 18}},
+		{"query ($a) { a }", "Expected \":\", found )", Location{1, 10}},
+		{"fragment on on T { a }", "Unexpected on", Location{1, 10}},
+		{"fragment F T { a }", "Expected \"on\", found T", Location{1, 12}},
+		{"{ a } b", "Unexpected b", Location{1, 7}},
+		{"{ a: }", "Expected name, found }", Location{1, 6}},
+		{`{ a(b: "\q") }`, "Invalid escape sequence \\q", Location{1, 9}},
+	}
+	for _, tt := range tests {
+		_, err := parse(tt.src)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: error = %v", tt.src, err)
+			continue
+		}
+		if e.Message != "Syntax Error: "+tt.message || len(e.Locations) != 1 || e.Locations[0] != tt.loc {
+			t.Errorf("%q: error %q at %v, want %q at %v", tt.src, e.Message, e.Locations, tt.message, tt.loc)
+		}
+	}
+}
+
+func TestParseTokenLimit(t *testing.T) {
+	// The braces and the end of the document count too
+	fields := func(n int) string { return "{" + strings.Repeat(" a", n) + " }" }
+	start := time.Now()
+	if _, err := parse(fields(maxTokens - 3)); err != nil {
+		t.Errorf("a document of %d tokens: %v", maxTokens, err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("parsing %d tokens on one line took %v", maxTokens, elapsed)
+	}
+	_, err := parse(fields(maxTokens - 2))
+	if err == nil || !strings.Contains(err.Error(), "more than 20000 tokens") {
+		t.Errorf("a document of %d tokens: %v", maxTokens+1, err)
+	}
+}
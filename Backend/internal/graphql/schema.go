@@ -0,0 +1,553 @@
+// Package graphql is a small GraphQL server: a parser, validator and executor
+// for queries and mutations against a schema declared in Go.
+//
+// Resolvers are batched. Execution walks the query breadth-first, calling each
+// field's resolver once per level with every parent object at that level, so a
+// resolver can load the children of all its parents with one database query
+// instead of one per parent. Each wraps a resolver that handles one parent at a
+// time, and the default resolver reads struct fields by their JSON names.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is a *Scalar, *Object, *Enum, *InputObject, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Serialize turns a resolved value into JSON; Parse turns
+// an input value into the value resolvers receive. Input values are JSON values
+// decoded with UseNumber, or literals: json.Number, string, bool, or
+// EnumLiteral for an unquoted name.
+type Scalar struct {
+	Name        string
+	Description string
+	Serialize   func(v interface{}) (interface{}, error)
+	Parse       func(v interface{}) (interface{}, error)
+}
+
+// EnumLiteral is an unquoted name passed to a scalar's Parse
+type EnumLiteral string
+
+// Object is an output type with fields
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+
+	fieldMap map[string]*Field
+}
+
+// Field is a field of an object. A nil Resolve reads the field from the parent
+// by its JSON name.
+type Field struct {
+	Name              string
+	Description       string
+	Type              Type
+	Args              []*Argument
+	Resolve           ResolveFunc
+	DeprecationReason string
+}
+
+// Argument is an argument of a field or a field of an input object. Default,
+// when set, is used if the argument is omitted, and is already in the form
+// resolvers receive.
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+	Default     interface{}
+}
+
+// Enum is a leaf type with a fixed set of names
+type Enum struct {
+	Name        string
+	Description string
+	Values      []*EnumValue
+}
+
+// EnumValue is one name of an enum. Value is what resolvers receive and
+// return for it, the name itself when nil.
+type EnumValue struct {
+	Name              string
+	Description       string
+	Value             interface{}
+	DeprecationReason string
+}
+
+// InputObject is an input type with fields
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      []*Argument
+}
+
+// List is a list of another type
+type List struct {
+	OfType Type
+}
+
+// NonNull is another type that can't be null
+type NonNull struct {
+	OfType Type
+}
+
+func (t *Scalar) String() string      { return t.Name }
+func (t *Object) String() string      { return t.Name }
+func (t *Enum) String() string        { return t.Name }
+func (t *InputObject) String() string { return t.Name }
+func (t *List) String() string        { return "[" + t.OfType.String() + "]" }
+func (t *NonNull) String() string     { return t.OfType.String() + "!" }
+
+// NewList returns a list of t
+func NewList(t Type) *List { return &List{OfType: t} }
+
+// NewNonNull returns t, not null
+func NewNonNull(t Type) *NonNull { return &NonNull{OfType: t} }
+
+// field returns the named field, or nil
+func (t *Object) field(name string) *Field {
+	return t.fieldMap[name]
+}
+
+// value returns the enum value with the given name, or nil
+func (t *Enum) value(name string) *EnumValue {
+	for _, v := range t.Values {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func (v *EnumValue) goValue() interface{} {
+	if v.Value == nil {
+		return v.Name
+	}
+	return v.Value
+}
+
+// namedType strips lists and non-null wrappers
+func namedType(t Type) Type {
+	for {
+		switch wrapped := t.(type) {
+		case *List:
+			t = wrapped.OfType
+		case *NonNull:
+			t = wrapped.OfType
+		default:
+			return t
+		}
+	}
+}
+
+func typeName(t Type) string {
+	switch t := t.(type) {
+	case *Scalar:
+		return t.Name
+	case *Object:
+		return t.Name
+	case *Enum:
+		return t.Name
+	case *InputObject:
+		return t.Name
+	}
+	return ""
+}
+
+func typeDescription(t Type) string {
+	switch t := t.(type) {
+	case *Scalar:
+		return t.Description
+	case *Object:
+		return t.Description
+	case *Enum:
+		return t.Description
+	case *InputObject:
+		return t.Description
+	}
+	return ""
+}
+
+func isLeafType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *Enum:
+		return true
+	}
+	return false
+}
+
+func isInputType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *Enum, *InputObject:
+		return true
+	}
+	return false
+}
+
+func isOutputType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *Enum, *Object:
+		return true
+	}
+	return false
+}
+
+// The built-in scalars
+var (
+	Int = &Scalar{
+		Name:        "Int",
+		Description: "The `Int` scalar type represents non-fractional signed whole numeric values. Int can represent values between -(2^31) and 2^31 - 1.",
+		Serialize:   serializeInt,
+		Parse:       parseInt,
+	}
+	Float = &Scalar{
+		Name:        "Float",
+		Description: "The `Float` scalar type represents signed double-precision fractional values as specified by [IEEE 754](https://en.wikipedia.org/wiki/IEEE_floating_point).",
+		Serialize:   serializeFloat,
+		Parse:       parseFloat,
+	}
+	String = &Scalar{
+		Name:        "String",
+		Description: "The `String` scalar type represents textual data, represented as UTF-8 character sequences.",
+		Serialize:   serializeString,
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent a non string value: %s", describe(v))
+		},
+	}
+	Boolean = &Scalar{
+		Name:        "Boolean",
+		Description: "The `Boolean` scalar type represents `true` or `false`.",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.Bool {
+				return rv.Bool(), nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %s", describe(v))
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %s", describe(v))
+		},
+	}
+	ID = &Scalar{
+		Name:        "ID",
+		Description: "The `ID` scalar type represents a unique identifier, often used to refetch an object or as key for a cache. The ID type appears in a JSON response as a String; however, it is not intended to be human-readable. When expected as an input type, any string (such as `\"4\"`) or integer (such as `4`) input value will be accepted as an ID.",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if hex, ok := v.(interface{ Hex() string }); ok {
+				return hex.Hex(), nil
+			}
+			if n, err := serializeInt(v); err == nil {
+				return strconv.Itoa(n.(int)), nil
+			}
+			if s, err := serializeString(v); err == nil {
+				return s, nil
+			}
+			return nil, fmt.Errorf("ID cannot represent value: %s", describe(v))
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case string:
+				return v, nil
+			case json.Number:
+				if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+					return string(v), nil
+				}
+			}
+			return nil, fmt.Errorf("ID cannot represent value: %s", describe(v))
+		},
+	}
+)
+
+func serializeInt(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	var n int64
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %d", rv.Uint())
+		}
+		n = int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("Int cannot represent non-integer value: %v", f)
+		}
+		if f < math.MinInt32 || f > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %v", f)
+		}
+		n = int64(f)
+	default:
+		return nil, fmt.Errorf("Int cannot represent non-integer value: %s", describe(v))
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return nil, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %d", n)
+	}
+	return int(n), nil
+}
+
+func parseInt(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Int cannot represent non-integer value: %s", v)
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %s", v)
+		}
+		return int(n), nil
+	case float64, int, int64:
+		return serializeInt(v)
+	}
+	return nil, fmt.Errorf("Int cannot represent non-integer value: %s", describe(v))
+}
+
+func serializeFloat(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		if f := rv.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("Float cannot represent non numeric value: %s", describe(v))
+}
+
+func parseFloat(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("Float cannot represent non numeric value: %s", v)
+		}
+		return f, nil
+	case float64, int, int64:
+		return serializeFloat(v)
+	}
+	return nil, fmt.Errorf("Float cannot represent non numeric value: %s", describe(v))
+}
+
+func serializeString(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	return nil, fmt.Errorf("String cannot represent value: %s", describe(v))
+}
+
+// describe prints an input value for an error message
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case EnumLiteral:
+		return string(v)
+	case json.Number:
+		return string(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// SchemaConfig declares a schema's root types
+type SchemaConfig struct {
+	Query    *Object
+	Mutation *Object // Optional
+}
+
+// Schema is a validated set of types, ready to run queries against
+type Schema struct {
+	query    *Object
+	mutation *Object
+	types    map[string]Type
+}
+
+// NewSchema collects the types reachable from the root types and checks them
+func NewSchema(config SchemaConfig) (*Schema, error) {
+	if config.Query == nil {
+		return nil, fmt.Errorf("graphql: schema has no query type")
+	}
+	s := &Schema{query: config.Query, mutation: config.Mutation, types: map[string]Type{}}
+
+	roots := []Type{config.Query, String, Boolean, schemaType}
+	if config.Mutation != nil {
+		roots = append(roots, config.Mutation)
+	}
+	for _, root := range roots {
+		if err := s.add(root); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// add registers t and every type it refers to
+func (s *Schema) add(t Type) error {
+	t = namedType(t)
+	name := typeName(t)
+	if existing, ok := s.types[name]; ok {
+		if existing != t {
+			return fmt.Errorf("graphql: schema has two types named %q", name)
+		}
+		return nil
+	}
+	if !validName(name) {
+		return fmt.Errorf("graphql: invalid type name %q", name)
+	}
+	s.types[name] = t
+
+	switch t := t.(type) {
+	case *Scalar:
+		if t.Serialize == nil || t.Parse == nil {
+			return fmt.Errorf("graphql: scalar %s needs Serialize and Parse", t.Name)
+		}
+	case *Enum:
+		if len(t.Values) == 0 {
+			return fmt.Errorf("graphql: enum %s has no values", t.Name)
+		}
+		for _, v := range t.Values {
+			if !validName(v.Name) || v.Name == "true" || v.Name == "false" || v.Name == "null" {
+				return fmt.Errorf("graphql: invalid enum value %s.%s", t.Name, v.Name)
+			}
+		}
+	case *Object:
+		if len(t.Fields) == 0 {
+			return fmt.Errorf("graphql: object %s has no fields", t.Name)
+		}
+		t.fieldMap = make(map[string]*Field, len(t.Fields))
+		for _, f := range t.Fields {
+			if !validName(f.Name) || t.fieldMap[f.Name] != nil {
+				return fmt.Errorf("graphql: invalid or repeated field %s.%s", t.Name, f.Name)
+			}
+			if f.Type == nil || !isOutputType(f.Type) {
+				return fmt.Errorf("graphql: field %s.%s needs an output type", t.Name, f.Name)
+			}
+			t.fieldMap[f.Name] = f
+			if err := s.addArguments(t.Name+"."+f.Name, f.Args); err != nil {
+				return err
+			}
+			if err := s.add(f.Type); err != nil {
+				return err
+			}
+		}
+	case *InputObject:
+		if len(t.Fields) == 0 {
+			return fmt.Errorf("graphql: input object %s has no fields", t.Name)
+		}
+		return s.addArguments(t.Name, t.Fields)
+	default:
+		return fmt.Errorf("graphql: unsupported type %T", t)
+	}
+	return nil
+}
+
+func (s *Schema) addArguments(owner string, args []*Argument) error {
+	seen := map[string]bool{}
+	for _, arg := range args {
+		if !validName(arg.Name) || seen[arg.Name] {
+			return fmt.Errorf("graphql: invalid or repeated argument %s(%s)", owner, arg.Name)
+		}
+		seen[arg.Name] = true
+		if arg.Type == nil || !isInputType(arg.Type) {
+			return fmt.Errorf("graphql: argument %s(%s) needs an input type", owner, arg.Name)
+		}
+		if err := s.add(arg.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validName reports whether name is a GraphQL name not reserved for introspection
+func validName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") && !introspectionTypes[name] {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c == '_' || isLetter(c) || i > 0 && isDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// typeList returns the schema's types sorted by name
+func (s *Schema) typeList() []Type {
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	types := make([]Type, len(names))
+	for i, name := range names {
+		types[i] = s.types[name]
+	}
+	return types
+}
+
+// resolveRef returns the type a variable definition refers to, or nil if it's unknown
+func (s *Schema) resolveRef(ref *typeRef) Type {
+	var t Type
+	if ref.elem != nil {
+		elem := s.resolveRef(ref.elem)
+		if elem == nil {
+			return nil
+		}
+		t = NewList(elem)
+	} else if t = s.types[ref.name]; t == nil {
+		return nil
+	}
+	if ref.nonNull {
+		return NewNonNull(t)
+	}
+	return t
+}
+
+// Error is a GraphQL error. Resolvers may return one to set Extensions; Path
+// and Locations are filled in by the executor.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response is the result of a request. Data is absent when the request failed
+// before execution, and null when execution failed at the root.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
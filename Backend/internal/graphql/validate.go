@@ -0,0 +1,594 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxDepth is how deeply selections may nest, counting from the root fields
+const MaxDepth = 15
+
+// MaxFields is how many fields an operation may select, counting each
+// fragment once for every place it's spread
+const MaxFields = 1000
+
+// executableDirectiveLocations lists where @include and @skip may appear
+var executableDirectiveLocations = []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}
+
+// variableUsage is a variable used where a value of the given type is expected
+type variableUsage struct {
+	name       string
+	typ        Type
+	hasDefault bool // The argument or input field has a default
+	loc        Location
+}
+
+// validator checks a document against the schema
+type validator struct {
+	schema    *Schema
+	doc       *document
+	fragments map[string]*fragmentDefinition
+	errors    []*Error
+
+	// Per operation or fragment body: the fragments it spreads and the variables it uses
+	spreads map[interface{}][]string
+	usages  map[interface{}][]variableUsage
+	owner   interface{}
+}
+
+// validate returns every error in the document
+func (s *Schema) validate(doc *document) []*Error {
+	v := &validator{
+		schema:    s,
+		doc:       doc,
+		fragments: map[string]*fragmentDefinition{},
+		spreads:   map[interface{}][]string{},
+		usages:    map[interface{}][]variableUsage{},
+	}
+
+	for _, fragment := range doc.fragments {
+		if v.fragments[fragment.name] != nil {
+			v.errorf(fragment.loc, "There can be only one fragment named \"%s\".", fragment.name)
+			continue
+		}
+		v.fragments[fragment.name] = fragment
+	}
+
+	names := map[string]bool{}
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			v.errorf(op.loc, "This anonymous operation must be the only defined operation.")
+		}
+		if op.name != "" && names[op.name] {
+			v.errorf(op.loc, "There can be only one operation named \"%s\".", op.name)
+		}
+		names[op.name] = true
+	}
+
+	for _, fragment := range doc.fragments {
+		if v.fragments[fragment.name] != fragment {
+			continue
+		}
+		t, ok := v.schema.types[fragment.typeCondition].(*Object)
+		if !ok {
+			if v.schema.types[fragment.typeCondition] == nil {
+				v.errorf(fragment.loc, "Unknown type \"%s\".", fragment.typeCondition)
+			} else {
+				v.errorf(fragment.loc, "Fragment \"%s\" cannot condition on non composite type \"%s\".", fragment.name, fragment.typeCondition)
+			}
+			continue
+		}
+		v.owner = fragment
+		v.directives(fragment.directives, "FRAGMENT_DEFINITION")
+		v.selectionSet(t, fragment.selectionSet)
+	}
+	cyclic := v.fragmentCycles()
+
+	used := map[string]bool{}
+	for _, op := range doc.operations {
+		var root *Object
+		switch op.kind {
+		case "query":
+			root = v.schema.query
+		case "mutation":
+			root = v.schema.mutation
+		}
+		if root == nil {
+			v.errorf(op.loc, "Schema is not configured to execute %s operation.", op.kind)
+			continue
+		}
+
+		v.owner = op
+		v.variableDefinitions(op)
+		v.directives(op.directives, strings.ToUpper(op.kind))
+		v.selectionSet(root, op.selectionSet)
+
+		reachable := v.reachableFragments(op)
+		for name := range reachable {
+			used[name] = true
+		}
+		v.variableUsages(op, reachable)
+		if !cyclic {
+			// Counting first bounds the work of the later walks over fragments
+			if v.fieldCount(op.selectionSet, 0) > MaxFields {
+				v.errorf(op.loc, "Query selects more than the limit of %d fields.", MaxFields)
+				continue
+			}
+			if depth := v.depth(op.selectionSet, 0); depth > MaxDepth {
+				v.errorf(op.loc, "Query is nested %d levels deep, deeper than the limit of %d.", depth, MaxDepth)
+			}
+			v.overlaps(root, op.selectionSet, 0)
+		}
+	}
+
+	for _, fragment := range doc.fragments {
+		if !used[fragment.name] && v.fragments[fragment.name] == fragment {
+			v.errorf(fragment.loc, "Fragment \"%s\" is never used.", fragment.name)
+		}
+	}
+	return v.errors
+}
+
+func (v *validator) errorf(loc Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}})
+}
+
+func (v *validator) variableDefinitions(op *operationDefinition) {
+	seen := map[string]bool{}
+	for _, definition := range op.variables {
+		if seen[definition.name] {
+			v.errorf(definition.loc, "There can be only one variable named \"$%s\".", definition.name)
+		}
+		seen[definition.name] = true
+
+		t := v.schema.resolveRef(definition.typ)
+		if t == nil || !isInputType(t) {
+			v.errorf(definition.loc, "Variable \"$%s\" cannot be non-input type \"%s\".", definition.name, definition.typ)
+			continue
+		}
+		if definition.defaultValue != nil {
+			if _, err := coerceLiteral(t, definition.defaultValue, nil); err != nil {
+				v.errorf(definition.defaultValue.loc, "Variable \"$%s\" has invalid default value %s. %v", definition.name, definition.defaultValue, err)
+			}
+		}
+	}
+}
+
+func (v *validator) selectionSet(t *Object, selections []selection) {
+	for _, s := range selections {
+		switch s := s.(type) {
+		case *field:
+			v.directives(s.directives, "FIELD")
+			v.field(t, s)
+		case *fragmentSpread:
+			v.directives(s.directives, "FRAGMENT_SPREAD")
+			fragment := v.fragments[s.name]
+			if fragment == nil {
+				v.errorf(s.loc, "Unknown fragment \"%s\".", s.name)
+				continue
+			}
+			v.spreads[v.owner] = append(v.spreads[v.owner], s.name)
+			if _, ok := v.schema.types[fragment.typeCondition].(*Object); ok && fragment.typeCondition != t.Name {
+				v.errorf(s.loc, "Fragment \"%s\" cannot be spread here as objects of type \"%s\" can never be of type \"%s\".", s.name, t.Name, fragment.typeCondition)
+			}
+		case *inlineFragment:
+			v.directives(s.directives, "INLINE_FRAGMENT")
+			if s.typeCondition != "" && s.typeCondition != t.Name {
+				switch v.schema.types[s.typeCondition].(type) {
+				case nil:
+					v.errorf(s.loc, "Unknown type \"%s\".", s.typeCondition)
+				case *Object:
+					v.errorf(s.loc, "Fragment cannot be spread here as objects of type \"%s\" can never be of type \"%s\".", t.Name, s.typeCondition)
+				default:
+					v.errorf(s.loc, "Fragment cannot condition on non composite type \"%s\".", s.typeCondition)
+				}
+				continue
+			}
+			v.selectionSet(t, s.selectionSet)
+		}
+	}
+}
+
+func (v *validator) field(parent *Object, f *field) {
+	definition := v.schema.fieldDefinition(parent, f.name)
+	if definition == nil {
+		v.errorf(f.loc, "Cannot query field \"%s\" on type \"%s\".", f.name, parent.Name)
+		return
+	}
+
+	v.arguments(fmt.Sprintf("%s.%s", parent.Name, f.name), "field", definition.Args, f.arguments, f.loc)
+
+	switch t := namedType(definition.Type).(type) {
+	case *Object:
+		if len(f.selectionSet) == 0 {
+			v.errorf(f.loc, "Field \"%s\" of type \"%s\" must have a selection of subfields. Did you mean \"%s { ... }\"?", f.name, definition.Type, f.name)
+			return
+		}
+		v.selectionSet(t, f.selectionSet)
+	default:
+		if len(f.selectionSet) > 0 {
+			v.errorf(f.loc, "Field \"%s\" must not have a selection since type \"%s\" has no subfields.", f.name, definition.Type)
+		}
+	}
+}
+
+// arguments checks the arguments given to a field or directive
+func (v *validator) arguments(owner, kind string, definitions []*Argument, nodes []*argument, loc Location) {
+	seen := map[string]bool{}
+	for _, node := range nodes {
+		if seen[node.name] {
+			v.errorf(node.loc, "There can be only one argument named \"%s\".", node.name)
+			continue
+		}
+		seen[node.name] = true
+
+		var definition *Argument
+		for _, d := range definitions {
+			if d.Name == node.name {
+				definition = d
+			}
+		}
+		if definition == nil {
+			v.errorf(node.loc, "Unknown argument \"%s\" on %s \"%s\".", node.name, kind, owner)
+			continue
+		}
+		if err := v.literal(definition.Type, node.value, definition.Default != nil); err != nil {
+			v.errorf(node.value.loc, "%v", err)
+		}
+	}
+
+	for _, definition := range definitions {
+		if _, required := definition.Type.(*NonNull); required && !seen[definition.Name] && definition.Default == nil {
+			v.errorf(loc, "%s \"%s\" argument \"%s\" of type \"%s\" is required, but it was not provided.", strings.ToUpper(kind[:1])+kind[1:], owner, definition.Name, definition.Type)
+		}
+	}
+}
+
+// literal checks a value against the type expected where it's used, and
+// records the variables inside it
+func (v *validator) literal(t Type, node *value, hasDefault bool) error {
+	if node.kind == variableValue {
+		v.usages[v.owner] = append(v.usages[v.owner], variableUsage{name: node.raw, typ: t, hasDefault: hasDefault, loc: node.loc})
+		return nil
+	}
+	if !node.hasVariables() {
+		_, err := coerceLiteral(t, node, nil)
+		return err
+	}
+
+	// Check the parts around the variables
+	if nonNull, ok := t.(*NonNull); ok {
+		t = nonNull.OfType
+	}
+	switch t := t.(type) {
+	case *List:
+		if node.kind != listValue {
+			return v.literal(t.OfType, node, false)
+		}
+		for _, item := range node.list {
+			if err := v.literal(t.OfType, item, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *InputObject:
+		if node.kind != objectValue {
+			return fmt.Errorf("Expected value of type \"%s\", found %s.", t.Name, node)
+		}
+		seen := map[string]bool{}
+		for _, f := range node.fields {
+			definition := inputField(t, f.name)
+			if definition == nil {
+				return fmt.Errorf("Field \"%s\" is not defined by type \"%s\".", f.name, t.Name)
+			}
+			if seen[f.name] {
+				return fmt.Errorf("There can be only one input field named \"%s\".", f.name)
+			}
+			seen[f.name] = true
+			if err := v.literal(definition.Type, f.value, definition.Default != nil); err != nil {
+				return err
+			}
+		}
+		for _, definition := range t.Fields {
+			if _, required := definition.Type.(*NonNull); required && !seen[definition.Name] && definition.Default == nil {
+				return fmt.Errorf("Field \"%s.%s\" of required type \"%s\" was not provided.", t.Name, definition.Name, definition.Type)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Expected value of type \"%s\", found %s.", t, node)
+}
+
+func (v *validator) directives(directives []*directive, location string) {
+	seen := map[string]bool{}
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			v.errorf(d.loc, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		allowed := false
+		for _, l := range executableDirectiveLocations {
+			allowed = allowed || l == location
+		}
+		if !allowed {
+			v.errorf(d.loc, "Directive \"@%s\" may not be used on %s.", d.name, location)
+			continue
+		}
+		if seen[d.name] {
+			v.errorf(d.loc, "The directive \"@%s\" can only be used once at this location.", d.name)
+		}
+		seen[d.name] = true
+		v.arguments("@"+d.name, "directive", conditionArgs, d.arguments, d.loc)
+	}
+}
+
+// fragmentCycles reports fragments that spread themselves
+func (v *validator) fragmentCycles() bool {
+	cyclic := false
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		fragment := v.fragments[name]
+		if fragment == nil || state[name] == done {
+			return
+		}
+		if state[name] == visiting {
+			cyclic = true
+			start := 0
+			for i, n := range path {
+				if n == name {
+					start = i
+				}
+			}
+			via := ""
+			if cycle := path[start+1:]; len(cycle) > 0 {
+				via = " via " + strings.Join(cycle, ", ")
+			}
+			v.errorf(fragment.loc, "Cannot spread fragment \"%s\" within itself%s.", name, via)
+			return
+		}
+		state[name] = visiting
+		for _, spread := range v.spreads[fragment] {
+			visit(spread, append(path, name))
+		}
+		state[name] = done
+	}
+
+	names := make([]string, 0, len(v.fragments))
+	for name := range v.fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		visit(name, nil)
+	}
+	return cyclic
+}
+
+// reachableFragments returns the fragments an operation spreads, directly or not
+func (v *validator) reachableFragments(op *operationDefinition) map[string]bool {
+	reachable := map[string]bool{}
+	queue := append([]string(nil), v.spreads[op]...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if reachable[name] || v.fragments[name] == nil {
+			continue
+		}
+		reachable[name] = true
+		queue = append(queue, v.spreads[v.fragments[name]]...)
+	}
+	return reachable
+}
+
+// variableUsages checks that the operation defines every variable it uses,
+// with a compatible type, and uses every variable it defines
+func (v *validator) variableUsages(op *operationDefinition, fragments map[string]bool) {
+	usages := append([]variableUsage(nil), v.usages[op]...)
+	names := make([]string, 0, len(fragments))
+	for name := range fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		usages = append(usages, v.usages[v.fragments[name]]...)
+	}
+
+	definitions := map[string]*variableDefinition{}
+	for _, definition := range op.variables {
+		definitions[definition.name] = definition
+	}
+
+	used := map[string]bool{}
+	for _, usage := range usages {
+		used[usage.name] = true
+		definition := definitions[usage.name]
+		if definition == nil {
+			if op.name != "" {
+				v.errorf(usage.loc, "Variable \"$%s\" is not defined by operation \"%s\".", usage.name, op.name)
+			} else {
+				v.errorf(usage.loc, "Variable \"$%s\" is not defined.", usage.name)
+			}
+			continue
+		}
+
+		varType := v.schema.resolveRef(definition.typ)
+		if varType == nil || !isInputType(varType) {
+			continue
+		}
+		locationType := usage.typ
+		if nonNull, ok := locationType.(*NonNull); ok {
+			if _, ok := varType.(*NonNull); !ok {
+				hasDefault := definition.defaultValue != nil && definition.defaultValue.kind != nullValue
+				if hasDefault || usage.hasDefault {
+					locationType = nonNull.OfType
+				}
+			}
+		}
+		if !subType(varType, locationType) {
+			v.errorf(usage.loc, "Variable \"$%s\" of type \"%s\" used in position expecting type \"%s\".", usage.name, varType, usage.typ)
+		}
+	}
+
+	for _, definition := range op.variables {
+		if !used[definition.name] {
+			if op.name != "" {
+				v.errorf(definition.loc, "Variable \"$%s\" is never used in operation \"%s\".", definition.name, op.name)
+			} else {
+				v.errorf(definition.loc, "Variable \"$%s\" is never used.", definition.name)
+			}
+		}
+	}
+}
+
+// subType reports whether a value of type a can be used where b is expected
+func subType(a, b Type) bool {
+	if nb, ok := b.(*NonNull); ok {
+		na, ok := a.(*NonNull)
+		return ok && subType(na.OfType, nb.OfType)
+	}
+	if na, ok := a.(*NonNull); ok {
+		return subType(na.OfType, b)
+	}
+	if lb, ok := b.(*List); ok {
+		la, ok := a.(*List)
+		return ok && subType(la.OfType, lb.OfType)
+	}
+	if _, ok := a.(*List); ok {
+		return false
+	}
+	return a == b
+}
+
+// depth returns how deeply the selections nest, following fragments
+func (v *validator) depth(selections []selection, level int) int {
+	deepest := level
+	if level > MaxDepth {
+		return level
+	}
+	for _, s := range selections {
+		var d int
+		switch s := s.(type) {
+		case *field:
+			if len(s.selectionSet) == 0 {
+				d = level + 1
+			} else {
+				d = v.depth(s.selectionSet, level+1)
+			}
+		case *fragmentSpread:
+			if fragment := v.fragments[s.name]; fragment != nil {
+				d = v.depth(fragment.selectionSet, level)
+			}
+		case *inlineFragment:
+			d = v.depth(s.selectionSet, level)
+		}
+		deepest = max(deepest, d)
+	}
+	return deepest
+}
+
+// fieldCount returns how many fields the selections hold once fragments are
+// expanded, adding to counted and stopping soon after the limit is passed
+func (v *validator) fieldCount(selections []selection, counted int) int {
+	for _, s := range selections {
+		if counted > MaxFields {
+			return counted
+		}
+		switch s := s.(type) {
+		case *field:
+			counted = v.fieldCount(s.selectionSet, counted+1)
+		case *fragmentSpread:
+			if fragment := v.fragments[s.name]; fragment != nil {
+				counted = v.fieldCount(fragment.selectionSet, counted)
+			}
+		case *inlineFragment:
+			counted = v.fieldCount(s.selectionSet, counted)
+		}
+	}
+	return counted
+}
+
+// overlaps reports fields returned under the same key that can't be merged
+func (v *validator) overlaps(t *Object, selections []selection, level int) {
+	if level > MaxDepth {
+		return
+	}
+	keys, groups := v.fieldsByKey(selections, map[string]bool{})
+	for _, key := range keys {
+		fields := groups[key]
+		first := fields[0]
+		conflict := false
+		for _, f := range fields[1:] {
+			switch {
+			case f.name != first.name:
+				v.errorf(f.loc, "Fields \"%s\" conflict because \"%s\" and \"%s\" are different fields. Use different aliases on the fields to fetch both if this was intentional.", key, first.name, f.name)
+				conflict = true
+			case printArguments(f.arguments) != printArguments(first.arguments):
+				v.errorf(f.loc, "Fields \"%s\" conflict because they have differing arguments. Use different aliases on the fields to fetch both if this was intentional.", key)
+				conflict = true
+			}
+			if conflict {
+				break
+			}
+		}
+		if conflict {
+			continue
+		}
+
+		definition := v.schema.fieldDefinition(t, first.name)
+		if definition == nil {
+			continue
+		}
+		child, ok := namedType(definition.Type).(*Object)
+		if !ok {
+			continue
+		}
+		var merged []selection
+		for _, f := range fields {
+			merged = append(merged, f.selectionSet...)
+		}
+		v.overlaps(child, merged, level+1)
+	}
+}
+
+// fieldsByKey groups the fields of a selection set by response key, following fragments
+func (v *validator) fieldsByKey(selections []selection, visited map[string]bool) ([]string, map[string][]*field) {
+	var keys []string
+	groups := map[string][]*field{}
+	var collect func([]selection)
+	collect = func(selections []selection) {
+		for _, s := range selections {
+			switch s := s.(type) {
+			case *field:
+				key := s.responseKey()
+				if groups[key] == nil {
+					keys = append(keys, key)
+				}
+				groups[key] = append(groups[key], s)
+			case *fragmentSpread:
+				if fragment := v.fragments[s.name]; fragment != nil && !visited[s.name] {
+					visited[s.name] = true
+					collect(fragment.selectionSet)
+				}
+			case *inlineFragment:
+				collect(s.selectionSet)
+			}
+		}
+	}
+	collect(selections)
+	return keys, groups
+}
+
+func printArguments(arguments []*argument) string {
+	printed := make([]string, len(arguments))
+	for i, arg := range arguments {
+		printed[i] = arg.name + ":" + arg.value.String()
+	}
+	sort.Strings(printed)
+	return strings.Join(printed, ",")
+}
@@ -0,0 +1,184 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validationErrors parses and validates query against the test schema
+func validationErrors(t *testing.T, query string) []string {
+	t.Helper()
+	schema, _ := newTestSchema(t)
+	doc, err := parse(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	var messages []string
+	for _, e := range schema.validate(doc) {
+		messages = append(messages, e.Message)
+	}
+	return messages
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        string // The only error, or empty when the query is valid
+	}{
+		// Fields
+		{"unknown field", `{ nope }`, `Cannot query field "nope" on type "Query".`},
+		{"unknown nested field", `{ pets { nope } }`, `Cannot query field "nope" on type "Pet".`},
+		{"object without selection", `{ pet(name: "Rex") }`, `Field "pet" of type "Pet" must have a selection of subfields. Did you mean "pet { ... }"?`},
+		{"selection on a scalar", `{ hello { x } }`, `Field "hello" must not have a selection since type "String!" has no subfields.`},
+		{"typename anywhere", `{ __typename pets { __typename } }`, ``},
+		{"introspection only on the query root", `{ pets { __schema { queryType { name } } } }`, `Cannot query field "__schema" on type "Pet".`},
+
+		// Arguments
+		{"unknown argument", `{ hello(nom: "x") }`, `Unknown argument "nom" on field "Query.hello".`},
+		{"duplicate argument", `{ hello(name: "a", name: "b") }`, `There can be only one argument named "name".`},
+		{"missing argument", `{ pet { name } }`, `Field "Query.pet" argument "name" of type "String!" is required, but it was not provided.`},
+		{"wrong literal", `{ pet(name: 1) { name } }`, `Expected value of type "String", found 1; String cannot represent a non string value: 1`},
+		{"null for a non-null argument", `{ pet(name: null) { name } }`, `Expected value of type "String!", found null.`},
+		{"unknown enum value", `{ pets(kind: BIRD) { name } }`, `Value "BIRD" does not exist in "PetKind" enum.`},
+		{"string for an enum", `{ pets(kind: "DOG") { name } }`, `Enum "PetKind" cannot represent non-enum value: "DOG".`},
+		{"missing input field", `{ pets(filter: {limit: 1}) { name } }`, `Field "PetFilter.kind" of required type "PetKind!" was not provided.`},
+		{"unknown input field", `{ pets(filter: {kind: DOG, colour: RED}) { name } }`, `Field "colour" is not defined by type "PetFilter".`},
+		{"repeated input field", `{ pets(filter: {kind: DOG, kind: CAT}) { name } }`, `There can be only one input field named "kind".`},
+		{"int out of range", `{ pets(filter: {kind: DOG, limit: 3000000000}) { name } }`, `Expected value of type "Int", found 3000000000; Int cannot represent non 32-bit signed integer value: 3000000000`},
+
+		// Variables
+		{"undefined variable", `query Q { hello(name: $n) }`, `Variable "$n" is not defined by operation "Q".`},
+		{"unused variable", `query($n: String) { hello }`, `Variable "$n" is never used.`},
+		{"duplicate variable", `query($n: String, $n: String) { hello(name: $n) }`, `There can be only one variable named "$n".`},
+		{"output type variable", `query($p: Pet) { hello(name: $p) }`, `Variable "$p" cannot be non-input type "Pet".`},
+		{"unknown variable type", `query($p: Cat) { hello(name: $p) }`, `Variable "$p" cannot be non-input type "Cat".`},
+		{"nullable variable for a non-null argument", `query($n: String) { pet(name: $n) { name } }`, `Variable "$n" of type "String" used in position expecting type "String!".`},
+		{"nullable variable with a default", `query($n: String = "Rex") { pet(name: $n) { name } }`, ``},
+		{"variable for a list item", `query($id: ID!) { ids(ids: [$id, "b"]) }`, ``},
+		{"variable of the wrong type", `query($n: Int) { hello(name: $n) }`, `Variable "$n" of type "Int" used in position expecting type "String".`},
+		{"list variable for an item", `query($n: [String]) { hello(name: $n) }`, `Variable "$n" of type "[String]" used in position expecting type "String".`},
+		{"bad default", `query($n: String = 1) { hello(name: $n) }`, `Variable "$n" has invalid default value 1. Expected value of type "String", found 1; String cannot represent a non string value: 1`},
+		{"variable in a fragment", `query($n: String!) { ...F } fragment F on Query { pet(name: $n) { name } }`, ``},
+		{"variable missing for a fragment", `query Q { ...F } fragment F on Query { pet(name: $n) { name } }`, `Variable "$n" is not defined by operation "Q".`},
+
+		// Fragments
+		{"unknown fragment", `{ ...F }`, `Unknown fragment "F".`},
+		{"unused fragment", `{ hello } fragment F on Query { hello }`, `Fragment "F" is never used.`},
+		{"duplicate fragment", `{ ...F } fragment F on Query { hello } fragment F on Query { hello }`, `There can be only one fragment named "F".`},
+		{"fragment on a scalar", `{ ...F } fragment F on String { x }`, `Fragment "F" cannot condition on non composite type "String".`},
+		{"fragment on an unknown type", `{ ...F } fragment F on Cat { x }`, `Unknown type "Cat".`},
+		{"spread of another type", `{ pets { ...F } } fragment F on Query { hello }`, `Fragment "F" cannot be spread here as objects of type "Pet" can never be of type "Query".`},
+		{"inline fragment of another type", `{ pets { ... on Query { hello } } }`, `Fragment cannot be spread here as objects of type "Pet" can never be of type "Query".`},
+		{"inline fragment on a scalar", `{ pets { ... on String { x } } }`, `Fragment cannot condition on non composite type "String".`},
+		{"fragment cycle", `{ ...A } fragment A on Query { ...B } fragment B on Query { ...A }`, `Cannot spread fragment "A" within itself via B.`},
+		{"fragment spreading itself", `{ ...A } fragment A on Query { hello ...A }`, `Cannot spread fragment "A" within itself.`},
+
+		// Operations
+		{"anonymous with others", `{ hello } query Q { hello }`, `This anonymous operation must be the only defined operation.`},
+		{"duplicate operation", `query Q { hello } query Q { hello }`, `There can be only one operation named "Q".`},
+		{"subscription", `subscription { hello }`, `Schema is not configured to execute subscription operation.`},
+		{"mutation field in a query", `{ add(n: 1) }`, `Cannot query field "add" on type "Query".`},
+
+		// Directives
+		{"unknown directive", `{ hello @nope }`, `Unknown directive "@nope".`},
+		{"directive on an operation", `query @skip(if: true) { hello }`, `Directive "@skip" may not be used on QUERY.`},
+		{"repeated directive", `{ hello @skip(if: true) @skip(if: false) }`, `The directive "@skip" can only be used once at this location.`},
+		{"directive without its argument", `{ hello @include }`, `Directive "@include" argument "if" of type "Boolean!" is required, but it was not provided.`},
+
+		// Overlapping fields
+		{"same field twice", `{ hello hello }`, ``},
+		{"different fields under one key", `{ a: hello a: fail }`, `Fields "a" conflict because "hello" and "fail" are different fields. Use different aliases on the fields to fetch both if this was intentional.`},
+		{"different arguments under one key", `{ hello(name: "a") hello(name: "b") }`, `Fields "hello" conflict because they have differing arguments. Use different aliases on the fields to fetch both if this was intentional.`},
+		{"conflict through a fragment", `{ pets { name ...F } } fragment F on Pet { name: kind }`, `Fields "name" conflict because "name" and "kind" are different fields. Use different aliases on the fields to fetch both if this was intentional.`},
+		{"conflict in merged children", `{ pet(name: "Rex") { n: name } pet(name: "Rex") { n: kind } }`, `Fields "n" conflict because "name" and "kind" are different fields. Use different aliases on the fields to fetch both if this was intentional.`},
+	}
+	for _, tt := range tests {
+		got := validationErrors(t, tt.query)
+		switch {
+		case tt.want == "" && len(got) > 0:
+			t.Errorf("%s: %s gave %q", tt.name, tt.query, got)
+		case tt.want != "" && (len(got) != 1 || got[0] != tt.want):
+			t.Errorf("%s: %s gave %q\nwant %q", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	got := validationErrors(t, `query Q($unused: Int) { nope hello(nom: 1) ...Missing }`)
+	want := []string{
+		`Cannot query field "nope" on type "Query".`,
+		`Unknown argument "nom" on field "Query.hello".`,
+		`Unknown fragment "Missing".`,
+		`Variable "$unused" is never used in operation "Q".`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("errors = %q\nwant %q", got, want)
+	}
+}
+
+// nested returns a query whose friends fields nest depth levels deep
+func nested(depth int) string {
+	return "{ pets { " + strings.Repeat("friends { ", depth-1) + "name" + strings.Repeat(" }", depth) + " }"
+}
+
+func TestValidateDepth(t *testing.T) {
+	if got := validationErrors(t, nested(MaxDepth-1)); len(got) > 0 {
+		t.Errorf("a query %d levels deep: %q", MaxDepth, got)
+	}
+	want := fmt.Sprintf("Query is nested %d levels deep, deeper than the limit of %d.", MaxDepth+1, MaxDepth)
+	if got := validationErrors(t, nested(MaxDepth)); len(got) != 1 || got[0] != want {
+		t.Errorf("a query %d levels deep: %q", MaxDepth+1, got)
+	}
+
+	// Fragments add the depth of what they select
+	query := "{ pets { ...F } } fragment F on Pet { " + strings.Repeat("friends { ", MaxDepth-1) + "name" + strings.Repeat(" }", MaxDepth-1) + " }"
+	if got := validationErrors(t, query); len(got) != 1 || got[0] != want {
+		t.Errorf("a query nested through a fragment: %q", got)
+	}
+}
+
+func TestValidateFieldCount(t *testing.T) {
+	aliases := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "a%d: hello ", i)
+		}
+		return "{ " + b.String() + "}"
+	}
+	want := fmt.Sprintf("Query selects more than the limit of %d fields.", MaxFields)
+
+	if got := validationErrors(t, aliases(MaxFields)); len(got) > 0 {
+		t.Errorf("%d fields: %q", MaxFields, got)
+	}
+	if got := validationErrors(t, aliases(MaxFields+1)); len(got) != 1 || got[0] != want {
+		t.Errorf("%d fields: %q", MaxFields+1, got)
+	}
+
+	// A fragment counts again for every place it's spread: 101 pets of 10 fields
+	var b strings.Builder
+	for i := 0; i < 101; i++ {
+		fmt.Fprintf(&b, "p%d: pets { ...F } ", i)
+	}
+	query := "{ " + b.String() + "} fragment F on Pet { " + strings.Repeat("name ", 9) + "}"
+	if got := validationErrors(t, query); len(got) != 1 || got[0] != want {
+		t.Errorf("fields spread 101 times: %q", got)
+	}
+
+	// Fragments that each spread the next twice select 2^40 fields; counting
+	// stops at the limit rather than expanding them
+	b.Reset()
+	b.WriteString("{ ...F0 } ")
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, "fragment F%d on Query { ...F%d ...F%d } ", i, i+1, i+1)
+	}
+	b.WriteString("fragment F40 on Query { hello }")
+	start := time.Now()
+	if got := validationErrors(t, b.String()); len(got) != 1 || got[0] != want {
+		t.Errorf("fields spread 2^40 times: %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("validating fields spread 2^40 times took %v", elapsed)
+	}
+}
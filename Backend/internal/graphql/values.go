@@ -0,0 +1,329 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coerceVariables checks the request's variables against the operation's
+// definitions, applying defaults
+func (s *Schema) coerceVariables(definitions []*variableDefinition, provided map[string]interface{}) (map[string]interface{}, []*Error) {
+	coerced := map[string]interface{}{}
+	var errs []*Error
+	for _, definition := range definitions {
+		t := s.resolveRef(definition.typ)
+		raw, ok := provided[definition.name]
+
+		var err error
+		switch {
+		case !ok && definition.defaultValue != nil:
+			coerced[definition.name], err = coerceLiteral(t, definition.defaultValue, nil)
+		case !ok:
+			if _, required := t.(*NonNull); required {
+				err = fmt.Errorf("Variable \"$%s\" of required type \"%s\" was not provided.", definition.name, t)
+			}
+		default:
+			coerced[definition.name], err = coerceValue(t, raw)
+			if err != nil {
+				err = fmt.Errorf("Variable \"$%s\" got invalid value %s; %v", definition.name, describe(raw), err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, &Error{Message: err.Error(), Locations: []Location{definition.loc}})
+		}
+	}
+	return coerced, errs
+}
+
+// coerceValue turns a JSON input value into the value resolvers receive
+func coerceValue(t Type, v interface{}) (interface{}, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("Expected non-nullable type \"%s\" not to be null.", t)
+		}
+		return coerceValue(nonNull.OfType, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]interface{})
+		if !ok {
+			item, err := coerceValue(t.OfType, v)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if coerced[i], err = coerceValue(t.OfType, item); err != nil {
+				return nil, fmt.Errorf("At index %d: %v", i, err)
+			}
+		}
+		return coerced, nil
+	case *InputObject:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Expected type \"%s\" to be an object.", t.Name)
+		}
+		for name := range fields {
+			if inputField(t, name) == nil {
+				return nil, fmt.Errorf("Field \"%s\" is not defined by type \"%s\".", name, t.Name)
+			}
+		}
+		coerced := map[string]interface{}{}
+		for _, f := range t.Fields {
+			raw, ok := fields[f.Name]
+			if !ok {
+				if f.Default != nil {
+					coerced[f.Name] = f.Default
+				} else if _, required := f.Type.(*NonNull); required {
+					return nil, fmt.Errorf("Field \"%s\" of required type \"%s\" was not provided.", f.Name, f.Type)
+				}
+				continue
+			}
+			value, err := coerceValue(f.Type, raw)
+			if err != nil {
+				return nil, fmt.Errorf("At field \"%s\": %v", f.Name, err)
+			}
+			coerced[f.Name] = value
+		}
+		return coerced, nil
+	case *Enum:
+		name, ok := v.(string)
+		if value := t.value(name); ok && value != nil {
+			return value.goValue(), nil
+		}
+		return nil, fmt.Errorf("Value %s does not exist in \"%s\" enum.", describe(v), t.Name)
+	case *Scalar:
+		return t.Parse(v)
+	}
+	return nil, fmt.Errorf("Type \"%s\" is not an input type.", t)
+}
+
+// coerceLiteral turns a literal into the value resolvers receive. Variables
+// must already be coerced; present is false for a lone variable that wasn't
+// provided, so the caller can fall back to a default.
+func coerceLiteral(t Type, v *value, variables map[string]interface{}) (interface{}, error) {
+	value, _, err := coerceLiteralPresent(t, v, variables)
+	return value, err
+}
+
+func coerceLiteralPresent(t Type, v *value, variables map[string]interface{}) (interface{}, bool, error) {
+	if v.kind == variableValue {
+		value, ok := variables[v.raw]
+		if _, required := t.(*NonNull); required && value == nil && ok {
+			return nil, true, fmt.Errorf("Expected non-nullable type \"%s\" not to be null.", t)
+		}
+		return value, ok, nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if v.kind == nullValue {
+			return nil, true, fmt.Errorf("Expected value of type \"%s\", found null.", t)
+		}
+		return coerceLiteralPresent(nonNull.OfType, v, variables)
+	}
+	if v.kind == nullValue {
+		return nil, true, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if v.kind != listValue {
+			item, err := coerceLiteral(t.OfType, v, variables)
+			if err != nil {
+				return nil, true, err
+			}
+			return []interface{}{item}, true, nil
+		}
+		coerced := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			var err error
+			if coerced[i], err = coerceLiteral(t.OfType, item, variables); err != nil {
+				return nil, true, err
+			}
+		}
+		return coerced, true, nil
+	case *InputObject:
+		if v.kind != objectValue {
+			return nil, true, fmt.Errorf("Expected value of type \"%s\", found %s.", t.Name, v)
+		}
+		seen := map[string]bool{}
+		for _, field := range v.fields {
+			if inputField(t, field.name) == nil {
+				return nil, true, fmt.Errorf("Field \"%s\" is not defined by type \"%s\".", field.name, t.Name)
+			}
+			if seen[field.name] {
+				return nil, true, fmt.Errorf("There can be only one input field named \"%s\".", field.name)
+			}
+			seen[field.name] = true
+		}
+		coerced := map[string]interface{}{}
+		for _, f := range t.Fields {
+			var node *value
+			for _, field := range v.fields {
+				if field.name == f.Name {
+					node = field.value
+				}
+			}
+			present := false
+			if node != nil {
+				value, ok, err := coerceLiteralPresent(f.Type, node, variables)
+				if err != nil {
+					return nil, true, err
+				}
+				if ok {
+					coerced[f.Name], present = value, true
+				}
+			}
+			if !present {
+				if f.Default != nil {
+					coerced[f.Name] = f.Default
+				} else if _, required := f.Type.(*NonNull); required {
+					return nil, true, fmt.Errorf("Field \"%s.%s\" of required type \"%s\" was not provided.", t.Name, f.Name, f.Type)
+				}
+			}
+		}
+		return coerced, true, nil
+	case *Enum:
+		if v.kind != enumValue {
+			return nil, true, fmt.Errorf("Enum \"%s\" cannot represent non-enum value: %s.", t.Name, v)
+		}
+		value := t.value(v.raw)
+		if value == nil {
+			return nil, true, fmt.Errorf("Value \"%s\" does not exist in \"%s\" enum.", v.raw, t.Name)
+		}
+		return value.goValue(), true, nil
+	case *Scalar:
+		var raw interface{}
+		switch v.kind {
+		case intValue, floatValue:
+			raw = json.Number(v.raw)
+		case stringValue:
+			raw = v.raw
+		case booleanValue:
+			raw = v.raw == "true"
+		case enumValue:
+			raw = EnumLiteral(v.raw)
+		default:
+			return nil, true, fmt.Errorf("Expected value of type \"%s\", found %s.", t.Name, v)
+		}
+		value, err := t.Parse(raw)
+		if err != nil {
+			return nil, true, fmt.Errorf("Expected value of type \"%s\", found %s; %v", t.Name, v, err)
+		}
+		return value, true, nil
+	}
+	return nil, true, fmt.Errorf("Type \"%s\" is not an input type.", t)
+}
+
+// coerceArguments returns a field's arguments, applying defaults. Omitted
+// arguments without a default are absent from the map.
+func coerceArguments(definitions []*Argument, nodes []*argument, variables map[string]interface{}) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, definition := range definitions {
+		present := false
+		for _, node := range nodes {
+			if node.name != definition.Name {
+				continue
+			}
+			value, ok, err := coerceLiteralPresent(definition.Type, node.value, variables)
+			if err != nil {
+				return nil, fmt.Errorf("Argument \"%s\" has invalid value %s. %v", definition.Name, node.value, err)
+			}
+			if ok {
+				args[definition.Name], present = value, true
+			}
+		}
+		if present {
+			continue
+		}
+		if definition.Default != nil {
+			args[definition.Name] = definition.Default
+		} else if _, required := definition.Type.(*NonNull); required {
+			return nil, fmt.Errorf("Argument \"%s\" of required type \"%s\" was not provided.", definition.Name, definition.Type)
+		}
+	}
+	return args, nil
+}
+
+func inputField(t *InputObject, name string) *Argument {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// printValue prints a default value as a GraphQL literal, for introspection
+func printValue(t Type, v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	switch t := t.(type) {
+	case *NonNull:
+		return printValue(t.OfType, v)
+	case *List:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return printValue(t.OfType, v)
+		}
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = printValue(t.OfType, rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *InputObject:
+		fields, _ := v.(map[string]interface{})
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		printed := make([]string, len(names))
+		for i, name := range names {
+			var fieldType Type = String
+			if f := inputField(t, name); f != nil {
+				fieldType = f.Type
+			}
+			printed[i] = name + ": " + printValue(fieldType, fields[name])
+		}
+		return "{" + strings.Join(printed, ", ") + "}"
+	case *Enum:
+		for _, value := range t.Values {
+			if value.goValue() == v {
+				return value.Name
+			}
+		}
+	case *Scalar:
+		serialized, err := t.Serialize(v)
+		if err != nil {
+			break
+		}
+		switch serialized := serialized.(type) {
+		case string:
+			return strconv.Quote(serialized)
+		case bool:
+			return strconv.FormatBool(serialized)
+		case int:
+			return strconv.Itoa(serialized)
+		case float64:
+			return strconv.FormatFloat(serialized, 'g', -1, 64)
+		}
+		data, err := json.Marshal(serialized)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
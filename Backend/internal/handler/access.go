@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Space roles, from least to most privileged
+const (
+	RoleViewer = "viewer" // Read the tree and logs
+	RoleEditor = "editor" // Also create, modify and run
+	RoleOwner  = "owner"  // Also rename, delete and manage members
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// isValidRole reports whether role is a known space role
+func isValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// spaceRole returns the user's role in a space, or "" if they have no access.
+// The user who created the space is always an owner.
+func spaceRole(ctx context.Context, spaceID primitive.ObjectID, userID string) (string, error) {
+	var space models.Space
+	err := db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": spaceID}).Decode(&space)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if space.UserID == userID {
+		return RoleOwner, nil
+	}
+
+	var member models.SpaceMember
+	err = db.Database.Collection("space_members").FindOne(ctx, bson.M{"spaceId": spaceID, "userId": userID}).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return member.Role, nil
+}
+
+// authorizeSpace checks that the caller has at least minRole in a space. Callers without
+// any access get 404 with notFound, so the resource's existence isn't revealed; callers
+// with too low a role get 403. It returns false when a response has been written.
+func authorizeSpace(c *gin.Context, spaceID primitive.ObjectID, minRole, notFound string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role, err := spaceRole(ctx, spaceID, currentUserID(c))
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to check space access")
+		return false
+	}
+
+	if role == "" {
+		apierror.Abort(c, apierror.NotFound, notFound)
+		return false
+	}
+
+	if roleRanks[role] < roleRanks[minRole] {
+		apierror.Abort(c, apierror.Forbidden, "This action requires the "+minRole+" role in the space")
+		return false
+	}
+
+	return true
+}
+
+// accessibleSpaces returns the IDs of every space the user owns or is a member of, with their role
+func accessibleSpaces(ctx context.Context, userID string) (map[primitive.ObjectID]string, error) {
+	roles := make(map[primitive.ObjectID]string)
+
+	cursor, err := db.Database.Collection("space_members").Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	var members []models.SpaceMember
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		roles[member.SpaceID] = member.Role
+	}
+
+	cursor, err = db.Database.Collection("spaces").Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	var owned []models.Space
+	if err := cursor.All(ctx, &owned); err != nil {
+		return nil, err
+	}
+	for _, space := range owned {
+		roles[space.ID] = RoleOwner
+	}
+
+	return roles, nil
+}
+
+// spaceIDList returns the keys of a role map for use in $in filters
+func spaceIDList(roles map[primitive.ObjectID]string) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(roles))
+	for id := range roles {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// loadVault fetches a vault and checks the caller has at least minRole in its space.
+// It returns false when a response has been written.
+func loadVault(c *gin.Context, vaultID primitive.ObjectID, minRole string) (models.Vault, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var vault models.Vault
+	if err := db.Database.Collection("vaults").FindOne(ctx, bson.M{"_id": vaultID}).Decode(&vault); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Vault not found")
+		return vault, false
+	}
+
+	return vault, authorizeSpace(c, vault.SpaceID, minRole, "Vault not found")
+}
+
+// loadLog fetches a log and checks the caller has at least minRole in its space.
+// It returns false when a response has been written.
+func loadLog(c *gin.Context, logID primitive.ObjectID, minRole string) (models.Log, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var log models.Log
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": logID}).Decode(&log); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Log not found")
+		return log, false
+	}
+
+	return log, authorizeSpace(c, log.SpaceID, minRole, "Log not found")
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newAccessAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/spaces/:id", GetSpace)
+	a.api.PUT("/spaces/:id", UpdateSpace)
+	a.api.DELETE("/spaces/:id", DeleteSpace)
+	a.api.GET("/vaults", GetVaults)
+	a.api.GET("/vaults/:id", GetVault)
+	a.api.POST("/vaults", CreateVault)
+	a.api.PUT("/vaults/:id", UpdateVault)
+	a.api.DELETE("/vaults/:id", DeleteVault)
+	a.api.GET("/logs", GetLogs)
+	a.api.GET("/logs/:id", GetLog)
+	a.api.POST("/logs", CreateLog)
+	a.api.PUT("/logs/:id", UpdateLog)
+	a.api.DELETE("/logs/:id", DeleteLog)
+	a.api.GET("/tree", GetTree)
+	a.api.GET("/spaces/:id/members", GetSpaceMembers)
+	a.api.PUT("/spaces/:id/members/:userId", UpdateSpaceMember)
+	a.api.DELETE("/spaces/:id/members/:userId", RemoveSpaceMember)
+	a.api.GET("/spaces/:id/invitations", GetSpaceInvitations)
+	a.api.POST("/spaces/:id/invitations", CreateSpaceInvitation)
+	a.api.GET("/audit", GetAuditLog)
+	return a
+}
+
+// accessFixture is a space owned by its creator, with a vault, a log and a
+// second member to manage
+type accessFixture struct {
+	space  models.Space
+	vault  models.Vault
+	log    models.Log
+	member models.User
+}
+
+func TestRolePermissions(t *testing.T) {
+	setupDB(t)
+	creator, _ := createUser(t, "ada@example.com")
+	a := newAccessAPI()
+
+	tests := []struct {
+		name    string
+		minRole string
+		request func(f accessFixture) (method, target string, body interface{})
+	}{
+		{"get space", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/spaces/" + f.space.ID.Hex(), nil
+		}},
+		{"rename space", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "PUT", "/api/spaces/" + f.space.ID.Hex(), api.SpaceRequest{Name: "Renamed"}
+		}},
+		{"delete space", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "DELETE", "/api/spaces/" + f.space.ID.Hex(), nil
+		}},
+		{"list vaults", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/vaults?spaceId=" + f.space.ID.Hex(), nil
+		}},
+		{"get vault", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/vaults/" + f.vault.ID.Hex(), nil
+		}},
+		{"create vault", RoleEditor, func(f accessFixture) (string, string, interface{}) {
+			return "POST", "/api/vaults", api.CreateVaultRequest{SpaceID: f.space.ID.Hex(), Name: "lib"}
+		}},
+		{"rename vault", RoleEditor, func(f accessFixture) (string, string, interface{}) {
+			return "PUT", "/api/vaults/" + f.vault.ID.Hex(), api.UpdateVaultRequest{Name: "lib"}
+		}},
+		{"delete vault", RoleEditor, func(f accessFixture) (string, string, interface{}) {
+			return "DELETE", "/api/vaults/" + f.vault.ID.Hex(), nil
+		}},
+		{"list logs", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/logs?vaultId=" + f.vault.ID.Hex(), nil
+		}},
+		{"get log", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/logs/" + f.log.ID.Hex(), nil
+		}},
+		{"create log", RoleEditor, func(f accessFixture) (string, string, interface{}) {
+			return "POST", "/api/logs", api.CreateLogRequest{SpaceID: f.space.ID.Hex(), VaultID: f.vault.ID.Hex(), Name: "b.py"}
+		}},
+		{"update log", RoleEditor, func(f accessFixture) (string, string, interface{}) {
+			return "PUT", "/api/logs/" + f.log.ID.Hex(), api.UpdateLogRequest{Code: "print(2)"}
+		}},
+		{"delete log", RoleEditor, func(f accessFixture) (string, string, interface{}) {
+			return "DELETE", "/api/logs/" + f.log.ID.Hex(), nil
+		}},
+		{"get tree", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/tree?spaceId=" + f.space.ID.Hex(), nil
+		}},
+		{"list members", RoleViewer, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/spaces/" + f.space.ID.Hex() + "/members", nil
+		}},
+		{"change a member's role", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "PUT", "/api/spaces/" + f.space.ID.Hex() + "/members/" + f.member.ID.Hex(), api.UpdateSpaceMemberRequest{Role: RoleEditor}
+		}},
+		{"remove a member", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "DELETE", "/api/spaces/" + f.space.ID.Hex() + "/members/" + f.member.ID.Hex(), nil
+		}},
+		{"list invitations", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/spaces/" + f.space.ID.Hex() + "/invitations", nil
+		}},
+		{"invite", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "POST", "/api/spaces/" + f.space.ID.Hex() + "/invitations", api.CreateSpaceInvitationRequest{Email: "carol@example.com", Role: RoleViewer}
+		}},
+		{"read the audit log", RoleOwner, func(f accessFixture) (string, string, interface{}) {
+			return "GET", "/api/audit?spaceId=" + f.space.ID.Hex(), nil
+		}},
+	}
+
+	// Every request runs against a fresh space, as a caller holding each role
+	member, _ := createUser(t, "bob@example.com")
+	roles := []string{"", RoleViewer, RoleEditor, RoleOwner}
+	tokens := map[string]string{}
+	callers := map[string]models.User{}
+	for _, role := range roles {
+		callers[role], tokens[role] = createUser(t, "caller-"+role+"@example.com")
+	}
+	for _, tt := range tests {
+		for _, role := range roles {
+			space := createSpace(t, creator.ID.Hex(), "Space")
+			vault := createVault(t, space, "src", nil)
+			f := accessFixture{space: space, vault: vault, log: createLog(t, vault, "a.py", "print(1)"), member: member}
+			addMember(t, space.ID, member.ID.Hex(), RoleViewer)
+			if role != "" {
+				addMember(t, space.ID, callers[role].ID.Hex(), role)
+			}
+
+			method, target, body := tt.request(f)
+			w := a.request(method, target, tokens[role], body)
+			switch {
+			case role == "" && w.Code != http.StatusNotFound:
+				t.Errorf("%s without access: status %d, want 404", tt.name, w.Code)
+			case role != "" && roleRanks[role] < roleRanks[tt.minRole] && w.Code != http.StatusForbidden:
+				t.Errorf("%s as %s: status %d, want 403", tt.name, role, w.Code)
+			case roleRanks[role] >= roleRanks[tt.minRole] && w.Code >= 300:
+				t.Errorf("%s as %s: status %d; body %s", tt.name, role, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestMembersLeaveButCreatorsStay(t *testing.T) {
+	setupDB(t)
+	creator, creatorToken := createUser(t, "ada@example.com")
+	owner, ownerToken := createUser(t, "bob@example.com")
+	viewer, viewerToken := createUser(t, "carol@example.com")
+	space := createSpace(t, creator.ID.Hex(), "Space")
+	addMember(t, space.ID, owner.ID.Hex(), RoleOwner)
+	addMember(t, space.ID, viewer.ID.Hex(), RoleViewer)
+	a := newAccessAPI()
+	members := "/api/spaces/" + space.ID.Hex() + "/members/"
+
+	// The creator has no membership to change or remove, even by another owner
+	expectError(t, a.request("PUT", members+creator.ID.Hex(), ownerToken, api.UpdateSpaceMemberRequest{Role: RoleViewer}), http.StatusNotFound, apierror.NotFound)
+	expectError(t, a.request("DELETE", members+creator.ID.Hex(), ownerToken, nil), http.StatusNotFound, apierror.NotFound)
+	expectError(t, a.request("PUT", members+viewer.ID.Hex(), creatorToken, api.UpdateSpaceMemberRequest{Role: "admin"}), http.StatusBadRequest, apierror.InvalidRequest)
+
+	// A viewer can leave, and then loses access
+	expectStatus(t, a.request("DELETE", members+viewer.ID.Hex(), viewerToken, nil), http.StatusOK)
+	expectError(t, a.request("GET", "/api/spaces/"+space.ID.Hex(), viewerToken, nil), http.StatusNotFound, apierror.NotFound)
+
+	// A demoted owner loses owner actions at once
+	expectStatus(t, a.request("PUT", members+owner.ID.Hex(), creatorToken, api.UpdateSpaceMemberRequest{Role: RoleEditor}), http.StatusOK)
+	expectError(t, a.request("PUT", "/api/spaces/"+space.ID.Hex(), ownerToken, api.SpaceRequest{Name: "Mine"}), http.StatusForbidden, apierror.Forbidden)
+	if got := auditActions(t, owner.ID.Hex()); strings.Join(got, " ") != "member.update" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestRecheckAccessEndsWithTheSession(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+
+	if role, err := recheckAccess(token, space.ID, user.ID.Hex()); err != nil || role != RoleOwner {
+		t.Fatalf("recheckAccess = %q, %v; want %q", role, err, RoleOwner)
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := db.Database.Collection("users").UpdateByID(ctx, user.ID, bson.M{"$inc": bson.M{"sessionGeneration": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if role, err := recheckAccess(token, space.ID, user.ID.Hex()); err != nil || role != "" {
+		t.Errorf("recheckAccess after signing out everywhere = %q, %v; want no role", role, err)
+	}
+}
+
+// auditActions returns the actions recorded for a target, oldest first
+func auditActions(t *testing.T, targetID string) []string {
+	t.Helper()
+	ctx, cancel := testContext()
+	defer cancel()
+	cursor, err := db.Database.Collection("audit").Find(ctx, bson.M{"targetId": targetID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []models.AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	var actions []string
+	for _, entry := range entries {
+		if entry.RequestID == "" {
+			t.Errorf("audit entry %s has no request ID", entry.Action)
+		}
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
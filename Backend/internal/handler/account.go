@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	accountDeletionPurpose = "delete-account"
+	accountDeletionTTL     = 10 * time.Minute
+
+	// accountDeletionReauthWindow is how recently an account without a password must have signed in
+	accountDeletionReauthWindow = 5 * time.Minute
+
+	// deletedUserID replaces the user ID on records kept after the account is deleted
+	deletedUserID = "deleted"
+)
+
+// accountExportFile is one JSON file in an account export archive
+type accountExportFile struct {
+	name       string
+	collection string
+	filter     bson.M
+	sort       bson.M
+	into       interface{} // Pointer to a slice of the collection's model
+}
+
+// ExportAccount streams a zip archive of everything the current user owns
+func ExportAccount(c *gin.Context) {
+	userID := currentUserID(c)
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		apierror.Abort(c, apierror.Forbidden, "This account can't be exported")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := db.Database.Collection("users").FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		apierror.Abort(c, apierror.NotFound, "User not found")
+		return
+	}
+
+	// Owned spaces, and everything in them regardless of who created it
+	var spaces []models.Space
+	cursor, err := db.Database.Collection("spaces").Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &spaces)
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch spaces")
+		return
+	}
+
+	spaceIDs := []primitive.ObjectID{}
+	for _, space := range spaces {
+		spaceIDs = append(spaceIDs, space.ID)
+	}
+	ownedOrCreated := bson.M{"$or": []bson.M{
+		{"spaceId": bson.M{"$in": spaceIDs}},
+		{"userId": userID},
+	}}
+
+	files := []accountExportFile{
+		{"vaults.json", "vaults", ownedOrCreated, bson.M{"path": 1}, &[]models.Vault{}},
+		{"logs.json", "logs", ownedOrCreated, bson.M{"path": 1}, &[]models.Log{}},
+		{"runs.json", "audit", bson.M{"actor": userID, "action": "run.execute"}, bson.M{"_id": 1}, &[]models.AuditEntry{}},
+		{"ai_usage.json", "ai_usage", bson.M{"userId": userID}, bson.M{"createdAt": 1}, &[]models.AIUsage{}},
+		{"chat_sessions.json", "chat_sessions", bson.M{"userId": userID}, bson.M{"createdAt": 1}, &[]models.ChatSession{}},
+		{"memberships.json", "space_members", bson.M{"userId": userID}, bson.M{"createdAt": 1}, &[]models.SpaceMember{}},
+		{"share_links.json", "shares", bson.M{"createdBy": userID}, bson.M{"createdAt": 1}, &[]models.ShareLink{}},
+		{"secrets.json", "secrets", bson.M{"spaceId": bson.M{"$in": spaceIDs}}, bson.M{"name": 1}, &[]models.Secret{}},
+		{"api_tokens.json", "api_tokens", bson.M{"userId": userID}, bson.M{"createdAt": 1}, &[]models.APIToken{}},
+		{"audit.json", "audit", bson.M{"actor": userID}, bson.M{"_id": 1}, &[]models.AuditEntry{}},
+	}
+
+	// Load everything before writing so a failure can still be reported as JSON
+	for _, file := range files {
+		cursor, err := db.Database.Collection(file.collection).Find(ctx, file.filter, options.Find().SetSort(file.sort))
+		if err == nil {
+			err = cursor.All(ctx, file.into)
+		}
+		if err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to fetch "+file.collection)
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("codeflow-export-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	entries := append([]accountExportFile{
+		{name: "user.json", into: user},
+		{name: "spaces.json", into: spaces},
+	}, files...)
+	for _, file := range entries {
+		if err := writeExportFile(archive, file.name, file.into); err != nil {
+			// Headers are already sent, so the truncated archive is the only signal
+			log.Printf("Failed to write account export for %s: %v", userID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write account export for %s: %v", userID, err)
+	}
+}
+
+// writeExportFile adds value to the archive as indented JSON
+func writeExportFile(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// RequestAccountDeletion checks the user is still who they say they are, then issues a
+// short-lived token that must be sent back to DeleteAccount
+func RequestAccountDeletion(c *gin.Context) {
+	var req api.AccountDeletionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	userID := currentUserID(c)
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		apierror.Abort(c, apierror.Forbidden, "The admin account can't be deleted")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := db.Database.Collection("users").FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		apierror.Abort(c, apierror.NotFound, "User not found")
+		return
+	}
+
+	if user.PasswordHash != "" {
+		if req.Password == "" || !auth.CheckPassword(user.PasswordHash, req.Password) {
+			apierror.Abort(c, apierror.Forbidden, "Enter your current password to delete the account")
+			return
+		}
+	} else {
+		issuedAt, err := auth.SessionIssuedAt(middleware.RequestToken(c))
+		if err != nil || time.Since(issuedAt) > accountDeletionReauthWindow {
+			apierror.Abort(c, apierror.Forbidden, "Sign in again to delete the account")
+			return
+		}
+	}
+
+	token, expiresAt, err := auth.IssueConfirmationToken(userID, accountDeletionPurpose, accountDeletionTTL)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create confirmation token")
+		return
+	}
+
+	c.JSON(http.StatusOK, api.AccountDeletionConfirmation{
+		ConfirmationToken: token,
+		ExpiresAt:         expiresAt,
+	})
+}
+
+// DeleteAccount deletes the current user and all of their data. Spaces they own
+// are deleted with everything in them; logs and vaults they created in other
+// people's spaces stay with those spaces, as does their AI usage there, without
+// their user ID. Audit entries are kept because the audit log is append-only.
+// If any step fails the user is kept, so the request can be retried.
+func DeleteAccount(c *gin.Context) {
+	var req api.DeleteAccountRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	userID := currentUserID(c)
+	confirmedID, err := auth.ParseConfirmationToken(req.ConfirmationToken, accountDeletionPurpose)
+	if err != nil || confirmedID != userID {
+		apierror.Abort(c, apierror.InvalidRequest, "Invalid or expired confirmation token")
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		apierror.Abort(c, apierror.Forbidden, "The admin account can't be deleted")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := db.Database.Collection("users").FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		apierror.Abort(c, apierror.NotFound, "User not found")
+		return
+	}
+
+	spaces, err := deleteSpaceData(ctx, bson.M{"userId": userID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete spaces")
+		return
+	}
+
+	// Everything else keyed by the user, in spaces they don't own. AI usage in those
+	// spaces still counts against the space's budget, so it is kept without the user ID.
+	cleanup := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{"space_members", bson.M{"userId": userID}},
+		{"chat_sessions", bson.M{"userId": userID}},
+		{"shares", bson.M{"createdBy": userID}},
+		{"api_tokens", bson.M{"userId": userID}},
+		{"ai_usage", bson.M{"userId": userID, "spaceId": bson.M{"$exists": false}}},
+		{"quota_counters", bson.M{"_id": bson.M{"$regex": "^[^:]+:user:" + regexp.QuoteMeta(userID) + ":"}}},
+	}
+	for _, step := range cleanup {
+		if _, err := db.Database.Collection(step.collection).DeleteMany(ctx, step.filter); err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to delete "+step.collection)
+			return
+		}
+	}
+	if _, err := db.Database.Collection("ai_usage").UpdateMany(ctx, bson.M{"userId": userID},
+		bson.M{"$set": bson.M{"userId": deletedUserID}}); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete ai_usage")
+		return
+	}
+
+	if _, err := db.Database.Collection("users").DeleteOne(ctx, bson.M{"_id": objectID}); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete account")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "account.delete",
+		TargetType: "user",
+		TargetID:   userID,
+		Before:     auditSnapshot{"spaces": spaces.DeletedCount},
+	})
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.SessionCookieName, "", -1, "/", "", secureCookies(), true)
+	c.JSON(http.StatusOK, MessageResponse{Message: "Account deleted successfully"})
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newAccountAPI() *testAPI {
+	a := newTestAPI()
+	a.api.POST("/account/deletion", RequestAccountDeletion)
+	a.api.DELETE("/account", DeleteAccount)
+	return a
+}
+
+// deleteAccount confirms and deletes the account, failing the test if either step fails
+func deleteAccount(t *testing.T, a *testAPI, token string, req api.AccountDeletionRequest) {
+	t.Helper()
+	w := a.request("POST", "/api/account/deletion", token, req)
+	expectStatus(t, w, http.StatusOK)
+	confirmation := decodeBody[api.AccountDeletionConfirmation](t, w)
+	w = a.request("DELETE", "/api/account", token, api.DeleteAccountRequest{ConfirmationToken: confirmation.ConfirmationToken})
+	expectStatus(t, w, http.StatusOK)
+}
+
+// staleSession signs a session token for userID as if it had been issued an hour ago
+func staleSession(t *testing.T, userID string) string {
+	t.Helper()
+	issuedAt := time.Now().Add(-time.Hour)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(issuedAt),
+		ExpiresAt: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour)),
+		Issuer:    "codeflow",
+	}).SignedString([]byte("handler-test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAccountDeletionRequiresPassword(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	a := newAccountAPI()
+
+	expectError(t, a.request("POST", "/api/account/deletion", token, api.AccountDeletionRequest{}), http.StatusForbidden, "FORBIDDEN")
+	expectError(t, a.request("POST", "/api/account/deletion", token, api.AccountDeletionRequest{Password: "wrong"}), http.StatusForbidden, "FORBIDDEN")
+
+	deleteAccount(t, a, token, api.AccountDeletionRequest{Password: "password"})
+
+	ctx, cancel := testContext()
+	defer cancel()
+	if n, err := db.Database.Collection("users").CountDocuments(ctx, bson.M{"_id": user.ID}); err != nil || n != 0 {
+		t.Errorf("%d users left after deletion (%v)", n, err)
+	}
+}
+
+func TestAccountDeletionWithoutPasswordRequiresRecentLogin(t *testing.T) {
+	setupDB(t)
+	user := models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", OIDCSubject: "ada", CreatedAt: time.Now()}
+	insert(t, "users", user)
+	a := newAccountAPI()
+
+	w := a.request("POST", "/api/account/deletion", staleSession(t, user.ID.Hex()), api.AccountDeletionRequest{})
+	expectError(t, w, http.StatusForbidden, "FORBIDDEN")
+
+	fresh, _, err := auth.IssueSessionToken(user.ID.Hex(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteAccount(t, a, fresh, api.AccountDeletionRequest{})
+}
+
+func TestAccountDeletionKeepsUsageInOtherSpaces(t *testing.T) {
+	setupDB(t)
+	owner, _ := createUser(t, "ada@example.com")
+	member, token := createUser(t, "bob@example.com")
+	shared := createSpace(t, owner.ID.Hex(), "Shared")
+	addMember(t, shared.ID, member.ID.Hex(), RoleEditor)
+	own := createSpace(t, member.ID.Hex(), "Own")
+	insert(t, "ai_usage",
+		models.AIUsage{ID: primitive.NewObjectID(), UserID: member.ID.Hex(), SpaceID: &shared.ID, TotalTokens: 100, CreatedAt: time.Now()},
+		models.AIUsage{ID: primitive.NewObjectID(), UserID: member.ID.Hex(), TotalTokens: 10, CreatedAt: time.Now()},
+	)
+
+	deleteAccount(t, newAccountAPI(), token, api.AccountDeletionRequest{Password: "password"})
+
+	ctx, cancel := testContext()
+	defer cancel()
+	if n, _ := db.Database.Collection("spaces").CountDocuments(ctx, bson.M{"_id": own.ID}); n != 0 {
+		t.Error("the deleted user's own space is still there")
+	}
+	if n, _ := db.Database.Collection("ai_usage").CountDocuments(ctx, bson.M{"userId": member.ID.Hex()}); n != 0 {
+		t.Errorf("%d usage records still name the deleted user", n)
+	}
+
+	// The shared space's budget still counts the tokens spent in it this month
+	var kept []models.AIUsage
+	cursor, err := db.Database.Collection("ai_usage").Find(ctx, bson.M{})
+	if err == nil {
+		err = cursor.All(ctx, &kept)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept[0].UserID != deletedUserID || *kept[0].SpaceID != shared.ID || kept[0].TotalTokens != 100 {
+		t.Errorf("usage after deletion = %+v, want only the shared space's, without the user ID", kept)
+	}
+}
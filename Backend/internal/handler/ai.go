@@ -2,7 +2,9 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,73 +12,105 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/ratelimit"
+
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// UPDATED: Added Filename field for better language context
-type GenerateRequest struct {
-	Prompt   string `json:"prompt" binding:"required"`
-	Language string `json:"language,omitempty"`
-	Filename string `json:"filename,omitempty"`
-}
-
-type GenerateResponse struct {
-	Code     string `json:"code"`
-	Provider string `json:"provider"` // "openai" or "gemini"
-}
+const (
+	defaultMaxRepairs = 2
+	maxRepairsLimit   = 5
+)
 
-// Rate limiter
+// Provider endpoints; tests point them at fakes
 var (
-	openaiLimiter = &rateLimiter{
-		maxRequests: 50,
-		window:      time.Minute,
-		requests:    make([]time.Time, 0),
-	}
-	geminiLimiter = &rateLimiter{
-		maxRequests: 100,
-		window:      time.Minute,
-		requests:    make([]time.Time, 0),
-	}
+	openAIAPI = "https://api.openai.com/v1"
+	geminiAPI = "https://generativelanguage.googleapis.com/v1beta"
 )
 
-type rateLimiter struct {
-	sync.Mutex
-	maxRequests int
-	window      time.Duration
-	requests    []time.Time
+// providerLimit returns the token bucket shared by all users of a provider API key
+func providerLimit(provider string) ratelimit.Limit {
+	if provider == "gemini" {
+		return ratelimit.FromEnv("GEMINI_RATE_LIMIT", 100)
+	}
+	return ratelimit.FromEnv("OPENAI_RATE_LIMIT", 50)
 }
 
-func (rl *rateLimiter) allow() bool {
-	rl.Lock()
-	defer rl.Unlock()
+// rateLimitedError is returned when every provider's rate limit is exhausted
+type rateLimitedError struct {
+	result ratelimit.Result
+}
 
-	now := time.Now()
-	cutoff := now.Add(-rl.window)
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("AI provider rate limit exceeded, retry after %s", e.result.RetryAfter.Round(time.Second))
+}
 
-	// Remove old requests
-	validRequests := make([]time.Time, 0)
-	for _, t := range rl.requests {
-		if t.After(cutoff) {
-			validRequests = append(validRequests, t)
-		}
-	}
-	rl.requests = validRequests
+// takeProviderToken takes a token from the provider's bucket.
+// Errors from the rate limit backend allow the call rather than block AI entirely.
+func takeProviderToken(provider string) ratelimit.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
 
-	if len(rl.requests) < rl.maxRequests {
-		rl.requests = append(rl.requests, now)
-		return true
+	result, err := ratelimit.Default.Take(ctx, "provider:"+provider, providerLimit(provider))
+	if err != nil {
+		return ratelimit.Result{Allowed: true}
 	}
-	return false
+	return result
 }
 
 // UPDATED: Code-only generation with strict prompting and post-processing
 func GenerateCode(c *gin.Context) {
-	var req GenerateRequest
+	userID := currentUserID(c)
+
+	var req api.GenerateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	switch req.Mode {
+	case "", api.ModeGenerate:
+	case api.ModeExplain, api.ModeReview, api.ModeTests:
+		generateForLog(c, req)
+		return
+	default:
+		apierror.Abort(c, apierror.InvalidRequest, "Invalid mode")
+		return
+	}
+
+	if strings.TrimSpace(req.Prompt) == "" {
+		apierror.Abort(c, apierror.InvalidRequest, "prompt is required")
+		return
+	}
+
+	call := aiCall{UserID: userID, Mode: api.ModeGenerate}
+	if req.SpaceID != "" {
+		spaceID, err := primitive.ObjectIDFromHex(req.SpaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+		if !authorizeSpace(c, spaceID, RoleViewer, "Space not found") {
+			return
+		}
+		call.SpaceID = &spaceID
+	}
+
+	var language string
+	if req.Agentic {
+		var ok bool
+		if language, ok = agenticLanguage(c, req); !ok {
+			return
+		}
+	}
+
+	if !enforceAIBudget(c, call) {
 		return
 	}
 
@@ -97,68 +131,206 @@ func GenerateCode(c *gin.Context) {
 	systemPrompt := buildStrictSystemPrompt(languageContext)
 	userPrompt := buildCodeOnlyUserPrompt(req.Prompt, languageContext)
 
-	var generatedCode string
-	var provider string
-	var lastError error
-
-	// Try OpenAI first
-	if openaiLimiter.allow() {
-		code, err := generateWithOpenAI(systemPrompt, userPrompt)
-		if err == nil {
-			generatedCode = code
-			provider = "openai"
-		} else {
-			lastError = err
-		}
-	}
-
-	// Fallback to Gemini if OpenAI failed
-	if generatedCode == "" && geminiLimiter.allow() {
-		code, err := generateWithGemini(systemPrompt, userPrompt)
-		if err == nil {
-			generatedCode = code
-			provider = "gemini"
-		} else {
-			lastError = err
-		}
+	if req.Agentic {
+		generateAgentic(c, call, req, language, systemPrompt, userPrompt, languageContext)
+		return
 	}
 
-	// If both failed, return error
-	if generatedCode == "" {
-		if lastError != nil && (strings.Contains(lastError.Error(), "API key") || strings.Contains(lastError.Error(), "not set")) {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status":  400,
-				"code":    "API_KEY_INVALID",
-				"message": "No AI provider available. Configure OPENAI_API_KEY or GEMINI_API_KEY in backend .env",
-			})
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  400,
-			"code":    "PROVIDER_UNAVAILABLE",
-			"message": "No AI provider available",
-		})
+	generatedCode, provider, err := generateWithFallback(call, systemPrompt, userPrompt)
+	if err != nil {
+		respondProviderError(c, err)
 		return
 	}
 
 	// UPDATED: Post-process to ensure code-only output
 	cleanCode := extractCodeOnly(generatedCode)
 
+	auditAI(c, call, "ai", "", auditSnapshot{"provider": provider, "codeBytes": len(cleanCode)})
+
 	// Check if cleaned code is empty
 	if strings.TrimSpace(cleanCode) == "" {
-		c.JSON(http.StatusOK, GenerateResponse{
+		c.JSON(http.StatusOK, api.GenerateResponse{
 			Code:     "",
 			Provider: provider,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, GenerateResponse{
+	c.JSON(http.StatusOK, api.GenerateResponse{
 		Code:     cleanCode,
 		Provider: provider,
 	})
 }
 
+// agenticLanguage returns the language agentic mode runs code in, writing a 400
+// when it can't be told
+func agenticLanguage(c *gin.Context, req api.GenerateRequest) (string, bool) {
+	if req.Language != "" {
+		return req.Language, true
+	}
+	if req.Filename == "" {
+		apierror.Abort(c, apierror.InvalidRequest, "language or filename required for agentic mode")
+		return "", false
+	}
+	// Guessing would run the code under the wrong runtime and waste every repair
+	language, ok := models.LanguageFromFilename(req.Filename)
+	if !ok {
+		apierror.Abort(c, apierror.InvalidRequest, "Can't infer the language of "+req.Filename+"; set language for agentic mode")
+		return "", false
+	}
+	return language, true
+}
+
+// generateAgentic generates code, runs it, and feeds failures back to the provider for repair
+func generateAgentic(c *gin.Context, call aiCall, req api.GenerateRequest, language, systemPrompt, userPrompt, languageContext string) {
+	maxRepairs := req.MaxRepairs
+	if maxRepairs <= 0 {
+		maxRepairs = defaultMaxRepairs
+	}
+	if maxRepairs > maxRepairsLimit {
+		maxRepairs = maxRepairsLimit
+	}
+
+	var attempts []api.GenerationAttempt
+	prompt := userPrompt
+	passed := false
+	stopped := ""
+
+	for i := 0; i <= maxRepairs; i++ {
+		// The first call was charged with the request; each repair is another
+		if i > 0 {
+			if err := chargeAIBudget(call); err != nil {
+				stopped = err.Message
+				break
+			}
+		}
+
+		generatedCode, provider, err := generateWithFallback(call, systemPrompt, prompt)
+		if err != nil {
+			// Nothing generated yet, so there is no code to return
+			if len(attempts) == 0 {
+				respondProviderError(c, err)
+				return
+			}
+			break
+		}
+
+		code := extractCodeOnly(generatedCode)
+		charge, quotaErr := takeDailyQuota(QuotaRunsPerDay, call.UserID, call.SpaceID)
+		if quotaErr != nil {
+			attempts = append(attempts, api.GenerationAttempt{Code: code, Provider: provider, Error: "Not run: " + quotaErr.Message})
+			stopped = quotaErr.Message
+			break
+		}
+
+		result, err := executeCode(language, code)
+		if err != nil {
+			// Keep the attempts so far; the caller still gets the latest code
+			charge.refund()
+			attempts = append(attempts, api.GenerationAttempt{Code: code, Provider: provider, Error: "Code runner failed: " + err.Error()})
+			break
+		}
+
+		attempts = append(attempts, api.GenerationAttempt{
+			Code:     code,
+			Provider: provider,
+			Stdout:   result.Run.Stdout,
+			Stderr:   result.Stderr(),
+			ExitCode: result.ExitCode(),
+		})
+
+		if !result.Failed() {
+			passed = true
+			break
+		}
+
+		prompt = buildRepairUserPrompt(req.Prompt, code, result.Stderr(), result.ExitCode(), languageContext)
+	}
+
+	final := attempts[len(attempts)-1]
+
+	auditAI(c, call, "ai", "", auditSnapshot{
+		"provider":  final.Provider,
+		"codeBytes": len(final.Code),
+		"attempts":  len(attempts),
+		"passed":    passed,
+	})
+
+	c.JSON(http.StatusOK, api.GenerateResponse{
+		Code:     final.Code,
+		Provider: final.Provider,
+		Passed:   &passed,
+		Attempts: attempts,
+		Stopped:  stopped,
+	})
+}
+
+// generateWithFallback tries OpenAI first and falls back to Gemini, returning the raw output and provider name
+func generateWithFallback(call aiCall, systemPrompt, userPrompt string) (string, string, error) {
+	messages := []OpenAIMessage{{Role: "user", Content: userPrompt}}
+	return completeWithFallback(call, systemPrompt, messages, true)
+}
+
+// completeWithFallback sends a multi-turn conversation to OpenAI, falling back to Gemini.
+// codeOnly adds stop sequences that cut the output off at markdown fences.
+// Every provider attempt is recorded for usage accounting.
+func completeWithFallback(call aiCall, systemPrompt string, messages []OpenAIMessage, codeOnly bool) (string, string, error) {
+	var lastError error
+	var limited *ratelimit.Result
+
+	// Try OpenAI first
+	if result := takeProviderToken("openai"); result.Allowed {
+		start := time.Now()
+		out, err := generateWithOpenAI(systemPrompt, messages, codeOnly)
+		recordAIUsage(call, "openai", out, time.Since(start), err)
+		if err == nil && out.Text != "" {
+			return out.Text, "openai", nil
+		}
+		lastError = err
+	} else {
+		limited = &result
+	}
+
+	// Fallback to Gemini if OpenAI failed
+	if result := takeProviderToken("gemini"); result.Allowed {
+		start := time.Now()
+		out, err := generateWithGemini(systemPrompt, messages)
+		recordAIUsage(call, "gemini", out, time.Since(start), err)
+		if err == nil && out.Text != "" {
+			return out.Text, "gemini", nil
+		}
+		lastError = err
+	} else if limited == nil || result.RetryAfter < limited.RetryAfter {
+		limited = &result
+	}
+
+	// Only report rate limiting if no provider was actually tried
+	if lastError == nil && limited != nil {
+		return "", "", &rateLimitedError{result: *limited}
+	}
+
+	if lastError == nil {
+		lastError = fmt.Errorf("no AI provider available")
+	}
+	return "", "", lastError
+}
+
+// respondProviderError writes the error response used when every provider failed
+func respondProviderError(c *gin.Context, lastError error) {
+	var limited *rateLimitedError
+	if errors.As(lastError, &limited) {
+		ratelimit.WriteHeaders(c.Writer.Header(), limited.result)
+		apierror.Abort(c, apierror.RateLimited, limited.Error())
+		return
+	}
+
+	if strings.Contains(lastError.Error(), "API key") || strings.Contains(lastError.Error(), "not set") {
+		apierror.Abort(c, apierror.APIKeyInvalid, "No AI provider available. Configure OPENAI_API_KEY or GEMINI_API_KEY in backend .env")
+		return
+	}
+	apierror.Abort(c, apierror.ProviderUnavailable, "No AI provider available")
+}
+
 // UPDATED: Build strict system prompt for code-only output
 func buildStrictSystemPrompt(languageContext string) string {
 	prompt := `You are a code generator.
@@ -188,6 +360,21 @@ func buildCodeOnlyUserPrompt(userRequest, languageContext string) string {
 	return prompt
 }
 
+// buildRepairUserPrompt asks the provider to fix code that failed to compile or run
+func buildRepairUserPrompt(userRequest, code, stderr string, exitCode int, languageContext string) string {
+	prompt := fmt.Sprintf(`Original task:
+%s
+
+The following code failed with exit code %d:
+%s
+
+Error output:
+%s
+
+Fix the code so it compiles and runs successfully.`, userRequest, exitCode, code, stderr)
+	return buildCodeOnlyUserPrompt(prompt, languageContext)
+}
+
 // UPDATED: Extract code-only from potential markdown/prose response
 func extractCodeOnly(text string) string {
 	// Strategy 1: If text contains triple-backtick code blocks, extract the first one
@@ -228,6 +415,14 @@ func extractCodeOnly(text string) string {
 	return strings.TrimSpace(result)
 }
 
+// completion is the text returned by a provider plus the usage it reported
+type completion struct {
+	Text             string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
 // OpenAI API structures
 type OpenAIRequest struct {
 	Model       string          `json:"model"`
@@ -247,19 +442,25 @@ type OpenAIResponse struct {
 	Choices []struct {
 		Message OpenAIMessage `json:"message"`
 	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
 }
 
 // UPDATED: OpenAI with strict parameters and stop sequences
-func generateWithOpenAI(systemPrompt, userPrompt string) (string, error) {
+func generateWithOpenAI(systemPrompt string, messages []OpenAIMessage, codeOnly bool) (completion, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY not set")
+		return completion{}, fmt.Errorf("OPENAI_API_KEY not set")
 	}
 
 	model := os.Getenv("OPENAI_MODEL")
 	if model == "" {
 		model = "gpt-4o-mini"
 	}
+	result := completion{Model: model}
 
 	// UPDATED: Lower temperature for more deterministic code
 	temperature := 0.2
@@ -277,25 +478,24 @@ func generateWithOpenAI(systemPrompt, userPrompt string) (string, error) {
 	}
 
 	reqBody := OpenAIRequest{
-		Model: model,
-		Messages: []OpenAIMessage{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
-		},
+		Model:       model,
+		Messages:    append([]OpenAIMessage{{Role: "system", Content: systemPrompt}}, messages...),
 		Temperature: temperature,
 		MaxTokens:   maxTokens,
 		TopP:        1.0,
-		Stop:        []string{"```", "<code>", "</code>"}, // UPDATED: Stop sequences
+	}
+	if codeOnly {
+		reqBody.Stop = []string{"```", "<code>", "</code>"} // UPDATED: Stop sequences
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", openAIAPI+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -304,25 +504,28 @@ func generateWithOpenAI(systemPrompt, userPrompt string) (string, error) {
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI API error: %s", string(body))
+		return result, fmt.Errorf("OpenAI API error: %s", string(body))
 	}
 
 	var openaiResp OpenAIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&openaiResp); err != nil {
-		return "", err
+		return result, err
 	}
 
 	if len(openaiResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+		return result, fmt.Errorf("no response from OpenAI")
 	}
 
-	return openaiResp.Choices[0].Message.Content, nil
+	result.Text = openaiResp.Choices[0].Message.Content
+	result.PromptTokens = openaiResp.Usage.PromptTokens
+	result.CompletionTokens = openaiResp.Usage.CompletionTokens
+	return result, nil
 }
 
 // Gemini API structures
@@ -343,6 +546,7 @@ type GeminiGenConfig struct {
 }
 
 type GeminiContent struct {
+	Role  string       `json:"role,omitempty"` // "user" or "model"
 	Parts []GeminiPart `json:"parts"`
 }
 
@@ -354,19 +558,25 @@ type GeminiResponse struct {
 	Candidates []struct {
 		Content GeminiContent `json:"content"`
 	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
 }
 
 // UPDATED: Gemini with system instruction and strict parameters
-func generateWithGemini(systemPrompt, userPrompt string) (string, error) {
+func generateWithGemini(systemPrompt string, messages []OpenAIMessage) (completion, error) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
-		return "", fmt.Errorf("GEMINI_API_KEY not set")
+		return completion{}, fmt.Errorf("GEMINI_API_KEY not set")
 	}
 
 	model := os.Getenv("GEMINI_MODEL")
 	if model == "" {
 		model = "gemini-2.0-flash-exp"
 	}
+	result := completion{Model: model}
 
 	// UPDATED: Use system instruction for Gemini
 	reqBody := GeminiRequest{
@@ -375,13 +585,7 @@ func generateWithGemini(systemPrompt, userPrompt string) (string, error) {
 				{Text: systemPrompt},
 			},
 		},
-		Contents: []GeminiContent{
-			{
-				Parts: []GeminiPart{
-					{Text: userPrompt},
-				},
-			},
-		},
+		Contents: toGeminiContents(messages),
 		GenerationConfig: &GeminiGenConfig{
 			Temperature: 0.2,
 			MaxTokens:   800,
@@ -391,29 +595,48 @@ func generateWithGemini(systemPrompt, userPrompt string) (string, error) {
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s", model, apiKey)
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", geminiAPI, model, apiKey)
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return result, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Gemini API error: %s", string(body))
+		return result, fmt.Errorf("Gemini API error: %s", string(body))
 	}
 
 	var geminiResp GeminiResponse
 	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
-		return "", err
+		return result, err
 	}
 
 	if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response from Gemini")
+		return result, fmt.Errorf("no response from Gemini")
 	}
 
-	return geminiResp.Candidates[0].Content.Parts[0].Text, nil
+	result.Text = geminiResp.Candidates[0].Content.Parts[0].Text
+	result.PromptTokens = geminiResp.UsageMetadata.PromptTokenCount
+	result.CompletionTokens = geminiResp.UsageMetadata.CandidatesTokenCount
+	return result, nil
+}
+
+// toGeminiContents converts OpenAI-style chat messages to Gemini contents
+func toGeminiContents(messages []OpenAIMessage) []GeminiContent {
+	contents := make([]GeminiContent, 0, len(messages))
+	for _, m := range messages {
+		role := "user"
+		if m.Role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, GeminiContent{
+			Role:  role,
+			Parts: []GeminiPart{{Text: m.Content}},
+		})
+	}
+	return contents
 }
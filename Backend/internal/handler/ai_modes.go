@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// generateForLog handles the modes that operate on an existing log
+func generateForLog(c *gin.Context, req api.GenerateRequest) {
+	userID := currentUserID(c)
+
+	if req.LogID == "" {
+		apierror.Abort(c, apierror.InvalidRequest, "logId required for "+req.Mode+" mode")
+		return
+	}
+
+	logID, err := primitive.ObjectIDFromHex(req.LogID)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+		return
+	}
+
+	// Generating tests creates a log, so it needs edit access
+	minRole := RoleViewer
+	if req.Mode == api.ModeTests {
+		minRole = RoleEditor
+	}
+
+	log, ok := loadLog(c, logID, minRole)
+	if !ok {
+		return
+	}
+
+	// Refuse to generate tests that couldn't be saved before paying for them
+	if req.Mode == api.ModeTests && !ensureNoTestLog(c, log) {
+		return
+	}
+
+	call := aiCall{UserID: userID, SpaceID: &log.SpaceID, Mode: req.Mode}
+	if !enforceAIBudget(c, call) {
+		return
+	}
+
+	switch req.Mode {
+	case api.ModeExplain:
+		explainLog(c, call, req, log)
+	case api.ModeReview:
+		reviewLog(c, call, req, log)
+	case api.ModeTests:
+		generateTestsForLog(c, call, req, log)
+	}
+}
+
+// explainLog returns a prose explanation of a log with line references
+func explainLog(c *gin.Context, call aiCall, req api.GenerateRequest, log models.Log) {
+	messages := []OpenAIMessage{{Role: "user", Content: buildLogUserPrompt(log, req.Prompt)}}
+	output, provider, err := completeWithFallback(call, buildExplainSystemPrompt(log.Language), messages, false)
+	if err != nil {
+		respondProviderError(c, err)
+		return
+	}
+
+	explanation := strings.TrimSpace(output)
+	auditAI(c, call, "log", log.ID.Hex(), auditSnapshot{"provider": provider})
+
+	c.JSON(http.StatusOK, api.ExplainResponse{
+		Explanation: explanation,
+		References:  parseLineReferences(explanation, countLines(log.Code)),
+		Provider:    provider,
+	})
+}
+
+// reviewLog returns structured review findings for a log
+func reviewLog(c *gin.Context, call aiCall, req api.GenerateRequest, log models.Log) {
+	messages := []OpenAIMessage{{Role: "user", Content: buildLogUserPrompt(log, req.Prompt)}}
+	output, provider, err := completeWithFallback(call, buildReviewSystemPrompt(log.Language), messages, false)
+	if err != nil {
+		respondProviderError(c, err)
+		return
+	}
+
+	findings, err := parseReviewFindings(output, countLines(log.Code))
+	if err != nil {
+		apierror.Abort(c, apierror.UpstreamError, "Failed to parse review from provider")
+		return
+	}
+
+	auditAI(c, call, "log", log.ID.Hex(), auditSnapshot{"provider": provider, "findings": len(findings)})
+
+	c.JSON(http.StatusOK, api.ReviewResponse{
+		Findings: findings,
+		Provider: provider,
+	})
+}
+
+// generateTestsForLog generates unit tests for a log and stores them in a new sibling log
+func generateTestsForLog(c *gin.Context, call aiCall, req api.GenerateRequest, log models.Log) {
+	output, provider, err := generateWithFallback(call, buildTestsSystemPrompt(log.Language), buildLogUserPrompt(log, req.Prompt))
+	if err != nil {
+		respondProviderError(c, err)
+		return
+	}
+
+	code := extractCodeOnly(output)
+	if strings.TrimSpace(code) == "" {
+		apierror.Abort(c, apierror.UpstreamError, "Provider returned no test code")
+		return
+	}
+
+	name := testFilenameFor(log.Name, log.Language)
+	testLog := models.Log{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   log.SpaceID,
+		VaultID:   log.VaultID,
+		UserID:    call.UserID,
+		Name:      name,
+		Path:      path.Join(path.Dir(log.Path), name),
+		Language:  log.Language,
+		Code:      code,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	collection := db.Database.Collection("logs")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !enforceLogQuota(c, ctx, log.SpaceID, call.UserID, code) {
+		return
+	}
+
+	if _, err := collection.InsertOne(ctx, testLog); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create log")
+		return
+	}
+
+	auditAI(c, call, "log", log.ID.Hex(), auditSnapshot{"provider": provider, "logId": testLog.ID.Hex()})
+	recordAudit(c, models.AuditEntry{
+		Action:     "log.create",
+		SpaceID:    &testLog.SpaceID,
+		TargetType: "log",
+		TargetID:   testLog.ID.Hex(),
+		After:      logSnapshot(testLog),
+	})
+
+	events.Publish(events.ForLog(events.LogCreated, testLog))
+
+	c.JSON(http.StatusCreated, api.TestsResponse{
+		Log:      testLog,
+		Provider: provider,
+	})
+}
+
+// ensureNoTestLog responds with a conflict if the vault already has a log
+// named like the tests that would be generated for log
+func ensureNoTestLog(c *gin.Context, log models.Log) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	name := testFilenameFor(log.Name, log.Language)
+	var existing models.Log
+	err := db.Database.Collection("logs").FindOne(ctx, bson.M{"vaultId": log.VaultID, "name": name},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return true
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to check for existing tests")
+		return false
+	}
+
+	apierror.Respond(c, apierror.New(apierror.Conflict, "A log named "+name+" already exists in this vault").
+		WithDetails(gin.H{"logId": existing.ID.Hex()}))
+	return false
+}
+
+// buildExplainSystemPrompt builds the system prompt for explain mode
+func buildExplainSystemPrompt(language string) string {
+	return fmt.Sprintf(`You are a senior %s developer explaining code to a colleague.
+The code is given with line numbers in the form "N: code".
+Explain what the code does in clear prose.
+Whenever you refer to specific code, cite it as "line N" or "lines N-M".
+Do NOT use markdown code fences and do NOT repeat the full code.`, language)
+}
+
+// buildReviewSystemPrompt builds the system prompt for review mode
+func buildReviewSystemPrompt(language string) string {
+	return fmt.Sprintf(`You are a strict %s code reviewer.
+The code is given with line numbers in the form "N: code".
+Report bugs, security problems, performance issues and maintainability concerns.
+Respond with ONLY a JSON array, no prose and no markdown. Each element must be:
+{"severity": "critical|major|minor|info", "startLine": N, "endLine": M, "message": "...", "suggestion": "..."}
+Return [] if there are no findings.`, language)
+}
+
+// buildTestsSystemPrompt builds the system prompt for test-generation mode
+func buildTestsSystemPrompt(language string) string {
+	return buildStrictSystemPrompt(language) + `
+Write unit tests for the given code using the standard or most common test framework for the language.
+Cover normal behaviour, edge cases and error handling.
+The tests will be saved as a separate file next to the original file.`
+}
+
+// buildLogUserPrompt formats a log with line numbers plus optional extra instructions
+func buildLogUserPrompt(log models.Log, instructions string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s (%s)\n\n", log.Name, log.Language)
+	for i, line := range strings.Split(log.Code, "\n") {
+		fmt.Fprintf(&b, "%d: %s\n", i+1, line)
+	}
+	if strings.TrimSpace(instructions) != "" {
+		fmt.Fprintf(&b, "\nAdditional instructions: %s\n", instructions)
+	}
+	return b.String()
+}
+
+var lineReferenceRegex = regexp.MustCompile(`(?i)\blines?\s+(\d+)(?:\s*(?:-|–|to|through)\s*(\d+))?`)
+
+// parseLineReferences extracts "line N" and "lines N-M" citations from prose
+func parseLineReferences(text string, totalLines int) []api.LineRange {
+	refs := []api.LineRange{}
+	seen := make(map[api.LineRange]bool)
+
+	for _, match := range lineReferenceRegex.FindAllStringSubmatch(text, -1) {
+		start, _ := strconv.Atoi(match[1])
+		end := start
+		if match[2] != "" {
+			end, _ = strconv.Atoi(match[2])
+		}
+
+		r, ok := clampLineRange(start, end, totalLines)
+		if !ok || seen[r] {
+			continue
+		}
+		seen[r] = true
+		refs = append(refs, r)
+	}
+
+	return refs
+}
+
+// parseReviewFindings decodes the JSON array returned in review mode
+func parseReviewFindings(text string, totalLines int) ([]api.ReviewFinding, error) {
+	text = strings.TrimSpace(text)
+	start := strings.Index(text, "[")
+	end := strings.LastIndex(text, "]")
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("no JSON array in review output")
+	}
+
+	var raw []struct {
+		Severity   string `json:"severity"`
+		StartLine  int    `json:"startLine"`
+		EndLine    int    `json:"endLine"`
+		Message    string `json:"message"`
+		Suggestion string `json:"suggestion"`
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &raw); err != nil {
+		return nil, err
+	}
+
+	findings := []api.ReviewFinding{}
+	for _, r := range raw {
+		if strings.TrimSpace(r.Message) == "" {
+			continue
+		}
+
+		lines, ok := clampLineRange(r.StartLine, r.EndLine, totalLines)
+		if !ok {
+			lines = api.LineRange{Start: 1, End: totalLines}
+		}
+
+		findings = append(findings, api.ReviewFinding{
+			Severity:   normalizeSeverity(r.Severity),
+			Lines:      lines,
+			Message:    strings.TrimSpace(r.Message),
+			Suggestion: strings.TrimSpace(r.Suggestion),
+		})
+	}
+
+	return findings, nil
+}
+
+// normalizeSeverity maps provider severities onto the supported set
+func normalizeSeverity(severity string) string {
+	switch strings.ToLower(strings.TrimSpace(severity)) {
+	case "critical", "blocker", "error":
+		return "critical"
+	case "major", "high", "warning":
+		return "major"
+	case "minor", "medium", "low":
+		return "minor"
+	default:
+		return "info"
+	}
+}
+
+// clampLineRange validates a line range against the number of lines in the file
+func clampLineRange(start, end, totalLines int) (api.LineRange, bool) {
+	if start < 1 || start > totalLines {
+		return api.LineRange{}, false
+	}
+	if end < start {
+		end = start
+	}
+	if end > totalLines {
+		end = totalLines
+	}
+	return api.LineRange{Start: start, End: end}, true
+}
+
+// countLines returns the number of lines in code
+func countLines(code string) int {
+	return strings.Count(code, "\n") + 1
+}
+
+// testFilenameFor returns the conventional test filename for a source file
+func testFilenameFor(filename, language string) string {
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+
+	switch language {
+	case "python":
+		return "test_" + base + ext
+	case "javascript", "typescript":
+		return base + ".test" + ext
+	case "java":
+		return base + "Test" + ext
+	default:
+		return base + "_test" + ext
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTestsModeCreatesSiblingLog(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	vault := createVault(t, space, "src", nil)
+	source := createLog(t, vault, "add.py", "def add(a, b):\n    return a + b\n")
+	requests := fakeOpenAI(t, "```python\nfrom add import add\n\ndef test_add():\n    assert add(1, 2) == 3\n```")
+
+	sub := events.Subscribe(space.ID)
+	defer sub.Close()
+
+	a := newAIAPI()
+	w := a.request("POST", "/api/ai/generate", token, api.GenerateRequest{Mode: api.ModeTests, LogID: source.ID.Hex()})
+	expectStatus(t, w, http.StatusCreated)
+
+	resp := decodeBody[api.TestsResponse](t, w)
+	if resp.Log.Name != "test_add.py" || resp.Log.VaultID != vault.ID || resp.Log.Path != "src/test_add.py" {
+		t.Fatalf("log = %+v", resp.Log)
+	}
+
+	select {
+	case event := <-sub.Events:
+		if event.Type != events.LogCreated || event.ID != resp.Log.ID {
+			t.Errorf("event = %+v, want log.created for the new log", event)
+		}
+	default:
+		t.Error("no event published for the new log")
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	var entry models.AuditEntry
+	err := db.Database.Collection("audit").FindOne(ctx, bson.M{"action": "log.create", "targetId": resp.Log.ID.Hex()}).Decode(&entry)
+	if err != nil {
+		t.Fatalf("no log.create audit entry for the new log: %v", err)
+	}
+
+	// A second run would collide with the saved tests, so it's refused before the provider is asked
+	w = a.request("POST", "/api/ai/generate", token, api.GenerateRequest{Mode: api.ModeTests, LogID: source.ID.Hex()})
+	body := expectError(t, w, http.StatusConflict, "CONFLICT")
+	if details, _ := body.Details.(map[string]interface{}); details["logId"] != resp.Log.ID.Hex() {
+		t.Errorf("details = %v, want the existing log's ID", body.Details)
+	}
+	if len(*requests) != 1 {
+		t.Errorf("provider called %d times, want 1", len(*requests))
+	}
+}
+
+func TestTestsModeRequiresEditor(t *testing.T) {
+	setupDB(t)
+	owner, _ := createUser(t, "ada@example.com")
+	viewer, token := createUser(t, "bob@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	addMember(t, space.ID, viewer.ID.Hex(), RoleViewer)
+	source := createLog(t, createVault(t, space, "src", nil), "add.py", "x = 1")
+	fakeOpenAI(t, "def test_x(): pass")
+
+	w := newAIAPI().request("POST", "/api/ai/generate", token, api.GenerateRequest{Mode: api.ModeTests, LogID: source.ID.Hex()})
+	expectError(t, w, http.StatusForbidden, "FORBIDDEN")
+}
+
+func TestExplainModeSendsNoStopSequences(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	source := createLog(t, createVault(t, space, "src", nil), "add.py", "a = 1\nb = 2\nprint(a + b)\n")
+	requests := fakeOpenAI(t, "Lines 1-2 set the values and line 3 prints:\n```python\nprint(a + b)\n```")
+
+	w := newAIAPI().request("POST", "/api/ai/generate", token, api.GenerateRequest{Mode: api.ModeExplain, LogID: source.ID.Hex()})
+	expectStatus(t, w, http.StatusOK)
+
+	resp := decodeBody[api.ExplainResponse](t, w)
+	if want := []api.LineRange{{Start: 1, End: 2}, {Start: 3, End: 3}}; !reflect.DeepEqual(resp.References, want) {
+		t.Errorf("references = %v, want %v", resp.References, want)
+	}
+	if stop := (*requests)[0].Stop; len(stop) != 0 {
+		t.Errorf("explain request has stop sequences %q that cut off code samples", stop)
+	}
+}
+
+func TestParseLineReferences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []api.LineRange
+	}{
+		{"nothing to see", []api.LineRange{}},
+		{"Line 2 and lines 3-5", []api.LineRange{{Start: 2, End: 2}, {Start: 3, End: 5}}},
+		{"lines 2 to 4 and line 2 through 4", []api.LineRange{{Start: 2, End: 4}}},
+		{"line 7 is past the end, lines 5–20 are clamped", []api.LineRange{{Start: 5, End: 6}}},
+		{"lines 3-1", []api.LineRange{{Start: 3, End: 3}}},
+		{"line 0", []api.LineRange{}},
+	}
+	for _, tt := range tests {
+		if got := parseLineReferences(tt.text, 6); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLineReferences(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseReviewFindings(t *testing.T) {
+	text := "Here you go:\n```json\n[" +
+		`{"severity": "High", "startLine": 2, "endLine": 3, "message": " Off by one ", "suggestion": "Use <="},` +
+		`{"severity": "nit", "startLine": 40, "endLine": 41, "message": "Out of range"},` +
+		`{"severity": "error", "startLine": 1, "endLine": 1, "message": ""}` +
+		"]\n```"
+
+	got, err := parseReviewFindings(text, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []api.ReviewFinding{
+		{Severity: "major", Lines: api.LineRange{Start: 2, End: 3}, Message: "Off by one", Suggestion: "Use <="},
+		{Severity: "info", Lines: api.LineRange{Start: 1, End: 5}, Message: "Out of range"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %+v, want %+v", got, want)
+	}
+
+	if _, err := parseReviewFindings("Looks good to me!", 5); err == nil {
+		t.Error("expected an error for output without a JSON array")
+	}
+}
+
+func TestTestFilenameFor(t *testing.T) {
+	tests := []struct{ filename, language, want string }{
+		{"add.py", "python", "test_add.py"},
+		{"sum.js", "javascript", "sum.test.js"},
+		{"sum.ts", "typescript", "sum.test.ts"},
+		{"Main.java", "java", "MainTest.java"},
+		{"main.go", "go", "main_test.go"},
+	}
+	for _, tt := range tests {
+		if got := testFilenameFor(tt.filename, tt.language); got != tt.want {
+			t.Errorf("testFilenameFor(%q, %q) = %q, want %q", tt.filename, tt.language, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"codeflow-backend/api"
+)
+
+// fakeOpenAI answers chat completions with replies in turn and records the requests
+func fakeOpenAI(t *testing.T, replies ...string) *[]OpenAIRequest {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []OpenAIRequest
+	openAIAPI = fakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, req)
+		reply := replies[min(len(requests), len(replies))-1]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": OpenAIMessage{Role: "assistant", Content: reply}}},
+			"usage":   map[string]int{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	})
+	t.Cleanup(func() { openAIAPI = "https://api.openai.com/v1" })
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("GEMINI_API_KEY", "")
+	return &requests
+}
+
+// prompt returns the last message of a chat request
+func prompt(req OpenAIRequest) string {
+	return req.Messages[len(req.Messages)-1].Content
+}
+
+// fakePiston runs code by calling run with the submitted main file
+func fakePiston(t *testing.T, run func(code string) (int, PistonResponse)) {
+	t.Helper()
+	pistonAPI = fakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req PistonRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		status, resp := run(req.Files[len(req.Files)-1].Content)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	})
+	t.Cleanup(func() { pistonAPI = "https://emkc.org/api/v2/piston" })
+}
+
+func newAIAPI() *testAPI {
+	a := newTestAPI()
+	a.api.POST("/ai/generate", GenerateCode)
+	a.api.POST("/run", RunCode)
+	return a
+}
+
+func TestAgenticModeRepairsFailingCode(t *testing.T) {
+	setupDB(t)
+	_, token := createUser(t, "ada@example.com")
+	requests := fakeOpenAI(t, "print(1/0)", "print(1)")
+	fakePiston(t, func(code string) (int, PistonResponse) {
+		if code == "print(1/0)" {
+			return http.StatusOK, PistonResponse{Run: PistonStage{Stderr: "ZeroDivisionError", Code: 1}}
+		}
+		return http.StatusOK, PistonResponse{Run: PistonStage{Stdout: "1\n"}}
+	})
+
+	w := newAIAPI().request("POST", "/api/ai/generate", token, api.GenerateRequest{Prompt: "print one", Language: "python", Agentic: true})
+	expectStatus(t, w, http.StatusOK)
+
+	resp := decodeBody[api.GenerateResponse](t, w)
+	if resp.Passed == nil || !*resp.Passed || resp.Code != "print(1)" || len(resp.Attempts) != 2 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if repair := prompt((*requests)[1]); !strings.Contains(repair, "ZeroDivisionError") || !strings.Contains(repair, "print(1/0)") {
+		t.Errorf("repair prompt doesn't include the failure: %q", repair)
+	}
+}
+
+func TestAgenticModeKeepsAttemptsWhenRunnerFails(t *testing.T) {
+	setupDB(t)
+	t.Setenv("QUOTA_USER_RUNS_PER_DAY", "10")
+	ada, token := createUser(t, "ada@example.com")
+	fakeOpenAI(t, "print(1/0)", "print(1)")
+	fakePiston(t, func(code string) (int, PistonResponse) {
+		if code == "print(1/0)" {
+			return http.StatusOK, PistonResponse{Run: PistonStage{Stderr: "ZeroDivisionError", Code: 1}}
+		}
+		return http.StatusServiceUnavailable, PistonResponse{}
+	})
+
+	w := newAIAPI().request("POST", "/api/ai/generate", token, api.GenerateRequest{Prompt: "print one", Language: "python", Agentic: true, MaxRepairs: 3})
+	expectStatus(t, w, http.StatusOK)
+
+	resp := decodeBody[api.GenerateResponse](t, w)
+	if resp.Passed == nil || *resp.Passed || resp.Code != "print(1)" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if len(resp.Attempts) != 2 {
+		t.Fatalf("got %d attempts, want the failed run and the one the runner couldn't take", len(resp.Attempts))
+	}
+	if resp.Attempts[0].Error != "" || resp.Attempts[0].Stderr != "ZeroDivisionError" {
+		t.Errorf("first attempt = %+v", resp.Attempts[0])
+	}
+	if !strings.Contains(resp.Attempts[1].Error, "status 503") {
+		t.Errorf("last attempt error = %q", resp.Attempts[1].Error)
+	}
+
+	// Only the run that happened counts against the quota
+	ctx, cancel := testContext()
+	defer cancel()
+	if runs, err := dailyCount(ctx, QuotaRunsPerDay, "user", ada.ID.Hex()); err != nil || runs != 1 {
+		t.Errorf("runs = %d, %v; want 1", runs, err)
+	}
+}
+
+func TestAgenticModeChargesEveryRoundAndRun(t *testing.T) {
+	setupDB(t)
+	ada, token := createUser(t, "ada@example.com")
+	fakeOpenAI(t, "print(1/0)")
+	fakePiston(t, func(string) (int, PistonResponse) {
+		return http.StatusOK, PistonResponse{Run: PistonStage{Stderr: "ZeroDivisionError", Code: 1}}
+	})
+	a := newAIAPI()
+	counts := func() (int, int) {
+		t.Helper()
+		ctx, cancel := testContext()
+		defer cancel()
+		runs, err := dailyCount(ctx, QuotaRunsPerDay, "user", ada.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls, err := dailyCount(ctx, QuotaAICallsPerDay, "user", ada.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runs, calls
+	}
+	req := api.GenerateRequest{Prompt: "print one", Language: "python", Agentic: true, MaxRepairs: 5}
+
+	// The run quota stops the loop before the third run
+	t.Setenv("QUOTA_USER_RUNS_PER_DAY", "2")
+	t.Setenv("QUOTA_USER_AI_CALLS_PER_DAY", "100")
+	w := a.request("POST", "/api/ai/generate", token, req)
+	expectStatus(t, w, http.StatusOK)
+	resp := decodeBody[api.GenerateResponse](t, w)
+	if len(resp.Attempts) != 3 || !strings.HasPrefix(resp.Attempts[2].Error, "Not run: ") || resp.Stopped == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if runs, calls := counts(); runs != 2 || calls != 3 {
+		t.Errorf("counts = %d runs, %d calls; want 2, 3", runs, calls)
+	}
+
+	// The AI call quota stops it before the next repair
+	t.Setenv("QUOTA_USER_RUNS_PER_DAY", "100")
+	t.Setenv("QUOTA_USER_AI_CALLS_PER_DAY", "5")
+	w = a.request("POST", "/api/ai/generate", token, req)
+	expectStatus(t, w, http.StatusOK)
+	resp = decodeBody[api.GenerateResponse](t, w)
+	if len(resp.Attempts) != 2 || resp.Attempts[1].Error != "" || resp.Stopped == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if runs, calls := counts(); runs != 4 || calls != 5 {
+		t.Errorf("counts = %d runs, %d calls; want 4, 5", runs, calls)
+	}
+}
+
+func TestAgenticModeNeedsAKnownLanguage(t *testing.T) {
+	setupDB(t)
+	_, token := createUser(t, "ada@example.com")
+	requests := fakeOpenAI(t, "puts 1")
+	a := newAIAPI()
+
+	for _, req := range []api.GenerateRequest{
+		{Prompt: "print one", Filename: "main.rb", Agentic: true},
+		{Prompt: "print one", Filename: "Makefile", Agentic: true},
+		{Prompt: "print one", Agentic: true},
+	} {
+		expectError(t, a.request("POST", "/api/ai/generate", token, req), http.StatusBadRequest, "INVALID_REQUEST")
+	}
+	if len(*requests) != 0 {
+		t.Errorf("made %d provider calls for a language it can't run", len(*requests))
+	}
+}
+
+func TestRunCodeReportsRunnerFailureAsUpstreamError(t *testing.T) {
+	setupDB(t)
+	t.Setenv("QUOTA_USER_RUNS_PER_DAY", "1")
+	_, token := createUser(t, "ada@example.com")
+	fakePiston(t, func(string) (int, PistonResponse) { return http.StatusBadRequest, PistonResponse{} })
+
+	w := newAIAPI().request("POST", "/api/run", token, api.RunRequest{Language: "python", Code: "print(1)"})
+	body := expectError(t, w, http.StatusBadGateway, "UPSTREAM_ERROR")
+	if !strings.Contains(body.Message, "status 400") {
+		t.Errorf("message = %q", body.Message)
+	}
+
+	// The failed run was refunded, so the only run of the day still goes ahead
+	fakePiston(t, func(string) (int, PistonResponse) {
+		return http.StatusOK, PistonResponse{Run: PistonStage{Stdout: "1\n"}}
+	})
+	expectStatus(t, newAIAPI().request("POST", "/api/run", token, api.RunRequest{Language: "python", Code: "print(1)"}), http.StatusOK)
+}
+
+func TestExecuteCodeErrors(t *testing.T) {
+	pistonAPI = "http://127.0.0.1:1"
+	t.Cleanup(func() { pistonAPI = "https://emkc.org/api/v2/piston" })
+	if _, err := executeCode("python", "print(1)"); err == nil || !strings.HasPrefix(err.Error(), "failed to reach the code runner") {
+		t.Errorf("unreachable runner: err = %v", err)
+	}
+
+	pistonAPI = fakeServer(t, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("not json")) })
+	if _, err := executeCode("python", "print(1)"); err == nil || !strings.HasPrefix(err.Error(), "failed to parse") {
+		t.Errorf("bad response: err = %v", err)
+	}
+}
+
+func TestExtractCodeOnly(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"print(1)", "print(1)"},
+		{"Here is the code:\n\nprint(1)\n", "print(1)"},
+		{"Sure.\n```python\nprint(1)\n```\nDone.", "print(1)"},
+		{"```\nx = 1\ny = 2\n```", "x = 1\ny = 2"},
+	}
+	for _, tt := range tests {
+		if got := extractCodeOnly(tt.in); got != tt.want {
+			t.Errorf("extractCodeOnly(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
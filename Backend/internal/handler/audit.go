@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// auditSnapshot summarises an object for the before/after fields of an audit entry
+type auditSnapshot = map[string]interface{}
+
+// recordAudit appends an entry to the audit log, filling in the actor, IP, request ID
+// and timestamp from the request. The IP is only taken from forwarding headers set by
+// TRUSTED_PROXIES. Failures are logged and never fail the request.
+func recordAudit(c *gin.Context, entry models.AuditEntry) {
+	entry.ID = primitive.NewObjectID()
+	if entry.Actor == "" {
+		entry.Actor = currentUserID(c)
+	}
+	if entry.Actor == "" {
+		entry.Actor = "anonymous"
+	}
+	entry.IP = c.ClientIP()
+	if remote := c.RemoteIP(); remote != entry.IP {
+		entry.ProxyIP = remote
+	}
+	entry.RequestID = c.GetString(middleware.RequestIDKey)
+	entry.CreatedAt = time.Now()
+
+	// The request context may already be used up by a slow provider or Piston call
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Database.Collection("audit").InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// auditAI records a completed AI call
+func auditAI(c *gin.Context, call aiCall, targetType, targetID string, after auditSnapshot) {
+	recordAudit(c, models.AuditEntry{
+		Action:     "ai." + call.Mode,
+		SpaceID:    call.SpaceID,
+		TargetType: targetType,
+		TargetID:   targetID,
+		After:      after,
+	})
+}
+
+func spaceSnapshot(space models.Space) auditSnapshot {
+	return auditSnapshot{"name": space.Name}
+}
+
+func vaultSnapshot(vault models.Vault) auditSnapshot {
+	snapshot := auditSnapshot{"name": vault.Name, "path": vault.Path}
+	if vault.ParentID != nil {
+		snapshot["parentId"] = vault.ParentID.Hex()
+	}
+	return snapshot
+}
+
+// logSnapshot records the size of the code rather than the code itself
+func logSnapshot(log models.Log) auditSnapshot {
+	return auditSnapshot{
+		"name":      log.Name,
+		"path":      log.Path,
+		"language":  log.Language,
+		"vaultId":   log.VaultID.Hex(),
+		"codeBytes": len(log.Code),
+	}
+}
+
+// GetAuditLog lists audit entries for a space, newest first. Only owners can read it.
+// Query: ?spaceId=xxx&actor=userId&action=log.update&targetId=xxx&from=YYYY-MM-DD&to=YYYY-MM-DD&limit=50&before=entryId
+func GetAuditLog(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Query("spaceId"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, "spaceId query parameter required")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleOwner, "Space not found") {
+		return
+	}
+
+	filter := bson.M{"spaceId": spaceID}
+	if actor := c.Query("actor"); actor != "" {
+		filter["actor"] = actor
+	}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+	if targetID := c.Query("targetId"); targetID != "" {
+		filter["targetId"] = targetID
+	}
+
+	createdAt := bson.M{}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid from date, expected YYYY-MM-DD")
+			return
+		}
+		createdAt["$gte"] = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid to date, expected YYYY-MM-DD")
+			return
+		}
+		createdAt["$lt"] = t.AddDate(0, 0, 1)
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	// Page backwards through entries with the ID of the last entry seen
+	if before := c.Query("before"); before != "" {
+		beforeID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid before cursor")
+			return
+		}
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+
+	limit := defaultAuditPageSize
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxAuditPageSize)
+	}
+
+	collection := db.Database.Collection("audit")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch audit log")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []models.AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode audit log")
+		return
+	}
+
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	// A full page means there may be more entries
+	var nextCursor string
+	if len(entries) == limit {
+		nextCursor = entries[len(entries)-1].ID.Hex()
+	}
+
+	c.JSON(http.StatusOK, api.AuditPage{
+		Entries:    entries,
+		NextCursor: nextCursor,
+	})
+}
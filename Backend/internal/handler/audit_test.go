@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// newAuditAPI serves a route that records a "test.audit" entry for the caller
+func newAuditAPI(trustedProxies []string) *testAPI {
+	a := newTestAPI()
+	if err := a.engine.SetTrustedProxies(trustedProxies); err != nil {
+		panic(err)
+	}
+	audit := func(c *gin.Context) {
+		recordAudit(c, models.AuditEntry{Action: "test.audit", TargetType: "test"})
+		c.Status(http.StatusNoContent)
+	}
+	a.engine.POST("/public/audit", audit)
+	a.api.POST("/audit", audit)
+	return a
+}
+
+func lastAuditEntry(t *testing.T) models.AuditEntry {
+	t.Helper()
+	ctx, cancel := testContext()
+	defer cancel()
+	var entry models.AuditEntry
+	if err := db.Database.Collection("audit").FindOne(ctx, bson.M{"action": "test.audit"}).Decode(&entry); err != nil {
+		t.Fatalf("no audit entry recorded: %v", err)
+	}
+	if _, err := db.Database.Collection("audit").DeleteMany(ctx, bson.M{"action": "test.audit"}); err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestAuditIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	a := newAuditAPI(nil)
+
+	// httptest requests come from 192.0.2.1
+	w := a.request("POST", "/api/audit", token, nil, "X-Forwarded-For", "203.0.113.9", "X-Real-IP", "203.0.113.9", middleware.RequestIDHeader, "req-1")
+	expectStatus(t, w, http.StatusNoContent)
+
+	entry := lastAuditEntry(t)
+	if entry.IP != "192.0.2.1" || entry.ProxyIP != "" {
+		t.Errorf("ip = %q, proxy = %q; want the connecting address", entry.IP, entry.ProxyIP)
+	}
+	if entry.Actor != user.ID.Hex() || entry.RequestID != "req-1" {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	expectStatus(t, a.request("POST", "/public/audit", "", nil), http.StatusNoContent)
+	if entry := lastAuditEntry(t); entry.Actor != "anonymous" {
+		t.Errorf("actor = %q without a session, want anonymous", entry.Actor)
+	}
+}
+
+func TestAuditRecordsAddressForwardedByTrustedProxy(t *testing.T) {
+	setupDB(t)
+	_, token := createUser(t, "ada@example.com")
+	a := newAuditAPI([]string{"192.0.2.0/24"})
+
+	w := a.request("POST", "/api/audit", token, nil, "X-Forwarded-For", "198.51.100.7, 203.0.113.9")
+	expectStatus(t, w, http.StatusNoContent)
+
+	// The proxy appended the client it saw; anything before it was sent by the client
+	entry := lastAuditEntry(t)
+	if entry.IP != "203.0.113.9" || entry.ProxyIP != "192.0.2.1" {
+		t.Errorf("ip = %q, proxy = %q; want 203.0.113.9 via 192.0.2.1", entry.IP, entry.ProxyIP)
+	}
+}
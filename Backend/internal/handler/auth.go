@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// currentUserID returns the ID of the user authenticated by the auth middleware
+func currentUserID(c *gin.Context) string {
+	return c.GetString(middleware.UserIDKey)
+}
+
+// Register creates a user account and starts a session
+func Register(c *gin.Context) {
+	var req api.RegisterRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if len(req.Password) < auth.MinPasswordLength {
+		apierror.Abort(c, apierror.InvalidRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to hash password")
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+
+	user := models.User{
+		ID:           primitive.NewObjectID(),
+		Email:        email,
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	collection := db.Database.Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		apierror.Abort(c, apierror.Conflict, "Email already registered")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create user")
+		return
+	}
+
+	startSession(c, http.StatusCreated, user)
+}
+
+// Login verifies email and password and starts a session
+func Login(c *gin.Context) {
+	var req api.LoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	collection := db.Database.Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Unknown emails leave the hash empty, which still costs a bcrypt comparison
+	var user models.User
+	err := collection.FindOne(ctx, bson.M{"email": normalizeEmail(req.Email)}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		apierror.Abort(c, apierror.Internal, "Failed to log in")
+		return
+	}
+	if !auth.CheckPassword(user.PasswordHash, req.Password) {
+		apierror.Abort(c, apierror.Unauthorized, "Invalid email or password")
+		return
+	}
+
+	startSession(c, http.StatusOK, user)
+}
+
+// Logout ends the user's sessions everywhere and clears the session cookie
+func Logout(c *gin.Context) {
+	if userID, generation, err := auth.ParseSessionToken(middleware.RequestToken(c)); err == nil {
+		if !endSessions(userID, generation) {
+			apierror.Abort(c, apierror.Internal, "Failed to end session")
+			return
+		}
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.SessionCookieName, "", -1, "/", "", secureCookies(), true)
+	c.JSON(http.StatusOK, MessageResponse{Message: "Logged out successfully"})
+}
+
+// GetCurrentUser returns the authenticated user
+func GetCurrentUser(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == middleware.LegacyAdminUserID {
+		c.JSON(http.StatusOK, gin.H{"id": userID, "name": "Admin"})
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		apierror.Abort(c, apierror.NotFound, "User not found")
+		return
+	}
+
+	collection := db.Database.Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		apierror.Abort(c, apierror.NotFound, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// startSession issues a session token, sets it as a cookie and returns it with the user
+func startSession(c *gin.Context, status int, user models.User) {
+	token, expiresAt, ok := issueSession(c, user)
+	if !ok {
+		return
+	}
+
+	c.JSON(status, api.SessionResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		User:      user,
+	})
+}
+
+// issueSession issues a session token and sets it as a cookie, writing an error response on failure
+func issueSession(c *gin.Context, user models.User) (string, time.Time, bool) {
+	token, expiresAt, err := auth.IssueSessionToken(user.ID.Hex(), user.SessionGeneration)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create session")
+		return "", time.Time{}, false
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.SessionCookieName, token, int(time.Until(expiresAt).Seconds()), "/", "", secureCookies(), true)
+	return token, expiresAt, true
+}
+
+// endSessions moves a user past session generation, ending every session issued at it.
+// Sessions already ended, and users that no longer exist, are left alone.
+func endSessions(userID string, generation int) bool {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = db.Database.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": objectID, "sessionGeneration": sessionGenerationFilter(generation)},
+		bson.M{"$set": bson.M{"sessionGeneration": generation + 1}})
+	return err == nil
+}
+
+// sessionGenerationFilter matches a stored session generation; 0 is stored as a missing field
+func sessionGenerationFilter(generation int) interface{} {
+	if generation == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return generation
+}
+
+// secureCookies reports whether cookies should be marked Secure (COOKIE_SECURE=true)
+func secureCookies() bool {
+	return os.Getenv("COOKIE_SECURE") == "true"
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/auth"
+)
+
+func newAuthAPI() *testAPI {
+	a := newTestAPI()
+	a.engine.POST("/api/auth/login", Login)
+	a.engine.POST("/api/auth/logout", Logout)
+	a.api.GET("/auth/me", GetCurrentUser)
+	return a
+}
+
+func TestLogoutEndsSessionsServerSide(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	a := newAuthAPI()
+
+	w := a.request("POST", "/api/auth/login", "", api.LoginRequest{Email: user.Email, Password: "password"})
+	expectStatus(t, w, http.StatusOK)
+	other := decodeBody[api.SessionResponse](t, w).Token
+
+	expectStatus(t, a.request("POST", "/api/auth/logout", token, nil), http.StatusOK)
+
+	// Both sessions were issued before the logout, so both have ended
+	expectError(t, a.request("GET", "/api/auth/me", token, nil), http.StatusUnauthorized, "UNAUTHORIZED")
+	expectError(t, a.request("GET", "/api/auth/me", other, nil), http.StatusUnauthorized, "UNAUTHORIZED")
+
+	w = a.request("POST", "/api/auth/login", "", api.LoginRequest{Email: user.Email, Password: "password"})
+	expectStatus(t, w, http.StatusOK)
+	fresh := decodeBody[api.SessionResponse](t, w).Token
+	expectStatus(t, a.request("GET", "/api/auth/me", fresh, nil), http.StatusOK)
+
+	// Logging out with an already ended token doesn't end the new session
+	expectStatus(t, a.request("POST", "/api/auth/logout", token, nil), http.StatusOK)
+	expectStatus(t, a.request("GET", "/api/auth/me", fresh, nil), http.StatusOK)
+}
+
+func TestLogoutWithoutSession(t *testing.T) {
+	setupDB(t)
+	w := newAuthAPI().request("POST", "/api/auth/logout", "not-a-token", nil)
+	expectStatus(t, w, http.StatusOK)
+	if cookie := w.Result().Cookies(); len(cookie) != 1 || cookie[0].Name != auth.SessionCookieName || cookie[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want the session cookie cleared", cookie)
+	}
+}
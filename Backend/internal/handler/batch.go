@@ -0,0 +1,610 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxBatchOperations = 100
+
+// batchFailure stops a batch at the operation that failed
+type batchFailure struct {
+	index int
+	err   *apierror.Error
+}
+
+func (f *batchFailure) Error() string {
+	return fmt.Sprintf("operation %d: %s", f.index, f.err.Message)
+}
+
+// batchRun is the state shared by the operations of one batch
+type batchRun struct {
+	userID  string
+	roles   map[primitive.ObjectID]string
+	created map[int]primitive.ObjectID
+	results []api.BatchResult
+	audits  []models.AuditEntry
+	changes []events.Event
+}
+
+// ExecuteBatch runs an ordered list of vault and log operations. On a replica set
+// they run in one transaction, so either all of them apply or none do. A
+// standalone server can't run transactions, so operations apply one at a time
+// and the batch stops at the first failure, leaving earlier ones in place.
+func ExecuteBatch(c *gin.Context) {
+	var req api.BatchRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		apierror.Abort(c, apierror.InvalidRequest, fmt.Sprintf("A batch must have between 1 and %d operations", maxBatchOperations))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	run := &batchRun{userID: currentUserID(c), roles: map[primitive.ObjectID]string{}}
+	atomic := db.SupportsTransactions
+
+	var failure *batchFailure
+	if atomic {
+		var err error
+		if failure, err = run.executeInTransaction(ctx, req.Operations); err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to run batch")
+			return
+		}
+	} else {
+		failure = run.execute(ctx, req.Operations)
+	}
+
+	// A failed transaction left nothing behind to audit or announce
+	if failure == nil || !atomic {
+		for _, entry := range run.audits {
+			recordAudit(c, entry)
+		}
+		for _, event := range run.changes {
+			events.Publish(event)
+		}
+	}
+
+	if failure != nil {
+		details := gin.H{"index": failure.index, "atomic": atomic}
+		if failure.err.Details != nil {
+			details["error"] = failure.err.Details
+		}
+		if !atomic {
+			details["applied"] = run.results
+		}
+		apierror.Respond(c, apierror.New(failure.err.Code, fmt.Sprintf("Operation %d failed: %s", failure.index, failure.err.Message)).
+			WithStatus(failure.err.Status).
+			WithDetails(details))
+		return
+	}
+
+	c.JSON(http.StatusOK, api.BatchResponse{Atomic: atomic, Results: run.results})
+}
+
+// executeInTransaction runs the operations in a transaction. The driver retries
+// the whole transaction on transient errors, so each attempt starts afresh.
+func (b *batchRun) executeInTransaction(ctx context.Context, ops []api.BatchOperation) (*batchFailure, error) {
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if failure := b.execute(sc, ops); failure != nil {
+			return nil, failure
+		}
+		return nil, nil
+	})
+
+	var failure *batchFailure
+	if errors.As(err, &failure) {
+		return failure, nil
+	}
+	return nil, err
+}
+
+// execute applies operations in order and stops at the first failure
+func (b *batchRun) execute(ctx context.Context, ops []api.BatchOperation) *batchFailure {
+	b.created = map[int]primitive.ObjectID{}
+	b.results = []api.BatchResult{}
+	b.audits = nil
+	b.changes = nil
+
+	for i, op := range ops {
+		result, err := b.apply(ctx, op)
+		if err != nil {
+			return &batchFailure{index: i, err: err}
+		}
+		result.Index = i
+		if op.Op == "create" {
+			b.created[i], _ = primitive.ObjectIDFromHex(result.ID)
+		}
+		b.results = append(b.results, result)
+	}
+	return nil
+}
+
+func (b *batchRun) apply(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	switch op.Type + "." + op.Op {
+	case "vault.create":
+		return b.createVault(ctx, op)
+	case "vault.update":
+		return b.updateVault(ctx, op)
+	case "vault.move":
+		return b.moveVault(ctx, op)
+	case "vault.delete":
+		return b.deleteVault(ctx, op)
+	case "log.create":
+		return b.createLog(ctx, op)
+	case "log.update":
+		return b.updateLog(ctx, op)
+	case "log.move":
+		return b.moveLog(ctx, op)
+	case "log.delete":
+		return b.deleteLog(ctx, op)
+	}
+	return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "op must be create, update, move or delete and type must be vault or log")
+}
+
+func (b *batchRun) createVault(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	if op.Name == nil || strings.TrimSpace(*op.Name) == "" {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "name is required")
+	}
+
+	vault := models.Vault{
+		ID:        primitive.NewObjectID(),
+		UserID:    b.userID,
+		Name:      *op.Name,
+		Path:      *op.Name,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if op.ParentID != nil && *op.ParentID != "" {
+		parent, err := b.loadVault(ctx, *op.ParentID)
+		if err != nil {
+			return api.BatchResult{}, err
+		}
+		if op.SpaceID != "" && op.SpaceID != parent.SpaceID.Hex() {
+			return api.BatchResult{}, apierror.New(apierror.NotFound, "Parent vault not found")
+		}
+		vault.SpaceID = parent.SpaceID
+		vault.ParentID = &parent.ID
+		vault.Path = path.Join(parent.Path, *op.Name)
+	} else {
+		spaceID, err := primitive.ObjectIDFromHex(op.SpaceID)
+		if err != nil {
+			return api.BatchResult{}, apierror.New(apierror.InvalidID, "Invalid space ID")
+		}
+		if err := b.authorize(ctx, spaceID, "Space not found"); err != nil {
+			return api.BatchResult{}, err
+		}
+		vault.SpaceID = spaceID
+	}
+
+	if err := checkVaultQuota(ctx, vault.SpaceID, b.userID); err != nil {
+		return api.BatchResult{}, err
+	}
+
+	if _, err := db.Database.Collection("vaults").InsertOne(ctx, vault); err != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to create vault")
+	}
+
+	b.audit("vault.create", vault.SpaceID, "vault", vault.ID, nil, vaultSnapshot(vault))
+	b.announce(events.ForVault(events.VaultCreated, vault))
+	return api.BatchResult{Status: http.StatusCreated, ID: vault.ID.Hex(), Data: vault}, nil
+}
+
+func (b *batchRun) updateVault(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	vault, err := b.loadVault(ctx, op.ID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if err := checkIfVersion(op, vault.Version); err != nil {
+		return api.BatchResult{}, err
+	}
+	if op.Name == nil || strings.TrimSpace(*op.Name) == "" {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "name is required")
+	}
+
+	newPath := *op.Name
+	if vault.ParentID != nil {
+		var parent models.Vault
+		if err := db.Database.Collection("vaults").FindOne(ctx, bson.M{"_id": vault.ParentID}).Decode(&parent); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return api.BatchResult{}, apierror.New(apierror.NotFound, "Parent vault not found")
+			}
+			return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to fetch parent vault")
+		}
+		newPath = path.Join(parent.Path, *op.Name)
+	}
+
+	return b.saveVault(ctx, vault, "vault.update", events.VaultUpdated, bson.M{
+		"$set": bson.M{"name": *op.Name, "path": newPath, "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}, newPath)
+}
+
+func (b *batchRun) moveVault(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	vault, err := b.loadVault(ctx, op.ID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if err := checkIfVersion(op, vault.Version); err != nil {
+		return api.BatchResult{}, err
+	}
+	if op.ParentID == nil {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, `parentId is required, or "" to move to the top level`)
+	}
+
+	update := bson.M{"$inc": bson.M{"version": 1}}
+	newPath := vault.Name
+
+	if *op.ParentID == "" {
+		update["$set"] = bson.M{"path": newPath, "updatedAt": time.Now()}
+		update["$unset"] = bson.M{"parentId": ""}
+	} else {
+		parent, err := b.loadVault(ctx, *op.ParentID)
+		if err != nil {
+			return api.BatchResult{}, err
+		}
+		if parent.SpaceID != vault.SpaceID {
+			return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "Vaults can only move within their space")
+		}
+
+		tree, treeErr := vaultTree(ctx, vault.ID)
+		if treeErr != nil {
+			return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to move vault")
+		}
+		for _, id := range tree {
+			if id == parent.ID {
+				return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "A vault can't move into itself or a vault nested under it")
+			}
+		}
+
+		newPath = path.Join(parent.Path, vault.Name)
+		update["$set"] = bson.M{"parentId": parent.ID, "path": newPath, "updatedAt": time.Now()}
+	}
+
+	return b.saveVault(ctx, vault, "vault.move", events.VaultMoved, update, newPath)
+}
+
+// saveVault applies an update to a vault at its loaded version and brings the
+// paths of its contents in line with newPath
+func (b *batchRun) saveVault(ctx context.Context, vault models.Vault, action, eventType string, update bson.M, newPath string) (api.BatchResult, *apierror.Error) {
+	collection := db.Database.Collection("vaults")
+
+	result, err := collection.UpdateOne(ctx, versionFilter(vault.ID, vault.Version), update)
+	if err != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to update vault")
+	}
+	if result.MatchedCount == 0 {
+		return api.BatchResult{}, writeMissedError(ctx, collection, vault.ID, "Vault not found")
+	}
+
+	nested, nestedErr := rewriteDescendantPaths(ctx, vault.ID, vault.Path, newPath)
+	if nestedErr != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to update nested paths")
+	}
+
+	var updated models.Vault
+	collection.FindOne(ctx, bson.M{"_id": vault.ID}).Decode(&updated)
+
+	b.audit(action, vault.SpaceID, "vault", vault.ID, vaultSnapshot(vault), vaultSnapshot(updated))
+	b.announce(events.ForVault(eventType, updated))
+	b.announce(nested...)
+	return api.BatchResult{Status: http.StatusOK, ID: vault.ID.Hex(), Data: updated}, nil
+}
+
+func (b *batchRun) deleteVault(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	vault, err := b.loadVault(ctx, op.ID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if err := checkIfVersion(op, vault.Version); err != nil {
+		return api.BatchResult{}, err
+	}
+
+	collection := db.Database.Collection("vaults")
+	result, deleteErr := collection.DeleteOne(ctx, versionFilter(vault.ID, vault.Version))
+	if deleteErr != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to delete vault")
+	}
+	if result.DeletedCount == 0 {
+		return api.BatchResult{}, writeMissedError(ctx, collection, vault.ID, "Vault not found")
+	}
+
+	contents, contentsErr := deleteVaultContents(ctx, vault)
+	if contentsErr != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to delete vault contents")
+	}
+
+	b.audit("vault.delete", vault.SpaceID, "vault", vault.ID, vaultSnapshot(vault), nil)
+	b.announce(events.Deleted(events.VaultDeleted, vault.SpaceID, vault.ID))
+	b.announce(contents...)
+	return api.BatchResult{Status: http.StatusOK, ID: vault.ID.Hex()}, nil
+}
+
+func (b *batchRun) createLog(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	if op.Name == nil || strings.TrimSpace(*op.Name) == "" {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "name is required")
+	}
+
+	vault, err := b.loadVault(ctx, op.VaultID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if op.SpaceID != "" && op.SpaceID != vault.SpaceID.Hex() {
+		return api.BatchResult{}, apierror.New(apierror.NotFound, "Vault not found")
+	}
+
+	code := ""
+	if op.Code != nil {
+		code = *op.Code
+	}
+	if err := checkLogQuota(ctx, vault.SpaceID, b.userID, code); err != nil {
+		return api.BatchResult{}, err
+	}
+
+	language := models.InferLanguageFromFilename(*op.Name)
+	if op.Language != nil && *op.Language != "" {
+		language = *op.Language
+	}
+
+	log := models.Log{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   vault.SpaceID,
+		VaultID:   vault.ID,
+		UserID:    b.userID,
+		Name:      *op.Name,
+		Path:      path.Join(vault.Path, *op.Name),
+		Language:  language,
+		Code:      code,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if _, err := db.Database.Collection("logs").InsertOne(ctx, log); err != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to create log")
+	}
+
+	b.audit("log.create", log.SpaceID, "log", log.ID, nil, logSnapshot(log))
+	b.announce(events.ForLog(events.LogCreated, log))
+	return api.BatchResult{Status: http.StatusCreated, ID: log.ID.Hex(), Data: log}, nil
+}
+
+func (b *batchRun) updateLog(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	log, err := b.loadLog(ctx, op.ID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if err := checkIfVersion(op, log.Version); err != nil {
+		return api.BatchResult{}, err
+	}
+	if op.Name != nil && strings.TrimSpace(*op.Name) == "" {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "name can't be empty")
+	}
+	if op.Language != nil && *op.Language == "" {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "language can't be empty")
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+
+	if op.Name != nil {
+		var vault models.Vault
+		db.Database.Collection("vaults").FindOne(ctx, bson.M{"_id": log.VaultID}).Decode(&vault)
+
+		set["name"] = *op.Name
+		set["path"] = path.Join(vault.Path, *op.Name)
+		set["language"] = models.InferLanguageFromFilename(*op.Name)
+	}
+
+	if op.Language != nil {
+		set["language"] = *op.Language
+	}
+
+	if op.Code != nil {
+		if err := checkCodeSizeQuota(ctx, log.SpaceID, len(log.Code), len(*op.Code)); err != nil {
+			return api.BatchResult{}, err
+		}
+		set["code"] = *op.Code
+	}
+
+	return b.saveLog(ctx, log, "log.update", events.LogUpdated, set)
+}
+
+func (b *batchRun) moveLog(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	log, err := b.loadLog(ctx, op.ID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if err := checkIfVersion(op, log.Version); err != nil {
+		return api.BatchResult{}, err
+	}
+	if op.VaultID == "" {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "vaultId is required")
+	}
+
+	vault, err := b.loadVault(ctx, op.VaultID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if vault.SpaceID != log.SpaceID {
+		return api.BatchResult{}, apierror.New(apierror.InvalidRequest, "Logs can only move within their space")
+	}
+
+	return b.saveLog(ctx, log, "log.move", events.LogMoved, bson.M{
+		"vaultId":   vault.ID,
+		"path":      path.Join(vault.Path, log.Name),
+		"updatedAt": time.Now(),
+	})
+}
+
+// saveLog sets fields on a log at its loaded version
+func (b *batchRun) saveLog(ctx context.Context, log models.Log, action, eventType string, set bson.M) (api.BatchResult, *apierror.Error) {
+	collection := db.Database.Collection("logs")
+
+	result, err := collection.UpdateOne(ctx, versionFilter(log.ID, log.Version), bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to update log")
+	}
+	if result.MatchedCount == 0 {
+		return api.BatchResult{}, writeMissedError(ctx, collection, log.ID, "Log not found")
+	}
+
+	var updated models.Log
+	collection.FindOne(ctx, bson.M{"_id": log.ID}).Decode(&updated)
+
+	b.audit(action, log.SpaceID, "log", log.ID, logSnapshot(log), logSnapshot(updated))
+	b.announce(events.ForLog(eventType, updated))
+	return api.BatchResult{Status: http.StatusOK, ID: log.ID.Hex(), Data: updated}, nil
+}
+
+func (b *batchRun) deleteLog(ctx context.Context, op api.BatchOperation) (api.BatchResult, *apierror.Error) {
+	log, err := b.loadLog(ctx, op.ID)
+	if err != nil {
+		return api.BatchResult{}, err
+	}
+	if err := checkIfVersion(op, log.Version); err != nil {
+		return api.BatchResult{}, err
+	}
+
+	collection := db.Database.Collection("logs")
+	result, deleteErr := collection.DeleteOne(ctx, versionFilter(log.ID, log.Version))
+	if deleteErr != nil {
+		return api.BatchResult{}, apierror.New(apierror.Internal, "Failed to delete log")
+	}
+	if result.DeletedCount == 0 {
+		return api.BatchResult{}, writeMissedError(ctx, collection, log.ID, "Log not found")
+	}
+
+	b.audit("log.delete", log.SpaceID, "log", log.ID, logSnapshot(log), nil)
+	b.announce(events.Deleted(events.LogDeleted, log.SpaceID, log.ID))
+	return api.BatchResult{Status: http.StatusOK, ID: log.ID.Hex()}, nil
+}
+
+// resolveID parses an ObjectID or a "$N" reference to an earlier create
+func (b *batchRun) resolveID(ref, kind string) (primitive.ObjectID, *apierror.Error) {
+	if strings.HasPrefix(ref, "$") {
+		index, err := strconv.Atoi(ref[1:])
+		if id, ok := b.created[index]; err == nil && ok {
+			return id, nil
+		}
+		return primitive.NilObjectID, apierror.New(apierror.InvalidRequest, ref+" doesn't refer to an earlier create operation")
+	}
+
+	id, err := primitive.ObjectIDFromHex(ref)
+	if err != nil {
+		return primitive.NilObjectID, apierror.New(apierror.InvalidID, "Invalid "+kind+" ID")
+	}
+	return id, nil
+}
+
+// authorize checks the caller can edit a space, remembering roles for the rest of the batch
+func (b *batchRun) authorize(ctx context.Context, spaceID primitive.ObjectID, notFound string) *apierror.Error {
+	role, ok := b.roles[spaceID]
+	if !ok {
+		var err error
+		if role, err = spaceRole(ctx, spaceID, b.userID); err != nil {
+			return apierror.New(apierror.Internal, "Failed to check space access")
+		}
+		b.roles[spaceID] = role
+	}
+
+	if role == "" {
+		return apierror.New(apierror.NotFound, notFound)
+	}
+	if roleRanks[role] < roleRanks[RoleEditor] {
+		return apierror.New(apierror.Forbidden, "This action requires the "+RoleEditor+" role in the space")
+	}
+	return nil
+}
+
+// loadVault is the batch counterpart of loadVault, requiring the editor role
+func (b *batchRun) loadVault(ctx context.Context, ref string) (models.Vault, *apierror.Error) {
+	var vault models.Vault
+	id, apiErr := b.resolveID(ref, "vault")
+	if apiErr != nil {
+		return vault, apiErr
+	}
+
+	if err := db.Database.Collection("vaults").FindOne(ctx, bson.M{"_id": id}).Decode(&vault); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return vault, apierror.New(apierror.NotFound, "Vault not found")
+		}
+		return vault, apierror.New(apierror.Internal, "Failed to fetch vault")
+	}
+	return vault, b.authorize(ctx, vault.SpaceID, "Vault not found")
+}
+
+// loadLog is the batch counterpart of loadLog, requiring the editor role
+func (b *batchRun) loadLog(ctx context.Context, ref string) (models.Log, *apierror.Error) {
+	var log models.Log
+	id, apiErr := b.resolveID(ref, "log")
+	if apiErr != nil {
+		return log, apiErr
+	}
+
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": id}).Decode(&log); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return log, apierror.New(apierror.NotFound, "Log not found")
+		}
+		return log, apierror.New(apierror.Internal, "Failed to fetch log")
+	}
+	return log, b.authorize(ctx, log.SpaceID, "Log not found")
+}
+
+// audit queues an audit entry, recorded once the batch has been applied
+func (b *batchRun) audit(action string, spaceID primitive.ObjectID, targetType string, targetID primitive.ObjectID, before, after auditSnapshot) {
+	b.audits = append(b.audits, models.AuditEntry{
+		Action:     action,
+		SpaceID:    &spaceID,
+		TargetType: targetType,
+		TargetID:   targetID.Hex(),
+		Before:     before,
+		After:      after,
+	})
+}
+
+// announce queues change events, published once the batch has been applied
+func (b *batchRun) announce(changes ...events.Event) {
+	b.changes = append(b.changes, changes...)
+}
+
+// checkIfVersion fails an operation whose ifVersion is stale
+func checkIfVersion(op api.BatchOperation, current int64) *apierror.Error {
+	if op.IfVersion == nil || *op.IfVersion == current {
+		return nil
+	}
+	return preconditionFailedError(current)
+}
@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultChatContextTokens = 4000
+	chatTitleLength          = 60
+)
+
+// CreateChatSession creates a new AI chat session for a space or log
+func CreateChatSession(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var req api.CreateChatSessionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session := models.ChatSession{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Title:     req.Title,
+		Messages:  []models.ChatMessage{},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	switch {
+	case req.LogID != "":
+		logID, err := primitive.ObjectIDFromHex(req.LogID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+			return
+		}
+
+		log, ok := loadLog(c, logID, RoleViewer)
+		if !ok {
+			return
+		}
+		session.SpaceID = log.SpaceID
+		session.LogID = &log.ID
+
+	case req.SpaceID != "":
+		spaceID, err := primitive.ObjectIDFromHex(req.SpaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+
+		if !authorizeSpace(c, spaceID, RoleViewer, "Space not found") {
+			return
+		}
+		session.SpaceID = spaceID
+
+	default:
+		apierror.Abort(c, apierror.InvalidRequest, "spaceId or logId required")
+		return
+	}
+
+	_, err := db.Database.Collection("chat_sessions").InsertOne(ctx, session)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create chat session")
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// GetChatSessions lists chat sessions without their messages
+func GetChatSessions(c *gin.Context) {
+	userID := currentUserID(c)
+
+	filter := bson.M{"userId": userID}
+
+	if spaceID := c.Query("spaceId"); spaceID != "" {
+		objectID, err := primitive.ObjectIDFromHex(spaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+		filter["spaceId"] = objectID
+	}
+
+	if logID := c.Query("logId"); logID != "" {
+		objectID, err := primitive.ObjectIDFromHex(logID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+			return
+		}
+		filter["logId"] = objectID
+	}
+
+	collection := db.Database.Collection("chat_sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetProjection(bson.M{"messages": 0}).
+		SetSort(bson.M{"updatedAt": -1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch chat sessions")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.ChatSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode chat sessions")
+		return
+	}
+
+	if sessions == nil {
+		sessions = []models.ChatSession{}
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// GetChatSession retrieves a chat session with its full message history
+func GetChatSession(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid chat session ID")
+		return
+	}
+
+	collection := db.Database.Collection("chat_sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.ChatSession
+	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&session)
+	if err != nil {
+		apierror.Abort(c, apierror.NotFound, "Chat session not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// PostChatMessage sends a message in a chat session and stores the assistant reply
+func PostChatMessage(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid chat session ID")
+		return
+	}
+
+	var req api.ChatMessageRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	collection := db.Database.Collection("chat_sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.ChatSession
+	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&session)
+	if err != nil {
+		apierror.Abort(c, apierror.NotFound, "Chat session not found")
+		return
+	}
+
+	// Members who lost access to the space can no longer continue its chats
+	if !authorizeSpace(c, session.SpaceID, RoleViewer, "Chat session not found") {
+		return
+	}
+
+	call := aiCall{UserID: userID, SpaceID: &session.SpaceID, Mode: "chat"}
+	if !enforceAIBudget(c, call) {
+		return
+	}
+
+	// Attach the current contents of the linked log, if it still exists
+	var log *models.Log
+	if session.LogID != nil {
+		var current models.Log
+		if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": *session.LogID}).Decode(&current); err == nil {
+			log = &current
+		}
+	}
+
+	userMessage := models.ChatMessage{
+		Role:      "user",
+		Content:   req.Content,
+		CreatedAt: time.Now(),
+	}
+
+	systemPrompt := buildChatSystemPrompt(log)
+	history := append(session.Messages, userMessage)
+	messages := trimChatHistory(history, chatContextBudget()-estimateTokens(systemPrompt))
+
+	output, provider, err := completeWithFallback(call, systemPrompt, messages, false)
+	if err != nil {
+		respondProviderError(c, err)
+		return
+	}
+
+	reply := models.ChatMessage{
+		Role:      "assistant",
+		Content:   strings.TrimSpace(output),
+		Provider:  provider,
+		CreatedAt: time.Now(),
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if session.Title == "" {
+		set["title"] = chatTitleFrom(req.Content)
+	}
+
+	// The provider call can outlast the read timeout, so use a fresh context for the write
+	writeCtx, writeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer writeCancel()
+
+	update := bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": []models.ChatMessage{userMessage, reply}}},
+		"$set":  set,
+	}
+	_, err = collection.UpdateOne(writeCtx, bson.M{"_id": objectID, "userId": userID}, update)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to save chat messages")
+		return
+	}
+
+	auditAI(c, call, "chat", id, auditSnapshot{"provider": provider})
+
+	c.JSON(http.StatusCreated, reply)
+}
+
+// DeleteChatSession deletes a chat session
+func DeleteChatSession(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid chat session ID")
+		return
+	}
+
+	collection := db.Database.Collection("chat_sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectID, "userId": userID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete chat session")
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		apierror.Abort(c, apierror.NotFound, "Chat session not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Chat session deleted successfully"})
+}
+
+// buildChatSystemPrompt builds the system prompt for a chat, including the linked log if any
+func buildChatSystemPrompt(log *models.Log) string {
+	prompt := `You are a pair-programming assistant helping the user iterate on a solution.
+Answer concisely. When you propose code, give the complete updated code in a single fenced block.`
+
+	if log != nil {
+		prompt += "\n\nThe conversation is about this file:\n" + buildLogUserPrompt(*log, "")
+	}
+
+	return prompt
+}
+
+// trimChatHistory keeps the most recent messages that fit in the token budget.
+// The latest message is always kept so the provider has something to answer.
+func trimChatHistory(history []models.ChatMessage, budget int) []OpenAIMessage {
+	start := len(history) - 1
+	used := estimateTokens(history[start].Content)
+
+	for start > 0 {
+		cost := estimateTokens(history[start-1].Content)
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start--
+	}
+
+	// Conversations must start with a user turn
+	for start < len(history)-1 && history[start].Role != "user" {
+		start++
+	}
+
+	messages := make([]OpenAIMessage, 0, len(history)-start)
+	for _, m := range history[start:] {
+		messages = append(messages, OpenAIMessage{Role: m.Role, Content: m.Content})
+	}
+	return messages
+}
+
+// chatContextBudget returns the token budget for chat history from AI_CHAT_CONTEXT_TOKENS
+func chatContextBudget() int {
+	if tokensStr := os.Getenv("AI_CHAT_CONTEXT_TOKENS"); tokensStr != "" {
+		if parsed, err := strconv.Atoi(tokensStr); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	return defaultChatContextTokens
+}
+
+// estimateTokens approximates the token count of text (roughly four characters per token)
+func estimateTokens(text string) int {
+	return len(text)/4 + 1
+}
+
+// chatTitleFrom derives a session title from the first user message
+func chatTitleFrom(content string) string {
+	title := strings.TrimSpace(strings.SplitN(strings.TrimSpace(content), "\n", 2)[0])
+	if runes := []rune(title); len(runes) > chatTitleLength {
+		title = strings.TrimSpace(string(runes[:chatTitleLength])) + "..."
+	}
+	return title
+}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/websocket"
+)
+
+// Client message types
+const (
+	CollabJoinRequest   = "join"   // First message: sessionId and revision to resume, or neither
+	CollabOpRequest     = "op"     // An operation against revision, with a unique opId
+	CollabCursorRequest = "cursor" // The client's cursor moved
+	CollabSaveRequest   = "save"   // Write the document to the log now instead of on the next interval
+)
+
+// Server message types
+const (
+	CollabInit        = "init"   // The whole document at revision, for a new or unresumable client
+	CollabResume      = "resume" // Resuming at the client's revision; the ops it missed follow
+	CollabAck         = "ack"    // The client's opId was applied as revision
+	CollabOp          = "op"     // Another client's operation, or an outside change without clientId
+	CollabCursorMoved = "cursor" // A peer's cursor moved
+	CollabJoin        = "join"   // A peer joined, or its details changed
+	CollabLeave       = "leave"  // A peer left
+	CollabSaved       = "saved"  // The document up to revision was saved as the log's version
+	CollabError       = "error"  // A request failed; the connection stays open unless the log is gone
+)
+
+// CollaborateOnLog upgrades to a WebSocket for editing a log together with
+// everyone else who has it open. Messages are CollabRequest and CollabEvent
+// JSON objects; operations use the ot.js format. The document is written back
+// to the log every few seconds and when the last client leaves, and outside
+// changes to the log are merged in. Viewers can follow along but not edit.
+func CollaborateOnLog(c *gin.Context) {
+	logID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+		return
+	}
+
+	current, ok := loadLog(c, logID, RoleViewer)
+	if !ok {
+		return
+	}
+
+	if !isWebSocketUpgrade(c.Request) {
+		apierror.Abort(c, apierror.InvalidRequest, "This endpoint requires a WebSocket upgrade")
+		return
+	}
+
+	userID := currentUserID(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	role, err := spaceRole(ctx, current.SpaceID, userID)
+	name := collabUserName(ctx, userID)
+	cancel()
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to check access")
+		return
+	}
+
+	clientID, err := generateShareToken()
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to start session")
+		return
+	}
+
+	canWrite := middleware.HasScope(c, auth.ScopeWrite)
+	token := middleware.RequestToken(c)
+	client := &collabClient{
+		peer: api.CollabPeer{ClientID: clientID, UserID: userID, Name: name, CanEdit: canWrite && roleRanks[role] >= roleRanks[RoleEditor]},
+		send: make(chan api.CollabEvent, collabClientBuffer),
+	}
+
+	serveWebSocket(c, func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = maxPatchBodyBytes
+
+		ws.SetReadDeadline(time.Now().Add(feedWriteTimeout))
+		var join api.CollabRequest
+		if err := websocket.JSON.Receive(ws, &join); err != nil || join.Type != CollabJoinRequest {
+			websocket.JSON.Send(ws, api.CollabEvent{Type: CollabError, Error: apierror.New(apierror.InvalidRequest, "The first message must be a join")})
+			return
+		}
+		ws.SetReadDeadline(time.Time{})
+
+		doc, joined := joinCollabDocument(logID, client, join)
+		if !joined {
+			websocket.JSON.Send(ws, api.CollabEvent{Type: CollabError, Error: apierror.New(apierror.NotFound, "Log not found")})
+			return
+		}
+		defer func() {
+			doc.leave(client)
+			if client.ops > 0 {
+				recordAudit(c, models.AuditEntry{
+					Action:     "log.collaborate",
+					SpaceID:    &current.SpaceID,
+					TargetType: "log",
+					TargetID:   logID.Hex(),
+					After:      auditSnapshot{"operations": client.ops},
+				})
+			}
+		}()
+
+		go writeCollabEvents(ws, client.send)
+		go recheckCollabAccess(ws, doc, client, current.SpaceID, canWrite, token)
+
+		for {
+			var request api.CollabRequest
+			if err := websocket.JSON.Receive(ws, &request); err != nil {
+				return
+			}
+
+			var apiErr *apierror.Error
+			switch request.Type {
+			case CollabOpRequest:
+				if request.Op == nil {
+					apiErr = apierror.New(apierror.InvalidRequest, "op is required")
+				} else {
+					apiErr = doc.apply(client, request.Revision, request.OpID, *request.Op)
+				}
+			case CollabCursorRequest:
+				if request.Cursor != nil {
+					doc.moveCursor(client, *request.Cursor)
+				}
+			case CollabSaveRequest:
+				signal(doc.saveNow)
+			default:
+				apiErr = apierror.New(apierror.InvalidRequest, "Unknown message type "+request.Type)
+			}
+
+			if apiErr != nil {
+				doc.mu.Lock()
+				client.deliver(api.CollabEvent{Type: CollabError, OpID: request.OpID, Error: apiErr})
+				doc.mu.Unlock()
+			}
+		}
+	})
+}
+
+// joinCollabDocument adds the client to the log's document, opening it again
+// if it closed in between
+func joinCollabDocument(logID primitive.ObjectID, client *collabClient, join api.CollabRequest) (*collabDocument, bool) {
+	for attempt := 0; attempt < 3; attempt++ {
+		doc, err := openCollabDocument(logID)
+		if err != nil {
+			return nil, false
+		}
+		if doc.join(client, join.SessionID, join.Revision) {
+			return doc, true
+		}
+	}
+	return nil, false
+}
+
+// writeCollabEvents sends queued events until the client is dropped, then closes the connection
+func writeCollabEvents(ws *websocket.Conn, send <-chan api.CollabEvent) {
+	defer ws.Close()
+	for event := range send {
+		ws.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		if err := websocket.JSON.Send(ws, event); err != nil {
+			return
+		}
+	}
+}
+
+// recheckCollabAccess disconnects a client whose session or token ends or that
+// loses access to the space, and stops it editing when it's demoted to viewer
+func recheckCollabAccess(ws *websocket.Conn, doc *collabDocument, client *collabClient, spaceID primitive.ObjectID, canWrite bool, token string) {
+	ticker := time.NewTicker(feedAccessCheckInterval)
+	defer ticker.Stop()
+
+	userID := client.peer.UserID
+	for range ticker.C {
+		if doc.hasLeft(client) {
+			return
+		}
+
+		role, err := recheckAccess(token, spaceID, userID)
+		if err != nil {
+			continue
+		}
+		if role == "" {
+			ws.Close()
+			return
+		}
+		doc.setCanEdit(client, canWrite && roleRanks[role] >= roleRanks[RoleEditor])
+	}
+}
+
+// hasLeft reports whether the client is no longer connected to the document
+func (d *collabDocument) hasLeft(client *collabClient) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return client.gone
+}
+
+// collabUserName is the name shown to other editors
+func collabUserName(ctx context.Context, userID string) string {
+	if userID == middleware.LegacyAdminUserID {
+		return "Admin"
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return userID
+	}
+
+	var user models.User
+	if err := db.Database.Collection("users").FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		return userID
+	}
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Email
+}
@@ -0,0 +1,576 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/collab"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collabHistoryLimit is how many operations a document keeps for resuming clients
+	collabHistoryLimit = 1000
+
+	// collabClientBuffer is how many messages a client may fall behind before it's dropped
+	collabClientBuffer = 256
+)
+
+// errCollabConflict means the log changed in the database since the document last saw it
+var errCollabConflict = errors.New("log changed since it was loaded")
+
+// collabDocuments holds the live document of every log being edited
+var collabDocuments = struct {
+	sync.Mutex
+	byLog map[primitive.ObjectID]*collabDocument
+}{byLog: map[primitive.ObjectID]*collabDocument{}}
+
+// collabSaveInterval is how often live documents are written back to their log
+func collabSaveInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("COLLAB_SAVE_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Second
+}
+
+// collabDocument is the shared state of one log while clients edit it. Clients
+// send operations against a revision; the document transforms them past the
+// operations applied since, applies them and relays them to everyone else.
+//
+// The text in the database lags behind: saved holds the code and version last
+// read or written, and unsaved is the operation from it to the current text.
+// When someone changes the log outside the session, their change is merged by
+// transforming it against unsaved, so neither side's edits are lost.
+type collabDocument struct {
+	mu sync.Mutex
+
+	logID     primitive.ObjectID
+	spaceID   primitive.ObjectID
+	sessionID string
+	closed    bool
+
+	text         []uint16
+	revision     int
+	history      []collabHistoryEntry // Operations after revision historyStart
+	historyStart int
+	clients      map[string]*collabClient
+
+	savedCode    string
+	savedVersion int64
+	unsaved      collab.Operation
+	saving       bool // History isn't trimmed while a save is writing a snapshot
+
+	saveNow chan struct{}
+	idle    chan struct{}
+}
+
+type collabHistoryEntry struct {
+	op       collab.Operation
+	clientID string // Empty for changes merged from outside the session
+	opID     string
+}
+
+// collabClient is one connection to a document
+type collabClient struct {
+	peer api.CollabPeer
+	send chan api.CollabEvent
+	gone bool
+	ops  int
+}
+
+// openCollabDocument returns the live document for a log, loading it if nobody is editing it yet
+func openCollabDocument(logID primitive.ObjectID) (*collabDocument, error) {
+	collabDocuments.Lock()
+	doc, ok := collabDocuments.byLog[logID]
+	collabDocuments.Unlock()
+	if ok && !doc.isClosed() {
+		return doc, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var current models.Log
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": logID}).Decode(&current); err != nil {
+		return nil, err
+	}
+
+	sessionID, err := generateShareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	text := utf16.Encode([]rune(current.Code))
+	doc = &collabDocument{
+		logID:        logID,
+		spaceID:      current.SpaceID,
+		sessionID:    sessionID,
+		text:         text,
+		clients:      map[string]*collabClient{},
+		savedCode:    current.Code,
+		savedVersion: current.Version,
+		unsaved:      collab.Identity(len(text)),
+		saveNow:      make(chan struct{}, 1),
+		idle:         make(chan struct{}, 1),
+	}
+
+	collabDocuments.Lock()
+	defer collabDocuments.Unlock()
+	if existing, ok := collabDocuments.byLog[logID]; ok && !existing.isClosed() {
+		// Someone else opened it first
+		return existing, nil
+	}
+	collabDocuments.byLog[logID] = doc
+	go doc.run()
+	return doc, nil
+}
+
+// join adds a client and sends it the document and everyone connected,
+// itself included. A client that was connected to the same session at a
+// revision still in the history resumes from there; anyone else starts from
+// the current text.
+func (d *collabDocument) join(client *collabClient, sessionID string, revision int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	d.clients[client.peer.ClientID] = client
+
+	if sessionID == d.sessionID && revision >= d.historyStart && revision <= d.revision {
+		client.deliver(api.CollabEvent{Type: CollabResume, SessionID: d.sessionID, Revision: revision, ClientID: client.peer.ClientID, Version: d.savedVersion, Peers: d.peers()})
+		for i, entry := range d.history[revision-d.historyStart:] {
+			op := entry.op
+			client.deliver(api.CollabEvent{Type: CollabOp, Revision: revision + i + 1, ClientID: entry.clientID, OpID: entry.opID, Op: &op})
+		}
+	} else {
+		code := string(utf16.Decode(d.text))
+		client.deliver(api.CollabEvent{Type: CollabInit, SessionID: d.sessionID, Revision: d.revision, ClientID: client.peer.ClientID, Version: d.savedVersion, Code: &code, Peers: d.peers()})
+	}
+
+	peer := client.peer
+	d.broadcastExcept(client, api.CollabEvent{Type: CollabJoin, ClientID: peer.ClientID, Peer: &peer})
+	return true
+}
+
+// leave removes a client, saving and closing the document after the last one
+func (d *collabDocument) leave(client *collabClient) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.clients[client.peer.ClientID]; !ok {
+		return
+	}
+
+	delete(d.clients, client.peer.ClientID)
+	client.drop()
+	d.broadcast(api.CollabEvent{Type: CollabLeave, ClientID: client.peer.ClientID})
+
+	if len(d.clients) == 0 {
+		signal(d.idle)
+	}
+}
+
+// apply transforms a client's operation against the ones it hadn't seen,
+// applies it and relays it. A repeated opId, from a client resending after a
+// reconnect, is acknowledged without being applied again.
+func (d *collabDocument) apply(client *collabClient, revision int, opID string, op collab.Operation) *apierror.Error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !client.peer.CanEdit {
+		return apierror.New(apierror.Forbidden, "You can't edit this log")
+	}
+
+	if opID != "" {
+		for i, entry := range d.history {
+			if entry.opID == opID {
+				client.deliver(api.CollabEvent{Type: CollabAck, Revision: d.historyStart + i + 1, OpID: opID})
+				return nil
+			}
+		}
+	}
+
+	if revision < d.historyStart || revision > d.revision {
+		return apierror.New(apierror.PreconditionFailed, "Revision is no longer available; join again").
+			WithDetails(map[string]int{"revision": d.revision})
+	}
+
+	for _, entry := range d.history[revision-d.historyStart:] {
+		var err error
+		if op, _, err = collab.Transform(op, entry.op); err != nil {
+			return apierror.New(apierror.InvalidRequest, "Operation doesn't match revision "+fmt.Sprint(revision))
+		}
+	}
+
+	text, err := op.Apply(d.text)
+	if err != nil {
+		return apierror.New(apierror.InvalidRequest, "Invalid operation: "+err.Error())
+	}
+
+	logQuota := api.QuotaUsage{Name: QuotaLogBytes, Scope: "log", Limit: currentQuotaLimits().LogBytes}
+	if size := utf16Bytes(text); size > utf16Bytes(d.text) && logQuota.Exceeded(size) {
+		return quotaExceededError(logQuota)
+	}
+
+	unsaved, err := collab.Compose(d.unsaved, op)
+	if err != nil {
+		return apierror.New(apierror.Internal, "Failed to apply operation")
+	}
+
+	d.text = text
+	d.unsaved = unsaved
+	d.record(op, client.peer.ClientID, opID)
+	client.ops++
+
+	client.deliver(api.CollabEvent{Type: CollabAck, Revision: d.revision, OpID: opID})
+	d.broadcastExcept(client, api.CollabEvent{Type: CollabOp, Revision: d.revision, ClientID: client.peer.ClientID, OpID: opID, Op: &op})
+	return nil
+}
+
+// moveCursor records a client's cursor and shows it to the others
+func (d *collabDocument) moveCursor(client *collabClient, cursor api.CollabCursor) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cursor.Position = clamp(cursor.Position, 0, len(d.text))
+	cursor.SelectionEnd = clamp(cursor.SelectionEnd, 0, len(d.text))
+	client.peer.Cursor = &cursor
+	d.broadcastExcept(client, api.CollabEvent{Type: CollabCursorMoved, ClientID: client.peer.ClientID, Cursor: &cursor})
+}
+
+// setCanEdit updates whether a client may send operations after its role changes, and tells everyone
+func (d *collabDocument) setCanEdit(client *collabClient, canEdit bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if client.peer.CanEdit != canEdit {
+		client.peer.CanEdit = canEdit
+		peer := client.peer
+		d.broadcast(api.CollabEvent{Type: CollabJoin, ClientID: peer.ClientID, Peer: &peer})
+	}
+}
+
+// record appends an applied operation to the history and moves every cursor past it
+func (d *collabDocument) record(op collab.Operation, clientID, opID string) {
+	d.history = append(d.history, collabHistoryEntry{op: op, clientID: clientID, opID: opID})
+	d.revision++
+
+	if excess := len(d.history) - collabHistoryLimit; excess > 0 && !d.saving {
+		d.history = append([]collabHistoryEntry(nil), d.history[excess:]...)
+		d.historyStart += excess
+	}
+
+	for _, client := range d.clients {
+		if cursor := client.peer.Cursor; cursor != nil {
+			cursor.Position = collab.TransformIndex(op, cursor.Position)
+			cursor.SelectionEnd = collab.TransformIndex(op, cursor.SelectionEnd)
+		}
+	}
+}
+
+func (d *collabDocument) peers() []api.CollabPeer {
+	peers := make([]api.CollabPeer, 0, len(d.clients))
+	for _, client := range d.clients {
+		peers = append(peers, client.peer)
+	}
+	return peers
+}
+
+func (d *collabDocument) broadcast(event api.CollabEvent) {
+	d.broadcastExcept(nil, event)
+}
+
+func (d *collabDocument) broadcastExcept(except *collabClient, event api.CollabEvent) {
+	for _, client := range d.clients {
+		if client != except {
+			client.deliver(event)
+		}
+	}
+}
+
+// deliver queues an event for the client, dropping a client that has stopped
+// reading; it reconnects and resumes. Callers hold the document's lock.
+func (c *collabClient) deliver(event api.CollabEvent) {
+	if c.gone {
+		return
+	}
+	select {
+	case c.send <- event:
+	default:
+		c.drop()
+	}
+}
+
+// drop ends the client's connection once its queued events are written
+func (c *collabClient) drop() {
+	if !c.gone {
+		c.gone = true
+		close(c.send)
+	}
+}
+
+// run saves the document periodically, on request and when the last client
+// leaves, and merges changes made to the log outside the session
+func (d *collabDocument) run() {
+	sub := events.Subscribe(d.spaceID)
+	defer func() { sub.Close() }()
+
+	ticker := time.NewTicker(collabSaveInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.save(false)
+
+		case <-d.saveNow:
+			d.save(true)
+
+		case <-d.idle:
+			d.save(false)
+			if d.closeIfIdle() {
+				return
+			}
+
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Dropped for falling behind; catch up from the database
+				sub = events.Subscribe(d.spaceID)
+				d.refresh()
+				continue
+			}
+			switch {
+			case event.Type == events.SpaceDeleted || event.Type == events.LogDeleted && event.ID == d.logID:
+				d.close(apierror.New(apierror.NotFound, "Log was deleted"))
+			case event.Type == events.LogUpdated && event.ID == d.logID && event.Version > d.currentSavedVersion():
+				d.refresh()
+			}
+		}
+	}
+}
+
+// save writes the current text to the log if it has changed, merging and
+// retrying when the log was changed elsewhere in the meantime. A requested
+// save reports saved even when there was nothing to write.
+func (d *collabDocument) save(requested bool) {
+	for attempt := 0; attempt < 3; attempt++ {
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			return
+		}
+		if d.unsaved.IsNoop() {
+			if requested {
+				d.broadcast(api.CollabEvent{Type: CollabSaved, Revision: d.revision, Version: d.savedVersion})
+			}
+			d.mu.Unlock()
+			return
+		}
+		code := string(utf16.Decode(d.text))
+		revision, oldBytes, version := d.revision, len(d.savedCode), d.savedVersion
+		d.saving = true
+		d.mu.Unlock()
+
+		saved, err := d.write(code, oldBytes, version)
+
+		d.mu.Lock()
+		d.saving = false
+		var apiErr *apierror.Error
+		switch {
+		case err == nil:
+			d.savedCode = code
+			d.savedVersion = saved.Version
+			d.unsaved = d.composeSince(revision)
+			d.broadcast(api.CollabEvent{Type: CollabSaved, Revision: revision, Version: saved.Version})
+			d.mu.Unlock()
+			events.Publish(events.ForLog(events.LogUpdated, saved))
+			return
+
+		case errors.Is(err, errCollabConflict):
+			d.mu.Unlock()
+			d.refresh()
+			continue
+
+		case errors.Is(err, mongo.ErrNoDocuments):
+			d.mu.Unlock()
+			d.close(apierror.New(apierror.NotFound, "Log was deleted"))
+			return
+
+		case errors.As(err, &apiErr):
+			d.broadcast(api.CollabEvent{Type: CollabError, Error: apiErr})
+			d.mu.Unlock()
+			return
+
+		default:
+			d.mu.Unlock()
+			log.Printf("Failed to save collaborative edits to log %s: %v", d.logID.Hex(), err)
+			return
+		}
+	}
+}
+
+// write stores code as the log's code if the log is still at version
+func (d *collabDocument) write(code string, oldBytes int, version int64) (models.Log, error) {
+	var saved models.Log
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if apiErr := checkCodeSizeQuota(ctx, d.spaceID, oldBytes, len(code)); apiErr != nil {
+		return saved, apiErr
+	}
+
+	collection := db.Database.Collection("logs")
+	update := bson.M{
+		"$set": bson.M{"code": code, "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, versionFilter(d.logID, version), update, opts).Decode(&saved)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if count, countErr := collection.CountDocuments(ctx, bson.M{"_id": d.logID}); countErr == nil && count > 0 {
+			return saved, errCollabConflict
+		}
+	}
+	return saved, err
+}
+
+// refresh merges a change to the log made outside the session. The outside
+// change is a diff from the last saved code; it's transformed against the
+// unsaved edits and applied like any other operation.
+func (d *collabDocument) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var current models.Log
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": d.logID}).Decode(&current); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			d.close(apierror.New(apierror.NotFound, "Log was deleted"))
+		}
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || current.Version == d.savedVersion {
+		return
+	}
+
+	outside := collab.Diff(utf16.Encode([]rune(d.savedCode)), utf16.Encode([]rune(current.Code)))
+	op, unsaved, err := collab.Transform(outside, d.unsaved)
+	if err == nil {
+		var text []uint16
+		if text, err = op.Apply(d.text); err == nil {
+			d.text = text
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to merge outside change to log %s: %v", d.logID.Hex(), err)
+		return
+	}
+
+	d.savedCode = current.Code
+	d.savedVersion = current.Version
+	d.unsaved = unsaved
+	if !op.IsNoop() {
+		d.record(op, "", "")
+		d.broadcast(api.CollabEvent{Type: CollabOp, Revision: d.revision, Op: &op})
+	}
+}
+
+// composeSince combines the operations applied after revision into one
+func (d *collabDocument) composeSince(revision int) collab.Operation {
+	combined := collab.Identity(len(d.text))
+	entries := d.history[revision-d.historyStart:]
+	if len(entries) > 0 {
+		combined = collab.Identity(entries[0].op.BaseLength())
+	}
+	for _, entry := range entries {
+		combined, _ = collab.Compose(combined, entry.op)
+	}
+	return combined
+}
+
+func (d *collabDocument) currentSavedVersion() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.savedVersion
+}
+
+// close sends reason to every client and disconnects them
+func (d *collabDocument) close(reason *apierror.Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	d.broadcast(api.CollabEvent{Type: CollabError, Error: reason})
+	for id, client := range d.clients {
+		client.drop()
+		delete(d.clients, id)
+	}
+	signal(d.idle)
+}
+
+func (d *collabDocument) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+// closeIfIdle forgets the document if nobody joined while it was saving
+func (d *collabDocument) closeIfIdle() bool {
+	collabDocuments.Lock()
+	defer collabDocuments.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.clients) > 0 {
+		return false
+	}
+	if !d.closed && !d.unsaved.IsNoop() {
+		log.Printf("Discarding unsaved collaborative edits to log %s", d.logID.Hex())
+	}
+	d.closed = true
+	if collabDocuments.byLog[d.logID] == d {
+		delete(collabDocuments.byLog, d.logID)
+	}
+	return true
+}
+
+// signal wakes a goroutine waiting on ch without blocking
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// utf16Bytes is the UTF-8 size of UTF-16 text, which quotas are measured in
+func utf16Bytes(text []uint16) int {
+	size := 0
+	for _, r := range utf16.Decode(text) {
+		size += utf8.RuneLen(r)
+	}
+	return size
+}
+
+func clamp(n, low, high int) int {
+	return max(low, min(n, high))
+}
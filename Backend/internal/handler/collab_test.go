@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+	"unicode/utf16"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/collab"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// openTestDocument opens the live document for a new log holding code. Saves
+// only happen when a test asks for them.
+func openTestDocument(t *testing.T, code string) (*collabDocument, models.Log) {
+	t.Helper()
+	t.Setenv("COLLAB_SAVE_INTERVAL", "1h")
+	user, _ := createUser(t, "ada@example.com")
+	log := createLog(t, createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil), "main.py", code)
+
+	doc, err := openCollabDocument(log.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { doc.close(apierror.New(apierror.NotFound, "Test over")) })
+	return doc, log
+}
+
+func joinTestDocument(t *testing.T, doc *collabDocument, clientID, sessionID string, revision int) *collabClient {
+	t.Helper()
+	client := &collabClient{peer: api.CollabPeer{ClientID: clientID, CanEdit: true}, send: make(chan api.CollabEvent, collabClientBuffer)}
+	if !doc.join(client, sessionID, revision) {
+		t.Fatal("document is closed")
+	}
+	return client
+}
+
+func testOperation(t *testing.T, ops string) collab.Operation {
+	t.Helper()
+	var op collab.Operation
+	if err := json.Unmarshal([]byte(ops), &op); err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// received drains the events queued for a client
+func received(client *collabClient) []api.CollabEvent {
+	var events []api.CollabEvent
+	for {
+		select {
+		case event := <-client.send:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// replay applies the operations in events to text, as a client would
+func replay(t *testing.T, text string, events []api.CollabEvent) string {
+	t.Helper()
+	units := utf16.Encode([]rune(text))
+	for _, event := range events {
+		if event.Type != CollabOp {
+			continue
+		}
+		var err error
+		if units, err = event.Op.Apply(units); err != nil {
+			t.Fatalf("Failed to apply %s event at revision %d: %v", event.Type, event.Revision, err)
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func documentText(doc *collabDocument) string {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	return string(utf16.Decode(doc.text))
+}
+
+func TestCollabRebasesConcurrentOperations(t *testing.T) {
+	setupDB(t)
+	doc, _ := openTestDocument(t, "a😀b")
+	alice := joinTestDocument(t, doc, "alice", "", 0)
+	bob := joinTestDocument(t, doc, "bob", "", 0)
+	received(alice)
+	received(bob)
+
+	// Both edit revision 0; Bob's edit is rebased past Alice's
+	if err := doc.apply(alice, 0, "a1", testOperation(t, `[1, -2, "🎉", 1]`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.apply(bob, 0, "b1", testOperation(t, `[3, "c", 1]`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := documentText(doc); got != "a🎉cb" {
+		t.Fatalf("document = %q", got)
+	}
+
+	// Each client applies its own edit, then what it's sent. Bob transforms
+	// Alice's edit past his own pending one, as the editor does.
+	if got := replay(t, "a🎉b", received(alice)); got != "a🎉cb" {
+		t.Errorf("Alice sees %q", got)
+	}
+	bobEvents := received(bob)
+	if len(bobEvents) != 2 || bobEvents[0].Type != CollabOp || bobEvents[1].Type != CollabAck || bobEvents[1].Revision != 2 {
+		t.Fatalf("Bob got %+v", bobEvents)
+	}
+	_, aliceForBob, err := collab.Transform(testOperation(t, `[3, "c", 1]`), *bobEvents[0].Op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replay(t, "a😀cb", []api.CollabEvent{{Type: CollabOp, Op: &aliceForBob}}); got != "a🎉cb" {
+		t.Errorf("Bob sees %q", got)
+	}
+
+	// A resent operation is acknowledged again, not applied twice
+	if err := doc.apply(bob, 0, "b1", testOperation(t, `[3, "c", 1]`)); err != nil || documentText(doc) != "a🎉cb" {
+		t.Errorf("resending b1 gave %v, document %q", err, documentText(doc))
+	}
+	if events := received(bob); len(events) != 1 || events[0].Type != CollabAck || events[0].Revision != 2 {
+		t.Errorf("resending b1 sent %+v", events)
+	}
+
+	if err := doc.apply(alice, 0, "a2", testOperation(t, `[2, "x", 1]`)); err == nil || err.Code != apierror.InvalidRequest {
+		t.Errorf("an operation splitting the emoji gave %v", err)
+	}
+	if err := doc.apply(alice, 3, "a3", testOperation(t, `[6]`)); err == nil || err.Code != apierror.PreconditionFailed {
+		t.Errorf("an operation against a future revision gave %v", err)
+	}
+}
+
+func TestCollabResume(t *testing.T) {
+	setupDB(t)
+	doc, _ := openTestDocument(t, "one")
+	alice := joinTestDocument(t, doc, "alice", "", 0)
+	first := received(alice)[0]
+	if first.Type != CollabInit || *first.Code != "one" || first.Revision != 0 {
+		t.Fatalf("first event = %+v", first)
+	}
+
+	for i, op := range []string{`[3, " two"]`, `[7, " three"]`, `[-4, 9]`} {
+		if err := doc.apply(alice, i, "", testOperation(t, op)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Resuming from revision 1 replays the two operations since
+	bob := joinTestDocument(t, doc, "bob", first.SessionID, 1)
+	events := received(bob)
+	if events[0].Type != CollabResume || events[0].Revision != 1 || len(events) != 3 {
+		t.Fatalf("resume sent %+v", events)
+	}
+	if got := replay(t, "one two", events); got != "two three" {
+		t.Errorf("resumed document = %q", got)
+	}
+
+	doc.mu.Lock()
+	composed := doc.composeSince(1)
+	doc.mu.Unlock()
+	if got := replay(t, "one two", []api.CollabEvent{{Type: CollabOp, Op: &composed}}); got != "two three" {
+		t.Errorf("composeSince(1) gives %q", got)
+	}
+
+	// Another session, or a revision that never existed, starts from the text
+	for _, resume := range []struct {
+		sessionID string
+		revision  int
+	}{{"other", 1}, {first.SessionID, 4}} {
+		carol := joinTestDocument(t, doc, "carol", resume.sessionID, resume.revision)
+		if event := received(carol)[0]; event.Type != CollabInit || *event.Code != "two three" || event.Revision != 3 {
+			t.Errorf("joining with %+v sent %+v", resume, event)
+		}
+		doc.leave(carol)
+	}
+}
+
+func TestCollabSaveMergesConcurrentWrite(t *testing.T) {
+	setupDB(t)
+	doc, log := openTestDocument(t, "a = 1\nb = 2\n")
+	alice := joinTestDocument(t, doc, "alice", "", 0)
+	received(alice)
+
+	if err := doc.apply(alice, 0, "", testOperation(t, `[12, "c = 3\n"]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log is changed through the REST API before the session saves
+	ctx, cancel := testContext()
+	defer cancel()
+	_, err := db.Database.Collection("logs").UpdateOne(ctx, bson.M{"_id": log.ID},
+		bson.M{"$set": bson.M{"code": "a = 10\nb = 2\n"}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The save loses the version race, merges the outside change and saves again
+	doc.save(true)
+
+	var saved models.Log
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": log.ID}).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a = 10\nb = 2\nc = 3\n"; saved.Code != want || documentText(doc) != want {
+		t.Errorf("saved %q, document %q; want both edits", saved.Code, documentText(doc))
+	}
+	if saved.Version != log.Version+2 {
+		t.Errorf("version = %d, want %d", saved.Version, log.Version+2)
+	}
+
+	events := received(alice)
+	if got := replay(t, "a = 1\nb = 2\nc = 3\n", events); got != saved.Code {
+		t.Errorf("Alice sees %q", got)
+	}
+	if last := events[len(events)-1]; last.Type != CollabSaved || last.Version != saved.Version || last.Revision != 2 {
+		t.Errorf("last event = %+v", last)
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	if !doc.unsaved.IsNoop() || doc.savedVersion != saved.Version {
+		t.Errorf("after saving, unsaved = %v at version %d", doc.unsaved, doc.savedVersion)
+	}
+}
@@ -0,0 +1,315 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/db/dbtest"
+	"codeflow-backend/internal/handler"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/openapi"
+	"codeflow-backend/internal/ratelimit"
+	"codeflow-backend/internal/secrets"
+	"codeflow-backend/internal/server"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contract sends requests through the real router and checks every response
+// against the OpenAPI document
+type contract struct {
+	t      *testing.T
+	engine *gin.Engine
+	doc    openapi.Document
+	called map[string]bool // "METHOD path" of every operation exercised
+}
+
+// send makes a request to target, the Gin path with its parameters filled in,
+// and checks the response against the operation at path. A string body is
+// sent as is; headers are name, value pairs.
+func (c *contract) send(method, path, target, token string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	c.engine.ServeHTTP(w, req)
+	c.called[method+" "+path] = true
+
+	if c.doc.Paths != nil {
+		if err := c.doc.CheckResponse(method, path, w.Code, w.Header(), w.Body.Bytes()); err != nil {
+			c.t.Errorf("%s %s: %v; body %s", method, target, err, w.Body.String())
+		}
+	}
+	return w
+}
+
+// call is send for a request that must succeed
+func (c *contract) call(method, path, target, token string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	w := c.send(method, path, target, token, body, headers...)
+	if w.Code >= 400 {
+		c.t.Fatalf("%s %s = %d; body %s", method, target, w.Code, w.Body.String())
+	}
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+// TestOperationsMatchOpenAPI runs every documented operation through the
+// router and checks each response against its schema
+func TestOperationsMatchOpenAPI(t *testing.T) {
+	dbtest.Setup(t)
+	ratelimit.Default = ratelimit.NewMemoryStore()
+	handler.FakeUpstreams(t, "```python\nprint('hi')\n```")
+	t.Setenv("SECRETS_MASTER_KEY", strings.Repeat("ab", 32))
+	secrets.Init()
+
+	gin.DefaultWriter = io.Discard
+	engine, err := server.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &contract{t: t, engine: engine, called: map[string]bool{}}
+	c.doc = decode[openapi.Document](t, c.call("GET", "/api/openapi.json", "/api/openapi.json", "", nil))
+	c.call("GET", "/api/openapi.json", "/api/openapi.json", "", nil)
+	c.call("GET", "/health", "/health", "", nil)
+
+	// Accounts
+	owner := decode[api.SessionResponse](t, c.call("POST", "/api/auth/register", "/api/auth/register", "",
+		api.RegisterRequest{Email: "owner@example.com", Password: "password", Name: "Owner"})).Token
+	member := decode[api.SessionResponse](t, c.call("POST", "/api/auth/register", "/api/auth/register", "",
+		api.RegisterRequest{Email: "member@example.com", Password: "password"})).Token
+	invitee := decode[api.SessionResponse](t, c.call("POST", "/api/auth/register", "/api/auth/register", "",
+		api.RegisterRequest{Email: "invitee@example.com", Password: "password"})).Token
+	leaver := decode[api.SessionResponse](t, c.call("POST", "/api/auth/login", "/api/auth/login", "",
+		api.LoginRequest{Email: "member@example.com", Password: "password"})).Token
+	c.call("GET", "/api/auth/me", "/api/auth/me", owner, nil)
+
+	// Other tests may configure an identity provider; either way the responses are documented
+	c.send("GET", "/api/auth/oidc/login", "/api/auth/oidc/login?returnTo=/", "", nil)
+	c.send("GET", "/api/auth/oidc/callback", "/api/auth/oidc/callback?code=x&state=y", "", nil)
+
+	apiToken := decode[api.CreatedAPIToken](t, c.call("POST", "/api/tokens", "/api/tokens", owner,
+		api.CreateAPITokenRequest{Name: "ci", Scopes: []string{"read"}})).APIToken
+	c.call("GET", "/api/tokens", "/api/tokens", owner, nil)
+
+	// Spaces, vaults and logs
+	w := c.call("POST", "/api/spaces", "/api/spaces", owner, api.SpaceRequest{Name: "Space"})
+	space := decode[models.Space](t, w)
+	spacePath := "/api/spaces/" + space.ID.Hex()
+	c.call("GET", "/api/spaces", "/api/spaces?limit=1", owner, nil)
+	w = c.call("GET", "/api/spaces/:id", spacePath, owner, nil)
+	w = c.call("PUT", "/api/spaces/:id", spacePath, owner, api.SpaceRequest{Name: "Renamed"}, "If-Match", w.Header().Get("ETag"))
+	spaceETag := w.Header().Get("ETag")
+
+	vault := decode[models.Vault](t, c.call("POST", "/api/vaults", "/api/vaults", owner,
+		api.CreateVaultRequest{SpaceID: space.ID.Hex(), Name: "src"}))
+	vaultPath := "/api/vaults/" + vault.ID.Hex()
+	c.call("GET", "/api/vaults", "/api/vaults?spaceId="+space.ID.Hex(), owner, nil)
+	c.call("GET", "/api/vaults/:id", vaultPath, owner, nil)
+	c.call("PUT", "/api/vaults/:id", vaultPath, owner, api.UpdateVaultRequest{Name: "app"})
+
+	log := decode[models.Log](t, c.call("POST", "/api/logs", "/api/logs", owner,
+		api.CreateLogRequest{SpaceID: space.ID.Hex(), VaultID: vault.ID.Hex(), Name: "main.py", Code: "print(1)\n"}))
+	logPath := "/api/logs/" + log.ID.Hex()
+	c.call("GET", "/api/logs", "/api/logs?vaultId="+vault.ID.Hex(), owner, nil)
+	c.call("GET", "/api/logs/:id", logPath, owner, nil)
+	c.call("PUT", "/api/logs/:id", logPath, owner, api.UpdateLogRequest{Code: "print(2)\n"})
+	c.call("PATCH", "/api/logs/:id", logPath, owner, `{"code": "print(3)\n"}`)
+	c.call("GET", "/api/tree", "/api/tree?spaceId="+space.ID.Hex(), owner, nil)
+
+	name := "docs"
+	c.call("POST", "/api/batch", "/api/batch", owner, api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: "create", Type: "vault", SpaceID: space.ID.Hex(), Name: &name},
+	}})
+
+	c.call("GET", "/api/graphql", "/api/graphql?query="+url.QueryEscape("{ spaces { id name role } }"), owner, nil)
+	c.call("POST", "/api/graphql", "/api/graphql", owner, map[string]interface{}{
+		"query":     "query($id: ID!) { log(id: $id) { path code vault { name } } }",
+		"variables": map[string]string{"id": log.ID.Hex()},
+	})
+
+	// Streams answer with an error unless the request is a WebSocket upgrade
+	c.send("GET", "/api/spaces/:id/events", spacePath+"/events", owner, nil)
+	c.send("GET", "/api/logs/:id/collab", logPath+"/collab", owner, nil)
+
+	// Members and invitations
+	invite := func(email string) api.CreatedInvitation {
+		return decode[api.CreatedInvitation](t, c.call("POST", "/api/spaces/:id/invitations", spacePath+"/invitations", owner,
+			api.CreateSpaceInvitationRequest{Email: email, Role: "editor"}))
+	}
+	accepted, declined, revoked := invite("member@example.com"), invite("invitee@example.com"), invite("other@example.com")
+	c.call("GET", "/api/spaces/:id/invitations", spacePath+"/invitations", owner, nil)
+	c.call("POST", "/api/invitations/lookup", "/api/invitations/lookup", member, api.InvitationTokenRequest{Token: accepted.Token})
+	c.call("POST", "/api/invitations/:id/accept", "/api/invitations/"+accepted.Invitation.ID.Hex()+"/accept", member,
+		api.InvitationTokenRequest{Token: accepted.Token})
+	c.call("POST", "/api/invitations/:id/decline", "/api/invitations/"+declined.Invitation.ID.Hex()+"/decline", invitee,
+		api.InvitationTokenRequest{Token: declined.Token})
+
+	members := decode[[]api.SpaceMemberView](t, c.call("GET", "/api/spaces/:id/members", spacePath+"/members", owner, nil))
+	var memberID string
+	for _, m := range members {
+		if m.Role != "owner" {
+			memberID = m.UserID
+		}
+	}
+	memberPath := spacePath + "/members/" + memberID
+	c.call("PUT", "/api/spaces/:id/members/:userId", memberPath, owner, api.UpdateSpaceMemberRequest{Role: "viewer"})
+
+	// Secrets, shares and webhooks
+	c.call("POST", "/api/spaces/:id/secrets", spacePath+"/secrets", owner, api.CreateSecretRequest{Name: "API_KEY", Value: "one"})
+	c.call("PUT", "/api/spaces/:id/secrets/:name", spacePath+"/secrets/API_KEY", owner, api.UpdateSecretRequest{Value: "two"})
+	c.call("GET", "/api/spaces/:id/secrets", spacePath+"/secrets", owner, nil)
+
+	share := decode[models.ShareLink](t, c.call("POST", "/api/shares", "/api/shares", owner,
+		api.CreateShareLinkRequest{TargetType: "log", TargetID: log.ID.Hex(), AllowRun: true}))
+	sharePath := "/api/public/shares/" + share.Token
+	c.call("GET", "/api/shares", "/api/shares", owner, nil)
+	c.call("GET", "/api/public/shares/:token", sharePath, "", nil)
+	c.call("GET", "/api/public/shares/:token/logs/:logId", sharePath+"/logs/"+log.ID.Hex(), "", nil)
+	c.call("POST", "/api/public/shares/:token/run", sharePath+"/run", "", api.RunSharedLogRequest{})
+
+	webhook := decode[api.CreatedWebhook](t, c.call("POST", "/api/spaces/:id/webhooks", spacePath+"/webhooks", owner,
+		api.WebhookRequest{URL: "https://example.com/hook", Events: []string{"*"}})).Webhook
+	webhookPath := spacePath + "/webhooks/" + webhook.ID.Hex()
+	c.call("GET", "/api/spaces/:id/webhooks", spacePath+"/webhooks", owner, nil)
+	c.call("PUT", "/api/spaces/:id/webhooks/:webhookId", webhookPath, owner,
+		api.WebhookRequest{URL: "https://example.com/hooks", Events: []string{"log.updated"}})
+	delivery := decode[models.WebhookDelivery](t, c.call("POST", "/api/spaces/:id/webhooks/:webhookId/ping", webhookPath+"/ping", owner, nil))
+	c.call("GET", "/api/spaces/:id/webhooks/:webhookId/deliveries", webhookPath+"/deliveries?limit=1", owner, nil)
+	c.call("POST", "/api/spaces/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver",
+		webhookPath+"/deliveries/"+delivery.ID.Hex()+"/redeliver", owner, nil)
+
+	c.call("GET", "/api/quotas", "/api/quotas?spaceId="+space.ID.Hex(), owner, nil)
+	c.call("GET", "/api/audit", "/api/audit?limit=5&spaceId="+space.ID.Hex(), owner, nil)
+
+	// Running code and AI
+	c.call("POST", "/api/run", "/api/run", owner, api.RunRequest{Language: "python", Code: "print(1)", SpaceID: space.ID.Hex(), Secrets: []string{"API_KEY"}})
+	c.call("POST", "/api/ai/generate", "/api/ai/generate", owner, api.GenerateRequest{Prompt: "Say hi", Language: "python", SpaceID: space.ID.Hex()})
+	c.call("GET", "/api/ai/usage", "/api/ai/usage?groupBy=space", owner, nil)
+	c.call("GET", "/api/ai/budget", "/api/ai/budget?spaceId="+space.ID.Hex(), owner, nil)
+	session := decode[models.ChatSession](t, c.call("POST", "/api/ai/sessions", "/api/ai/sessions", owner,
+		api.CreateChatSessionRequest{SpaceID: space.ID.Hex(), LogID: log.ID.Hex()}))
+	sessionPath := "/api/ai/sessions/" + session.ID.Hex()
+	c.call("POST", "/api/ai/sessions/:id/messages", sessionPath+"/messages", owner, api.ChatMessageRequest{Content: "Explain"})
+	c.call("GET", "/api/ai/sessions", "/api/ai/sessions?spaceId="+space.ID.Hex(), owner, nil)
+	c.call("GET", "/api/ai/sessions/:id", sessionPath, owner, nil)
+
+	c.call("GET", "/api/account/export", "/api/account/export", owner, nil)
+
+	// Deletes, in reverse order of creation
+	c.call("DELETE", "/api/ai/sessions/:id", sessionPath, owner, nil)
+	c.call("DELETE", "/api/spaces/:id/webhooks/:webhookId", webhookPath, owner, nil)
+	c.call("DELETE", "/api/shares/:id", "/api/shares/"+share.ID.Hex(), owner, nil)
+	c.call("DELETE", "/api/spaces/:id/secrets/:name", spacePath+"/secrets/API_KEY", owner, nil)
+	c.call("DELETE", "/api/spaces/:id/members/:userId", memberPath, owner, nil)
+	c.call("DELETE", "/api/spaces/:id/invitations/:invitationId", spacePath+"/invitations/"+revoked.Invitation.ID.Hex(), owner, nil)
+	c.call("DELETE", "/api/logs/:id", logPath, owner, nil)
+	c.call("DELETE", "/api/vaults/:id", vaultPath, owner, nil)
+	c.call("DELETE", "/api/tokens/:id", "/api/tokens/"+apiToken.ID.Hex(), owner, nil)
+	c.call("DELETE", "/api/spaces/:id", spacePath, owner, nil, "If-Match", spaceETag)
+	c.call("POST", "/api/auth/logout", "/api/auth/logout", leaver, nil)
+
+	confirmation := decode[api.AccountDeletionConfirmation](t, c.call("POST", "/api/account/deletion", "/api/account/deletion", owner,
+		api.AccountDeletionRequest{Password: "password"}))
+	c.call("DELETE", "/api/account", "/api/account", owner, api.DeleteAccountRequest{ConfirmationToken: confirmation.ConfirmationToken})
+
+	var missed []string
+	for path, operations := range c.doc.Paths {
+		for method := range operations {
+			ginPath := openAPIToGin(path)
+			if !c.called[strings.ToUpper(method)+" "+ginPath] {
+				missed = append(missed, strings.ToUpper(method)+" "+ginPath)
+			}
+		}
+	}
+	sort.Strings(missed)
+	if len(missed) > 0 {
+		t.Errorf("documented operations the test doesn't exercise: %s", strings.Join(missed, ", "))
+	}
+}
+
+// openAPIToGin turns /spaces/{id} back into /spaces/:id
+func openAPIToGin(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + strings.Trim(segment, "{}")
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// The document must describe what an unsuccessful request gets back too
+func TestErrorsMatchOpenAPI(t *testing.T) {
+	dbtest.Setup(t)
+	ratelimit.Default = ratelimit.NewMemoryStore()
+	gin.DefaultWriter = io.Discard
+	engine, err := server.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &contract{t: t, engine: engine, called: map[string]bool{}}
+	c.doc = decode[openapi.Document](t, c.call("GET", "/api/openapi.json", "/api/openapi.json", "", nil))
+
+	token := decode[api.SessionResponse](t, c.call("POST", "/api/auth/register", "/api/auth/register", "",
+		api.RegisterRequest{Email: "ada@example.com", Password: "password"})).Token
+	space := decode[models.Space](t, c.call("POST", "/api/spaces", "/api/spaces", token, api.SpaceRequest{Name: "Space"}))
+
+	tests := []struct {
+		method, path, target, token string
+		body                        interface{}
+		status                      int
+		headers                     []string
+	}{
+		{"GET", "/api/auth/me", "/api/auth/me", "", nil, http.StatusUnauthorized, nil},
+		{"POST", "/api/auth/register", "/api/auth/register", "", `{"email": "ada"}`, http.StatusBadRequest, nil},
+		{"GET", "/api/spaces/:id", "/api/spaces/nope", token, nil, http.StatusBadRequest, nil},
+		{"GET", "/api/logs/:id", "/api/logs/0123456789abcdef01234567", token, nil, http.StatusNotFound, nil},
+		{"PUT", "/api/spaces/:id", "/api/spaces/" + space.ID.Hex(), token, api.SpaceRequest{Name: "x"}, http.StatusPreconditionFailed, []string{"If-Match", `"9"`}},
+		{"POST", "/api/graphql", "/api/graphql", token, map[string]string{"query": "{ nope }"}, http.StatusOK, nil}, // Errors are in the body
+		{"POST", "/api/graphql", "/api/graphql", token, "{", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		if w := c.send(tt.method, tt.path, tt.target, tt.token, tt.body, tt.headers...); w.Code != tt.status {
+			t.Errorf("%s %s = %d, want %d; body %s", tt.method, tt.target, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// versionETag is the strong ETag of a space, vault or log version
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// spaceETag is the strong ETag of a space as seen by a member with role. Responses
+// carry the caller's role, so a role change must change the tag even though the
+// space's version doesn't.
+func spaceETag(version int64, role string) string {
+	return `"` + strconv.FormatInt(version, 10) + "." + role + `"`
+}
+
+// contentETag is a weak ETag derived from a response body
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag and answers 304 when If-None-Match already has it
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	if etagListMatches(c.GetHeader("If-None-Match"), etag, false) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// respondVersioned writes a vault or log with its ETag, or 304 if the client has it
+func respondVersioned(c *gin.Context, version int64, body interface{}) {
+	if notModified(c, versionETag(version)) {
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// respondSpace writes a space with its ETag for the caller's role, or 304 if the client has it
+func respondSpace(c *gin.Context, space models.Space) {
+	if notModified(c, spaceETag(space.Version, space.Role)) {
+		return
+	}
+	c.JSON(http.StatusOK, space)
+}
+
+// respondWithETag writes body with a content ETag, or 304 if the client has it
+func respondWithETag(c *gin.Context, body interface{}) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to encode response")
+		return
+	}
+	if notModified(c, contentETag(encoded)) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", encoded)
+}
+
+// writeFilter returns the filter for updating or deleting a document at version
+// current. With If-Match the filter also requires the version to be unchanged,
+// so a concurrent write between loading and writing is caught too. It writes a
+// 412 and returns false when If-Match is already stale.
+func writeFilter(c *gin.Context, id primitive.ObjectID, current int64) (bson.M, bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" || strings.TrimSpace(ifMatch) == "*" {
+		return bson.M{"_id": id}, true
+	}
+
+	if !etagListMatches(ifMatch, versionETag(current), true) {
+		respondPreconditionFailed(c, current)
+		return nil, false
+	}
+
+	return versionFilter(id, current), true
+}
+
+// versionFilter matches a document only while it is still at version
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		// Documents created before versioning have no version field
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"_id": id, "version": version}
+}
+
+// respondWriteMissed explains why a write filter matched nothing: the document
+// is gone, or it changed after If-Match was checked
+func respondWriteMissed(c *gin.Context, ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, notFound string) {
+	var current struct {
+		Version int64 `bson:"version"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"version": 1})
+	if err := collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&current); err != nil {
+		apierror.Abort(c, apierror.NotFound, notFound)
+		return
+	}
+	respondPreconditionFailed(c, current.Version)
+}
+
+// writeMissedError is respondWriteMissed for callers that report errors
+// themselves, such as batches and GraphQL mutations
+func writeMissedError(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, notFound string) *apierror.Error {
+	var current struct {
+		Version int64 `bson:"version"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"version": 1})
+	if err := collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&current); err != nil {
+		return apierror.New(apierror.NotFound, notFound)
+	}
+	return preconditionFailedError(current.Version)
+}
+
+// respondPreconditionFailed tells a stale client the current version
+func respondPreconditionFailed(c *gin.Context, current int64) {
+	c.Header("ETag", versionETag(current))
+	apierror.Respond(c, preconditionFailedError(current))
+}
+
+// preconditionFailedError is the 412 error carrying the current version
+func preconditionFailedError(current int64) *apierror.Error {
+	return apierror.New(apierror.PreconditionFailed, "The resource has changed since it was loaded").
+		WithDetails(gin.H{"version": current, "etag": versionETag(current)})
+}
+
+// etagListMatches compares etag with an If-Match or If-None-Match list. Strong
+// comparison (If-Match) never matches weak tags, and ignores the role in space
+// tags since only the version decides whether a write is stale.
+func etagListMatches(header, etag string, strong bool) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if withoutRole(candidate) == etag && !strings.HasPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchNamesVersions reports whether an If-Match header lists versions
+// rather than being absent or allowing any version with *
+func ifMatchNamesVersions(header string) bool {
+	if strings.TrimSpace(header) == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimSpace(candidate) == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutRole turns a space ETag into the version ETag it was made from
+func withoutRole(etag string) string {
+	if i := strings.IndexByte(etag, '.'); i > 0 && strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) {
+		return etag[:i] + `"`
+	}
+	return etag
+}
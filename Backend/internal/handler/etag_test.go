@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newSpaceAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/spaces/:id", GetSpace)
+	a.api.PUT("/spaces/:id", UpdateSpace)
+	return a
+}
+
+func versionHeader(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func TestEtagListMatches(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		strong, want bool
+	}{
+		{`"3"`, `"3"`, true, true},
+		{`"2", "3"`, `"3"`, true, true},
+		{`*`, `"3"`, true, true},
+		{`W/"3"`, `"3"`, true, false},
+		{`"3.owner"`, `"3"`, true, true},
+		{`"3.owner"`, `"4"`, true, false},
+		{`"3.viewer"`, `"3.owner"`, false, false},
+		{`W/"3.owner"`, `"3.owner"`, false, true},
+		{``, `"3"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagListMatches(tt.header, tt.etag, tt.strong); got != tt.want {
+			t.Errorf("etagListMatches(%q, %q, %v) = %v, want %v", tt.header, tt.etag, tt.strong, got, tt.want)
+		}
+	}
+}
+
+func TestSpaceETagFollowsRole(t *testing.T) {
+	setupDB(t)
+	owner, ownerToken := createUser(t, "ada@example.com")
+	member, memberToken := createUser(t, "bob@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	addMember(t, space.ID, member.ID.Hex(), RoleEditor)
+	a := newSpaceAPI()
+	target := "/api/spaces/" + space.ID.Hex()
+
+	w := a.request("GET", target, ownerToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	ownerTag := w.Header().Get("ETag")
+
+	w = a.request("GET", target, memberToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	memberTag := w.Header().Get("ETag")
+	if memberTag == ownerTag {
+		t.Fatalf("owner and editor both got ETag %s", ownerTag)
+	}
+	expectStatus(t, a.request("GET", target, memberToken, nil, "If-None-Match", memberTag), http.StatusNotModified)
+
+	// Demoting the member leaves the version alone, but their cached copy is stale
+	ctx, cancel := testContext()
+	defer cancel()
+	_, err := db.Database.Collection("space_members").UpdateOne(ctx,
+		bson.M{"spaceId": space.ID, "userId": member.ID.Hex()}, bson.M{"$set": bson.M{"role": RoleViewer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = a.request("GET", target, memberToken, nil, "If-None-Match", memberTag)
+	expectStatus(t, w, http.StatusOK)
+	if body := decodeBody[map[string]interface{}](t, w); body["role"] != RoleViewer {
+		t.Errorf("role = %v after the demotion, want viewer", body["role"])
+	}
+
+	// The tag from GET works as If-Match, and a stale one is refused
+	w = a.request("PUT", target, ownerToken, api.SpaceRequest{Name: "Renamed"}, "If-Match", ownerTag)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("ETag") == ownerTag {
+		t.Error("the ETag didn't change after renaming")
+	}
+	w = a.request("PUT", target, ownerToken, api.SpaceRequest{Name: "Again"}, "If-Match", ownerTag)
+	expectError(t, w, http.StatusPreconditionFailed, "PRECONDITION_FAILED")
+}
+
+func newVersionedAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/vaults/:id", GetVault)
+	a.api.PUT("/vaults/:id", UpdateVault)
+	a.api.DELETE("/vaults/:id", DeleteVault)
+	a.api.GET("/logs/:id", GetLog)
+	a.api.PUT("/logs/:id", UpdateLog)
+	a.api.DELETE("/logs/:id", DeleteLog)
+	a.api.DELETE("/spaces/:id", DeleteSpace)
+	a.api.GET("/tree", GetTree)
+	return a
+}
+
+// expectStale checks a 412 that tells the client the current version
+func expectStale(t *testing.T, w *httptest.ResponseRecorder, current int64) {
+	t.Helper()
+	body := expectError(t, w, http.StatusPreconditionFailed, apierror.PreconditionFailed)
+	details, _ := body.Details.(map[string]interface{})
+	if details["version"] != float64(current) || details["etag"] != versionHeader(current) || w.Header().Get("ETag") != versionHeader(current) {
+		t.Errorf("412 details = %v, ETag %s; want version %d", details, w.Header().Get("ETag"), current)
+	}
+}
+
+func TestIfMatchOnVaultsAndLogs(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	vault := createVault(t, space, "src", nil)
+	log := createLog(t, vault, "main.py", "print(1)")
+	a := newVersionedAPI()
+
+	for _, target := range []string{"/api/vaults/" + vault.ID.Hex(), "/api/logs/" + log.ID.Hex()} {
+		w := a.request("GET", target, token, nil)
+		expectStatus(t, w, http.StatusOK)
+		if tag := w.Header().Get("ETag"); tag != versionHeader(1) {
+			t.Errorf("%s: ETag = %s", target, tag)
+		}
+		for _, header := range []string{`"1"`, `W/"1"`, `"0", "1"`, `*`} {
+			expectStatus(t, a.request("GET", target, token, nil, "If-None-Match", header), http.StatusNotModified)
+		}
+		expectStatus(t, a.request("GET", target, token, nil, "If-None-Match", `"2"`), http.StatusOK)
+	}
+
+	// A stale or weak tag is refused and the log is left alone
+	target := "/api/logs/" + log.ID.Hex()
+	expectStale(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "print(2)"}, "If-Match", `"2"`), 1)
+	expectStale(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "print(2)"}, "If-Match", `W/"1"`), 1)
+	if stored, _ := findLog(t, log); stored.Code != "print(1)" || stored.Version != 1 {
+		t.Errorf("a refused write changed the log to %q, version %d", stored.Code, stored.Version)
+	}
+	w := a.request("PUT", target, token, api.UpdateLogRequest{Code: "print(2)"}, "If-Match", `"0", "1"`)
+	expectStatus(t, w, http.StatusOK)
+	if tag := w.Header().Get("ETag"); tag != versionHeader(2) {
+		t.Errorf("ETag after the update = %s", tag)
+	}
+	expectStatus(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "print(3)"}, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "print(4)"}), http.StatusOK)
+	expectStale(t, a.request("DELETE", target, token, nil, "If-Match", `"2"`), 4)
+	expectStatus(t, a.request("DELETE", target, token, nil, "If-Match", `"4"`), http.StatusOK)
+	expectError(t, a.request("DELETE", target, token, nil, "If-Match", `"4"`), http.StatusNotFound, apierror.NotFound)
+
+	// Vaults and spaces too
+	target = "/api/vaults/" + vault.ID.Hex()
+	expectStale(t, a.request("PUT", target, token, api.UpdateVaultRequest{Name: "lib"}, "If-Match", `"2"`), 1)
+	expectStatus(t, a.request("PUT", target, token, api.UpdateVaultRequest{Name: "lib"}, "If-Match", `"1"`), http.StatusOK)
+	expectStale(t, a.request("DELETE", target, token, nil, "If-Match", `"1"`), 2)
+	expectStatus(t, a.request("DELETE", target, token, nil, "If-Match", `"2"`), http.StatusOK)
+
+	target = "/api/spaces/" + space.ID.Hex()
+	expectStale(t, a.request("DELETE", target, token, nil, "If-Match", `"2.owner"`), 1)
+	expectStatus(t, a.request("DELETE", target, token, nil, "If-Match", `"1.owner"`), http.StatusOK)
+}
+
+func TestIfMatchOnUnversionedDocuments(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	vault := createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil)
+	log := createLog(t, vault, "main.py", "print(1)")
+
+	// Documents written before versioning have no version field
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := db.Database.Collection("logs").UpdateOne(ctx, bson.M{"_id": log.ID}, bson.M{"$unset": bson.M{"version": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	a := newVersionedAPI()
+	target := "/api/logs/" + log.ID.Hex()
+
+	w := a.request("GET", target, token, nil)
+	if tag := w.Header().Get("ETag"); tag != versionHeader(0) {
+		t.Errorf("ETag = %s", tag)
+	}
+	expectStatus(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "print(2)"}, "If-Match", versionHeader(0)), http.StatusOK)
+	if stored, _ := findLog(t, log); stored.Version != 1 {
+		t.Errorf("version = %d after the first versioned write", stored.Version)
+	}
+
+	// A write that misses because the version moved on is a 412, not a 404
+	if err := writeMissedError(ctx, db.Database.Collection("logs"), log.ID, "Log not found"); err == nil || err.Code != apierror.PreconditionFailed {
+		t.Errorf("missed write of a changed log: %v", err)
+	}
+	if err := writeMissedError(ctx, db.Database.Collection("logs"), primitive.NewObjectID(), "Log not found"); err == nil || err.Code != apierror.NotFound {
+		t.Errorf("missed write of a missing log: %v", err)
+	}
+}
+
+func TestTreeETagFollowsContent(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	vault := createVault(t, space, "src", nil)
+	createLog(t, vault, "a.py", "")
+	createLog(t, createVault(t, space, "lib", &vault), "b.py", "")
+	a := newVersionedAPI()
+	target := "/api/tree?spaceId=" + space.ID.Hex()
+
+	w := a.request("GET", target, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	tag := w.Header().Get("ETag")
+	if !strings.HasPrefix(tag, `W/"`) {
+		t.Fatalf("tree ETag = %q, want a weak tag", tag)
+	}
+	for i := 0; i < 5; i++ {
+		expectStatus(t, a.request("GET", target, token, nil, "If-None-Match", tag), http.StatusNotModified)
+	}
+
+	createLog(t, vault, "c.py", "")
+	w = a.request("GET", target, token, nil, "If-None-Match", tag)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("ETag") == tag {
+		t.Error("the tree ETag didn't change after adding a log")
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// feedAccessCheckInterval is how often an open feed rechecks that its token is
+	// still valid and its user still has access
+	feedAccessCheckInterval = 30 * time.Second
+
+	// feedWriteTimeout drops clients that stop reading
+	feedWriteTimeout = 10 * time.Second
+)
+
+// StreamSpaceEvents upgrades to a WebSocket that receives an events.Event as a
+// JSON text message for every change to the space, its vaults and its logs.
+// Clients send nothing. The server closes the socket when the space is deleted,
+// when the user loses access or their session or token ends, or when the client
+// falls too far behind; clients should reconnect and reload the tree, since
+// changes in between are not replayed.
+func StreamSpaceEvents(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleViewer, "Space not found") {
+		return
+	}
+
+	if !isWebSocketUpgrade(c.Request) {
+		apierror.Abort(c, apierror.InvalidRequest, "This endpoint requires a WebSocket upgrade")
+		return
+	}
+
+	// Subscribe before the upgrade so nothing is missed between the client's
+	// reload and the first event
+	sub := events.Subscribe(spaceID)
+	defer sub.Close()
+
+	userID := currentUserID(c)
+	token := middleware.RequestToken(c)
+	serveWebSocket(c, func(ws *websocket.Conn) {
+		closed := readUntilClosed(ws)
+
+		ticker := time.NewTicker(feedAccessCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				ws.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+				if err := websocket.JSON.Send(ws, event); err != nil {
+					return
+				}
+				if event.Type == events.SpaceDeleted {
+					return
+				}
+
+			case <-ticker.C:
+				if role, err := recheckAccess(token, spaceID, userID); err == nil && role == "" {
+					return
+				}
+
+			case <-closed:
+				return
+			}
+		}
+	})
+}
+
+// recheckAccess returns the user's current role in the space for a connection
+// authenticated with token, or "" once the session has ended, the access token
+// has been revoked or the user has lost access. Errors mean the check failed and
+// the connection should carry on until the next one.
+func recheckAccess(token string, spaceID primitive.ObjectID, userID string) (string, error) {
+	if err := middleware.CheckToken(token); err == middleware.ErrTokenEnded {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return spaceRole(ctx, spaceID, userID)
+}
+
+// serveWebSocket completes the upgrade and runs handle on the connection. The
+// route's middleware has already checked the Origin header.
+func serveWebSocket(c *gin.Context, handle func(ws *websocket.Conn)) {
+	server := websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			handle(ws)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// readUntilClosed discards incoming messages and closes the returned channel
+// when the client goes away
+func readUntilClosed(ws *websocket.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var message []byte
+		for websocket.Message.Receive(ws, &message) == nil {
+		}
+	}()
+	return closed
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header.Get("Connection"), "upgrade") && headerContainsToken(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContainsToken reports whether a comma-separated header value lists token
+func headerContainsToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Hooks for the black-box tests in package handler_test
+
+// FakeUpstreams answers AI requests with reply and runs all code successfully
+// until the test ends
+func FakeUpstreams(t *testing.T, reply string) {
+	t.Helper()
+	fakeOpenAI(t, reply)
+	fakePiston(t, func(code string) (int, PistonResponse) {
+		return http.StatusOK, PistonResponse{Run: PistonStage{Stdout: "ok\n"}}
+	})
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/graphql"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GraphQL runs a query or mutation against the space, vault and log graph.
+// Queries need the read scope and mutations the write scope; GET only runs
+// queries. Errors inside a valid request are returned with status 200 in the
+// errors list, each with the API error code in extensions.code. Operations
+// may nest graphql.MaxDepth levels deep and select graphql.MaxFields fields.
+func GraphQL(c *gin.Context) {
+	var req graphql.Request
+
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := decodeJSONNumbers([]byte(variables), &req.Variables); err != nil {
+				apierror.Abort(c, apierror.InvalidRequest, "variables must be a JSON object")
+				return
+			}
+		}
+	} else {
+		decoder := json.NewDecoder(c.Request.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&req); err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Body must be a JSON object with a query")
+			return
+		}
+	}
+
+	op, errs := graphQLSchema.Prepare(req)
+	if errs != nil {
+		c.JSON(http.StatusOK, graphql.Response{Errors: errs})
+		return
+	}
+
+	scope := auth.ScopeRead
+	if op.Kind() == "mutation" {
+		if c.Request.Method == http.MethodGet {
+			c.Header("Allow", http.MethodPost)
+			apierror.Abort(c, apierror.MethodNotAllowed, "Mutations must be sent with POST")
+			return
+		}
+		scope = auth.ScopeWrite
+	}
+	if !middleware.HasScope(c, scope) {
+		apierror.Abort(c, apierror.InsufficientScope, "Access token is missing the "+scope+" scope")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ctx = context.WithValue(ctx, graphQLStateKey{}, newGraphQLState(c))
+	c.JSON(http.StatusOK, op.Execute(ctx))
+}
+
+// decodeJSONNumbers decodes JSON keeping numbers exact, as GraphQL variables need
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+type graphQLStateKey struct{}
+
+// graphQLState is shared by the resolvers of one request. The loaders batch
+// and cache lookups by ID; mutations reset them, so later fields see the writes.
+type graphQLState struct {
+	c      *gin.Context
+	userID string
+	roles  map[primitive.ObjectID]string // Loaded on first use
+
+	spaces       *graphql.Loader[primitive.ObjectID, models.Space]
+	vaults       *graphql.Loader[primitive.ObjectID, models.Vault]
+	logs         *graphql.Loader[primitive.ObjectID, models.Log] // Without their code
+	logsWithCode *graphql.Loader[primitive.ObjectID, models.Log]
+}
+
+func newGraphQLState(c *gin.Context) *graphQLState {
+	s := &graphQLState{c: c, userID: currentUserID(c)}
+	s.spaces = graphql.NewLoader(func(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]models.Space, error) {
+		spaces, err := findAll[models.Space](ctx, "spaces", bson.M{"_id": bson.M{"$in": ids}}, nil)
+		byID := map[primitive.ObjectID]models.Space{}
+		for _, space := range spaces {
+			byID[space.ID] = space
+		}
+		return byID, err
+	})
+	s.vaults = graphql.NewLoader(func(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]models.Vault, error) {
+		vaults, err := findAll[models.Vault](ctx, "vaults", bson.M{"_id": bson.M{"$in": ids}}, nil)
+		byID := map[primitive.ObjectID]models.Vault{}
+		for _, vault := range vaults {
+			byID[vault.ID] = vault
+		}
+		return byID, err
+	})
+	s.logs = graphql.NewLoader(s.loadLogs(false))
+	s.logsWithCode = graphql.NewLoader(s.loadLogs(true))
+	return s
+}
+
+func (s *graphQLState) loadLogs(withCode bool) graphql.BatchFunc[primitive.ObjectID, models.Log] {
+	return func(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]models.Log, error) {
+		logs, err := findAll[models.Log](ctx, "logs", bson.M{"_id": bson.M{"$in": ids}}, logProjection(withCode))
+		byID := map[primitive.ObjectID]models.Log{}
+		for _, log := range logs {
+			byID[log.ID] = log
+		}
+		return byID, err
+	}
+}
+
+// graphQLStateFrom returns the state of the request a resolver runs in
+func graphQLStateFrom(ctx context.Context) *graphQLState {
+	return ctx.Value(graphQLStateKey{}).(*graphQLState)
+}
+
+// role returns the caller's role in a space, or "" without access
+func (s *graphQLState) role(ctx context.Context, spaceID primitive.ObjectID) (string, error) {
+	if s.roles == nil {
+		roles, err := accessibleSpaces(ctx, s.userID)
+		if err != nil {
+			return "", graphQLError(apierror.New(apierror.Internal, "Failed to check space access"))
+		}
+		s.roles = roles
+	}
+	return s.roles[spaceID], nil
+}
+
+// written forgets everything loaded so far, after a mutation
+func (s *graphQLState) written() {
+	s.roles = nil
+	s.spaces.Clear()
+	s.vaults.Clear()
+	s.logs.Clear()
+	s.logsWithCode.Clear()
+}
+
+// logsLoader returns the loader to use depending on whether code was asked for
+func (s *graphQLState) logsLoader(withCode bool) *graphql.Loader[primitive.ObjectID, models.Log] {
+	if withCode {
+		return s.logsWithCode
+	}
+	return s.logs
+}
+
+// logProjection leaves out the code of logs unless it's needed
+func logProjection(withCode bool) *options.FindOptions {
+	opts := options.Find().SetSort(bson.D{{Key: "path", Value: 1}})
+	if !withCode {
+		opts.SetProjection(bson.M{"code": 0})
+	}
+	return opts
+}
+
+// findAll decodes every document matching filter
+func findAll[T any](ctx context.Context, collection string, filter bson.M, opts *options.FindOptions) ([]T, error) {
+	if opts == nil {
+		opts = options.Find().SetSort(bson.D{{Key: "path", Value: 1}})
+	}
+	cursor, err := db.Database.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, graphQLError(apierror.New(apierror.Internal, "Failed to fetch "+collection))
+	}
+	var items []T
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, graphQLError(apierror.New(apierror.Internal, "Failed to decode "+collection))
+	}
+	return items, nil
+}
+
+// graphQLError carries an API error into a GraphQL response, keeping its code
+func graphQLError(err *apierror.Error) error {
+	extensions := map[string]interface{}{"code": err.Code, "status": err.Status}
+	if err.Details != nil {
+		extensions["details"] = err.Details
+	}
+	return &graphql.Error{Message: err.Message, Extensions: extensions}
+}
+
+// graphQLID parses an ID argument
+func graphQLID(args map[string]interface{}, name, kind string) (primitive.ObjectID, error) {
+	value, _ := args[name].(string)
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return id, graphQLError(apierror.New(apierror.InvalidID, "Invalid "+kind+" ID"))
+	}
+	return id, nil
+}
+
+// groupBy arranges items under the ID of each source, keeping their order
+func groupBy[T any](sources []interface{}, sourceID func(interface{}) primitive.ObjectID, items []T, key func(T) primitive.ObjectID) []interface{} {
+	grouped := map[primitive.ObjectID][]T{}
+	for _, item := range items {
+		grouped[key(item)] = append(grouped[key(item)], item)
+	}
+	values := make([]interface{}, len(sources))
+	for i, source := range sources {
+		group := grouped[sourceID(source)]
+		if group == nil {
+			group = []T{}
+		}
+		values[i] = group
+	}
+	return values
+}
+
+// distinctIDs returns the IDs without repeats, for $in filters
+func distinctIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := map[primitive.ObjectID]bool{}
+	distinct := []primitive.ObjectID{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	return distinct
+}
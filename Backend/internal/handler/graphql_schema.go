@@ -0,0 +1,589 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/graphql"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// graphQLSchema is the schema served at /api/graphql
+var graphQLSchema = newGraphQLSchema()
+
+var dateTimeType = &graphql.Scalar{
+	Name:        "DateTime",
+	Description: "An RFC 3339 timestamp",
+	Serialize: func(v interface{}) (interface{}, error) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("DateTime cannot represent value: %v", v)
+		}
+		return t.Format(time.RFC3339Nano), nil
+	},
+	Parse: func(v interface{}) (interface{}, error) {
+		s, _ := v.(string)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("DateTime must be an RFC 3339 timestamp")
+		}
+		return t, nil
+	},
+}
+
+func newGraphQLSchema() *graphql.Schema {
+	spaceType := &graphql.Object{Name: "Space", Description: "A workspace holding vaults and logs"}
+	vaultType := &graphql.Object{Name: "Vault", Description: "A folder of logs and nested vaults"}
+	logType := &graphql.Object{Name: "Log", Description: "A code file in a vault"}
+
+	id := graphql.NewNonNull(graphql.ID)
+	str := graphql.NewNonNull(graphql.String)
+	version := graphql.NewNonNull(graphql.Int)
+	timestamp := graphql.NewNonNull(dateTimeType)
+	ifVersion := &graphql.Argument{Name: "ifVersion", Type: graphql.Int, Description: "Fail with PRECONDITION_FAILED unless the target is at this version"}
+	language := &graphql.Argument{Name: "language", Type: graphql.String, Description: "Only logs in this language"}
+	vaultList := graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(vaultType)))
+	logList := graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(logType)))
+
+	spaceType.Fields = []*graphql.Field{
+		{Name: "id", Type: id},
+		{Name: "userId", Type: str, Description: "The owner"},
+		{Name: "name", Type: str},
+		{Name: "role", Type: str, Description: "The caller's role in the space", Resolve: resolveSpaceRole},
+		{Name: "version", Type: version},
+		{Name: "createdAt", Type: timestamp},
+		{Name: "updatedAt", Type: timestamp},
+		{Name: "vaults", Type: vaultList, Description: "The top-level vaults, or every vault with all: true", Resolve: resolveSpaceVaults,
+			Args: []*graphql.Argument{{Name: "all", Type: graphql.Boolean, Default: false}}},
+		{Name: "logs", Type: logList, Description: "Every log in the space", Resolve: resolveSpaceLogs, Args: []*graphql.Argument{language}},
+	}
+
+	vaultType.Fields = []*graphql.Field{
+		{Name: "id", Type: id},
+		{Name: "spaceId", Type: id},
+		{Name: "userId", Type: str, Description: "The creator"},
+		{Name: "name", Type: str},
+		{Name: "path", Type: str},
+		{Name: "parentId", Type: graphql.ID},
+		{Name: "version", Type: version},
+		{Name: "createdAt", Type: timestamp},
+		{Name: "updatedAt", Type: timestamp},
+		{Name: "space", Type: graphql.NewNonNull(spaceType), Resolve: resolveSpaceOf},
+		{Name: "parent", Type: vaultType, Resolve: resolveVaultParent},
+		{Name: "children", Type: vaultList, Description: "The vaults directly inside", Resolve: resolveVaultChildren},
+		{Name: "logs", Type: logList, Description: "The logs directly inside", Resolve: resolveVaultLogs, Args: []*graphql.Argument{language}},
+	}
+
+	logType.Fields = []*graphql.Field{
+		{Name: "id", Type: id},
+		{Name: "spaceId", Type: id},
+		{Name: "vaultId", Type: id},
+		{Name: "userId", Type: str, Description: "The creator"},
+		{Name: "name", Type: str},
+		{Name: "path", Type: str},
+		{Name: "language", Type: str},
+		{Name: "code", Type: str, Description: "Only loaded when selected"},
+		{Name: "version", Type: version},
+		{Name: "createdAt", Type: timestamp},
+		{Name: "updatedAt", Type: timestamp},
+		{Name: "vault", Type: graphql.NewNonNull(vaultType), Resolve: resolveLogVault},
+		{Name: "space", Type: graphql.NewNonNull(spaceType), Resolve: resolveSpaceOf},
+	}
+
+	idArg := func(name string) *graphql.Argument { return &graphql.Argument{Name: name, Type: id} }
+	strArg := func(name string) *graphql.Argument { return &graphql.Argument{Name: name, Type: str} }
+	optionalArg := func(name string, t graphql.Type) *graphql.Argument { return &graphql.Argument{Name: name, Type: t} }
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "spaces", Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(spaceType))), Description: "The spaces the caller owns or is a member of", Resolve: resolveSpaces},
+		{Name: "space", Type: spaceType, Args: []*graphql.Argument{idArg("id")}, Resolve: resolveSpace},
+		{Name: "vault", Type: vaultType, Args: []*graphql.Argument{idArg("id")}, Resolve: resolveVault},
+		{Name: "log", Type: logType, Args: []*graphql.Argument{idArg("id")}, Resolve: resolveLog},
+	}}
+
+	mutation := &graphql.Object{Name: "Mutation", Fields: []*graphql.Field{
+		{Name: "createSpace", Type: graphql.NewNonNull(spaceType), Args: []*graphql.Argument{strArg("name")}, Resolve: graphql.Each(createSpaceMutation)},
+		{Name: "updateSpace", Type: graphql.NewNonNull(spaceType), Args: []*graphql.Argument{idArg("id"), strArg("name"), ifVersion}, Resolve: graphql.Each(updateSpaceMutation)},
+		{Name: "deleteSpace", Type: id, Description: "Deletes a space and everything in it, returning its ID", Args: []*graphql.Argument{idArg("id"), ifVersion}, Resolve: graphql.Each(deleteSpaceMutation)},
+
+		{Name: "createVault", Type: graphql.NewNonNull(vaultType), Args: []*graphql.Argument{idArg("spaceId"), strArg("name"), optionalArg("parentId", graphql.ID)}, Resolve: batchMutation("create", "vault")},
+		{Name: "updateVault", Type: graphql.NewNonNull(vaultType), Args: []*graphql.Argument{idArg("id"), strArg("name"), ifVersion}, Resolve: batchMutation("update", "vault")},
+		{Name: "moveVault", Type: graphql.NewNonNull(vaultType), Description: "Moves a vault under parentId, or to the top level without it", Args: []*graphql.Argument{idArg("id"), optionalArg("parentId", graphql.ID), ifVersion}, Resolve: batchMutation("move", "vault")},
+		{Name: "deleteVault", Type: id, Description: "Deletes a vault with its nested vaults and logs, returning its ID", Args: []*graphql.Argument{idArg("id"), ifVersion}, Resolve: batchMutation("delete", "vault")},
+
+		{Name: "createLog", Type: graphql.NewNonNull(logType), Args: []*graphql.Argument{idArg("vaultId"), strArg("name"), optionalArg("language", graphql.String), optionalArg("code", graphql.String)}, Resolve: batchMutation("create", "log")},
+		{Name: "updateLog", Type: graphql.NewNonNull(logType), Args: []*graphql.Argument{idArg("id"), optionalArg("name", graphql.String), optionalArg("language", graphql.String), optionalArg("code", graphql.String), ifVersion}, Resolve: batchMutation("update", "log")},
+		{Name: "moveLog", Type: graphql.NewNonNull(logType), Args: []*graphql.Argument{idArg("id"), idArg("vaultId"), ifVersion}, Resolve: batchMutation("move", "log")},
+		{Name: "deleteLog", Type: id, Description: "Deletes a log, returning its ID", Args: []*graphql.Argument{idArg("id"), ifVersion}, Resolve: batchMutation("delete", "log")},
+	}}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Queries
+
+func resolveSpaces(p graphql.ResolveParams) ([]interface{}, error) {
+	state := graphQLStateFrom(p.Context)
+	if _, err := state.role(p.Context, primitive.NilObjectID); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	spaces, err := findAll[models.Space](p.Context, "spaces", bson.M{"_id": bson.M{"$in": spaceIDList(state.roles)}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, space := range spaces {
+		state.spaces.Prime(space.ID, space)
+	}
+	if spaces == nil {
+		spaces = []models.Space{}
+	}
+	return []interface{}{spaces}, nil
+}
+
+func resolveSpace(p graphql.ResolveParams) ([]interface{}, error) {
+	spaceID, err := graphQLID(p.Args, "id", "space")
+	if err != nil {
+		return nil, err
+	}
+	space, found, err := graphQLStateFrom(p.Context).visibleSpace(p.Context, spaceID)
+	if err != nil || !found {
+		return []interface{}{nil}, err
+	}
+	return []interface{}{space}, nil
+}
+
+func resolveVault(p graphql.ResolveParams) ([]interface{}, error) {
+	vaultID, err := graphQLID(p.Args, "id", "vault")
+	if err != nil {
+		return nil, err
+	}
+
+	state := graphQLStateFrom(p.Context)
+	vault, found, err := state.vaults.Load(p.Context, vaultID)
+	if err != nil || !found {
+		return []interface{}{nil}, err
+	}
+	if role, err := state.role(p.Context, vault.SpaceID); err != nil || role == "" {
+		return []interface{}{nil}, err
+	}
+	return []interface{}{vault}, nil
+}
+
+func resolveLog(p graphql.ResolveParams) ([]interface{}, error) {
+	logID, err := graphQLID(p.Args, "id", "log")
+	if err != nil {
+		return nil, err
+	}
+
+	state := graphQLStateFrom(p.Context)
+	log, found, err := state.logsLoader(p.Selects("code")).Load(p.Context, logID)
+	if err != nil || !found {
+		return []interface{}{nil}, err
+	}
+	if role, err := state.role(p.Context, log.SpaceID); err != nil || role == "" {
+		return []interface{}{nil}, err
+	}
+	return []interface{}{log}, nil
+}
+
+// visibleSpace loads a space the caller has access to
+func (s *graphQLState) visibleSpace(ctx context.Context, spaceID primitive.ObjectID) (models.Space, bool, error) {
+	role, err := s.role(ctx, spaceID)
+	if err != nil || role == "" {
+		return models.Space{}, false, err
+	}
+	return s.spaces.Load(ctx, spaceID)
+}
+
+// Fields. Each resolves the field for every parent at once, so a level of the
+// tree costs one query however many parents it has.
+
+func resolveSpaceRole(p graphql.ResolveParams) ([]interface{}, error) {
+	state := graphQLStateFrom(p.Context)
+	values := make([]interface{}, len(p.Sources))
+	for i, source := range p.Sources {
+		role, err := state.role(p.Context, source.(models.Space).ID)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = role
+	}
+	return values, nil
+}
+
+func resolveSpaceVaults(p graphql.ResolveParams) ([]interface{}, error) {
+	spaceIDs := sourceIDs(p.Sources, spaceIDOf)
+	filter := bson.M{"spaceId": bson.M{"$in": spaceIDs}}
+	if all, _ := p.Args["all"].(bool); !all {
+		filter["parentId"] = nil
+	}
+
+	vaults, err := findAll[models.Vault](p.Context, "vaults", filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	graphQLStateFrom(p.Context).primeVaults(vaults)
+	return groupBy(p.Sources, spaceIDOf, vaults, func(v models.Vault) primitive.ObjectID { return v.SpaceID }), nil
+}
+
+func resolveSpaceLogs(p graphql.ResolveParams) ([]interface{}, error) {
+	filter := bson.M{"spaceId": bson.M{"$in": sourceIDs(p.Sources, spaceIDOf)}}
+	if language, ok := p.Args["language"].(string); ok {
+		filter["language"] = language
+	}
+
+	logs, err := findAll[models.Log](p.Context, "logs", filter, logProjection(p.Selects("code")))
+	if err != nil {
+		return nil, err
+	}
+	return groupBy(p.Sources, spaceIDOf, logs, func(l models.Log) primitive.ObjectID { return l.SpaceID }), nil
+}
+
+func resolveVaultParent(p graphql.ResolveParams) ([]interface{}, error) {
+	var parentIDs []primitive.ObjectID
+	for _, source := range p.Sources {
+		if parentID := source.(models.Vault).ParentID; parentID != nil {
+			parentIDs = append(parentIDs, *parentID)
+		}
+	}
+
+	parents, err := graphQLStateFrom(p.Context).vaults.LoadMany(p.Context, parentIDs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(p.Sources))
+	for i, source := range p.Sources {
+		if parentID := source.(models.Vault).ParentID; parentID != nil {
+			if parent, ok := parents[*parentID]; ok {
+				values[i] = parent
+			}
+		}
+	}
+	return values, nil
+}
+
+func resolveVaultChildren(p graphql.ResolveParams) ([]interface{}, error) {
+	vaultIDs := sourceIDs(p.Sources, vaultIDOf)
+	children, err := findAll[models.Vault](p.Context, "vaults", bson.M{"parentId": bson.M{"$in": vaultIDs}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	graphQLStateFrom(p.Context).primeVaults(children)
+	return groupBy(p.Sources, vaultIDOf, children, func(v models.Vault) primitive.ObjectID { return *v.ParentID }), nil
+}
+
+func resolveVaultLogs(p graphql.ResolveParams) ([]interface{}, error) {
+	filter := bson.M{"vaultId": bson.M{"$in": sourceIDs(p.Sources, vaultIDOf)}}
+	if language, ok := p.Args["language"].(string); ok {
+		filter["language"] = language
+	}
+
+	logs, err := findAll[models.Log](p.Context, "logs", filter, logProjection(p.Selects("code")))
+	if err != nil {
+		return nil, err
+	}
+	return groupBy(p.Sources, vaultIDOf, logs, func(l models.Log) primitive.ObjectID { return l.VaultID }), nil
+}
+
+func resolveLogVault(p graphql.ResolveParams) ([]interface{}, error) {
+	vaultIDs := make([]primitive.ObjectID, len(p.Sources))
+	for i, source := range p.Sources {
+		vaultIDs[i] = source.(models.Log).VaultID
+	}
+
+	vaults, err := graphQLStateFrom(p.Context).vaults.LoadMany(p.Context, vaultIDs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(p.Sources))
+	for i, vaultID := range vaultIDs {
+		if vault, ok := vaults[vaultID]; ok {
+			values[i] = vault
+		} else {
+			values[i] = graphQLError(apierror.New(apierror.NotFound, "Vault not found"))
+		}
+	}
+	return values, nil
+}
+
+// resolveSpaceOf resolves the space of vaults or logs
+func resolveSpaceOf(p graphql.ResolveParams) ([]interface{}, error) {
+	spaceIDs := make([]primitive.ObjectID, len(p.Sources))
+	for i, source := range p.Sources {
+		switch source := source.(type) {
+		case models.Vault:
+			spaceIDs[i] = source.SpaceID
+		case models.Log:
+			spaceIDs[i] = source.SpaceID
+		}
+	}
+
+	spaces, err := graphQLStateFrom(p.Context).spaces.LoadMany(p.Context, spaceIDs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(p.Sources))
+	for i, spaceID := range spaceIDs {
+		if space, ok := spaces[spaceID]; ok {
+			values[i] = space
+		} else {
+			values[i] = graphQLError(apierror.New(apierror.NotFound, "Space not found"))
+		}
+	}
+	return values, nil
+}
+
+func (s *graphQLState) primeVaults(vaults []models.Vault) {
+	for _, vault := range vaults {
+		s.vaults.Prime(vault.ID, vault)
+	}
+}
+
+func spaceIDOf(source interface{}) primitive.ObjectID { return source.(models.Space).ID }
+func vaultIDOf(source interface{}) primitive.ObjectID { return source.(models.Vault).ID }
+
+// sourceIDs returns the distinct IDs of a batch of parents
+func sourceIDs(sources []interface{}, id func(interface{}) primitive.ObjectID) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(sources))
+	for i, source := range sources {
+		ids[i] = id(source)
+	}
+	return distinctIDs(ids)
+}
+
+// Mutations. Vault and log mutations run as single-operation batches, so they
+// check, audit and announce exactly like POST /api/batch.
+
+func createSpaceMutation(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	state := graphQLStateFrom(ctx)
+	name := args["name"].(string)
+	if strings.TrimSpace(name) == "" {
+		return nil, graphQLError(apierror.New(apierror.InvalidRequest, "name is required"))
+	}
+
+	space := models.Space{
+		ID:        primitive.NewObjectID(),
+		UserID:    state.userID,
+		Name:      name,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Role:      RoleOwner,
+	}
+
+	if _, err := db.Database.Collection("spaces").InsertOne(ctx, space); err != nil {
+		return nil, graphQLError(apierror.New(apierror.Internal, "Failed to create space"))
+	}
+
+	recordAudit(state.c, models.AuditEntry{
+		Action:     "space.create",
+		SpaceID:    &space.ID,
+		TargetType: "space",
+		TargetID:   space.ID.Hex(),
+		After:      spaceSnapshot(space),
+	})
+
+	state.written()
+	return space, nil
+}
+
+func updateSpaceMutation(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	state := graphQLStateFrom(ctx)
+	before, err := state.ownedSpace(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	name := args["name"].(string)
+	if strings.TrimSpace(name) == "" {
+		return nil, graphQLError(apierror.New(apierror.InvalidRequest, "name is required"))
+	}
+
+	collection := db.Database.Collection("spaces")
+	result, updateErr := collection.UpdateOne(ctx, versionFilter(before.ID, before.Version), bson.M{
+		"$set": bson.M{"name": name, "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if updateErr != nil {
+		return nil, graphQLError(apierror.New(apierror.Internal, "Failed to update space"))
+	}
+	if result.MatchedCount == 0 {
+		return nil, graphQLError(writeMissedError(ctx, collection, before.ID, "Space not found"))
+	}
+
+	var space models.Space
+	collection.FindOne(ctx, bson.M{"_id": before.ID}).Decode(&space)
+	space.Role = RoleOwner
+
+	recordAudit(state.c, models.AuditEntry{
+		Action:     "space.update",
+		SpaceID:    &space.ID,
+		TargetType: "space",
+		TargetID:   space.ID.Hex(),
+		Before:     spaceSnapshot(before),
+		After:      spaceSnapshot(space),
+	})
+
+	events.Publish(events.ForSpace(events.SpaceUpdated, space))
+
+	state.written()
+	return space, nil
+}
+
+func deleteSpaceMutation(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	state := graphQLStateFrom(ctx)
+	before, err := state.ownedSpace(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result, deleteErr := deleteSpaceData(ctx, versionFilter(before.ID, before.Version))
+	if deleteErr != nil {
+		return nil, graphQLError(apierror.New(apierror.Internal, "Failed to delete space"))
+	}
+	if result.DeletedCount == 0 {
+		return nil, graphQLError(writeMissedError(ctx, db.Database.Collection("spaces"), before.ID, "Space not found"))
+	}
+
+	recordAudit(state.c, models.AuditEntry{
+		Action:     "space.delete",
+		SpaceID:    &before.ID,
+		TargetType: "space",
+		TargetID:   before.ID.Hex(),
+		Before:     spaceSnapshot(before),
+	})
+
+	state.written()
+	return before.ID, nil
+}
+
+// ownedSpace loads the space named by the id argument, which only its owners
+// may change, and checks the ifVersion argument against it
+func (s *graphQLState) ownedSpace(ctx context.Context, args map[string]interface{}) (models.Space, error) {
+	spaceID, err := graphQLID(args, "id", "space")
+	if err != nil {
+		return models.Space{}, err
+	}
+
+	role, err := spaceRole(ctx, spaceID, s.userID)
+	if err != nil {
+		return models.Space{}, graphQLError(apierror.New(apierror.Internal, "Failed to check space access"))
+	}
+	if role == "" {
+		return models.Space{}, graphQLError(apierror.New(apierror.NotFound, "Space not found"))
+	}
+	if roleRanks[role] < roleRanks[RoleOwner] {
+		return models.Space{}, graphQLError(apierror.New(apierror.Forbidden, "This action requires the "+RoleOwner+" role in the space"))
+	}
+
+	var space models.Space
+	if err := db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": spaceID}).Decode(&space); err != nil {
+		return space, graphQLError(apierror.New(apierror.NotFound, "Space not found"))
+	}
+	if err := checkIfVersion(api.BatchOperation{IfVersion: versionArg(args)}, space.Version); err != nil {
+		return space, graphQLError(err)
+	}
+	return space, nil
+}
+
+// batchMutation resolves a vault or log mutation by running it as a batch of one
+func batchMutation(op, kind string) graphql.ResolveFunc {
+	return graphql.Each(func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+		operation := api.BatchOperation{
+			Op:        op,
+			Type:      kind,
+			Name:      stringArg(args, "name"),
+			Language:  stringArg(args, "language"),
+			Code:      stringArg(args, "code"),
+			IfVersion: versionArg(args),
+		}
+
+		// IDs are checked here, as batches would read "$N" as a reference to an earlier operation
+		for _, ref := range []struct {
+			arg, kind string
+			target    *string
+		}{
+			{"id", kind, &operation.ID},
+			{"spaceId", "space", &operation.SpaceID},
+			{"vaultId", "vault", &operation.VaultID},
+		} {
+			if _, ok := args[ref.arg]; !ok {
+				continue
+			}
+			id, err := graphQLID(args, ref.arg, ref.kind)
+			if err != nil {
+				return nil, err
+			}
+			*ref.target = id.Hex()
+		}
+
+		if kind == "vault" && (op == "create" || op == "move") {
+			parentID := ""
+			if args["parentId"] != nil {
+				id, err := graphQLID(args, "parentId", "parent")
+				if err != nil {
+					return nil, err
+				}
+				parentID = id.Hex()
+			}
+			operation.ParentID = &parentID
+		}
+
+		return graphQLStateFrom(ctx).batch(ctx, operation)
+	})
+}
+
+// batch applies one vault or log operation, then records its audit entries
+// and publishes its changes. Deletes return the deleted ID.
+func (s *graphQLState) batch(ctx context.Context, op api.BatchOperation) (interface{}, error) {
+	run := &batchRun{userID: s.userID, roles: map[primitive.ObjectID]string{}}
+	if failure := run.execute(ctx, []api.BatchOperation{op}); failure != nil {
+		return nil, graphQLError(failure.err)
+	}
+
+	for _, entry := range run.audits {
+		recordAudit(s.c, entry)
+	}
+	for _, event := range run.changes {
+		events.Publish(event)
+	}
+
+	s.written()
+	result := run.results[0]
+	if result.Data == nil {
+		return result.ID, nil
+	}
+	return result.Data, nil
+}
+
+// stringArg returns an optional string argument, nil when omitted or null
+func stringArg(args map[string]interface{}, name string) *string {
+	if value, ok := args[name].(string); ok {
+		return &value
+	}
+	return nil
+}
+
+// versionArg returns the ifVersion argument, nil when omitted or null
+func versionArg(args map[string]interface{}) *int64 {
+	if value, ok := args["ifVersion"].(int); ok {
+		version := int64(value)
+		return &version
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/graphql"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newGraphQLAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/graphql", GraphQL)
+	a.api.POST("/graphql", GraphQL)
+	a.api.PUT("/logs/:id", UpdateLog)
+	return a
+}
+
+// graphQLResult is a GraphQL response with its data left generic
+type graphQLResult struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []graphql.Error        `json:"errors"`
+}
+
+// code returns the API error code of the only error, or "" without errors
+func (r graphQLResult) code(t *testing.T) string {
+	t.Helper()
+	switch len(r.Errors) {
+	case 0:
+		return ""
+	case 1:
+		code, _ := r.Errors[0].Extensions["code"].(string)
+		return code
+	}
+	t.Fatalf("errors = %+v", r.Errors)
+	return ""
+}
+
+// graphQL posts a query with variables, given as name, value pairs
+func (a *testAPI) graphQL(t *testing.T, token, query string, variables ...interface{}) graphQLResult {
+	t.Helper()
+	req := graphql.Request{Query: query, Variables: map[string]interface{}{}}
+	for i := 0; i+1 < len(variables); i += 2 {
+		req.Variables[variables[i].(string)] = variables[i+1]
+	}
+	w := a.request("POST", "/api/graphql", token, req)
+	expectStatus(t, w, http.StatusOK)
+	return decodeBody[graphQLResult](t, w)
+}
+
+// createAPIToken stores a personal access token for a user with scopes
+func createAPIToken(t *testing.T, userID string, scopes ...string) string {
+	t.Helper()
+	plain, hash, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(t, "api_tokens", models.APIToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      "test",
+		Prefix:    prefix,
+		TokenHash: hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	})
+	return plain
+}
+
+// published drains the events delivered to a subscription
+func published(sub *events.Subscription) []events.Event {
+	var delivered []events.Event
+	for {
+		select {
+		case event := <-sub.Events:
+			delivered = append(delivered, event)
+		default:
+			return delivered
+		}
+	}
+}
+
+func TestGraphQLRolesPerSpace(t *testing.T) {
+	setupDB(t)
+	ada, adaToken := createUser(t, "ada@example.com")
+	bob, bobToken := createUser(t, "bob@example.com")
+	edited := createSpace(t, ada.ID.Hex(), "Edited")
+	viewed := createSpace(t, ada.ID.Hex(), "Viewed")
+	private := createSpace(t, ada.ID.Hex(), "Private")
+	addMember(t, edited.ID, bob.ID.Hex(), RoleEditor)
+	addMember(t, viewed.ID, bob.ID.Hex(), RoleViewer)
+	privateLog := createLog(t, createVault(t, private, "src", nil), "secret.py", "x = 1")
+	a := newGraphQLAPI()
+
+	roles := func(token string) string {
+		t.Helper()
+		result := a.graphQL(t, token, `{ spaces { name role } }`)
+		if len(result.Errors) > 0 {
+			t.Fatalf("errors = %+v", result.Errors)
+		}
+		var pairs []string
+		for _, space := range result.Data["spaces"].([]interface{}) {
+			space := space.(map[string]interface{})
+			pairs = append(pairs, space["name"].(string)+":"+space["role"].(string))
+		}
+		return strings.Join(pairs, ",")
+	}
+	if got := roles(adaToken); got != "Edited:owner,Private:owner,Viewed:owner" {
+		t.Errorf("Ada's spaces = %s", got)
+	}
+	if got := roles(bobToken); got != "Edited:editor,Viewed:viewer" {
+		t.Errorf("Bob's spaces = %s", got)
+	}
+
+	// What Bob can't see is null, as if it didn't exist
+	result := a.graphQL(t, bobToken, `query($space: ID!, $vault: ID!, $log: ID!) {
+		space(id: $space) { name } vault(id: $vault) { name } log(id: $log) { code }
+	}`, "space", private.ID.Hex(), "vault", privateLog.VaultID.Hex(), "log", privateLog.ID.Hex())
+	if len(result.Errors) > 0 || result.Data["space"] != nil || result.Data["vault"] != nil || result.Data["log"] != nil {
+		t.Errorf("Bob sees the private space as %+v", result)
+	}
+	result = a.graphQL(t, adaToken, `query($log: ID!) { log(id: $log) { code space { role } } }`, "log", privateLog.ID.Hex())
+	if got := result.Data["log"].(map[string]interface{}); got["code"] != "x = 1" {
+		t.Errorf("Ada sees the log as %+v", got)
+	}
+	if code := a.graphQL(t, adaToken, `{ space(id: "nope") { name } }`).code(t); code != string(apierror.InvalidID) {
+		t.Errorf("a malformed ID gave %s", code)
+	}
+
+	// Mutations follow the same roles as REST
+	tests := []struct {
+		name, query string
+		variables   []interface{}
+		want        apierror.Code
+	}{
+		{"editor creates a vault", `mutation($id: ID!) { createVault(spaceId: $id, name: "lib") { id } }`, []interface{}{"id", edited.ID.Hex()}, ""},
+		{"viewer creates a vault", `mutation($id: ID!) { createVault(spaceId: $id, name: "lib") { id } }`, []interface{}{"id", viewed.ID.Hex()}, apierror.Forbidden},
+		{"outsider creates a vault", `mutation($id: ID!) { createVault(spaceId: $id, name: "lib") { id } }`, []interface{}{"id", private.ID.Hex()}, apierror.NotFound},
+		{"outsider edits a log", `mutation($id: ID!) { updateLog(id: $id, code: "stolen") { id } }`, []interface{}{"id", privateLog.ID.Hex()}, apierror.NotFound},
+		{"editor renames the space", `mutation($id: ID!) { updateSpace(id: $id, name: "Mine") { id } }`, []interface{}{"id", edited.ID.Hex()}, apierror.Forbidden},
+		{"editor deletes the space", `mutation($id: ID!) { deleteSpace(id: $id) }`, []interface{}{"id", edited.ID.Hex()}, apierror.Forbidden},
+		{"outsider deletes the space", `mutation($id: ID!) { deleteSpace(id: $id) }`, []interface{}{"id", private.ID.Hex()}, apierror.NotFound},
+	}
+	for _, tt := range tests {
+		if code := a.graphQL(t, bobToken, tt.query, tt.variables...).code(t); code != string(tt.want) {
+			t.Errorf("%s: error code %q, want %q", tt.name, code, tt.want)
+		}
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	var stored models.Log
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": privateLog.ID}).Decode(&stored); err != nil || stored.Code != "x = 1" {
+		t.Errorf("the private log is now %q, %v", stored.Code, err)
+	}
+}
+
+func TestGraphQLScopes(t *testing.T) {
+	setupDB(t)
+	ada, _ := createUser(t, "ada@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	readOnly := createAPIToken(t, ada.ID.Hex(), auth.ScopeRead)
+	writeOnly := createAPIToken(t, ada.ID.Hex(), auth.ScopeWrite)
+	both := createAPIToken(t, ada.ID.Hex(), auth.ScopeRead, auth.ScopeWrite)
+	a := newGraphQLAPI()
+
+	query := graphql.Request{Query: `{ spaces { name } }`}
+	mutation := graphql.Request{Query: `mutation($id: ID!) { updateSpace(id: $id, name: "Renamed") { name } }`, Variables: map[string]interface{}{"id": space.ID.Hex()}}
+
+	expectStatus(t, a.request("POST", "/api/graphql", readOnly, query), http.StatusOK)
+	expectError(t, a.request("POST", "/api/graphql", writeOnly, query), http.StatusForbidden, apierror.InsufficientScope)
+	expectError(t, a.request("POST", "/api/graphql", readOnly, mutation), http.StatusForbidden, apierror.InsufficientScope)
+
+	// GET runs queries only, whatever the token may do
+	expectStatus(t, a.request("GET", "/api/graphql?query="+url.QueryEscape(query.Query), readOnly, nil), http.StatusOK)
+	w := a.request("GET", "/api/graphql?query="+url.QueryEscape(mutation.Query)+"&variables="+url.QueryEscape(`{"id": "`+space.ID.Hex()+`"}`), both, nil)
+	expectError(t, w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed)
+	if w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("Allow = %q", w.Header().Get("Allow"))
+	}
+
+	// Nothing was renamed until a token with the write scope asked
+	if result := a.graphQL(t, both, mutation.Query, "id", space.ID.Hex()); result.code(t) != "" {
+		t.Fatalf("errors = %+v", result.Errors)
+	}
+	if actions := auditActions(t, space.ID.Hex()); strings.Join(actions, ",") != "space.update" {
+		t.Errorf("audit = %v", actions)
+	}
+}
+
+func TestGraphQLMutationsMatchREST(t *testing.T) {
+	setupDB(t)
+	ada, token := createUser(t, "ada@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	vault := createVault(t, space, "src", nil)
+	viaREST := createLog(t, vault, "rest.py", "a = 1")
+	viaGraphQL := createLog(t, vault, "graphql.py", "a = 1")
+	a := newGraphQLAPI()
+
+	sub := events.Subscribe(space.ID)
+	defer sub.Close()
+
+	// A stale version fails like a stale If-Match, and changes nothing
+	update := `mutation($id: ID!, $version: Int) { updateLog(id: $id, code: "a = 2", ifVersion: $version) { code version } }`
+	result := a.graphQL(t, token, update, "id", viaGraphQL.ID.Hex(), "version", viaGraphQL.Version+1)
+	if result.code(t) != string(apierror.PreconditionFailed) || result.Errors[0].Extensions["status"] != float64(http.StatusPreconditionFailed) {
+		t.Errorf("a stale ifVersion gave %+v", result.Errors)
+	}
+	expectError(t, a.request("PUT", "/api/logs/"+viaREST.ID.Hex(), token, api.UpdateLogRequest{Code: "a = 2"}, "If-Match", versionHeader(viaREST.Version+1)),
+		http.StatusPreconditionFailed, apierror.PreconditionFailed)
+	if delivered := published(sub); len(delivered) != 0 {
+		t.Errorf("failed updates published %+v", delivered)
+	}
+
+	// The same update through each API is audited and announced the same way
+	result = a.graphQL(t, token, update, "id", viaGraphQL.ID.Hex(), "version", viaGraphQL.Version)
+	if got, _ := json.Marshal(result.Data); result.code(t) != "" || string(got) != `{"updateLog":{"code":"a = 2","version":2}}` {
+		t.Errorf("updateLog = %s, %+v", got, result.Errors)
+	}
+	expectStatus(t, a.request("PUT", "/api/logs/"+viaREST.ID.Hex(), token, api.UpdateLogRequest{Code: "a = 2"}, "If-Match", versionHeader(viaREST.Version)), http.StatusOK)
+
+	graphQLActions, restActions := auditActions(t, viaGraphQL.ID.Hex()), auditActions(t, viaREST.ID.Hex())
+	if strings.Join(graphQLActions, ",") != "log.update" || strings.Join(restActions, ",") != "log.update" {
+		t.Errorf("audit through GraphQL %v, through REST %v", graphQLActions, restActions)
+	}
+	delivered := published(sub)
+	if len(delivered) != 2 || delivered[0].ID != viaGraphQL.ID || delivered[1].ID != viaREST.ID ||
+		delivered[0].Type != events.LogUpdated || delivered[1].Type != events.LogUpdated || delivered[0].Version != 2 {
+		t.Errorf("events = %+v", delivered)
+	}
+
+	// Quotas apply, with the quota in the details
+	t.Setenv("QUOTA_SPACE_MAX_LOGS", "2")
+	result = a.graphQL(t, token, `mutation($vault: ID!) { createLog(vaultId: $vault, name: "extra.py") { id } }`, "vault", vault.ID.Hex())
+	if result.code(t) != string(apierror.QuotaExceeded) {
+		t.Fatalf("creating a third log gave %+v", result.Errors)
+	}
+	details, _ := result.Errors[0].Extensions["details"].(map[string]interface{})
+	if quota, _ := details["quota"].(map[string]interface{}); quota["name"] != QuotaLogs || quota["scope"] != "space" || quota["limit"] != float64(2) {
+		t.Errorf("quota details = %+v", result.Errors[0].Extensions)
+	}
+
+	// Later fields in the same request see earlier mutations. The failed field
+	// is non-null, so it nulls the data, but the first rename stays applied.
+	result = a.graphQL(t, token, `mutation($space: ID!, $version: Int) {
+		updateSpace(id: $space, name: "Renamed", ifVersion: $version) { version }
+		stale: updateSpace(id: $space, name: "Again", ifVersion: $version) { version }
+	}`, "space", space.ID.Hex(), "version", space.Version)
+	if result.code(t) != string(apierror.PreconditionFailed) || result.Errors[0].Path[0] != "stale" {
+		t.Errorf("renaming twice at one version gave %+v", result.Errors)
+	}
+	ctx, cancel := testContext()
+	defer cancel()
+	var renamed models.Space
+	if err := db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": space.ID}).Decode(&renamed); err != nil || renamed.Name != "Renamed" || renamed.Version != 2 {
+		t.Errorf("space = %+v, %v", renamed, err)
+	}
+	if delivered := published(sub); len(delivered) != 1 || delivered[0].Type != events.SpaceUpdated {
+		t.Errorf("renaming published %+v", delivered)
+	}
+}
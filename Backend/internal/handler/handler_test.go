@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/db/dbtest"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SECRET", "handler-test-secret")
+	auth.Init()
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// setupDB gives a test an empty database and fresh in-memory rate limits
+func setupDB(t *testing.T) {
+	t.Helper()
+	dbtest.Setup(t)
+	ratelimit.Default = ratelimit.NewMemoryStore()
+}
+
+// testAPI serves handlers under /api behind the real authentication middleware
+type testAPI struct {
+	engine *gin.Engine
+	api    *gin.RouterGroup
+}
+
+func newTestAPI() *testAPI {
+	r := gin.New()
+	r.SetTrustedProxies(nil) // Like the API without TRUSTED_PROXIES
+	r.Use(apierror.Recovery(), middleware.RequestIDMiddleware())
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(apierror.NoRoute)
+	r.NoMethod(apierror.NoMethod)
+	return &testAPI{engine: r, api: r.Group("/api", middleware.AuthMiddleware())}
+}
+
+// request sends body as JSON (a string or []byte is sent as is) with token as
+// the bearer token, if any; headers are name, value pairs
+func (a *testAPI) request(method, target, token string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	case []byte:
+		reader = bytes.NewBuffer(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+		reader = bytes.NewBuffer(data)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	a.engine.ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody decodes a JSON response body, failing the test if it can't
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+// expectError checks that a response is the error envelope with status and code
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, code apierror.Code) apierror.Error {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, status, w.Body.String())
+	}
+	body := decodeBody[apierror.Error](t, w)
+	if body.Code != code {
+		t.Fatalf("code = %s, want %s; body %s", body.Code, code, w.Body.String())
+	}
+	return body
+}
+
+// expectStatus checks a response's status
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, status, w.Body.String())
+	}
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+// insert stores documents in a collection
+func insert(t *testing.T, collection string, docs ...interface{}) {
+	t.Helper()
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := db.Database.Collection(collection).InsertMany(ctx, docs); err != nil {
+		t.Fatalf("Failed to insert into %s: %v", collection, err)
+	}
+}
+
+// createUser stores an account with the password "password" and returns it with a session token
+func createUser(t *testing.T, email string) (models.User, string) {
+	t.Helper()
+	hash, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{
+		ID:           primitive.NewObjectID(),
+		Email:        email,
+		Name:         email,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	insert(t, "users", user)
+
+	token, _, err := auth.IssueSessionToken(user.ID.Hex(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// createSpace stores a space owned by userID
+func createSpace(t *testing.T, userID, name string) models.Space {
+	t.Helper()
+	space := models.Space{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      name,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	insert(t, "spaces", space)
+	return space
+}
+
+// addMember gives userID a role in a space
+func addMember(t *testing.T, spaceID primitive.ObjectID, userID, role string) {
+	t.Helper()
+	insert(t, "space_members", models.SpaceMember{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   spaceID,
+		UserID:    userID,
+		Role:      role,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+}
+
+// createVault stores a vault in a space, under parent if it isn't nil
+func createVault(t *testing.T, space models.Space, name string, parent *models.Vault) models.Vault {
+	t.Helper()
+	vault := models.Vault{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   space.ID,
+		UserID:    space.UserID,
+		Name:      name,
+		Path:      name,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if parent != nil {
+		vault.ParentID = &parent.ID
+		vault.Path = parent.Path + "/" + name
+	}
+	insert(t, "vaults", vault)
+	return vault
+}
+
+// createLog stores a log in a vault
+func createLog(t *testing.T, vault models.Vault, name, code string) models.Log {
+	t.Helper()
+	log := models.Log{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   vault.SpaceID,
+		VaultID:   vault.ID,
+		UserID:    vault.UserID,
+		Name:      name,
+		Path:      path.Join(vault.Path, name),
+		Language:  models.InferLanguageFromFilename(name),
+		Code:      code,
+		Version:   1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	insert(t, "logs", log)
+	return log
+}
+
+// findLog reads a log back from the database, reporting whether it still exists
+func findLog(t *testing.T, log models.Log) (models.Log, bool) {
+	t.Helper()
+	ctx, cancel := testContext()
+	defer cancel()
+	var found models.Log
+	err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": log.ID}).Decode(&found)
+	return found, err == nil
+}
+
+// fakeServer serves handler until the test ends and returns its URL
+func fakeServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.URL
+}
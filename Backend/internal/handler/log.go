@@ -4,50 +4,59 @@ import (
 	"context"
 	"net/http"
 	"path"
+	"strings"
 	"time"
 
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
 	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
 	"codeflow-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // CreateLog creates a new log (code file)
 func CreateLog(c *gin.Context) {
-	var req struct {
-		SpaceID  string `json:"spaceId" binding:"required"`
-		VaultID  string `json:"vaultId" binding:"required"`
-		Name     string `json:"name" binding:"required"`
-		Code     string `json:"code"`
-		Language string `json:"language,omitempty"`
-	}
+	userID := currentUserID(c)
+
+	var req api.CreateLogRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
 		return
 	}
 
 	spaceID, err := primitive.ObjectIDFromHex(req.SpaceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
 		return
 	}
 
 	vaultID, err := primitive.ObjectIDFromHex(req.VaultID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vault ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid vault ID")
 		return
 	}
 
 	// Get vault to construct full path
-	vaultsCollection := db.Database.Collection("vaults")
-	ctx := context.Background()
-	var vault models.Vault
-	err = vaultsCollection.FindOne(ctx, bson.M{"_id": vaultID}).Decode(&vault)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Vault not found"})
+	vault, ok := loadVault(c, vaultID, RoleEditor)
+	if !ok {
+		return
+	}
+	if vault.SpaceID != spaceID {
+		apierror.Abort(c, apierror.NotFound, "Vault not found")
+		return
+	}
+
+	collection := db.Database.Collection("logs")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !enforceLogQuota(c, ctx, spaceID, userID, req.Code) {
 		return
 	}
 
@@ -66,34 +75,47 @@ func CreateLog(c *gin.Context) {
 		Path:      path.Join(vault.Path, req.Name),
 		Language:  language,
 		Code:      req.Code,
+		Version:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	collection := db.Database.Collection("logs")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	_, err = collection.InsertOne(ctx, log)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create log"})
+		apierror.Abort(c, apierror.Internal, "Failed to create log")
 		return
 	}
 
+	recordAudit(c, models.AuditEntry{
+		Action:     "log.create",
+		SpaceID:    &log.SpaceID,
+		TargetType: "log",
+		TargetID:   log.ID.Hex(),
+		After:      logSnapshot(log),
+	})
+
+	events.Publish(events.ForLog(events.LogCreated, log))
+
+	c.Header("ETag", versionETag(log.Version))
 	c.JSON(http.StatusCreated, log)
 }
 
-// GetLogs retrieves all logs for a vault or space
+// GetLogs retrieves a page of the logs in a vault or space
 func GetLogs(c *gin.Context) {
+	userID := currentUserID(c)
+
 	spaceID := c.Query("spaceId")
 	vaultID := c.Query("vaultId")
 
-	filter := bson.M{"userId": userID}
+	filter := bson.M{}
 
 	if spaceID != "" {
 		objectID, err := primitive.ObjectIDFromHex(spaceID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+		if !authorizeSpace(c, objectID, RoleViewer, "Space not found") {
 			return
 		}
 		filter["spaceId"] = objectID
@@ -102,7 +124,10 @@ func GetLogs(c *gin.Context) {
 	if vaultID != "" {
 		objectID, err := primitive.ObjectIDFromHex(vaultID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vault ID"})
+			apierror.Abort(c, apierror.InvalidID, "Invalid vault ID")
+			return
+		}
+		if _, ok := loadVault(c, objectID, RoleViewer); !ok {
 			return
 		}
 		filter["vaultId"] = objectID
@@ -112,16 +137,40 @@ func GetLogs(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, filter)
+	// Without a space or vault, list logs across every space the user can access
+	if len(filter) == 0 {
+		roles, err := accessibleSpaces(ctx, userID)
+		if err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to fetch logs")
+			return
+		}
+		filter["spaceId"] = bson.M{"$in": spaceIDList(roles)}
+	}
+
+	query, ok := parseListQuery(c, filter)
+	if !ok {
+		return
+	}
+	if language := c.Query("language"); language != "" {
+		filter["language"] = language
+	}
+
+	opts := query.findOptions()
+	// Listings often only need names and paths, so the code can be left out
+	if c.Query("omitCode") == "true" {
+		opts.SetProjection(bson.M{"code": 0})
+	}
+
+	cursor, err := collection.Find(ctx, query.pageFilter(filter), opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
+		apierror.Abort(c, apierror.Internal, "Failed to fetch logs")
 		return
 	}
 	defer cursor.Close(ctx)
 
 	var logs []models.Log
 	if err := cursor.All(ctx, &logs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode logs"})
+		apierror.Abort(c, apierror.Internal, "Failed to decode logs")
 		return
 	}
 
@@ -129,6 +178,12 @@ func GetLogs(c *gin.Context) {
 		logs = []models.Log{}
 	}
 
+	if len(logs) > query.Limit {
+		logs = logs[:query.Limit]
+		last := logs[len(logs)-1]
+		query.setNextCursor(c, pageItem{ID: last.ID, Name: last.Name, CreatedAt: last.CreatedAt, UpdatedAt: last.UpdatedAt})
+	}
+
 	c.JSON(http.StatusOK, logs)
 }
 
@@ -137,87 +192,130 @@ func GetLog(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
 		return
 	}
 
-	collection := db.Database.Collection("logs")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var log models.Log
-	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&log)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+	log, ok := loadLog(c, objectID, RoleViewer)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, log)
+	respondVersioned(c, log.Version, log)
 }
 
-// UpdateLog updates a log
+// UpdateLog updates a log's name or code
 func UpdateLog(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
 		return
 	}
 
-	var req struct {
-		Name string `json:"name,omitempty"`
-		Code string `json:"code,omitempty"`
+	var req api.UpdateLogRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Get current log
+	currentLog, ok := loadLog(c, objectID, RoleEditor)
+	if !ok {
 		return
 	}
 
-	collection := db.Database.Collection("logs")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	var changes api.LogChanges
+	if req.Name != "" {
+		changes.Name = &req.Name
+	}
+	if req.Code != "" {
+		changes.Code = &req.Code
+	}
+	saveLogChanges(c, currentLog, changes)
+}
 
-	// Get current log
-	var currentLog models.Log
-	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&currentLog)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+// saveLogChanges applies changes to a log, honouring If-Match, and writes the updated log
+func saveLogChanges(c *gin.Context, currentLog models.Log, changes api.LogChanges) {
+	if changes.Name != nil && strings.TrimSpace(*changes.Name) == "" {
+		apierror.Abort(c, apierror.InvalidRequest, "name can't be empty")
+		return
+	}
+	if changes.Language != nil && *changes.Language == "" {
+		apierror.Abort(c, apierror.InvalidRequest, "language can't be empty")
 		return
 	}
 
+	filter, ok := writeFilter(c, currentLog.ID, currentLog.Version)
+	if !ok {
+		return
+	}
+
+	collection := db.Database.Collection("logs")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"updatedAt": time.Now()}
 	update := bson.M{
-		"$set": bson.M{
-			"updatedAt": time.Now(),
-		},
+		"$set": set,
+		"$inc": bson.M{"version": 1},
 	}
 
-	if req.Name != "" {
+	if changes.Name != nil {
 		// Get vault to construct new path
 		vaultsCollection := db.Database.Collection("vaults")
 		var vault models.Vault
-		vaultsCollection.FindOne(ctx, bson.M{"_id": currentLog.VaultID}).Decode(&vault)
+		if err := vaultsCollection.FindOne(ctx, bson.M{"_id": currentLog.VaultID}).Decode(&vault); err != nil {
+			if err == mongo.ErrNoDocuments {
+				apierror.Abort(c, apierror.NotFound, "Vault not found")
+				return
+			}
+			apierror.Abort(c, apierror.Internal, "Failed to fetch vault")
+			return
+		}
 
-		newPath := path.Join(vault.Path, req.Name)
-		language := models.InferLanguageFromFilename(req.Name)
+		set["name"] = *changes.Name
+		set["path"] = path.Join(vault.Path, *changes.Name)
+		set["language"] = models.InferLanguageFromFilename(*changes.Name)
+	}
 
-		update["$set"].(bson.M)["name"] = req.Name
-		update["$set"].(bson.M)["path"] = newPath
-		update["$set"].(bson.M)["language"] = language
+	if changes.Language != nil {
+		set["language"] = *changes.Language
 	}
 
-	if req.Code != "" {
-		update["$set"].(bson.M)["code"] = req.Code
+	if changes.Code != nil {
+		if !enforceCodeSizeQuota(c, ctx, currentLog.SpaceID, len(currentLog.Code), len(*changes.Code)) {
+			return
+		}
+		set["code"] = *changes.Code
 	}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectID, "userId": userID}, update)
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update log"})
+		apierror.Abort(c, apierror.Internal, "Failed to update log")
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		respondWriteMissed(c, ctx, collection, currentLog.ID, "Log not found")
 		return
 	}
 
 	var log models.Log
-	collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&log)
+	collection.FindOne(ctx, bson.M{"_id": currentLog.ID}).Decode(&log)
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "log.update",
+		SpaceID:    &currentLog.SpaceID,
+		TargetType: "log",
+		TargetID:   currentLog.ID.Hex(),
+		Before:     logSnapshot(currentLog),
+		After:      logSnapshot(log),
+	})
+
+	events.Publish(events.ForLog(events.LogUpdated, log))
+
+	c.Header("ETag", versionETag(log.Version))
 	c.JSON(http.StatusOK, log)
 }
 
@@ -226,7 +324,17 @@ func DeleteLog(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+		return
+	}
+
+	log, ok := loadLog(c, objectID, RoleEditor)
+	if !ok {
+		return
+	}
+
+	filter, ok := writeFilter(c, objectID, log.Version)
+	if !ok {
 		return
 	}
 
@@ -234,16 +342,26 @@ func DeleteLog(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectID, "userId": userID})
+	result, err := collection.DeleteOne(ctx, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete log"})
+		apierror.Abort(c, apierror.Internal, "Failed to delete log")
 		return
 	}
 
 	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		respondWriteMissed(c, ctx, collection, objectID, "Log not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Log deleted successfully"})
+	recordAudit(c, models.AuditEntry{
+		Action:     "log.delete",
+		SpaceID:    &log.SpaceID,
+		TargetType: "log",
+		TargetID:   id,
+		Before:     logSnapshot(log),
+	})
+
+	events.Publish(events.Deleted(events.LogDeleted, log.SpaceID, log.ID))
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Log deleted successfully"})
 }
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"unicode/utf16"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	jsonPatchMediaType  = "application/json-patch+json"
+	mergePatchMediaType = "application/merge-patch+json"
+	maxTextEdits        = 1000
+	maxPatchBodyBytes   = 8 << 20
+)
+
+// PatchLog partially updates a log. It accepts a JSON object with any of name,
+// language, code or edits (application/json or application/merge-patch+json, where
+// null members are refused because no field can be removed), or
+// an RFC 6902 JSON Patch on /name, /language and /code (application/json-patch+json).
+// Edits are relative to a specific version, so they require If-Match.
+func PatchLog(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	if mediaType != "application/json" && mediaType != mergePatchMediaType && mediaType != jsonPatchMediaType {
+		apierror.Abort(c, apierror.UnsupportedMediaType, "Content-Type must be application/json, "+mergePatchMediaType+" or "+jsonPatchMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPatchBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Abort(c, apierror.PayloadTooLarge, fmt.Sprintf("Patch bodies are limited to %d MB", maxPatchBodyBytes>>20))
+			return
+		}
+		apierror.Abort(c, apierror.InvalidRequest, "Failed to read request body")
+		return
+	}
+
+	currentLog, ok := loadLog(c, objectID, RoleEditor)
+	if !ok {
+		return
+	}
+
+	var changes api.LogChanges
+	if mediaType == jsonPatchMediaType {
+		var ops []api.JSONPatchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid JSON Patch: "+err.Error())
+			return
+		}
+		if changes, ok = applyJSONPatch(c, currentLog.Name, currentLog.Language, currentLog.Code, ops); !ok {
+			return
+		}
+	} else {
+		// Merge patch would remove a member sent as null, but every field of a log is required
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(body, &members); err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid patch: "+err.Error())
+			return
+		}
+		for _, field := range []string{"name", "language", "code", "edits"} {
+			if value, ok := members[field]; ok && string(value) == "null" {
+				apierror.Abort(c, apierror.InvalidRequest, field+" can't be null")
+				return
+			}
+		}
+
+		var patch api.LogPatch
+		if err := json.Unmarshal(body, &patch); err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid patch: "+err.Error())
+			return
+		}
+		changes = patch.LogChanges
+
+		if len(patch.Edits) > 0 {
+			if patch.Code != nil {
+				apierror.Abort(c, apierror.InvalidRequest, "Send either code or edits, not both")
+				return
+			}
+			// Offsets are only meaningful against the version they were made on, so * won't do
+			if !ifMatchNamesVersions(c.GetHeader("If-Match")) {
+				apierror.Abort(c, apierror.PreconditionRequired, "Edits require If-Match with the version they were made against")
+				return
+			}
+			code, err := applyTextEdits(currentLog.Code, patch.Edits)
+			if err != nil {
+				apierror.Respond(c, apierror.New(apierror.InvalidRequest, err.Error()).WithDetails(gin.H{"codeLength": len(utf16.Encode([]rune(currentLog.Code)))}))
+				return
+			}
+			changes.Code = &code
+		}
+	}
+
+	saveLogChanges(c, currentLog, changes)
+}
+
+// applyJSONPatch runs replace, add and test operations against the editable fields
+func applyJSONPatch(c *gin.Context, name, language, code string, ops []api.JSONPatchOp) (api.LogChanges, bool) {
+	var changes api.LogChanges
+	fields := map[string]*string{"/name": &name, "/language": &language, "/code": &code}
+	changed := map[string]bool{}
+
+	for i, op := range ops {
+		field, ok := fields[op.Path]
+		if !ok {
+			apierror.Abort(c, apierror.InvalidRequest, fmt.Sprintf("Operation %d: path must be /name, /language or /code", i))
+			return changes, false
+		}
+
+		var value string
+		if op.Op != "remove" {
+			if op.Value == nil || json.Unmarshal(*op.Value, &value) != nil {
+				apierror.Abort(c, apierror.InvalidRequest, fmt.Sprintf("Operation %d: value must be a string", i))
+				return changes, false
+			}
+		}
+
+		switch op.Op {
+		case "replace", "add":
+			*field = value
+			changed[op.Path] = true
+		case "test":
+			if *field != value {
+				apierror.Respond(c, apierror.New(apierror.Conflict, fmt.Sprintf("Operation %d: test failed for %s", i, op.Path)).
+					WithDetails(gin.H{"operation": i}))
+				return changes, false
+			}
+		default:
+			apierror.Abort(c, apierror.InvalidRequest, fmt.Sprintf("Operation %d: op must be replace, add or test", i))
+			return changes, false
+		}
+	}
+
+	if changed["/name"] {
+		changes.Name = &name
+	}
+	if changed["/language"] {
+		changes.Language = &language
+	}
+	if changed["/code"] {
+		changes.Code = &code
+	}
+	return changes, true
+}
+
+// applyTextEdits applies non-overlapping range replacements. Every range refers
+// to the original code, so the order of edits in the request doesn't matter.
+func applyTextEdits(code string, edits []api.TextEdit) (string, error) {
+	if len(edits) > maxTextEdits {
+		return "", fmt.Errorf("At most %d edits are allowed", maxTextEdits)
+	}
+
+	units := utf16.Encode([]rune(code))
+	sorted := append([]api.TextEdit(nil), edits...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var out []uint16
+	pos := 0
+	for _, edit := range sorted {
+		if edit.Start < pos || edit.End < edit.Start || edit.End > len(units) {
+			return "", fmt.Errorf("Edit range %d-%d is out of bounds or overlaps another edit", edit.Start, edit.End)
+		}
+		if splitsSurrogatePair(units, edit.Start) || splitsSurrogatePair(units, edit.End) {
+			return "", fmt.Errorf("Edit range %d-%d splits a character", edit.Start, edit.End)
+		}
+		out = append(out, units[pos:edit.Start]...)
+		out = append(out, utf16.Encode([]rune(edit.Text))...)
+		pos = edit.End
+	}
+	out = append(out, units[pos:]...)
+
+	return string(utf16.Decode(out)), nil
+}
+
+// splitsSurrogatePair reports whether offset falls between the halves of a surrogate pair
+func splitsSurrogatePair(units []uint16, offset int) bool {
+	return offset > 0 && offset < len(units) && units[offset] >= 0xDC00 && units[offset] <= 0xDFFF
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newLogAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/logs/:id", GetLog)
+	a.api.PUT("/logs/:id", UpdateLog)
+	a.api.PATCH("/logs/:id", PatchLog)
+	return a
+}
+
+func TestUpdateLogIgnoresEmptyFields(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	log := createLog(t, createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil), "main.py", "print(1)\n")
+	a := newLogAPI()
+	target := "/api/logs/" + log.ID.Hex()
+
+	w := a.request("PUT", target, token, `{"name": "", "code": ""}`)
+	expectStatus(t, w, http.StatusOK)
+	if got := decodeBody[models.Log](t, w); got.Code != log.Code || got.Name != log.Name {
+		t.Errorf("PUT with empty fields changed the log to %q, %q", got.Name, got.Code)
+	}
+
+	w = a.request("PUT", target, token, api.UpdateLogRequest{Name: "app.js"})
+	expectStatus(t, w, http.StatusOK)
+	if got := decodeBody[models.Log](t, w); got.Name != "app.js" || got.Path != "src/app.js" || got.Language != "javascript" || got.Code != log.Code {
+		t.Errorf("renamed log = %+v", got)
+	}
+}
+
+func TestRenameLogNeedsItsVault(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	vault := createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil)
+	log := createLog(t, vault, "main.py", "print(1)\n")
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := db.Database.Collection("vaults").DeleteOne(ctx, bson.M{"_id": vault.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, newLogAPI().request("PUT", "/api/logs/"+log.ID.Hex(), token, api.UpdateLogRequest{Name: "app.py"}), http.StatusNotFound, "NOT_FOUND")
+	if got, _ := findLog(t, log); got.Name != "main.py" || got.Version != log.Version {
+		t.Errorf("log after a failed rename = %+v", got)
+	}
+}
+
+func TestPatchLogFieldPresence(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	log := createLog(t, createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil), "main.py", "print(1)\n")
+	a := newLogAPI()
+	target := "/api/logs/" + log.ID.Hex()
+	mergePatch := []string{"Content-Type", mergePatchMediaType}
+
+	for _, body := range []string{`{"code": null}`, `{"name": null}`, `{"language": null}`, `{"edits": null}`} {
+		expectError(t, a.request("PATCH", target, token, body, mergePatch...), http.StatusBadRequest, "INVALID_REQUEST")
+	}
+	expectError(t, a.request("PATCH", target, token, `[{"op": "replace", "path": "/code", "value": null}]`, "Content-Type", jsonPatchMediaType),
+		http.StatusBadRequest, "INVALID_REQUEST")
+
+	// A present empty string is applied, unlike with PUT
+	w := a.request("PATCH", target, token, `{"code": ""}`, mergePatch...)
+	expectStatus(t, w, http.StatusOK)
+	if got := decodeBody[models.Log](t, w); got.Code != "" || got.Name != log.Name {
+		t.Errorf("log after clearing the code = %+v", got)
+	}
+}
+
+func TestPatchLogBodyLimit(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	log := createLog(t, createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil), "main.py", "print(1)\n")
+
+	body := `{"code": "` + strings.Repeat("x", maxPatchBodyBytes) + `"}`
+	expectError(t, newLogAPI().request("PATCH", "/api/logs/"+log.ID.Hex(), token, body), http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE")
+	if got, _ := findLog(t, log); got.Code != log.Code {
+		t.Errorf("a truncated patch was applied")
+	}
+}
+
+func TestPatchLogEditsNeedCurrentVersion(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	log := createLog(t, createVault(t, createSpace(t, user.ID.Hex(), "Space"), "src", nil), "main.py", "a = '😀'\n")
+	a := newLogAPI()
+	target := "/api/logs/" + log.ID.Hex()
+
+	// Offsets are UTF-16 code units, so the emoji is two units wide
+	edits := api.LogPatch{Edits: []api.TextEdit{{Start: 5, End: 7, Text: "🎉"}, {Start: 0, End: 1, Text: "b"}}}
+	expectError(t, a.request("PATCH", target, token, edits), http.StatusPreconditionRequired, "PRECONDITION_REQUIRED")
+	for _, wildcard := range []string{"*", versionHeader(log.Version) + ", *"} {
+		expectError(t, a.request("PATCH", target, token, edits, "If-Match", wildcard), http.StatusPreconditionRequired, "PRECONDITION_REQUIRED")
+	}
+
+	split := api.LogPatch{Edits: []api.TextEdit{{Start: 6, End: 7, Text: "x"}}}
+	expectError(t, a.request("PATCH", target, token, split, "If-Match", versionHeader(log.Version)), http.StatusBadRequest, "INVALID_REQUEST")
+
+	w := a.request("PATCH", target, token, edits, "If-Match", versionHeader(log.Version))
+	expectStatus(t, w, http.StatusOK)
+	updated := decodeBody[models.Log](t, w)
+	if updated.Code != "b = '🎉'\n" || updated.Version != log.Version+1 {
+		t.Errorf("log after edits = %q at version %d", updated.Code, updated.Version)
+	}
+	if etag := w.Header().Get("ETag"); etag != versionHeader(updated.Version) {
+		t.Errorf("ETag = %s, want %s", etag, versionHeader(updated.Version))
+	}
+
+	// The same edits against the old version are refused with the current one
+	w = a.request("PATCH", target, token, edits, "If-Match", versionHeader(log.Version))
+	body := expectError(t, w, http.StatusPreconditionFailed, "PRECONDITION_FAILED")
+	if details, _ := body.Details.(map[string]interface{}); details["version"] != float64(updated.Version) {
+		t.Errorf("details = %v, want the current version", body.Details)
+	}
+}
@@ -0,0 +1,540 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetSpaceMembers lists everyone with access to a space, starting with its creator
+func GetSpaceMembers(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleViewer, "Space not found") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var space models.Space
+	if err := db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": spaceID}).Decode(&space); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Space not found")
+		return
+	}
+
+	cursor, err := db.Database.Collection("space_members").Find(ctx, bson.M{"spaceId": spaceID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch members")
+		return
+	}
+	var members []models.SpaceMember
+	if err := cursor.All(ctx, &members); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode members")
+		return
+	}
+
+	views := []api.SpaceMemberView{{UserID: space.UserID, Role: RoleOwner, CreatedAt: space.CreatedAt}}
+	for _, member := range members {
+		views = append(views, api.SpaceMemberView{UserID: member.UserID, Role: member.Role, CreatedAt: member.CreatedAt})
+	}
+
+	// Attach names and emails for members with accounts
+	users := lookupUsers(ctx, views)
+	for i := range views {
+		if user, exists := users[views[i].UserID]; exists {
+			views[i].Name = user.Name
+			views[i].Email = user.Email
+		}
+	}
+
+	c.JSON(http.StatusOK, views)
+}
+
+// UpdateSpaceMember changes a member's role
+func UpdateSpaceMember(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	var req api.UpdateSpaceMemberRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if !isValidRole(req.Role) {
+		apierror.Abort(c, apierror.InvalidRequest, "Invalid role")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleOwner, "Space not found") {
+		return
+	}
+
+	collection := db.Database.Collection("space_members")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"role":      req.Role,
+			"updatedAt": now,
+		},
+	}
+
+	// Fetch the previous role for the audit log
+	var member models.SpaceMember
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"spaceId": spaceID, "userId": c.Param("userId")}, update, opts).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		// The creator has no membership record and always stays an owner
+		apierror.Abort(c, apierror.NotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to update member")
+		return
+	}
+
+	previousRole := member.Role
+	member.Role = req.Role
+	member.UpdatedAt = now
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "member.update",
+		SpaceID:    &spaceID,
+		TargetType: "member",
+		TargetID:   member.UserID,
+		Before:     auditSnapshot{"role": previousRole},
+		After:      auditSnapshot{"role": member.Role},
+	})
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveSpaceMember removes a member from a space; members may also remove themselves
+func RemoveSpaceMember(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	memberID := c.Param("userId")
+	minRole := RoleOwner
+	if memberID == currentUserID(c) {
+		minRole = RoleViewer
+	}
+
+	if !authorizeSpace(c, spaceID, minRole, "Space not found") {
+		return
+	}
+
+	collection := db.Database.Collection("space_members")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var member models.SpaceMember
+	err = collection.FindOneAndDelete(ctx, bson.M{"spaceId": spaceID, "userId": memberID}).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		apierror.Abort(c, apierror.NotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to remove member")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "member.remove",
+		SpaceID:    &spaceID,
+		TargetType: "member",
+		TargetID:   memberID,
+		Before:     auditSnapshot{"role": member.Role},
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Member removed successfully"})
+}
+
+// invitationLifetime is how long an invitee has to accept
+const invitationLifetime = 7 * 24 * time.Hour
+
+// CreateSpaceInvitation invites someone by email to join a space with a role.
+// The response carries the invitation token for the owner to send to the invitee.
+func CreateSpaceInvitation(c *gin.Context) {
+	userID := currentUserID(c)
+
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	var req api.CreateSpaceInvitationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if !isValidRole(req.Role) {
+		apierror.Abort(c, apierror.InvalidRequest, "Invalid role")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleOwner, "Space not found") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var space models.Space
+	if err := db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": spaceID}).Decode(&space); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Space not found")
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+
+	// Reject invitations for people who already have access
+	var invitee models.User
+	if err := db.Database.Collection("users").FindOne(ctx, bson.M{"email": email}).Decode(&invitee); err == nil {
+		role, err := spaceRole(ctx, spaceID, invitee.ID.Hex())
+		if err == nil && role != "" {
+			apierror.Abort(c, apierror.Conflict, "User is already a member of this space")
+			return
+		}
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to generate invitation token")
+		return
+	}
+
+	// Re-inviting the same email replaces the pending invitation's role and token
+	// and gives the invitee a fresh week
+	now := time.Now()
+	invitation := models.SpaceInvitation{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   spaceID,
+		SpaceName: space.Name,
+		Email:     email,
+		Role:      req.Role,
+		InvitedBy: userID,
+		TokenHash: auth.HashAPIToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationLifetime),
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{
+		"$set": bson.M{
+			"role":      invitation.Role,
+			"spaceName": invitation.SpaceName,
+			"invitedBy": invitation.InvitedBy,
+			"tokenHash": invitation.TokenHash,
+			"expiresAt": invitation.ExpiresAt,
+		},
+		"$setOnInsert": bson.M{
+			"_id":       invitation.ID,
+			"createdAt": invitation.CreatedAt,
+		},
+	}
+	err = db.Database.Collection("space_invitations").
+		FindOneAndUpdate(ctx, bson.M{"spaceId": spaceID, "email": email}, update, opts).
+		Decode(&invitation)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create invitation")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "invitation.create",
+		SpaceID:    &spaceID,
+		TargetType: "invitation",
+		TargetID:   invitation.ID.Hex(),
+		After:      auditSnapshot{"email": invitation.Email, "role": invitation.Role},
+	})
+
+	c.JSON(http.StatusCreated, api.CreatedInvitation{Token: token, Invitation: invitation})
+}
+
+// GetSpaceInvitations lists pending invitations for a space
+func GetSpaceInvitations(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleOwner, "Space not found") {
+		return
+	}
+
+	findInvitations(c, bson.M{"spaceId": spaceID})
+}
+
+// DeleteSpaceInvitation withdraws a pending invitation
+func DeleteSpaceInvitation(c *gin.Context) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	invitationID, err := primitive.ObjectIDFromHex(c.Param("invitationId"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid invitation ID")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleOwner, "Space not found") {
+		return
+	}
+
+	collection := db.Database.Collection("space_invitations")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": invitationID, "spaceId": spaceID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete invitation")
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		apierror.Abort(c, apierror.NotFound, "Invitation not found")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "invitation.delete",
+		SpaceID:    &spaceID,
+		TargetType: "invitation",
+		TargetID:   invitationID.Hex(),
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Invitation deleted successfully"})
+}
+
+// GetMyInvitations lists the unexpired invitation the token in the request body
+// is for, so the invitee can see what they are accepting. The token travels in
+// the body rather than the URL to keep it out of logs. Invitations aren't listed
+// by email because account emails aren't verified.
+func GetMyInvitations(c *gin.Context) {
+	var req api.InvitationTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	findInvitations(c, bson.M{"tokenHash": auth.HashAPIToken(req.Token), "expiresAt": bson.M{"$gt": time.Now()}})
+}
+
+// AcceptInvitation turns the invitation in the :id param into a membership for
+// the current user. The token is single use: the invitation is gone once accepted,
+// and it is refused once expired or when the space has since been deleted.
+func AcceptInvitation(c *gin.Context) {
+	user, ok := loadCurrentUser(c)
+	if !ok {
+		return
+	}
+
+	// Take the invitation before adding the member so a token can't be accepted twice
+	invitation, ok := takeMyInvitation(c)
+	if !ok {
+		return
+	}
+	if invitation.Expired() {
+		apierror.Abort(c, apierror.NotFound, "Invitation not found or expired")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": invitation.SpaceID}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		apierror.Abort(c, apierror.NotFound, "Space not found")
+		return
+	}
+	if err != nil {
+		db.Database.Collection("space_invitations").InsertOne(ctx, invitation) // Leave the invitation to retry with
+		apierror.Abort(c, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+
+	member := models.SpaceMember{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   invitation.SpaceID,
+		UserID:    user.ID.Hex(),
+		Role:      invitation.Role,
+		InvitedBy: invitation.InvitedBy,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	_, err = db.Database.Collection("space_members").InsertOne(ctx, member)
+	if mongo.IsDuplicateKeyError(err) {
+		apierror.Abort(c, apierror.Conflict, "You are already a member of this space")
+		return
+	}
+	if err != nil {
+		db.Database.Collection("space_invitations").InsertOne(ctx, invitation) // Leave the invitation to retry with
+		apierror.Abort(c, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "invitation.accept",
+		SpaceID:    &invitation.SpaceID,
+		TargetType: "invitation",
+		TargetID:   invitation.ID.Hex(),
+		After:      auditSnapshot{"userId": member.UserID, "role": member.Role},
+	})
+
+	c.JSON(http.StatusOK, member)
+}
+
+// DeclineInvitation discards the invitation in the :id param
+func DeclineInvitation(c *gin.Context) {
+	invitation, ok := takeMyInvitation(c)
+	if !ok {
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "invitation.decline",
+		SpaceID:    &invitation.SpaceID,
+		TargetType: "invitation",
+		TargetID:   invitation.ID.Hex(),
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Invitation declined"})
+}
+
+// findInvitations responds with the invitations matching filter
+func findInvitations(c *gin.Context, filter bson.M) {
+	collection := db.Database.Collection("space_invitations")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch invitations")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var invitations []models.SpaceInvitation
+	if err := cursor.All(ctx, &invitations); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode invitations")
+		return
+	}
+
+	if invitations == nil {
+		invitations = []models.SpaceInvitation{}
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// takeMyInvitation deletes and returns the invitation in the :id param if the
+// request body carries its token
+func takeMyInvitation(c *gin.Context) (models.SpaceInvitation, bool) {
+	var invitation models.SpaceInvitation
+
+	invitationID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid invitation ID")
+		return invitation, false
+	}
+
+	var req api.InvitationTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return invitation, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.Database.Collection("space_invitations").FindOneAndDelete(ctx, bson.M{"_id": invitationID, "tokenHash": auth.HashAPIToken(req.Token)}).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		apierror.Abort(c, apierror.NotFound, "Invitation not found")
+		return invitation, false
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch invitation")
+		return invitation, false
+	}
+
+	return invitation, true
+}
+
+// loadCurrentUser fetches the authenticated user's account
+func loadCurrentUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	objectID, err := primitive.ObjectIDFromHex(currentUserID(c))
+	if err != nil {
+		apierror.Abort(c, apierror.Forbidden, "This action requires a user account")
+		return user, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.Database.Collection("users").FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		apierror.Abort(c, apierror.NotFound, "User not found")
+		return user, false
+	}
+
+	return user, true
+}
+
+// lookupUsers fetches the accounts for the given members, keyed by user ID
+func lookupUsers(ctx context.Context, views []api.SpaceMemberView) map[string]models.User {
+	ids := make([]primitive.ObjectID, 0, len(views))
+	for _, view := range views {
+		if objectID, err := primitive.ObjectIDFromHex(view.UserID); err == nil {
+			ids = append(ids, objectID)
+		}
+	}
+
+	users := make(map[string]models.User)
+	cursor, err := db.Database.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return users
+	}
+
+	var found []models.User
+	cursor.All(ctx, &found)
+	for _, user := range found {
+		users[user.ID.Hex()] = user
+	}
+	return users
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newMemberAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/spaces/:id/members", GetSpaceMembers)
+	a.api.GET("/spaces/:id/invitations", GetSpaceInvitations)
+	a.api.POST("/spaces/:id/invitations", CreateSpaceInvitation)
+	a.api.POST("/invitations/lookup", GetMyInvitations)
+	a.api.POST("/invitations/:id/accept", AcceptInvitation)
+	a.api.POST("/invitations/:id/decline", DeclineInvitation)
+	return a
+}
+
+// invite creates an invitation as the owner and returns it with its token
+func invite(t *testing.T, a *testAPI, token string, space models.Space, email, role string) api.CreatedInvitation {
+	t.Helper()
+	w := a.request("POST", "/api/spaces/"+space.ID.Hex()+"/invitations", token, api.CreateSpaceInvitationRequest{Email: email, Role: role})
+	expectStatus(t, w, http.StatusCreated)
+	created := decodeBody[api.CreatedInvitation](t, w)
+	if created.Token == "" || created.Invitation.Email != email || created.Invitation.Role != role {
+		t.Fatalf("created = %+v", created)
+	}
+	return created
+}
+
+func TestInvitationsRequireTheirToken(t *testing.T) {
+	setupDB(t)
+	owner, ownerToken := createUser(t, "ada@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	a := newMemberAPI()
+
+	created := invite(t, a, ownerToken, space, "bob@example.com", RoleEditor)
+	accept := "/api/invitations/" + created.Invitation.ID.Hex() + "/accept"
+
+	// Registering with the invited address isn't enough to see or accept the invitation
+	_, squatterToken := createUser(t, "bob@example.com")
+	expectError(t, a.request("POST", "/api/invitations/lookup", squatterToken, nil), http.StatusBadRequest, "INVALID_REQUEST")
+	expectError(t, a.request("POST", accept, squatterToken, api.InvitationTokenRequest{Token: "guess"}), http.StatusNotFound, "NOT_FOUND")
+	expectError(t, a.request("POST", accept, squatterToken, nil), http.StatusBadRequest, "INVALID_REQUEST")
+
+	invitee, inviteeToken := createUser(t, "bob@work.example.com")
+	w := a.request("POST", "/api/invitations/lookup", inviteeToken, api.InvitationTokenRequest{Token: created.Token})
+	expectStatus(t, w, http.StatusOK)
+	if found := decodeBody[[]models.SpaceInvitation](t, w); len(found) != 1 || found[0].ID != created.Invitation.ID {
+		t.Fatalf("invitations for the token = %+v", found)
+	}
+
+	w = a.request("POST", accept, inviteeToken, api.InvitationTokenRequest{Token: created.Token})
+	expectStatus(t, w, http.StatusOK)
+	if member := decodeBody[models.SpaceMember](t, w); member.UserID != invitee.ID.Hex() || member.Role != RoleEditor {
+		t.Errorf("member = %+v", member)
+	}
+
+	// The token is single use
+	expectError(t, a.request("POST", accept, squatterToken, api.InvitationTokenRequest{Token: created.Token}), http.StatusNotFound, "NOT_FOUND")
+	w = a.request("GET", "/api/spaces/"+space.ID.Hex()+"/members", ownerToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	if members := decodeBody[[]api.SpaceMemberView](t, w); len(members) != 2 {
+		t.Errorf("members = %+v, want the owner and the invitee", members)
+	}
+}
+
+func TestReinvitingRotatesTheToken(t *testing.T) {
+	setupDB(t)
+	owner, ownerToken := createUser(t, "ada@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	_, inviteeToken := createUser(t, "bob@example.com")
+	a := newMemberAPI()
+
+	first := invite(t, a, ownerToken, space, "bob@example.com", RoleViewer)
+	second := invite(t, a, ownerToken, space, "bob@example.com", RoleEditor)
+	if second.Invitation.ID != first.Invitation.ID {
+		t.Fatalf("re-inviting created a second invitation")
+	}
+
+	decline := "/api/invitations/" + first.Invitation.ID.Hex() + "/decline"
+	expectError(t, a.request("POST", decline, inviteeToken, api.InvitationTokenRequest{Token: first.Token}), http.StatusNotFound, "NOT_FOUND")
+	expectStatus(t, a.request("POST", decline, inviteeToken, api.InvitationTokenRequest{Token: second.Token}), http.StatusOK)
+
+	w := a.request("GET", "/api/spaces/"+space.ID.Hex()+"/invitations", ownerToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	if pending := decodeBody[[]models.SpaceInvitation](t, w); len(pending) != 0 {
+		t.Errorf("pending = %+v after declining", pending)
+	}
+}
+
+func TestOnlyOwnersInvite(t *testing.T) {
+	setupDB(t)
+	owner, _ := createUser(t, "ada@example.com")
+	editor, editorToken := createUser(t, "bob@example.com")
+	_, strangerToken := createUser(t, "eve@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	addMember(t, space.ID, editor.ID.Hex(), RoleEditor)
+	a := newMemberAPI()
+
+	body := api.CreateSpaceInvitationRequest{Email: "carol@example.com", Role: RoleViewer}
+	target := "/api/spaces/" + space.ID.Hex() + "/invitations"
+	expectError(t, a.request("POST", target, editorToken, body), http.StatusForbidden, "FORBIDDEN")
+	expectError(t, a.request("POST", target, strangerToken, body), http.StatusNotFound, "NOT_FOUND")
+}
+
+func TestExpiredInvitationsCantBeAccepted(t *testing.T) {
+	setupDB(t)
+	owner, ownerToken := createUser(t, "ada@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	_, inviteeToken := createUser(t, "bob@example.com")
+	a := newMemberAPI()
+
+	created := invite(t, a, ownerToken, space, "bob@example.com", RoleEditor)
+	if until := time.Until(created.Invitation.ExpiresAt); until < invitationLifetime-time.Minute || until > invitationLifetime {
+		t.Errorf("invitation expires in %v, want %v", until, invitationLifetime)
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	expired := bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Minute)}}
+	if _, err := db.Database.Collection("space_invitations").UpdateByID(ctx, created.Invitation.ID, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	w := a.request("POST", "/api/invitations/lookup", inviteeToken, api.InvitationTokenRequest{Token: created.Token})
+	expectStatus(t, w, http.StatusOK)
+	if found := decodeBody[[]models.SpaceInvitation](t, w); len(found) != 0 {
+		t.Errorf("lookup found an expired invitation: %+v", found)
+	}
+	accept := "/api/invitations/" + created.Invitation.ID.Hex() + "/accept"
+	expectError(t, a.request("POST", accept, inviteeToken, api.InvitationTokenRequest{Token: created.Token}), http.StatusNotFound, "NOT_FOUND")
+	if count, _ := db.Database.Collection("space_members").CountDocuments(ctx, bson.M{"spaceId": space.ID}); count != 0 {
+		t.Errorf("accepting an expired invitation added %d members", count)
+	}
+}
+
+func TestInvitationsToDeletedSpacesCantBeAccepted(t *testing.T) {
+	setupDB(t)
+	owner, ownerToken := createUser(t, "ada@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	_, inviteeToken := createUser(t, "bob@example.com")
+	a := newMemberAPI()
+
+	created := invite(t, a, ownerToken, space, "bob@example.com", RoleEditor)
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := db.Database.Collection("spaces").DeleteOne(ctx, bson.M{"_id": space.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	accept := "/api/invitations/" + created.Invitation.ID.Hex() + "/accept"
+	expectError(t, a.request("POST", accept, inviteeToken, api.InvitationTokenRequest{Token: created.Token}), http.StatusNotFound, "NOT_FOUND")
+	if count, _ := db.Database.Collection("space_members").CountDocuments(ctx, bson.M{"spaceId": space.ID}); count != 0 {
+		t.Errorf("accepting an invitation to a deleted space added %d members", count)
+	}
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const oidcCookiePath = "/api/auth/oidc"
+
+// OIDCLogin redirects the browser to the identity provider.
+// Query: ?returnTo=/path to come back to after signing in.
+func OIDCLogin(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, ok := loadOIDCProvider(c, ctx)
+	if !ok {
+		return
+	}
+
+	state, err := auth.NewOIDCState(safeReturnTo(c.Query("returnTo")))
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to start login")
+		return
+	}
+
+	cookie, err := auth.IssueOIDCState(state)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to start login")
+		return
+	}
+
+	// Lax so the cookie comes back on the provider's top-level redirect to the callback
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.OIDCStateCookieName, cookie, int(auth.OIDCStateTTL.Seconds()), oidcCookiePath, "", secureCookies(), true)
+
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state.State, state.Nonce, auth.PKCEChallenge(state.CodeVerifier)))
+}
+
+// OIDCCallback completes the login: it redeems the code, verifies the ID token,
+// finds or provisions the user and starts the same session as a local login
+func OIDCCallback(c *gin.Context) {
+	// The login state is single use
+	cookie, _ := c.Cookie(auth.OIDCStateCookieName)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.OIDCStateCookieName, "", -1, oidcCookiePath, "", secureCookies(), true)
+
+	if errCode := c.Query("error"); errCode != "" {
+		message := "Identity provider rejected the login: " + errCode
+		if description := c.Query("error_description"); description != "" {
+			message += " (" + description + ")"
+		}
+		apierror.Abort(c, apierror.Unauthorized, message)
+		return
+	}
+
+	state, err := auth.ParseOIDCState(cookie)
+	if err != nil || c.Query("state") == "" || c.Query("state") != state.State {
+		apierror.Abort(c, apierror.Unauthorized, "Login expired or invalid state, please try again")
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		apierror.Abort(c, apierror.InvalidRequest, "Missing authorization code")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	provider, ok := loadOIDCProvider(c, ctx)
+	if !ok {
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, code, state.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		apierror.Abort(c, apierror.Unauthorized, "Failed to redeem authorization code")
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		apierror.Abort(c, apierror.Unauthorized, "Invalid ID token")
+		return
+	}
+
+	user, apiErr := provisionOIDCUser(ctx, provider.Config.Issuer, claims)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	if state.ReturnTo == "" {
+		startSession(c, http.StatusOK, user)
+		return
+	}
+
+	if _, _, ok := issueSession(c, user); !ok {
+		return
+	}
+	// returnTo is a path on the frontend, which may be served from another origin
+	c.Redirect(http.StatusFound, strings.TrimSuffix(os.Getenv("OIDC_POST_LOGIN_URL"), "/")+state.ReturnTo)
+}
+
+// provisionOIDCUser finds the user for an identity provider subject. Users are matched
+// by subject first, then linked by verified email (see linkOIDCUser), and otherwise created.
+// On failure it returns the error to respond with.
+func provisionOIDCUser(ctx context.Context, issuer string, claims *auth.IDTokenClaims) (models.User, *apierror.Error) {
+	collection := db.Database.Collection("users")
+
+	var user models.User
+	err := collection.FindOne(ctx, bson.M{"oidcIssuer": issuer, "oidcSubject": claims.Subject}).Decode(&user)
+	if err == nil {
+		return user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, apierror.New(apierror.Internal, "Failed to look up user")
+	}
+
+	email := normalizeEmail(claims.Email)
+	if email == "" {
+		return user, apierror.New(apierror.InvalidRequest, "Identity provider did not return an email address")
+	}
+
+	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		// Only link an existing account when the provider vouches for the address,
+		// otherwise anyone could claim an account by setting its email at the provider
+		if !claims.EmailVerified || user.OIDCSubject != "" {
+			return user, apierror.New(apierror.Conflict, "Email already registered")
+		}
+
+		return linkOIDCUser(ctx, user, issuer, claims.Subject)
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, apierror.New(apierror.Internal, "Failed to look up user")
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+
+	user = models.User{
+		ID:          primitive.NewObjectID(),
+		Email:       email,
+		Name:        name,
+		OIDCIssuer:  issuer,
+		OIDCSubject: claims.Subject,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	_, err = collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return user, apierror.New(apierror.Conflict, "Email already registered")
+	}
+	if err != nil {
+		return user, apierror.New(apierror.Internal, "Failed to create user")
+	}
+
+	return user, nil
+}
+
+// linkOIDCUser links an existing local account to an identity provider subject.
+// Local accounts aren't verified, so whoever registered the address may not own
+// it: linking removes the password and ends every session and access token,
+// leaving the account to the owner of the address the provider vouched for.
+func linkOIDCUser(ctx context.Context, user models.User, issuer, subject string) (models.User, *apierror.Error) {
+	update := bson.M{
+		"$set":   bson.M{"oidcIssuer": issuer, "oidcSubject": subject, "updatedAt": time.Now()},
+		"$unset": bson.M{"passwordHash": ""},
+		"$inc":   bson.M{"sessionGeneration": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.Database.Collection("users").
+		FindOneAndUpdate(ctx, bson.M{"_id": user.ID, "oidcSubject": bson.M{"$exists": false}}, update, opts).
+		Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, apierror.New(apierror.Conflict, "Email already registered")
+	}
+	if err != nil {
+		return user, apierror.New(apierror.Internal, "Failed to link user")
+	}
+
+	if _, err := db.Database.Collection("api_tokens").DeleteMany(ctx, bson.M{"userId": user.ID.Hex()}); err != nil {
+		return user, apierror.New(apierror.Internal, "Failed to revoke access tokens")
+	}
+
+	log.Printf("Linked user %s to OIDC subject %s, removing their password and access tokens", user.ID.Hex(), subject)
+	return user, nil
+}
+
+// loadOIDCProvider returns the identity provider, writing an error response when it is unavailable
+func loadOIDCProvider(c *gin.Context, ctx context.Context) (*auth.OIDCProvider, bool) {
+	provider, err := auth.OIDC(ctx)
+	if errors.Is(err, auth.ErrOIDCNotConfigured) {
+		apierror.Abort(c, apierror.NotFound, "OIDC login is not configured")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		apierror.Abort(c, apierror.UpstreamError, "Identity provider unavailable")
+		return nil, false
+	}
+	return provider, true
+}
+
+// safeReturnTo only allows same-site paths, so the login can't be used as an open redirect
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		return ""
+	}
+	return returnTo
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/auth/oidctest"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	oidcOnce sync.Once
+	oidcFake *oidctest.Provider
+)
+
+// fakeOIDC configures OIDC login against an in-process provider that signs in
+// user. Discovery is cached for the process, so every test shares the provider.
+func fakeOIDC(t *testing.T, user oidctest.User) {
+	t.Helper()
+	oidcOnce.Do(func() {
+		var err error
+		oidcFake, _, err = oidctest.StartServer("codeflow", "client-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("OIDC_ISSUER", oidcFake.Issuer)
+		os.Setenv("OIDC_CLIENT_ID", "codeflow")
+		os.Setenv("OIDC_CLIENT_SECRET", "client-secret")
+		os.Setenv("OIDC_REDIRECT_URL", "http://app.example.com/api/auth/oidc/callback")
+		os.Setenv("OIDC_POST_LOGIN_URL", "http://app.example.com/")
+	})
+	oidcFake.SetUser(user)
+}
+
+func newOIDCAPI() *testAPI {
+	a := newAuthAPI()
+	a.engine.GET("/api/auth/oidc/login", OIDCLogin)
+	a.engine.GET("/api/auth/oidc/callback", OIDCCallback)
+	return a
+}
+
+// oidcLogin starts a login, lets the fake provider approve it and returns the
+// callback response
+func oidcLogin(t *testing.T, a *testAPI, returnTo string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := a.request("GET", "/api/auth/oidc/login?returnTo="+url.QueryEscape(returnTo), "", nil)
+	expectStatus(t, w, http.StatusFound)
+	stateCookie := w.Result().Cookies()[0]
+
+	authorizeURL, _ := url.Parse(w.Header().Get("Location"))
+	if q := authorizeURL.Query(); q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("login redirected to %s", authorizeURL)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authorizeURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	return a.request("GET", callback.RequestURI(), "", nil, "Cookie", stateCookie.String())
+}
+
+func TestOIDCLoginProvisionsAndFindsUsers(t *testing.T) {
+	setupDB(t)
+	fakeOIDC(t, oidctest.User{Subject: "sso-ada", Email: "Ada@Example.com", EmailVerified: true, Name: "Ada"})
+	a := newOIDCAPI()
+
+	w := oidcLogin(t, a, "")
+	expectStatus(t, w, http.StatusOK)
+	first := decodeBody[api.SessionResponse](t, w)
+	if first.User.Email != "ada@example.com" || first.User.Name != "Ada" {
+		t.Errorf("provisioned user = %+v", first.User)
+	}
+	expectStatus(t, a.request("GET", "/api/auth/me", first.Token, nil), http.StatusOK)
+
+	// The same subject signs in to the same account, even if the email changes
+	fakeOIDC(t, oidctest.User{Subject: "sso-ada", Email: "ada@elsewhere.example.com", EmailVerified: true})
+	w = oidcLogin(t, a, "/flow?log=1")
+	expectStatus(t, w, http.StatusFound)
+	if location := w.Header().Get("Location"); location != "http://app.example.com/flow?log=1" {
+		t.Errorf("redirected to %q", location)
+	}
+	var session string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == auth.SessionCookieName {
+			session = cookie.Value
+		}
+	}
+	w = a.request("GET", "/api/auth/me", session, nil)
+	expectStatus(t, w, http.StatusOK)
+	if user := decodeBody[models.User](t, w); user.ID != first.User.ID {
+		t.Errorf("second login signed in as %s, want %s", user.ID.Hex(), first.User.ID.Hex())
+	}
+}
+
+func TestOIDCLinkingEndsLocalCredentials(t *testing.T) {
+	setupDB(t)
+	local, localSession := createUser(t, "ada@example.com")
+	insert(t, "api_tokens", models.APIToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    local.ID.Hex(),
+		TokenHash: auth.HashAPIToken("cfp_planted"),
+		Scopes:    []string{auth.ScopeRead},
+		CreatedAt: time.Now(),
+	})
+	fakeOIDC(t, oidctest.User{Subject: "sso-ada", Email: "ada@example.com", EmailVerified: true})
+	a := newOIDCAPI()
+
+	w := oidcLogin(t, a, "")
+	expectStatus(t, w, http.StatusOK)
+	linked := decodeBody[api.SessionResponse](t, w)
+	if linked.User.ID != local.ID {
+		t.Fatalf("signed in as %s, want the existing account %s", linked.User.ID.Hex(), local.ID.Hex())
+	}
+	expectStatus(t, a.request("GET", "/api/auth/me", linked.Token, nil), http.StatusOK)
+
+	// Whoever registered the address before can't get back in
+	expectError(t, a.request("GET", "/api/auth/me", localSession, nil), http.StatusUnauthorized, "UNAUTHORIZED")
+	expectError(t, a.request("GET", "/api/auth/me", "cfp_planted", nil), http.StatusUnauthorized, "UNAUTHORIZED")
+	w = a.request("POST", "/api/auth/login", "", api.LoginRequest{Email: "ada@example.com", Password: "password"})
+	expectError(t, w, http.StatusUnauthorized, "UNAUTHORIZED")
+}
+
+func TestOIDCLinkConflicts(t *testing.T) {
+	setupDB(t)
+	createUser(t, "ada@example.com")
+	a := newOIDCAPI()
+
+	// An unverified address can't claim an existing account
+	fakeOIDC(t, oidctest.User{Subject: "sso-mallory", Email: "ada@example.com", EmailVerified: false})
+	expectError(t, oidcLogin(t, a, ""), http.StatusConflict, "CONFLICT")
+
+	fakeOIDC(t, oidctest.User{Subject: "sso-ada", Email: "ada@example.com", EmailVerified: true})
+	expectStatus(t, oidcLogin(t, a, ""), http.StatusOK)
+
+	// Nor can a second subject take over an account that is already linked
+	fakeOIDC(t, oidctest.User{Subject: "sso-other", Email: "ada@example.com", EmailVerified: true})
+	expectError(t, oidcLogin(t, a, ""), http.StatusConflict, "CONFLICT")
+
+	fakeOIDC(t, oidctest.User{Subject: "sso-nomail"})
+	expectError(t, oidcLogin(t, a, ""), http.StatusBadRequest, "INVALID_REQUEST")
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	setupDB(t)
+	fakeOIDC(t, oidctest.User{Subject: "sso-ada", Email: "ada@example.com", EmailVerified: true})
+	a := newOIDCAPI()
+
+	w := a.request("GET", "/api/auth/oidc/login", "", nil)
+	stateCookie := w.Result().Cookies()[0].String()
+
+	expectError(t, a.request("GET", "/api/auth/oidc/callback?code=x&state=forged", "", nil, "Cookie", stateCookie), http.StatusUnauthorized, "UNAUTHORIZED")
+	expectError(t, a.request("GET", "/api/auth/oidc/callback?code=x&state=forged", "", nil), http.StatusUnauthorized, "UNAUTHORIZED")
+
+	w = a.request("GET", "/api/auth/oidc/callback?error=access_denied&error_description=nope", "", nil, "Cookie", stateCookie)
+	body := expectError(t, w, http.StatusUnauthorized, "UNAUTHORIZED")
+	if !strings.Contains(body.Message, "access_denied") {
+		t.Errorf("message = %q", body.Message)
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	tests := map[string]string{
+		"/flow":               "/flow",
+		"//evil.example.com":  "",
+		"https://evil.com":    "",
+		"/\\evil.example.com": "",
+		"":                    "",
+	}
+	for in, want := range tests {
+		if got := safeReturnTo(in); got != want {
+			t.Errorf("safeReturnTo(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
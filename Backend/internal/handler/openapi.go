@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/graphql"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Parameters shared by several operations
+var (
+	listParams = []openapi.Param{
+		{Name: "limit", In: "query", Type: "integer", Description: "Page size, 100 by default and at most 500"},
+		{Name: "cursor", In: "query", Description: "X-Next-Cursor from the previous page"},
+		{Name: "sort", In: "query", Description: "name, createdAt or updatedAt; prefix with - for descending"},
+		{Name: "namePrefix", In: "query", Description: "Only items whose name starts with this"},
+		{Name: "updatedSince", In: "query", Description: "Only items updated at or after this RFC 3339 time"},
+	}
+	spaceIDParam      = openapi.Param{Name: "spaceId", In: "query", Required: true}
+	ifMatchParam      = openapi.Param{Name: "If-Match", In: "header", Description: "Version ETag the change is based on; a stale one gets 412"}
+	ifNoneMatchParam  = openapi.Param{Name: "If-None-Match", In: "header", Description: "ETag the client already has; a match gets 304"}
+	etagHeaders       = []openapi.Param{{Name: "ETag", Description: "Version of the returned resource"}}
+	spaceETagHeaders  = []openapi.Param{{Name: "ETag", Description: "Version of the space and the caller's role in it"}}
+	nextCursorHeaders = []openapi.Param{{Name: "X-Next-Cursor", Description: "Cursor for the next page; absent on the last page"}}
+)
+
+// apiOperations documents every route registered by server.New. The server tests fail if
+// the two disagree, see CheckOpenAPIRoutes.
+func apiOperations() []openapi.Operation {
+	return []openapi.Operation{
+		{Method: "GET", Path: "/health", Summary: "Health check", Tag: "Meta", Response: map[string]string{}},
+		{Method: "GET", Path: "/api/openapi.json", Handler: GetOpenAPI, Summary: "This document", Tag: "Meta", Response: map[string]interface{}{}},
+
+		// Accounts
+		{Method: "POST", Path: "/api/auth/register", Handler: Register, Summary: "Create an account and start a session", Tag: "Auth",
+			Body: api.RegisterRequest{}, Status: http.StatusCreated, Response: api.SessionResponse{}},
+		{Method: "POST", Path: "/api/auth/login", Handler: Login, Summary: "Start a session", Tag: "Auth",
+			Body: api.LoginRequest{}, Response: api.SessionResponse{}},
+		{Method: "POST", Path: "/api/auth/logout", Handler: Logout, Summary: "End all of the user's sessions", Tag: "Auth", Response: MessageResponse{}},
+		{Method: "GET", Path: "/api/auth/oidc/login", Handler: OIDCLogin, Summary: "Redirect to the identity provider", Tag: "Auth",
+			Params: []openapi.Param{{Name: "returnTo", In: "query", Description: "Frontend path to return to after signing in"}}, Status: http.StatusFound},
+		{Method: "GET", Path: "/api/auth/oidc/callback", Handler: OIDCCallback, Summary: "Finish signing in with the identity provider", Tag: "Auth",
+			Params: []openapi.Param{{Name: "code", In: "query"}, {Name: "state", In: "query"}}, Response: api.SessionResponse{}},
+		{Method: "GET", Path: "/api/auth/me", Handler: GetCurrentUser, Summary: "The authenticated user", Tag: "Auth", Auth: openapi.AuthAny,
+			Response: models.User{}},
+
+		{Method: "GET", Path: "/api/tokens", Handler: GetAPITokens, Summary: "List personal access tokens", Tag: "Tokens", Auth: openapi.AuthSession,
+			Response: []models.APIToken{}},
+		{Method: "POST", Path: "/api/tokens", Handler: CreateAPIToken, Summary: "Create a personal access token", Tag: "Tokens", Auth: openapi.AuthSession,
+			Body: api.CreateAPITokenRequest{}, Status: http.StatusCreated, Response: api.CreatedAPIToken{}},
+		{Method: "DELETE", Path: "/api/tokens/:id", Handler: DeleteAPIToken, Summary: "Revoke a personal access token", Tag: "Tokens", Auth: openapi.AuthSession,
+			Response: MessageResponse{}},
+
+		{Method: "POST", Path: "/api/account/deletion", Handler: RequestAccountDeletion, Summary: "Get a token confirming account deletion", Tag: "Account", Auth: openapi.AuthSession,
+			Body: api.AccountDeletionRequest{}, Response: api.AccountDeletionConfirmation{}},
+		{Method: "DELETE", Path: "/api/account", Handler: DeleteAccount, Summary: "Delete the account and its data", Tag: "Account", Auth: openapi.AuthSession,
+			Body: api.DeleteAccountRequest{}, Response: MessageResponse{}},
+		{Method: "GET", Path: "/api/account/export", Handler: ExportAccount, Summary: "Download all account data as a zip", Tag: "Account", Auth: auth.ScopeRead,
+			ResponseType: "application/zip"},
+
+		// Spaces, vaults and logs
+		{Method: "GET", Path: "/api/spaces", Handler: GetSpaces, Summary: "List spaces", Tag: "Spaces", Auth: auth.ScopeRead,
+			Params: listParams, Response: []models.Space{}, ResponseHeaders: nextCursorHeaders},
+		{Method: "GET", Path: "/api/spaces/:id", Handler: GetSpace, Summary: "Get a space", Tag: "Spaces", Auth: auth.ScopeRead,
+			Params: []openapi.Param{ifNoneMatchParam}, Response: models.Space{}, ResponseHeaders: spaceETagHeaders},
+		{Method: "POST", Path: "/api/spaces", Handler: CreateSpace, Summary: "Create a space", Tag: "Spaces", Auth: auth.ScopeWrite,
+			Body: api.SpaceRequest{}, Status: http.StatusCreated, Response: models.Space{}, ResponseHeaders: spaceETagHeaders},
+		{Method: "PUT", Path: "/api/spaces/:id", Handler: UpdateSpace, Summary: "Rename a space", Tag: "Spaces", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Body: api.SpaceRequest{}, Response: models.Space{}, ResponseHeaders: spaceETagHeaders},
+		{Method: "DELETE", Path: "/api/spaces/:id", Handler: DeleteSpace, Summary: "Delete a space and everything in it", Tag: "Spaces", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Response: MessageResponse{}},
+		{Method: "GET", Path: "/api/tree", Handler: GetTree, Summary: "The vault and log tree of a space", Tag: "Spaces", Auth: auth.ScopeRead,
+			Params: []openapi.Param{spaceIDParam, ifNoneMatchParam}, Response: []api.TreeNode{}, ResponseHeaders: etagHeaders},
+		{Method: "GET", Path: "/api/spaces/:id/events", Handler: StreamSpaceEvents, Tag: "Spaces", Auth: auth.ScopeRead,
+			Summary: "WebSocket feed of changes to the space; each text message is one event",
+			Status:  http.StatusSwitchingProtocols, Response: events.Event{}},
+
+		{Method: "GET", Path: "/api/vaults", Handler: GetVaults, Summary: "List the vaults in a space", Tag: "Vaults", Auth: auth.ScopeRead,
+			Params: append([]openapi.Param{spaceIDParam}, listParams...), Response: []models.Vault{}, ResponseHeaders: nextCursorHeaders},
+		{Method: "GET", Path: "/api/vaults/:id", Handler: GetVault, Summary: "Get a vault", Tag: "Vaults", Auth: auth.ScopeRead,
+			Params: []openapi.Param{ifNoneMatchParam}, Response: models.Vault{}, ResponseHeaders: etagHeaders},
+		{Method: "POST", Path: "/api/vaults", Handler: CreateVault, Summary: "Create a vault", Tag: "Vaults", Auth: auth.ScopeWrite,
+			Body: api.CreateVaultRequest{}, Status: http.StatusCreated, Response: models.Vault{}, ResponseHeaders: etagHeaders},
+		{Method: "PUT", Path: "/api/vaults/:id", Handler: UpdateVault, Summary: "Rename a vault", Tag: "Vaults", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Body: api.UpdateVaultRequest{}, Response: models.Vault{}, ResponseHeaders: etagHeaders},
+		{Method: "DELETE", Path: "/api/vaults/:id", Handler: DeleteVault, Summary: "Delete a vault with its nested vaults and logs", Tag: "Vaults", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Response: MessageResponse{}},
+
+		{Method: "GET", Path: "/api/logs", Handler: GetLogs, Summary: "List logs in a space or vault", Tag: "Logs", Auth: auth.ScopeRead,
+			Params: append([]openapi.Param{
+				{Name: "spaceId", In: "query"},
+				{Name: "vaultId", In: "query"},
+				{Name: "language", In: "query"},
+				{Name: "omitCode", In: "query", Type: "boolean", Description: "Leave out the code of each log"},
+			}, listParams...), Response: []models.Log{}, ResponseHeaders: nextCursorHeaders},
+		{Method: "GET", Path: "/api/logs/:id", Handler: GetLog, Summary: "Get a log", Tag: "Logs", Auth: auth.ScopeRead,
+			Params: []openapi.Param{ifNoneMatchParam}, Response: models.Log{}, ResponseHeaders: etagHeaders},
+		{Method: "POST", Path: "/api/logs", Handler: CreateLog, Summary: "Create a log", Tag: "Logs", Auth: auth.ScopeWrite,
+			Body: api.CreateLogRequest{}, Status: http.StatusCreated, Response: models.Log{}, ResponseHeaders: etagHeaders},
+		{Method: "PUT", Path: "/api/logs/:id", Handler: UpdateLog, Summary: "Update a log's name or code", Tag: "Logs", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Body: api.UpdateLogRequest{}, Response: models.Log{}, ResponseHeaders: etagHeaders},
+		{Method: "PATCH", Path: "/api/logs/:id", Handler: PatchLog, Summary: "Partially update a log, or edit ranges of its code", Tag: "Logs", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Body: api.LogPatch{},
+			ExtraBodies: map[string]interface{}{mergePatchMediaType: api.LogPatch{}, jsonPatchMediaType: []api.JSONPatchOp{}},
+			Response:    models.Log{}, ResponseHeaders: etagHeaders},
+		{Method: "DELETE", Path: "/api/logs/:id", Handler: DeleteLog, Summary: "Delete a log", Tag: "Logs", Auth: auth.ScopeWrite,
+			Params: []openapi.Param{ifMatchParam}, Response: MessageResponse{}},
+		{Method: "GET", Path: "/api/logs/:id/collab", Handler: CollaborateOnLog, Tag: "Logs", Auth: auth.ScopeRead,
+			Summary: "WebSocket for editing a log together; the client sends api.CollabRequest messages and receives these events",
+			Status:  http.StatusSwitchingProtocols, Response: openapi.OneOf{api.CollabEvent{}, api.CollabRequest{}}},
+
+		{Method: "POST", Path: "/api/batch", Handler: ExecuteBatch, Summary: "Run vault and log operations in order, atomically on a replica set", Tag: "Batch", Auth: auth.ScopeWrite,
+			Body: api.BatchRequest{}, Response: api.BatchResponse{}},
+
+		// GraphQL
+		{Method: "GET", Path: "/api/graphql", Handler: GraphQL, Summary: "Run a GraphQL query; needs the read scope", Tag: "GraphQL", Auth: openapi.AuthAny,
+			Params: []openapi.Param{{Name: "query", In: "query", Required: true}, {Name: "operationName", In: "query"}, {Name: "variables", In: "query", Description: "JSON object"}}, Response: graphql.Response{}},
+		{Method: "POST", Path: "/api/graphql", Handler: GraphQL, Summary: "Run a GraphQL query, or a mutation with the write scope", Tag: "GraphQL", Auth: openapi.AuthAny,
+			Body: graphql.Request{}, Response: graphql.Response{}},
+
+		// Sharing
+		{Method: "GET", Path: "/api/spaces/:id/members", Handler: GetSpaceMembers, Summary: "List a space's members", Tag: "Members", Auth: auth.ScopeRead,
+			Response: []api.SpaceMemberView{}},
+		{Method: "PUT", Path: "/api/spaces/:id/members/:userId", Handler: UpdateSpaceMember, Summary: "Change a member's role", Tag: "Members", Auth: auth.ScopeWrite,
+			Body: api.UpdateSpaceMemberRequest{}, Response: models.SpaceMember{}},
+		{Method: "DELETE", Path: "/api/spaces/:id/members/:userId", Handler: RemoveSpaceMember, Summary: "Remove a member", Tag: "Members", Auth: auth.ScopeWrite,
+			Response: MessageResponse{}},
+		{Method: "GET", Path: "/api/spaces/:id/invitations", Handler: GetSpaceInvitations, Summary: "List a space's pending invitations", Tag: "Members", Auth: auth.ScopeRead,
+			Response: []models.SpaceInvitation{}},
+		{Method: "POST", Path: "/api/spaces/:id/invitations", Handler: CreateSpaceInvitation, Summary: "Invite someone to a space", Tag: "Members", Auth: auth.ScopeWrite,
+			Body: api.CreateSpaceInvitationRequest{}, Status: http.StatusCreated, Response: api.CreatedInvitation{}},
+		{Method: "DELETE", Path: "/api/spaces/:id/invitations/:invitationId", Handler: DeleteSpaceInvitation, Summary: "Withdraw an invitation", Tag: "Members", Auth: auth.ScopeWrite,
+			Response: MessageResponse{}},
+		{Method: "POST", Path: "/api/invitations/lookup", Handler: GetMyInvitations, Summary: "Look up the invitation a token is for", Tag: "Members", Auth: auth.ScopeRead,
+			Body: api.InvitationTokenRequest{}, Response: []models.SpaceInvitation{}},
+		{Method: "POST", Path: "/api/invitations/:id/accept", Handler: AcceptInvitation, Summary: "Accept an invitation", Tag: "Members", Auth: auth.ScopeWrite,
+			Body: api.InvitationTokenRequest{}, Response: models.SpaceMember{}},
+		{Method: "POST", Path: "/api/invitations/:id/decline", Handler: DeclineInvitation, Summary: "Decline an invitation", Tag: "Members", Auth: auth.ScopeWrite,
+			Body: api.InvitationTokenRequest{}, Response: MessageResponse{}},
+
+		{Method: "GET", Path: "/api/spaces/:id/secrets", Handler: GetSecrets, Summary: "List a space's secret names", Tag: "Secrets", Auth: auth.ScopeRead,
+			Response: []models.Secret{}},
+		{Method: "POST", Path: "/api/spaces/:id/secrets", Handler: CreateSecret, Summary: "Store a secret", Tag: "Secrets", Auth: auth.ScopeWrite,
+			Body: api.CreateSecretRequest{}, Status: http.StatusCreated, Response: models.Secret{}},
+		{Method: "PUT", Path: "/api/spaces/:id/secrets/:name", Handler: UpdateSecret, Summary: "Replace a secret's value", Tag: "Secrets", Auth: auth.ScopeWrite,
+			Body: api.UpdateSecretRequest{}, Response: models.Secret{}},
+		{Method: "DELETE", Path: "/api/spaces/:id/secrets/:name", Handler: DeleteSecret, Summary: "Delete a secret", Tag: "Secrets", Auth: auth.ScopeWrite,
+			Response: MessageResponse{}},
+
+		{Method: "GET", Path: "/api/shares", Handler: GetShareLinks, Summary: "List share links", Tag: "Shares", Auth: auth.ScopeRead,
+			Params: []openapi.Param{{Name: "spaceId", In: "query"}}, Response: []models.ShareLink{}},
+		{Method: "POST", Path: "/api/shares", Handler: CreateShareLink, Summary: "Create a public link to a log or vault", Tag: "Shares", Auth: auth.ScopeWrite,
+			Body: api.CreateShareLinkRequest{}, Status: http.StatusCreated, Response: models.ShareLink{}},
+		{Method: "DELETE", Path: "/api/shares/:id", Handler: DeleteShareLink, Summary: "Revoke a share link", Tag: "Shares", Auth: auth.ScopeWrite,
+			Response: MessageResponse{}},
+		{Method: "GET", Path: "/api/public/shares/:token", Handler: GetSharedContent, Summary: "The content behind a share link", Tag: "Public",
+			Response: api.SharedContent{}},
+		{Method: "GET", Path: "/api/public/shares/:token/logs/:logId", Handler: GetSharedLog, Summary: "A log inside a shared vault", Tag: "Public",
+			Response: api.SharedLog{}},
+		{Method: "POST", Path: "/api/public/shares/:token/run", Handler: RunSharedLog, Summary: "Run shared code when the link allows it", Tag: "Public",
+			Body: api.RunSharedLogRequest{}, Response: api.RunResult{}},
+
+		{Method: "GET", Path: "/api/spaces/:id/webhooks", Handler: GetWebhooks, Summary: "List a space's webhooks", Tag: "Webhooks", Auth: auth.ScopeRead,
+			Response: []models.Webhook{}},
+		{Method: "POST", Path: "/api/spaces/:id/webhooks", Handler: CreateWebhook, Summary: "Subscribe a URL to a space's events", Tag: "Webhooks", Auth: auth.ScopeWrite,
+			Body: api.WebhookRequest{}, Status: http.StatusCreated, Response: api.CreatedWebhook{}},
+		{Method: "PUT", Path: "/api/spaces/:id/webhooks/:webhookId", Handler: UpdateWebhook, Summary: "Change a webhook's URL, events, secret or active flag", Tag: "Webhooks", Auth: auth.ScopeWrite,
+			Body: api.WebhookRequest{}, Response: models.Webhook{}},
+		{Method: "DELETE", Path: "/api/spaces/:id/webhooks/:webhookId", Handler: DeleteWebhook, Summary: "Delete a webhook and its deliveries", Tag: "Webhooks", Auth: auth.ScopeWrite,
+			Response: MessageResponse{}},
+		{Method: "POST", Path: "/api/spaces/:id/webhooks/:webhookId/ping", Handler: PingWebhook, Summary: "Send a ping event to a webhook", Tag: "Webhooks", Auth: auth.ScopeWrite,
+			Status: http.StatusAccepted, Response: models.WebhookDelivery{}},
+		{Method: "GET", Path: "/api/spaces/:id/webhooks/:webhookId/deliveries", Handler: GetWebhookDeliveries, Summary: "A webhook's deliveries, newest first", Tag: "Webhooks", Auth: auth.ScopeRead,
+			Params: []openapi.Param{
+				{Name: "status", In: "query", Description: "pending, succeeded or failed"},
+				{Name: "limit", In: "query", Type: "integer"},
+				{Name: "before", In: "query", Description: "nextCursor from the previous page"},
+			}, Response: api.WebhookDeliveryPage{}},
+		{Method: "POST", Path: "/api/spaces/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", Handler: RedeliverWebhookDelivery, Tag: "Webhooks", Auth: auth.ScopeWrite,
+			Summary: "Send a delivery's payload again as a new delivery", Status: http.StatusAccepted, Response: models.WebhookDelivery{}},
+
+		// Limits and history
+		{Method: "GET", Path: "/api/quotas", Handler: GetQuotas, Summary: "Usage against every quota", Tag: "Quotas", Auth: auth.ScopeRead,
+			Params: []openapi.Param{{Name: "spaceId", In: "query"}}, Response: api.QuotaReport{}},
+		{Method: "GET", Path: "/api/audit", Handler: GetAuditLog, Summary: "A space's audit log, newest first", Tag: "Audit", Auth: auth.ScopeRead,
+			Params: []openapi.Param{
+				spaceIDParam,
+				{Name: "actor", In: "query"},
+				{Name: "action", In: "query"},
+				{Name: "targetId", In: "query"},
+				{Name: "from", In: "query", Description: "YYYY-MM-DD"},
+				{Name: "to", In: "query", Description: "YYYY-MM-DD"},
+				{Name: "limit", In: "query", Type: "integer"},
+				{Name: "before", In: "query", Description: "nextCursor from the previous page"},
+			}, Response: api.AuditPage{}},
+
+		// Running code and AI
+		{Method: "POST", Path: "/api/run", Handler: RunCode, Summary: "Run code", Tag: "Run", Auth: auth.ScopeRun,
+			Body: api.RunRequest{}, Response: api.RunResult{}},
+		{Method: "POST", Path: "/api/ai/generate", Handler: GenerateCode, Summary: "Generate, explain, review or write tests for code", Tag: "AI", Auth: auth.ScopeAI,
+			Body: api.GenerateRequest{}, Response: openapi.OneOf{api.GenerateResponse{}, api.ExplainResponse{}, api.ReviewResponse{}, api.TestsResponse{}}},
+		{Method: "GET", Path: "/api/ai/usage", Handler: GetAIUsage, Summary: "AI usage totals", Tag: "AI", Auth: auth.ScopeAI,
+			Params: []openapi.Param{
+				{Name: "groupBy", In: "query", Description: "user, space or day (default)"},
+				{Name: "from", In: "query", Description: "YYYY-MM-DD"},
+				{Name: "to", In: "query", Description: "YYYY-MM-DD"},
+				{Name: "spaceId", In: "query"},
+			}, Response: []api.UsageTotals{}},
+		{Method: "GET", Path: "/api/ai/budget", Handler: GetAIBudget, Summary: "This month's AI usage against the budgets", Tag: "AI", Auth: auth.ScopeAI,
+			Params: []openapi.Param{{Name: "spaceId", In: "query"}}, Response: api.BudgetReport{}},
+		{Method: "GET", Path: "/api/ai/sessions", Handler: GetChatSessions, Summary: "List chat sessions", Tag: "AI", Auth: auth.ScopeAI,
+			Params: []openapi.Param{{Name: "spaceId", In: "query"}, {Name: "logId", In: "query"}}, Response: []models.ChatSession{}},
+		{Method: "GET", Path: "/api/ai/sessions/:id", Handler: GetChatSession, Summary: "Get a chat session with its messages", Tag: "AI", Auth: auth.ScopeAI,
+			Response: models.ChatSession{}},
+		{Method: "POST", Path: "/api/ai/sessions", Handler: CreateChatSession, Summary: "Start a chat session", Tag: "AI", Auth: auth.ScopeAI,
+			Body: api.CreateChatSessionRequest{}, Status: http.StatusCreated, Response: models.ChatSession{}},
+		{Method: "POST", Path: "/api/ai/sessions/:id/messages", Handler: PostChatMessage, Summary: "Send a chat message and get the reply", Tag: "AI", Auth: auth.ScopeAI,
+			Body: api.ChatMessageRequest{}, Status: http.StatusCreated, Response: models.ChatMessage{}},
+		{Method: "DELETE", Path: "/api/ai/sessions/:id", Handler: DeleteChatSession, Summary: "Delete a chat session", Tag: "AI", Auth: auth.ScopeAI,
+			Response: MessageResponse{}},
+	}
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument *openapi.Document
+)
+
+// GetOpenAPI serves the OpenAPI document describing every route
+func GetOpenAPI(c *gin.Context) {
+	openAPIOnce.Do(func() {
+		openAPIDocument = openapi.Build(openapi.Info{
+			Title:       "CodeFlow API",
+			Version:     "1.0",
+			Description: "Errors use the envelope in components.responses.Error. x-scope is the access token scope an operation needs.",
+		}, apiOperations(), apierror.Error{}, auth.SessionCookieName)
+	})
+	c.JSON(http.StatusOK, openAPIDocument)
+}
+
+// CheckOpenAPIRoutes reports routes that are missing from the OpenAPI document,
+// documented with the wrong handler, or documented but not registered
+func CheckOpenAPIRoutes(routes gin.RoutesInfo) error {
+	registered := make([]openapi.Route, len(routes))
+	for i, route := range routes {
+		registered[i] = openapi.Route{Method: route.Method, Path: route.Path, Handler: route.Handler}
+	}
+	return openapi.CheckRoutes(apiOperations(), registered)
+}
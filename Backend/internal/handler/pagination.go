@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultListPageSize = 100
+	maxListPageSize     = 500
+)
+
+// nextCursorHeader carries the cursor for the next page of a list endpoint. List
+// bodies stay plain arrays so existing clients keep working; the header is
+// absent on the last page.
+const nextCursorHeader = "X-Next-Cursor"
+
+var listSortFields = map[string]bool{"name": true, "createdAt": true, "updatedAt": true}
+
+// listQuery holds the paging, sorting and filtering options of a list request:
+// ?limit=&cursor=&sort=name|createdAt|updatedAt (prefix "-" for descending)
+// &namePrefix=&updatedSince=YYYY-MM-DD or RFC 3339
+type listQuery struct {
+	Limit      int
+	SortField  string
+	Descending bool
+	After      *listCursor
+}
+
+// listCursor is the position of the last item on a page
+type listCursor struct {
+	Sort  string             `json:"s"` // Sort the cursor was issued for, e.g. "-updatedAt"
+	Value string             `json:"v"` // Sort field value of the last item
+	ID    primitive.ObjectID `json:"id"`
+}
+
+// pageItem is what a cursor is built from
+type pageItem struct {
+	ID        primitive.ObjectID
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// parseListQuery reads the list options and adds the name and update time filters to filter
+func parseListQuery(c *gin.Context, filter bson.M) (listQuery, bool) {
+	query := listQuery{Limit: defaultListPageSize, SortField: "createdAt"}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid limit")
+			return query, false
+		}
+		query.Limit = min(parsed, maxListPageSize)
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+		query.SortField = strings.TrimPrefix(sort, "-")
+		if !listSortFields[query.SortField] {
+			apierror.Abort(c, apierror.InvalidRequest, "sort must be name, createdAt or updatedAt, optionally prefixed with -")
+			return query, false
+		}
+	}
+
+	if encoded := c.Query("cursor"); encoded != "" {
+		cursor, err := decodeListCursor(encoded)
+		if err != nil || cursor.Sort != query.sortKey() {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid cursor")
+			return query, false
+		}
+		query.After = cursor
+	}
+
+	if prefix := c.Query("namePrefix"); prefix != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	}
+
+	if since := c.Query("updatedSince"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", since)
+		}
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid updatedSince, expected YYYY-MM-DD or RFC 3339")
+			return query, false
+		}
+		filter["updatedAt"] = bson.M{"$gte": t}
+	}
+
+	return query, true
+}
+
+func (q listQuery) sortKey() string {
+	if q.Descending {
+		return "-" + q.SortField
+	}
+	return q.SortField
+}
+
+// pageFilter restricts filter to items after the cursor
+func (q listQuery) pageFilter(filter bson.M) bson.M {
+	if q.After == nil {
+		return filter
+	}
+
+	var value interface{} = q.After.Value
+	if q.SortField != "name" {
+		// The cursor was issued by us, so the time always parses
+		value, _ = time.Parse(time.RFC3339Nano, q.After.Value)
+	}
+
+	op := "$gt"
+	if q.Descending {
+		op = "$lt"
+	}
+
+	// Ties on the sort field are broken by ID
+	return bson.M{"$and": []bson.M{filter, {"$or": []bson.M{
+		{q.SortField: bson.M{op: value}},
+		{q.SortField: value, "_id": bson.M{op: q.After.ID}},
+	}}}}
+}
+
+// findOptions sorts by the requested field and fetches one extra item to detect a next page
+func (q listQuery) findOptions() *options.FindOptions {
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+	sort := bson.D{{Key: q.SortField, Value: direction}, {Key: "_id", Value: direction}}
+	return options.Find().SetSort(sort).SetLimit(int64(q.Limit + 1))
+}
+
+// setNextCursor sets the next page header from the last item returned
+func (q listQuery) setNextCursor(c *gin.Context, last pageItem) {
+	cursor := listCursor{Sort: q.sortKey(), ID: last.ID}
+	switch q.SortField {
+	case "name":
+		cursor.Value = last.Name
+	case "createdAt":
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "updatedAt":
+		cursor.Value = last.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	encoded, err := json.Marshal(cursor)
+	if err != nil {
+		return
+	}
+	c.Header(nextCursorHeader, base64.RawURLEncoding.EncodeToString(encoded))
+}
+
+func decodeListCursor(encoded string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != "name" && cursor.Sort != "-name" {
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+			return nil, err
+		}
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newListAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/spaces", GetSpaces)
+	a.api.GET("/vaults", GetVaults)
+	a.api.GET("/logs", GetLogs)
+	return a
+}
+
+// listAll follows the next cursor from target until the last page, returning
+// the names in order and the number of pages
+func listAll[T any](t *testing.T, a *testAPI, token, target string, name func(T) string) ([]string, int) {
+	t.Helper()
+	var names []string
+	pages := 0
+	cursor := ""
+	for {
+		page := target
+		if cursor != "" {
+			page += "&cursor=" + url.QueryEscape(cursor)
+		}
+		w := a.request("GET", page, token, nil)
+		expectStatus(t, w, http.StatusOK)
+		for _, item := range decodeBody[[]T](t, w) {
+			names = append(names, name(item))
+		}
+		pages++
+		if cursor = w.Header().Get(nextCursorHeader); cursor == "" {
+			return names, pages
+		}
+		if pages > 20 {
+			t.Fatalf("%s: paging doesn't end", target)
+		}
+	}
+}
+
+func logName(log models.Log) string { return log.Name }
+
+func TestListPaging(t *testing.T) {
+	setupDB(t)
+	ada, token := createUser(t, "ada@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	vault := createVault(t, space, "src", nil)
+
+	// Three logs share a creation time, so pages must break ties by ID
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	created := []time.Time{base, base, base, base.Add(time.Minute), base.Add(2 * time.Minute), base.Add(3 * time.Minute), base.Add(3 * time.Minute)}
+	names := []string{"c.py", "a.py", "g.py", "b.py", "e.py", "d.py", "f.py"}
+	var logs []models.Log
+	for i, name := range names {
+		log := models.Log{
+			ID:        primitive.NewObjectID(),
+			SpaceID:   space.ID,
+			VaultID:   vault.ID,
+			UserID:    ada.ID.Hex(),
+			Name:      name,
+			Path:      "src/" + name,
+			Language:  "python",
+			Version:   1,
+			CreatedAt: created[i],
+			UpdatedAt: created[len(created)-1-i],
+		}
+		insert(t, "logs", log)
+		logs = append(logs, log)
+	}
+
+	// The expected order of each sort, with ties in ID order
+	ordered := func(less func(a, b models.Log) int, descending bool) []string {
+		sorted := append([]models.Log{}, logs...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			c := less(sorted[i], sorted[j])
+			if c == 0 {
+				c = strings.Compare(sorted[i].ID.Hex(), sorted[j].ID.Hex())
+			}
+			return (c < 0) != descending
+		})
+		var names []string
+		for _, log := range sorted {
+			names = append(names, log.Name)
+		}
+		return names
+	}
+	byName := func(a, b models.Log) int { return strings.Compare(a.Name, b.Name) }
+	byCreated := func(a, b models.Log) int { return a.CreatedAt.Compare(b.CreatedAt) }
+	byUpdated := func(a, b models.Log) int { return a.UpdatedAt.Compare(b.UpdatedAt) }
+
+	a := newListAPI()
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", ordered(byCreated, false)},
+		{"name", ordered(byName, false)},
+		{"-name", ordered(byName, true)},
+		{"createdAt", ordered(byCreated, false)},
+		{"-createdAt", ordered(byCreated, true)},
+		{"updatedAt", ordered(byUpdated, false)},
+		{"-updatedAt", ordered(byUpdated, true)},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, 3, 7, 100} {
+			target := fmt.Sprintf("/api/logs?vaultId=%s&limit=%d&sort=%s", vault.ID.Hex(), limit, tt.sort)
+			got, pages := listAll(t, a, token, target, logName)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("sort %q, limit %d: %v, want %v", tt.sort, limit, got, tt.want)
+			}
+			if want := max(1, (len(logs)+limit-1)/limit); pages != want {
+				t.Errorf("sort %q, limit %d: %d pages, want %d", tt.sort, limit, pages, want)
+			}
+		}
+	}
+
+	// Items added behind the cursor don't shift later pages
+	w := a.request("GET", "/api/logs?vaultId="+vault.ID.Hex()+"&limit=3&sort=name", token, nil)
+	cursor := w.Header().Get(nextCursorHeader)
+	createLog(t, vault, "0.py", "")
+	w = a.request("GET", "/api/logs?vaultId="+vault.ID.Hex()+"&limit=3&sort=name&cursor="+cursor, token, nil)
+	if got := decodeBody[[]models.Log](t, w); len(got) != 3 || got[0].Name != "d.py" {
+		t.Errorf("page after the cursor = %v", got)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	setupDB(t)
+	ada, token := createUser(t, "ada@example.com")
+	bob, bobToken := createUser(t, "bob@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	other := createSpace(t, ada.ID.Hex(), "Other")
+	addMember(t, space.ID, bob.ID.Hex(), RoleViewer)
+	vault := createVault(t, space, "src", nil)
+	createVault(t, space, "src.old", nil)
+	createVault(t, space, "srcXold", nil)
+	createLog(t, vault, "main.py", "")
+	createLog(t, vault, "main.js", "")
+	createLog(t, createVault(t, other, "lib", nil), "main.go", "")
+	a := newListAPI()
+
+	names := func(token, target string) string {
+		got, _ := listAll(t, a, token, target, logName)
+		return strings.Join(got, " ")
+	}
+	if got := names(token, "/api/logs?sort=name&namePrefix=main."); got != "main.go main.js main.py" {
+		t.Errorf("logs across spaces = %s", got)
+	}
+	if got := names(bobToken, "/api/logs?sort=name&limit=1"); got != "main.js main.py" {
+		t.Errorf("logs a member can see = %s", got)
+	}
+	if got := names(token, "/api/logs?sort=name&language=python"); got != "main.py" {
+		t.Errorf("python logs = %s", got)
+	}
+
+	// The prefix is literal, not a pattern
+	vaults, _ := listAll(t, a, token, "/api/vaults?spaceId="+space.ID.Hex()+"&sort=name&namePrefix=src.", func(v models.Vault) string { return v.Name })
+	if fmt.Sprint(vaults) != "[src.old]" {
+		t.Errorf("vaults with the prefix src. = %v", vaults)
+	}
+
+	// Only logs updated since the given time are listed
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	insert(t, "logs", models.Log{ID: primitive.NewObjectID(), SpaceID: space.ID, VaultID: vault.ID, UserID: ada.ID.Hex(),
+		Name: "old.py", Path: "src/old.py", Version: 1, CreatedAt: old, UpdatedAt: old})
+	for _, since := range []string{"2021-01-01", "2021-01-01T00:00:00Z"} {
+		if got := names(token, "/api/logs?vaultId="+vault.ID.Hex()+"&sort=name&updatedSince="+url.QueryEscape(since)); got != "main.js main.py" {
+			t.Errorf("updatedSince %s: %s", since, got)
+		}
+	}
+
+	// Each page of spaces carries the caller's role
+	w := a.request("GET", "/api/spaces?limit=1&sort=name", token, nil)
+	spaces := decodeBody[[]models.Space](t, w)
+	w = a.request("GET", "/api/spaces?limit=1&sort=name&cursor="+w.Header().Get(nextCursorHeader), token, nil)
+	spaces = append(spaces, decodeBody[[]models.Space](t, w)...)
+	if len(spaces) != 2 || spaces[0].Name != "Other" || spaces[1].Name != "Space" || spaces[1].Role != RoleOwner || w.Header().Get(nextCursorHeader) != "" {
+		t.Errorf("spaces = %+v", spaces)
+	}
+	w = a.request("GET", "/api/spaces", bobToken, nil)
+	if spaces := decodeBody[[]models.Space](t, w); len(spaces) != 1 || spaces[0].Role != RoleViewer {
+		t.Errorf("member's spaces = %+v", spaces)
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	setupDB(t)
+	_, token := createUser(t, "ada@example.com")
+	a := newListAPI()
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	createdCursor := encode(`{"s":"createdAt","v":"2026-01-01T00:00:00Z","id":"` + primitive.NewObjectID().Hex() + `"}`)
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=-1",
+		"limit=ten",
+		"sort=code",
+		"sort=--name",
+		"cursor=not-base64!",
+		"cursor=" + encode("not json"),
+		"cursor=" + encode(`{"s":"createdAt","v":"yesterday","id":"`+primitive.NewObjectID().Hex()+`"}`),
+		"cursor=" + encode(`{"s":"createdAt","v":"2026-01-01T00:00:00Z","id":"nope"}`),
+		"sort=name&cursor=" + createdCursor, // Issued for another sort
+		"sort=-createdAt&cursor=" + createdCursor,
+		"updatedSince=last+week",
+		"updatedSince=2026-13-01",
+	} {
+		body := expectError(t, a.request("GET", "/api/logs?"+query, token, nil), http.StatusBadRequest, apierror.InvalidRequest)
+		if body.Message == "" {
+			t.Errorf("%s: no message", query)
+		}
+	}
+
+	// Large limits are capped rather than refused
+	expectStatus(t, a.request("GET", fmt.Sprintf("/api/logs?limit=%d", maxListPageSize*10), token, nil), http.StatusOK)
+	expectStatus(t, a.request("GET", "/api/logs?cursor="+createdCursor, token, nil), http.StatusOK)
+}
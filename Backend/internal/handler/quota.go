@@ -0,0 +1,420 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Quotas reported in quota errors and by GET /api/quotas
+const (
+	QuotaVaults        = "vaults"
+	QuotaLogs          = "logs"
+	QuotaLogBytes      = "logBytes"
+	QuotaSpaceBytes    = "spaceBytes"
+	QuotaRunsPerDay    = "runsPerDay"
+	QuotaAICallsPerDay = "aiCallsPerDay"
+)
+
+// quotaLimits are the configured limits; 0 means unlimited
+type quotaLimits struct {
+	UserVaults         int
+	UserLogs           int
+	UserRunsPerDay     int
+	UserAICallsPerDay  int
+	SpaceVaults        int
+	SpaceLogs          int
+	SpaceBytes         int
+	SpaceRunsPerDay    int
+	SpaceAICallsPerDay int
+	LogBytes           int
+}
+
+// currentQuotaLimits reads the QUOTA_* variables
+func currentQuotaLimits() quotaLimits {
+	return quotaLimits{
+		UserVaults:         envInt("QUOTA_USER_MAX_VAULTS"),
+		UserLogs:           envInt("QUOTA_USER_MAX_LOGS"),
+		UserRunsPerDay:     envInt("QUOTA_USER_RUNS_PER_DAY"),
+		UserAICallsPerDay:  envInt("QUOTA_USER_AI_CALLS_PER_DAY"),
+		SpaceVaults:        envInt("QUOTA_SPACE_MAX_VAULTS"),
+		SpaceLogs:          envInt("QUOTA_SPACE_MAX_LOGS"),
+		SpaceBytes:         envInt("QUOTA_SPACE_MAX_BYTES"),
+		SpaceRunsPerDay:    envInt("QUOTA_SPACE_RUNS_PER_DAY"),
+		SpaceAICallsPerDay: envInt("QUOTA_SPACE_AI_CALLS_PER_DAY"),
+		LogBytes:           envInt("QUOTA_MAX_LOG_BYTES"),
+	}
+}
+
+// respondQuotaExceeded writes the structured quota error. Daily quotas are 429s
+// that can be retried tomorrow; storage quotas are 403s until something is deleted.
+func respondQuotaExceeded(c *gin.Context, quota api.QuotaUsage) {
+	status := http.StatusForbidden
+	if quota.Name == QuotaRunsPerDay || quota.Name == QuotaAICallsPerDay {
+		status = http.StatusTooManyRequests
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(startOfNextDay(time.Now())).Seconds())+1))
+	}
+
+	apierror.Respond(c, quotaExceededError(quota).WithStatus(status))
+}
+
+// quotaExceededError is the structured quota error
+func quotaExceededError(quota api.QuotaUsage) *apierror.Error {
+	return apierror.New(apierror.QuotaExceeded, fmt.Sprintf("The %s quota for this %s has been exceeded", quota.Name, quota.Scope)).
+		WithDetails(gin.H{"quota": quota})
+}
+
+// enforceVaultQuota checks the vault count limits before creating a vault
+func enforceVaultQuota(c *gin.Context, ctx context.Context, spaceID primitive.ObjectID, userID string) bool {
+	if err := checkVaultQuota(ctx, spaceID, userID); err != nil {
+		apierror.Respond(c, err)
+		return false
+	}
+	return true
+}
+
+// checkVaultQuota is enforceVaultQuota without writing a response
+func checkVaultQuota(ctx context.Context, spaceID primitive.ObjectID, userID string) *apierror.Error {
+	limits := currentQuotaLimits()
+	return checkCountQuotas(ctx, "vaults", QuotaVaults, []countQuota{
+		{Scope: "user", Limit: limits.UserVaults, Filter: bson.M{"userId": userID}},
+		{Scope: "space", Limit: limits.SpaceVaults, Filter: bson.M{"spaceId": spaceID}},
+	})
+}
+
+// enforceLogQuota checks the log count and size limits before creating a log
+func enforceLogQuota(c *gin.Context, ctx context.Context, spaceID primitive.ObjectID, userID string, code string) bool {
+	if err := checkLogQuota(ctx, spaceID, userID, code); err != nil {
+		apierror.Respond(c, err)
+		return false
+	}
+	return true
+}
+
+// checkLogQuota is enforceLogQuota without writing a response
+func checkLogQuota(ctx context.Context, spaceID primitive.ObjectID, userID string, code string) *apierror.Error {
+	limits := currentQuotaLimits()
+	if err := checkCountQuotas(ctx, "logs", QuotaLogs, []countQuota{
+		{Scope: "user", Limit: limits.UserLogs, Filter: bson.M{"userId": userID}},
+		{Scope: "space", Limit: limits.SpaceLogs, Filter: bson.M{"spaceId": spaceID}},
+	}); err != nil {
+		return err
+	}
+	return checkCodeSizeQuota(ctx, spaceID, 0, len(code))
+}
+
+// enforceCodeSizeQuota checks the per-log and per-space byte limits when a log's
+// code changes from oldBytes to newBytes
+func enforceCodeSizeQuota(c *gin.Context, ctx context.Context, spaceID primitive.ObjectID, oldBytes, newBytes int) bool {
+	if err := checkCodeSizeQuota(ctx, spaceID, oldBytes, newBytes); err != nil {
+		apierror.Respond(c, err)
+		return false
+	}
+	return true
+}
+
+// checkCodeSizeQuota is enforceCodeSizeQuota without writing a response
+func checkCodeSizeQuota(ctx context.Context, spaceID primitive.ObjectID, oldBytes, newBytes int) *apierror.Error {
+	limits := currentQuotaLimits()
+
+	logQuota := api.QuotaUsage{Name: QuotaLogBytes, Scope: "log", Limit: limits.LogBytes}
+	if logQuota.Exceeded(newBytes) {
+		return quotaExceededError(logQuota)
+	}
+
+	// Shrinking a log is always allowed, even when the space is already over its limit
+	if limits.SpaceBytes == 0 || newBytes <= oldBytes {
+		return nil
+	}
+
+	used, err := spaceCodeBytes(ctx, spaceID)
+	if err != nil {
+		return apierror.New(apierror.Internal, "Failed to check quota")
+	}
+
+	spaceQuota := api.QuotaUsage{Name: QuotaSpaceBytes, Scope: "space", Limit: limits.SpaceBytes, Used: used}
+	if spaceQuota.Exceeded(newBytes - oldBytes) {
+		return quotaExceededError(spaceQuota)
+	}
+	return nil
+}
+
+// countQuota is a limit on the number of documents matching a filter
+type countQuota struct {
+	Scope  string
+	Limit  int
+	Filter bson.M
+}
+
+// checkCountQuotas refuses the request if adding one more document would exceed any of the limits.
+// The count and the insert that follows aren't atomic, so concurrent creates can each pass the
+// check and overshoot a limit by the number of requests in flight. These limits are soft; the
+// daily quotas, which guard paid resources, use counters that can't overshoot.
+func checkCountQuotas(ctx context.Context, collection, name string, quotas []countQuota) *apierror.Error {
+	for _, q := range quotas {
+		if q.Limit == 0 {
+			continue
+		}
+
+		count, err := db.Database.Collection(collection).CountDocuments(ctx, q.Filter)
+		if err != nil {
+			return apierror.New(apierror.Internal, "Failed to check quota")
+		}
+
+		quota := api.QuotaUsage{Name: name, Scope: q.Scope, Limit: q.Limit, Used: int(count)}
+		if quota.Exceeded(1) {
+			return quotaExceededError(quota)
+		}
+	}
+	return nil
+}
+
+// dailyCharge is a run or AI call counted against the daily quotas. It can be
+// refunded when the work it paid for didn't happen.
+type dailyCharge struct {
+	keys []string
+}
+
+// refund hands the charge back
+func (d dailyCharge) refund() {
+	if len(d.keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rollbackDailyCounters(ctx, db.Database.Collection("quota_counters"), d.keys)
+}
+
+// consumeDailyQuota counts a run or AI call against the user's and the space's daily limits.
+// Counters are incremented first and rolled back on refusal, so concurrent requests can't overshoot.
+func consumeDailyQuota(c *gin.Context, name, userID string, spaceID *primitive.ObjectID) (dailyCharge, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	charge, refused, err := chargeDailyQuota(ctx, name, userID, spaceID)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to check quota")
+		return charge, false
+	}
+	if refused != nil {
+		respondQuotaExceeded(c, *refused)
+		return charge, false
+	}
+	return charge, true
+}
+
+// takeDailyQuota is consumeDailyQuota without writing a response
+func takeDailyQuota(name, userID string, spaceID *primitive.ObjectID) (dailyCharge, *apierror.Error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	charge, refused, err := chargeDailyQuota(ctx, name, userID, spaceID)
+	if err != nil {
+		return charge, apierror.New(apierror.Internal, "Failed to check quota")
+	}
+	if refused != nil {
+		return charge, quotaExceededError(*refused)
+	}
+	return charge, nil
+}
+
+// chargeDailyQuota counts one unit against the daily quotas. It returns
+// the quota that refused the charge, if any.
+func chargeDailyQuota(ctx context.Context, name, userID string, spaceID *primitive.ObjectID) (dailyCharge, *api.QuotaUsage, error) {
+	limits := currentQuotaLimits()
+	userLimit, spaceLimit := limits.UserRunsPerDay, limits.SpaceRunsPerDay
+	if name == QuotaAICallsPerDay {
+		userLimit, spaceLimit = limits.UserAICallsPerDay, limits.SpaceAICallsPerDay
+	}
+
+	quotas := []api.QuotaUsage{}
+	keys := []string{}
+	if userLimit > 0 && userID != "" {
+		quotas = append(quotas, api.QuotaUsage{Name: name, Scope: "user", Limit: userLimit})
+		keys = append(keys, dailyCounterKey(name, "user", userID))
+	}
+	if spaceLimit > 0 && spaceID != nil {
+		quotas = append(quotas, api.QuotaUsage{Name: name, Scope: "space", Limit: spaceLimit})
+		keys = append(keys, dailyCounterKey(name, "space", spaceID.Hex()))
+	}
+
+	collection := db.Database.Collection("quota_counters")
+	expiresAt := startOfNextDay(time.Now()).Add(24 * time.Hour)
+
+	for i, key := range keys {
+		var counter struct {
+			Count int `bson:"count"`
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		update := bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expiresAt": expiresAt}}
+		if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&counter); err != nil {
+			rollbackDailyCounters(ctx, collection, keys[:i])
+			return dailyCharge{}, nil, err
+		}
+
+		quotas[i].Used = counter.Count - 1
+		if quotas[i].Exceeded(1) {
+			rollbackDailyCounters(ctx, collection, keys[:i+1])
+			return dailyCharge{}, &quotas[i], nil
+		}
+	}
+	return dailyCharge{keys: keys}, nil, nil
+}
+
+func rollbackDailyCounters(ctx context.Context, collection *mongo.Collection, keys []string) {
+	for _, key := range keys {
+		collection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$inc": bson.M{"count": -1}})
+	}
+}
+
+// dailyCounterKey identifies today's counter for a quota and subject
+func dailyCounterKey(name, scope, id string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", name, scope, id, time.Now().UTC().Format("2006-01-02"))
+}
+
+// dailyCount returns today's count for a quota and subject
+func dailyCount(ctx context.Context, name, scope, id string) (int, error) {
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	err := db.Database.Collection("quota_counters").FindOne(ctx, bson.M{"_id": dailyCounterKey(name, scope, id)}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return counter.Count, err
+}
+
+// spaceCodeBytes returns the total size of the code stored in a space
+func spaceCodeBytes(ctx context.Context, spaceID primitive.ObjectID) (int, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"spaceId": spaceID}},
+		{"$group": bson.M{"_id": "$spaceId", "bytes": bson.M{"$sum": bson.M{"$strLenBytes": bson.M{"$ifNull": []interface{}{"$code", ""}}}}}},
+	}
+
+	cursor, err := db.Database.Collection("logs").Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Bytes int `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Bytes, nil
+}
+
+// GetQuotas reports current usage against every limit for the user and, with ?spaceId=, the space
+func GetQuotas(c *gin.Context) {
+	userID := currentUserID(c)
+	limits := currentQuotaLimits()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	failed := func() {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch quota usage")
+	}
+
+	vaults, err := db.Database.Collection("vaults").CountDocuments(ctx, bson.M{"userId": userID})
+	if err != nil {
+		failed()
+		return
+	}
+	logs, err := db.Database.Collection("logs").CountDocuments(ctx, bson.M{"userId": userID})
+	if err != nil {
+		failed()
+		return
+	}
+	runs, err := dailyCount(ctx, QuotaRunsPerDay, "user", userID)
+	if err != nil {
+		failed()
+		return
+	}
+	aiCalls, err := dailyCount(ctx, QuotaAICallsPerDay, "user", userID)
+	if err != nil {
+		failed()
+		return
+	}
+
+	response := api.QuotaReport{
+		User: []api.QuotaUsage{
+			{Name: QuotaVaults, Scope: "user", Limit: limits.UserVaults, Used: int(vaults)},
+			{Name: QuotaLogs, Scope: "user", Limit: limits.UserLogs, Used: int(logs)},
+			{Name: QuotaRunsPerDay, Scope: "user", Limit: limits.UserRunsPerDay, Used: runs},
+			{Name: QuotaAICallsPerDay, Scope: "user", Limit: limits.UserAICallsPerDay, Used: aiCalls},
+		},
+		Log:      api.QuotaUsage{Name: QuotaLogBytes, Scope: "log", Limit: limits.LogBytes},
+		ResetsAt: startOfNextDay(time.Now()),
+	}
+
+	if spaceIDStr := c.Query("spaceId"); spaceIDStr != "" {
+		spaceID, err := primitive.ObjectIDFromHex(spaceIDStr)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+		if !authorizeSpace(c, spaceID, RoleViewer, "Space not found") {
+			return
+		}
+
+		vaults, err := db.Database.Collection("vaults").CountDocuments(ctx, bson.M{"spaceId": spaceID})
+		if err != nil {
+			failed()
+			return
+		}
+		logs, err := db.Database.Collection("logs").CountDocuments(ctx, bson.M{"spaceId": spaceID})
+		if err != nil {
+			failed()
+			return
+		}
+		bytes, err := spaceCodeBytes(ctx, spaceID)
+		if err != nil {
+			failed()
+			return
+		}
+		runs, err := dailyCount(ctx, QuotaRunsPerDay, "space", spaceID.Hex())
+		if err != nil {
+			failed()
+			return
+		}
+		aiCalls, err := dailyCount(ctx, QuotaAICallsPerDay, "space", spaceID.Hex())
+		if err != nil {
+			failed()
+			return
+		}
+
+		response.Space = []api.QuotaUsage{
+			{Name: QuotaVaults, Scope: "space", Limit: limits.SpaceVaults, Used: int(vaults)},
+			{Name: QuotaLogs, Scope: "space", Limit: limits.SpaceLogs, Used: int(logs)},
+			{Name: QuotaSpaceBytes, Scope: "space", Limit: limits.SpaceBytes, Used: bytes},
+			{Name: QuotaRunsPerDay, Scope: "space", Limit: limits.SpaceRunsPerDay, Used: runs},
+			{Name: QuotaAICallsPerDay, Scope: "space", Limit: limits.SpaceAICallsPerDay, Used: aiCalls},
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// startOfNextDay returns the next UTC midnight, when daily quotas reset
+func startOfNextDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
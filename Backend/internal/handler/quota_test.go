@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newQuotaAPI() *testAPI {
+	a := newTestAPI()
+	a.api.POST("/vaults", CreateVault)
+	a.api.POST("/logs", CreateLog)
+	a.api.PUT("/logs/:id", UpdateLog)
+	a.api.GET("/quotas", GetQuotas)
+	return a
+}
+
+// expectQuota checks that a response is the quota error for quota
+func expectQuota(t *testing.T, w *httptest.ResponseRecorder, status int, quota api.QuotaUsage) {
+	t.Helper()
+	body := expectError(t, w, status, apierror.QuotaExceeded)
+	details, _ := body.Details.(map[string]interface{})
+	got, _ := details["quota"].(map[string]interface{})
+	if got["name"] != quota.Name || got["scope"] != quota.Scope || got["limit"] != float64(quota.Limit) || got["used"] != float64(quota.Used) {
+		t.Errorf("quota = %v, want %+v", details["quota"], quota)
+	}
+}
+
+func TestQuotaUsageExceeded(t *testing.T) {
+	tests := []struct {
+		quota api.QuotaUsage
+		n     int
+		want  bool
+	}{
+		{api.QuotaUsage{Limit: 0, Used: 1000}, 1, false}, // Unlimited
+		{api.QuotaUsage{Limit: 2, Used: 1}, 1, false},
+		{api.QuotaUsage{Limit: 2, Used: 2}, 1, true},
+		{api.QuotaUsage{Limit: 10, Used: 4}, 6, false},
+		{api.QuotaUsage{Limit: 10, Used: 4}, 7, true},
+		{api.QuotaUsage{Limit: 10, Used: 12}, -1, true}, // Still over after shrinking
+	}
+	for _, tt := range tests {
+		if got := tt.quota.Exceeded(tt.n); got != tt.want {
+			t.Errorf("%+v.Exceeded(%d) = %v", tt.quota, tt.n, got)
+		}
+	}
+}
+
+func TestCountQuotas(t *testing.T) {
+	setupDB(t)
+	t.Setenv("QUOTA_USER_MAX_VAULTS", "2")
+	t.Setenv("QUOTA_SPACE_MAX_LOGS", "1")
+	ada, adaToken := createUser(t, "ada@example.com")
+	bob, bobToken := createUser(t, "bob@example.com")
+	first := createSpace(t, ada.ID.Hex(), "First")
+	second := createSpace(t, ada.ID.Hex(), "Second")
+	addMember(t, first.ID, bob.ID.Hex(), RoleEditor)
+	a := newQuotaAPI()
+
+	// The user limit counts vaults in every space
+	vault := createVault(t, first, "src", nil)
+	expectStatus(t, a.request("POST", "/api/vaults", adaToken, api.CreateVaultRequest{SpaceID: second.ID.Hex(), Name: "lib"}), http.StatusCreated)
+	expectQuota(t, a.request("POST", "/api/vaults", adaToken, api.CreateVaultRequest{SpaceID: second.ID.Hex(), Name: "docs"}), http.StatusForbidden,
+		api.QuotaUsage{Name: QuotaVaults, Scope: "user", Limit: 2, Used: 2})
+	expectStatus(t, a.request("POST", "/api/vaults", bobToken, api.CreateVaultRequest{SpaceID: first.ID.Hex(), Name: "docs"}), http.StatusCreated)
+
+	// The space limit counts logs by anyone
+	expectStatus(t, a.request("POST", "/api/logs", adaToken, api.CreateLogRequest{SpaceID: first.ID.Hex(), VaultID: vault.ID.Hex(), Name: "a.py"}), http.StatusCreated)
+	expectQuota(t, a.request("POST", "/api/logs", bobToken, api.CreateLogRequest{SpaceID: first.ID.Hex(), VaultID: vault.ID.Hex(), Name: "b.py"}), http.StatusForbidden,
+		api.QuotaUsage{Name: QuotaLogs, Scope: "space", Limit: 1, Used: 1})
+
+	w := a.request("GET", "/api/quotas?spaceId="+first.ID.Hex(), adaToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	report := decodeBody[api.QuotaReport](t, w)
+	if report.User[0] != (api.QuotaUsage{Name: QuotaVaults, Scope: "user", Limit: 2, Used: 2}) || report.User[1] != (api.QuotaUsage{Name: QuotaLogs, Scope: "user", Used: 1}) {
+		t.Errorf("user quotas = %+v", report.User)
+	}
+	if report.Space[0] != (api.QuotaUsage{Name: QuotaVaults, Scope: "space", Used: 2}) || report.Space[1] != (api.QuotaUsage{Name: QuotaLogs, Scope: "space", Limit: 1, Used: 1}) {
+		t.Errorf("space quotas = %+v", report.Space)
+	}
+	_, outsiderToken := createUser(t, "eve@example.com")
+	expectError(t, a.request("GET", "/api/quotas?spaceId="+first.ID.Hex(), outsiderToken, nil), http.StatusNotFound, apierror.NotFound)
+}
+
+func TestCodeSizeQuotas(t *testing.T) {
+	setupDB(t)
+	t.Setenv("QUOTA_MAX_LOG_BYTES", "10")
+	t.Setenv("QUOTA_SPACE_MAX_BYTES", "15")
+	ada, token := createUser(t, "ada@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	vault := createVault(t, space, "src", nil)
+	log := createLog(t, vault, "a.py", "123456789")
+	a := newQuotaAPI()
+
+	create := func(code string) *httptest.ResponseRecorder {
+		return a.request("POST", "/api/logs", token, api.CreateLogRequest{SpaceID: space.ID.Hex(), VaultID: vault.ID.Hex(), Name: "b.py", Code: code})
+	}
+	expectQuota(t, create("12345678901"), http.StatusForbidden, api.QuotaUsage{Name: QuotaLogBytes, Scope: "log", Limit: 10})
+	expectQuota(t, create("1234567"), http.StatusForbidden, api.QuotaUsage{Name: QuotaSpaceBytes, Scope: "space", Limit: 15, Used: 9})
+
+	// Growing a log counts only the difference, and shrinking is always allowed
+	target := "/api/logs/" + log.ID.Hex()
+	expectStatus(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "1234567890"}), http.StatusOK)
+	expectStatus(t, create("12345"), http.StatusCreated)
+	expectQuota(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "12345678901"}), http.StatusForbidden,
+		api.QuotaUsage{Name: QuotaLogBytes, Scope: "log", Limit: 10})
+
+	t.Setenv("QUOTA_SPACE_MAX_BYTES", "5")
+	expectStatus(t, a.request("PUT", target, token, api.UpdateLogRequest{Code: "123"}), http.StatusOK)
+}
+
+func TestDailyQuotas(t *testing.T) {
+	setupDB(t)
+	t.Setenv("QUOTA_USER_RUNS_PER_DAY", "2")
+	t.Setenv("QUOTA_SPACE_RUNS_PER_DAY", "1")
+	spaceID := primitive.NewObjectID()
+	userID := primitive.NewObjectID().Hex()
+
+	consume := func(spaceID *primitive.ObjectID) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		if _, ok := consumeDailyQuota(c, QuotaRunsPerDay, userID, spaceID); ok != (w.Code == http.StatusOK) {
+			t.Fatalf("consumeDailyQuota disagrees with its response %d", w.Code)
+		}
+		return w
+	}
+	counts := func() (int, int) {
+		t.Helper()
+		ctx, cancel := testContext()
+		defer cancel()
+		user, err := dailyCount(ctx, QuotaRunsPerDay, "user", userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		space, err := dailyCount(ctx, QuotaRunsPerDay, "space", spaceID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user, space
+	}
+
+	expectStatus(t, consume(&spaceID), http.StatusOK)
+
+	// The space refuses the second run, and the user's count is rolled back
+	w := consume(&spaceID)
+	expectQuota(t, w, http.StatusTooManyRequests, api.QuotaUsage{Name: QuotaRunsPerDay, Scope: "space", Limit: 1, Used: 1})
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry <= 0 || retry > 24*60*60+1 {
+		t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+	if user, space := counts(); user != 1 || space != 1 {
+		t.Errorf("after a refused run, counts = %d, %d; want 1, 1", user, space)
+	}
+
+	// Runs outside the space count for the user alone
+	expectStatus(t, consume(nil), http.StatusOK)
+	expectQuota(t, consume(nil), http.StatusTooManyRequests, api.QuotaUsage{Name: QuotaRunsPerDay, Scope: "user", Limit: 2, Used: 2})
+	if user, _ := counts(); user != 2 {
+		t.Errorf("user count = %d, want 2", user)
+	}
+
+	if key := dailyCounterKey(QuotaRunsPerDay, "user", userID); !strings.HasPrefix(key, QuotaRunsPerDay+":user:"+userID+":") {
+		t.Errorf("counter key = %s", key)
+	}
+}
@@ -2,19 +2,24 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-const pistonAPI = "https://emkc.org/api/v2/piston"
-
-type RunRequest struct {
-	Language string `json:"language" binding:"required"`
-	Code     string `json:"code" binding:"required"`
-}
+// pistonAPI is the Piston instance code runs on; tests point it at a fake
+var pistonAPI = "https://emkc.org/api/v2/piston"
 
 type PistonRequest struct {
 	Language string   `json:"language"`
@@ -29,73 +34,250 @@ type File struct {
 	Content string `json:"content"`
 }
 
+type PistonStage struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Code   int    `json:"code"`
+	Output string `json:"output"`
+}
+
 type PistonResponse struct {
-	Run struct {
-		Stdout string `json:"stdout"`
-		Stderr string `json:"stderr"`
-		Code   int    `json:"code"`
-		Output string `json:"output"`
-	} `json:"run"`
+	Compile *PistonStage `json:"compile,omitempty"` // Only present for compiled languages
+	Run     PistonStage  `json:"run"`
+}
+
+// Failed reports whether compilation or execution exited with a non-zero code
+func (r *PistonResponse) Failed() bool {
+	if r.Compile != nil && r.Compile.Code != 0 {
+		return true
+	}
+	return r.Run.Code != 0
+}
+
+// Stderr returns the compiler errors if compilation failed, otherwise the run stderr
+func (r *PistonResponse) Stderr() string {
+	if r.Compile != nil && r.Compile.Code != 0 {
+		return r.Compile.Stderr
+	}
+	return r.Run.Stderr
+}
+
+// ExitCode returns the compiler exit code if compilation failed, otherwise the run exit code
+func (r *PistonResponse) ExitCode() int {
+	if r.Compile != nil && r.Compile.Code != 0 {
+		return r.Compile.Code
+	}
+	return r.Run.Code
 }
 
 // RunCode executes code using Piston API
 func RunCode(c *gin.Context) {
-	var req RunRequest
+	var req api.RunRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
 		return
 	}
 
+	entry := models.AuditEntry{Action: "run.execute", TargetType: "run"}
+	var runLogID *primitive.ObjectID
+	if req.LogID != "" {
+		logID, err := primitive.ObjectIDFromHex(req.LogID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+			return
+		}
+		log, ok := loadLog(c, logID, RoleEditor)
+		if !ok {
+			return
+		}
+		entry.SpaceID = &log.SpaceID
+		entry.TargetType = "log"
+		entry.TargetID = log.ID.Hex()
+		runLogID = &log.ID
+	} else if req.SpaceID != "" {
+		spaceID, err := primitive.ObjectIDFromHex(req.SpaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+		if !authorizeSpace(c, spaceID, RoleEditor, "Space not found") {
+			return
+		}
+		entry.SpaceID = &spaceID
+	}
+
+	var env map[string]string
+	if len(req.Secrets) > 0 {
+		if entry.SpaceID == nil {
+			apierror.Abort(c, apierror.InvalidRequest, "logId or spaceId required to use secrets")
+			return
+		}
+		if !supportsEnvInjection(req.Language) {
+			apierror.Abort(c, apierror.InvalidRequest, "Secrets can't be injected into "+req.Language+" programs")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var err error
+		env, err = loadSecretEnv(ctx, *entry.SpaceID, req.Secrets)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, err.Error())
+			return
+		}
+	}
+
+	charge, ok := consumeDailyQuota(c, QuotaRunsPerDay, currentUserID(c), entry.SpaceID)
+	if !ok {
+		return
+	}
+
+	pistonResp, err := executeCodeWithEnv(req.Language, req.Code, env)
+	if err != nil {
+		// The run didn't happen, so it doesn't count
+		charge.refund()
+		respondRunnerError(c, err)
+		return
+	}
+
+	entry.After = runSnapshot(req.Language, req.Code, pistonResp)
+	if len(req.Secrets) > 0 {
+		entry.After["secrets"] = req.Secrets
+	}
+	recordAudit(c, entry)
+
+	if entry.SpaceID != nil {
+		events.Publish(events.ForRun(*entry.SpaceID, events.Run{
+			LogID:    runLogID,
+			UserID:   currentUserID(c),
+			Language: req.Language,
+			ExitCode: pistonResp.ExitCode(),
+		}))
+	}
+
+	// Return result with secret values masked
+	c.JSON(http.StatusOK, api.RunResult{
+		Stdout: maskSecrets(pistonResp.Run.Stdout, env),
+		Stderr: maskSecrets(pistonResp.Run.Stderr, env),
+		Code:   pistonResp.Run.Code,
+		Output: maskSecrets(pistonResp.Run.Output, env),
+	})
+}
+
+// runSnapshot summarises a run for the audit log
+func runSnapshot(language, code string, result *PistonResponse) auditSnapshot {
+	return auditSnapshot{
+		"language":  language,
+		"codeBytes": len(code),
+		"exitCode":  result.ExitCode(),
+	}
+}
+
+// executeCode runs code through the Piston API and returns the raw result
+func executeCode(language, code string) (*PistonResponse, error) {
+	return executeCodeWithEnv(language, code, nil)
+}
+
+// executeCodeWithEnv runs code with extra environment variables. Piston has no
+// way to pass an environment, so a small entrypoint sets the variables and then
+// runs the user's file unchanged.
+func executeCodeWithEnv(language, code string, env map[string]string) (*PistonResponse, error) {
 	// Map frontend language names to Piston language names
-	pistonLang := mapLanguageToPiston(req.Language)
-	filename := getFilenameForLanguage(req.Language)
+	pistonLang := mapLanguageToPiston(language)
+	filename := getFilenameForLanguage(language)
+
+	files := []File{
+		{
+			Name:    filename,
+			Content: code,
+		},
+	}
+
+	if len(env) > 0 {
+		bootstrap, err := envBootstrap(language, filename, env)
+		if err != nil {
+			return nil, err
+		}
+		// Piston runs the first file
+		files = append([]File{bootstrap}, files...)
+	}
 
 	pistonReq := PistonRequest{
 		Language: pistonLang,
 		Version:  "*", // Use latest version
-		Files: []File{
-			{
-				Name:    filename,
-				Content: req.Code,
-			},
-		},
-		Stdin: "",
-		Args:  []string{},
+		Files:    files,
+		Stdin:    "",
+		Args:     []string{},
 	}
 
 	// Make request to Piston API
 	jsonData, err := json.Marshal(pistonReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode request"})
-		return
+		return nil, fmt.Errorf("failed to encode request: %w", err)
 	}
 
-	resp, err := http.Post(pistonAPI+"/execute", "application/json", bytes.NewBuffer(jsonData))
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(pistonAPI+"/execute", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to execute code"})
-		return
+		return nil, fmt.Errorf("failed to reach the code runner: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
-		return
+		return nil, fmt.Errorf("failed to read the code runner response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("code runner responded with status %d", resp.StatusCode)
 	}
 
 	var pistonResp PistonResponse
 	if err := json.Unmarshal(body, &pistonResp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response"})
-		return
+		return nil, fmt.Errorf("failed to parse the code runner response: %w", err)
 	}
 
-	// Return result
-	c.JSON(http.StatusOK, gin.H{
-		"stdout": pistonResp.Run.Stdout,
-		"stderr": pistonResp.Run.Stderr,
-		"code":   pistonResp.Run.Code,
-		"output": pistonResp.Run.Output,
-	})
+	return &pistonResp, nil
+}
+
+// respondRunnerError reports that code couldn't be run because the runner failed
+func respondRunnerError(c *gin.Context, err error) {
+	apierror.Abort(c, apierror.UpstreamError, "Code runner failed: "+err.Error())
+}
+
+// supportsEnvInjection reports whether secrets can be injected for a language
+func supportsEnvInjection(language string) bool {
+	return language == "python" || language == "javascript"
+}
+
+// envBootstrap returns an entrypoint that sets env and then runs filename
+func envBootstrap(language, filename string, env map[string]string) (File, error) {
+	// A JSON object of strings is also a valid Python dict and JavaScript object literal
+	envJSON, err := json.Marshal(env)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to encode environment: %w", err)
+	}
+
+	switch language {
+	case "python":
+		return File{
+			Name: "codeflow_env.py",
+			Content: fmt.Sprintf(`import os, runpy, sys
+os.environ.update(%s)
+sys.argv[0] = %q
+runpy.run_path(%q, run_name="__main__")
+`, envJSON, filename, filename),
+		}, nil
+	case "javascript":
+		return File{
+			Name: "codeflow_env.js",
+			Content: fmt.Sprintf(`Object.assign(process.env, %s);
+require(%q);
+`, envJSON, "./"+filename),
+		}, nil
+	default:
+		return File{}, fmt.Errorf("secrets can't be injected into %s programs", language)
+	}
 }
 
 // mapLanguageToPiston maps our language names to Piston's expected names
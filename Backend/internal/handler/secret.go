@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/secrets"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxSecretValueBytes = 32 * 1024
+	secretMask          = "********"
+)
+
+var secretNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// GetSecrets lists the names of a space's secrets, never their values
+func GetSecrets(c *gin.Context) {
+	spaceID, ok := secretSpace(c)
+	if !ok {
+		return
+	}
+
+	collection := db.Database.Collection("secrets")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := collection.Find(ctx, bson.M{"spaceId": spaceID}, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch secrets")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var list []models.Secret
+	if err := cursor.All(ctx, &list); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode secrets")
+		return
+	}
+
+	if list == nil {
+		list = []models.Secret{}
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateSecret stores a new encrypted secret in a space
+func CreateSecret(c *gin.Context) {
+	spaceID, ok := secretSpace(c)
+	if !ok {
+		return
+	}
+
+	var req api.CreateSecretRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if !validateSecret(c, req.Name, req.Value) {
+		return
+	}
+
+	ciphertext, nonce, err := secrets.Seal(req.Value, secretAssociatedData(spaceID, req.Name))
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to encrypt secret")
+		return
+	}
+
+	secret := models.Secret{
+		ID:         primitive.NewObjectID(),
+		SpaceID:    spaceID,
+		Name:       req.Name,
+		Ciphertext: ciphertext,
+		Nonce:      nonce,
+		CreatedBy:  currentUserID(c),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	collection := db.Database.Collection("secrets")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = collection.InsertOne(ctx, secret)
+	if mongo.IsDuplicateKeyError(err) {
+		apierror.Abort(c, apierror.Conflict, "Secret already exists")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create secret")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "secret.create",
+		SpaceID:    &spaceID,
+		TargetType: "secret",
+		TargetID:   secret.Name,
+	})
+
+	c.JSON(http.StatusCreated, secret)
+}
+
+// UpdateSecret replaces the value of a secret
+func UpdateSecret(c *gin.Context) {
+	spaceID, ok := secretSpace(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("name")
+
+	var req api.UpdateSecretRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if !validateSecret(c, name, req.Value) {
+		return
+	}
+
+	ciphertext, nonce, err := secrets.Seal(req.Value, secretAssociatedData(spaceID, name))
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to encrypt secret")
+		return
+	}
+
+	collection := db.Database.Collection("secrets")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"ciphertext": ciphertext,
+			"nonce":      nonce,
+			"updatedAt":  time.Now(),
+		},
+	}
+
+	var secret models.Secret
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"spaceId": spaceID, "name": name}, update, opts).Decode(&secret)
+	if err != nil {
+		apierror.Abort(c, apierror.NotFound, "Secret not found")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "secret.update",
+		SpaceID:    &spaceID,
+		TargetType: "secret",
+		TargetID:   name,
+	})
+
+	c.JSON(http.StatusOK, secret)
+}
+
+// DeleteSecret deletes a secret
+func DeleteSecret(c *gin.Context) {
+	spaceID, ok := secretSpace(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("name")
+
+	collection := db.Database.Collection("secrets")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"spaceId": spaceID, "name": name})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete secret")
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		apierror.Abort(c, apierror.NotFound, "Secret not found")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "secret.delete",
+		SpaceID:    &spaceID,
+		TargetType: "secret",
+		TargetID:   name,
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Secret deleted successfully"})
+}
+
+// secretSpace parses the :id space param and requires the editor role and a configured store
+func secretSpace(c *gin.Context) (primitive.ObjectID, bool) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return spaceID, false
+	}
+
+	if !secrets.Enabled() {
+		apierror.Abort(c, apierror.NotConfigured, secrets.ErrNotConfigured.Error())
+		return spaceID, false
+	}
+
+	return spaceID, authorizeSpace(c, spaceID, RoleEditor, "Space not found")
+}
+
+func validateSecret(c *gin.Context, name, value string) bool {
+	if !secretNameRegex.MatchString(name) {
+		apierror.Abort(c, apierror.InvalidRequest, "Secret names must be valid environment variable names")
+		return false
+	}
+	if len(value) > maxSecretValueBytes {
+		apierror.Abort(c, apierror.InvalidRequest, fmt.Sprintf("Secret values are limited to %d bytes", maxSecretValueBytes))
+		return false
+	}
+	return true
+}
+
+// secretAssociatedData ties a ciphertext to its space and name
+func secretAssociatedData(spaceID primitive.ObjectID, name string) string {
+	return spaceID.Hex() + "/" + name
+}
+
+// loadSecretEnv decrypts the named secrets of a space into environment variables
+func loadSecretEnv(ctx context.Context, spaceID primitive.ObjectID, names []string) (map[string]string, error) {
+	if !secrets.Enabled() {
+		return nil, secrets.ErrNotConfigured
+	}
+
+	cursor, err := db.Database.Collection("secrets").Find(ctx, bson.M{"spaceId": spaceID, "name": bson.M{"$in": names}})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []models.Secret
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string, len(list))
+	for _, secret := range list {
+		value, err := secrets.Open(secret.Ciphertext, secret.Nonce, secretAssociatedData(spaceID, secret.Name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s", secret.Name)
+		}
+		env[secret.Name] = value
+	}
+
+	for _, name := range names {
+		if _, ok := env[name]; !ok {
+			return nil, fmt.Errorf("secret %s not found", name)
+		}
+	}
+	return env, nil
+}
+
+// maskSecrets replaces every secret value in text with a mask. Occurrences are
+// found in the original text and masked together, so values that overlap or
+// contain one another are fully hidden.
+func maskSecrets(text string, env map[string]string) string {
+	masked := make([]bool, len(text))
+	found := false
+	for _, value := range env {
+		if value == "" {
+			continue
+		}
+		covered := 0 // Bytes before this are already marked for this value
+		indexAll(text, value, func(i int) {
+			for j := max(i, covered); j < i+len(value); j++ {
+				masked[j] = true
+			}
+			covered = i + len(value)
+			found = true
+		})
+	}
+	if !found {
+		return text
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if !masked[i] {
+			b.WriteByte(text[i])
+			continue
+		}
+		b.WriteString(secretMask)
+		for i+1 < len(text) && masked[i+1] {
+			i++
+		}
+	}
+	return b.String()
+}
+
+// indexAll calls match with the start of every occurrence of value in text,
+// overlapping ones included. It uses Knuth-Morris-Pratt to stay linear when a
+// long value repeats, where calling strings.Index at each offset would not.
+func indexAll(text, value string, match func(int)) {
+	fail := make([]int, len(value))
+	for i, k := 1, 0; i < len(value); i++ {
+		for k > 0 && value[i] != value[k] {
+			k = fail[k-1]
+		}
+		if value[i] == value[k] {
+			k++
+		}
+		fail[i] = k
+	}
+
+	for i, k := 0, 0; i < len(text); i++ {
+		for k > 0 && text[i] != value[k] {
+			k = fail[k-1]
+		}
+		if text[i] == value[k] {
+			k++
+		}
+		if k == len(value) {
+			match(i - k + 1)
+			k = fail[k-1]
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/secrets"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// enableSecrets configures a master key for the secrets store
+func enableSecrets(t *testing.T) {
+	t.Helper()
+	t.Setenv("SECRETS_MASTER_KEY", hex.EncodeToString([]byte(strings.Repeat("k", 32))))
+	secrets.Init()
+	t.Cleanup(func() {
+		t.Setenv("SECRETS_MASTER_KEY", "")
+		secrets.Init()
+	})
+}
+
+func newSecretAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/spaces/:id/secrets", GetSecrets)
+	a.api.POST("/spaces/:id/secrets", CreateSecret)
+	a.api.PUT("/spaces/:id/secrets/:name", UpdateSecret)
+	a.api.DELETE("/spaces/:id/secrets/:name", DeleteSecret)
+	a.api.POST("/run", RunCode)
+	return a
+}
+
+// storedSecrets returns every document in the secrets and audit collections as
+// extended JSON, to check a value never reaches the database in the clear
+func storedSecrets(t *testing.T) string {
+	t.Helper()
+	ctx, cancel := testContext()
+	defer cancel()
+	var all []string
+	for _, name := range []string{"secrets", "audit"} {
+		cursor, err := db.Database.Collection(name).Find(ctx, bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var docs []bson.M
+		if err := cursor.All(ctx, &docs); err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			data, err := bson.MarshalExtJSON(doc, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, string(data))
+		}
+	}
+	return strings.Join(all, "\n")
+}
+
+func TestSecrets(t *testing.T) {
+	setupDB(t)
+	ada, token := createUser(t, "ada@example.com")
+	bob, bobToken := createUser(t, "bob@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	addMember(t, space.ID, bob.ID.Hex(), RoleViewer)
+	a := newSecretAPI()
+	target := "/api/spaces/" + space.ID.Hex() + "/secrets"
+
+	expectError(t, a.request("GET", target, token, nil), http.StatusServiceUnavailable, apierror.NotConfigured)
+	enableSecrets(t)
+
+	w := a.request("POST", target, token, api.CreateSecretRequest{Name: "API_TOKEN", Value: "hunter2"})
+	expectStatus(t, w, http.StatusCreated)
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("create returned the value: %s", w.Body.String())
+	}
+	expectError(t, a.request("POST", target, token, api.CreateSecretRequest{Name: "API_TOKEN", Value: "x"}), http.StatusConflict, apierror.Conflict)
+	expectError(t, a.request("POST", target, token, api.CreateSecretRequest{Name: "1BAD", Value: "x"}), http.StatusBadRequest, apierror.InvalidRequest)
+	expectError(t, a.request("POST", target, token, api.CreateSecretRequest{Name: "HUGE", Value: strings.Repeat("x", maxSecretValueBytes+1)}), http.StatusBadRequest, apierror.InvalidRequest)
+
+	// Viewers can't see even the names
+	expectError(t, a.request("GET", target, bobToken, nil), http.StatusForbidden, apierror.Forbidden)
+
+	w = a.request("GET", target, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if list := decodeBody[[]models.Secret](t, w); len(list) != 1 || list[0].Name != "API_TOKEN" || strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("list = %s", w.Body.String())
+	}
+
+	w = a.request("PUT", target+"/API_TOKEN", token, api.UpdateSecretRequest{Value: "correct horse"})
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), "correct horse") {
+		t.Errorf("update returned the value: %s", w.Body.String())
+	}
+	expectError(t, a.request("PUT", target+"/MISSING", token, api.UpdateSecretRequest{Value: "x"}), http.StatusNotFound, apierror.NotFound)
+
+	if stored := storedSecrets(t); strings.Contains(stored, "hunter2") || strings.Contains(stored, "correct horse") {
+		t.Errorf("a value was stored in the clear:\n%s", stored)
+	}
+	ctx, cancel := testContext()
+	defer cancel()
+	env, err := loadSecretEnv(ctx, space.ID, []string{"API_TOKEN"})
+	if err != nil || env["API_TOKEN"] != "correct horse" {
+		t.Errorf("loadSecretEnv = %v, %v", env, err)
+	}
+	if _, err := loadSecretEnv(ctx, space.ID, []string{"API_TOKEN", "MISSING"}); err == nil || err.Error() != "secret MISSING not found" {
+		t.Errorf("loading a missing secret: err = %v", err)
+	}
+
+	// A ciphertext copied to another name doesn't decrypt
+	var secret models.Secret
+	if err := db.Database.Collection("secrets").FindOne(ctx, bson.M{"name": "API_TOKEN"}).Decode(&secret); err != nil {
+		t.Fatal(err)
+	}
+	insert(t, "secrets", models.Secret{ID: primitive.NewObjectID(), SpaceID: space.ID, Name: "COPY", Ciphertext: secret.Ciphertext, Nonce: secret.Nonce})
+	if _, err := loadSecretEnv(ctx, space.ID, []string{"COPY"}); err == nil || err.Error() != "failed to decrypt secret COPY" {
+		t.Errorf("loading a copied secret: err = %v", err)
+	}
+
+	expectStatus(t, a.request("DELETE", target+"/API_TOKEN", token, nil), http.StatusOK)
+	expectError(t, a.request("DELETE", target+"/API_TOKEN", token, nil), http.StatusNotFound, apierror.NotFound)
+	if got := auditActions(t, "API_TOKEN"); strings.Join(got, " ") != "secret.create secret.update secret.delete" {
+		t.Errorf("audit = %v", got)
+	}
+}
+
+func TestRunMasksSecrets(t *testing.T) {
+	setupDB(t)
+	enableSecrets(t)
+	ada, token := createUser(t, "ada@example.com")
+	space := createSpace(t, ada.ID.Hex(), "Space")
+	a := newSecretAPI()
+	target := "/api/spaces/" + space.ID.Hex() + "/secrets"
+	expectStatus(t, a.request("POST", target, token, api.CreateSecretRequest{Name: "USER", Value: "admin"}), http.StatusCreated)
+	expectStatus(t, a.request("POST", target, token, api.CreateSecretRequest{Name: "PASSWORD", Value: "minty"}), http.StatusCreated)
+
+	// The program prints both values, overlapping in "adminty"
+	fakePiston(t, func(string) (int, PistonResponse) {
+		return http.StatusOK, PistonResponse{Run: PistonStage{Stdout: "user=admin\n", Stderr: "adminty", Output: "user=admin\nadminty"}}
+	})
+	w := a.request("POST", "/api/run", token, api.RunRequest{Language: "python", Code: "print(1)", SpaceID: space.ID.Hex(), Secrets: []string{"USER", "PASSWORD"}})
+	expectStatus(t, w, http.StatusOK)
+	result := decodeBody[api.RunResult](t, w)
+	if result.Stdout != "user="+secretMask+"\n" || result.Stderr != secretMask || result.Output != "user="+secretMask+"\n"+secretMask {
+		t.Errorf("result = %+v", result)
+	}
+	if stored := storedSecrets(t); strings.Contains(stored, "admin") || strings.Contains(stored, "minty") {
+		t.Errorf("a value was stored in the clear:\n%s", stored)
+	}
+
+	expectError(t, a.request("POST", "/api/run", token, api.RunRequest{Language: "python", Code: "print(1)", Secrets: []string{"USER"}}), http.StatusBadRequest, apierror.InvalidRequest)
+	expectError(t, a.request("POST", "/api/run", token, api.RunRequest{Language: "go", Code: "package main", SpaceID: space.ID.Hex(), Secrets: []string{"USER"}}), http.StatusBadRequest, apierror.InvalidRequest)
+}
+
+func TestMaskSecrets(t *testing.T) {
+	tests := []struct {
+		text string
+		env  map[string]string
+		want string
+	}{
+		{"no secrets here", map[string]string{"A": "hunter2"}, "no secrets here"},
+		{"pw=hunter2 again hunter2", map[string]string{"A": "hunter2"}, "pw=******** again ********"},
+		{"abc", map[string]string{"A": "", "B": "b"}, "a********c"},
+		{"token-and-tokenizer", map[string]string{"A": "token", "B": "tokenizer"}, "********-and-********"},
+		{"abcd", map[string]string{"A": "abc", "B": "bcd"}, "********"},    // Overlapping values
+		{"aaaaa", map[string]string{"A": "aa"}, "********"},                // Overlapping occurrences
+		{"x**y", map[string]string{"A": "*"}, "x********y"},                // Values in the mask itself
+		{"héllo wörld", map[string]string{"A": "ö"}, "héllo w********rld"}, // Multibyte
+		{"hunter2", nil, "hunter2"},
+	}
+	for _, tt := range tests {
+		if got := maskSecrets(tt.text, tt.env); got != tt.want {
+			t.Errorf("maskSecrets(%q, %v) = %q, want %q", tt.text, tt.env, got, tt.want)
+		}
+	}
+
+	// A long value repeated through a long output is marked once per byte
+	value := strings.Repeat("a", maxSecretValueBytes)
+	start := time.Now()
+	if got := maskSecrets(strings.Repeat("a", 1<<20)+"b", map[string]string{"A": value}); got != secretMask+"b" {
+		t.Errorf("masking a repeated value left %q", got[len(got)-min(len(got), 20):])
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("masking a repeated value took %v", elapsed)
+	}
+}
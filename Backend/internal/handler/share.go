@@ -0,0 +1,494 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateShareLink creates an unguessable public link to a log or vault. Only the
+// token's hash is stored, so the token is only in this response. The link stops
+// working if its creator stops being an editor of the space.
+func CreateShareLink(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var req api.CreateShareLinkRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	targetID, err := primitive.ObjectIDFromHex(req.TargetID)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid target ID")
+		return
+	}
+
+	if req.ExpiresInHours < 0 {
+		apierror.Abort(c, apierror.InvalidRequest, "expiresInHours must not be negative")
+		return
+	}
+
+	var spaceID primitive.ObjectID
+	if req.TargetType == "log" {
+		log, ok := loadLog(c, targetID, RoleEditor)
+		if !ok {
+			return
+		}
+		spaceID = log.SpaceID
+	} else {
+		vault, ok := loadVault(c, targetID, RoleEditor)
+		if !ok {
+			return
+		}
+		spaceID = vault.SpaceID
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to generate share token")
+		return
+	}
+
+	share := models.ShareLink{
+		ID:         primitive.NewObjectID(),
+		Token:      token,
+		TokenHash:  auth.HashAPIToken(token),
+		SpaceID:    spaceID,
+		TargetType: req.TargetType,
+		TargetID:   targetID,
+		CreatedBy:  userID,
+		AllowRun:   req.AllowRun,
+		CreatedAt:  time.Now(),
+	}
+	if req.ExpiresInHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		share.ExpiresAt = &expiresAt
+	}
+
+	collection := db.Database.Collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, share); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create share link")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "share.create",
+		SpaceID:    &share.SpaceID,
+		TargetType: "share",
+		TargetID:   share.ID.Hex(),
+		After:      shareSnapshot(share),
+	})
+
+	c.JSON(http.StatusCreated, share)
+}
+
+// GetShareLinks lists share links created by the user, or every link in a space for its owners
+func GetShareLinks(c *gin.Context) {
+	userID := currentUserID(c)
+
+	collection := db.Database.Collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"createdBy": userID}
+
+	if spaceID := c.Query("spaceId"); spaceID != "" {
+		objectID, err := primitive.ObjectIDFromHex(spaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+
+		role, err := spaceRole(ctx, objectID, userID)
+		if err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to check space access")
+			return
+		}
+		if role == "" {
+			apierror.Abort(c, apierror.NotFound, "Space not found")
+			return
+		}
+		if role == RoleOwner {
+			delete(filter, "createdBy")
+		}
+		filter["spaceId"] = objectID
+	}
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch share links")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var shares []models.ShareLink
+	if err := cursor.All(ctx, &shares); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode share links")
+		return
+	}
+
+	if shares == nil {
+		shares = []models.ShareLink{}
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+// DeleteShareLink revokes a share link; allowed for its creator and the space owners
+func DeleteShareLink(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid share link ID")
+		return
+	}
+
+	collection := db.Database.Collection("shares")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var share models.ShareLink
+	if err := collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&share); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Share link not found")
+		return
+	}
+
+	if share.CreatedBy != userID && !authorizeSpace(c, share.SpaceID, RoleOwner, "Share link not found") {
+		return
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": objectID}); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete share link")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "share.delete",
+		SpaceID:    &share.SpaceID,
+		TargetType: "share",
+		TargetID:   id,
+		Before:     shareSnapshot(share),
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Share link revoked successfully"})
+}
+
+// GetSharedContent serves the log, or the vault and its subtree, behind a share link
+func GetSharedContent(c *gin.Context) {
+	share, ok := loadShareLink(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	response := api.SharedContent{
+		TargetType: share.TargetType,
+		AllowRun:   share.AllowRun,
+		ExpiresAt:  share.ExpiresAt,
+	}
+
+	if share.TargetType == "log" {
+		var log models.Log
+		if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": share.TargetID}).Decode(&log); err != nil {
+			apierror.Abort(c, apierror.NotFound, "Shared content no longer exists")
+			return
+		}
+		shared := newSharedLog(log)
+		response.Log = &shared
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	tree, _, err := loadVaultSubtree(ctx, share.TargetID)
+	if err != nil {
+		apierror.Abort(c, apierror.NotFound, "Shared content no longer exists")
+		return
+	}
+
+	response.Tree = &tree
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSharedLog serves a single log from inside a shared vault
+func GetSharedLog(c *gin.Context) {
+	share, ok := loadShareLink(c)
+	if !ok {
+		return
+	}
+
+	log, ok := loadSharedLog(c, share, c.Param("logId"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, newSharedLog(log))
+}
+
+// RunSharedLog runs the shared code when the link allows it. Only stored code can be run,
+// so a share link can't be used to execute arbitrary programs.
+func RunSharedLog(c *gin.Context) {
+	share, ok := loadShareLink(c)
+	if !ok {
+		return
+	}
+
+	if !share.AllowRun {
+		apierror.Abort(c, apierror.Forbidden, "This share link does not allow running code")
+		return
+	}
+
+	var req api.RunSharedLogRequest
+	c.ShouldBindJSON(&req)
+
+	logID := req.LogID
+	if share.TargetType == "log" {
+		logID = share.TargetID.Hex()
+	}
+
+	log, ok := loadSharedLog(c, share, logID)
+	if !ok {
+		return
+	}
+
+	// Anonymous runs count against the space's daily quota
+	if _, ok := consumeDailyQuota(c, QuotaRunsPerDay, "", &log.SpaceID); !ok {
+		return
+	}
+
+	pistonResp, err := executeCode(log.Language, log.Code)
+	if err != nil {
+		respondRunnerError(c, err)
+		return
+	}
+
+	after := runSnapshot(log.Language, log.Code, pistonResp)
+	after["shareId"] = share.ID.Hex()
+	recordAudit(c, models.AuditEntry{
+		Action:     "run.execute",
+		SpaceID:    &log.SpaceID,
+		TargetType: "log",
+		TargetID:   log.ID.Hex(),
+		After:      after,
+	})
+
+	events.Publish(events.ForRun(log.SpaceID, events.Run{
+		LogID:    &log.ID,
+		Language: log.Language,
+		ExitCode: pistonResp.ExitCode(),
+	}))
+
+	c.JSON(http.StatusOK, api.RunResult{
+		Stdout: pistonResp.Run.Stdout,
+		Stderr: pistonResp.Run.Stderr,
+		Code:   pistonResp.Run.Code,
+		Output: pistonResp.Run.Output,
+	})
+}
+
+// loadShareLink fetches the unexpired share link in the :token param, as long as
+// its creator can still edit the space
+func loadShareLink(c *gin.Context) (models.ShareLink, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var share models.ShareLink
+	err := db.Database.Collection("shares").FindOne(ctx, bson.M{"tokenHash": auth.HashAPIToken(c.Param("token"))}).Decode(&share)
+	if err != nil || share.Expired() {
+		apierror.Abort(c, apierror.NotFound, "Share link not found or expired")
+		return share, false
+	}
+
+	role, err := spaceRole(ctx, share.SpaceID, share.CreatedBy)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to check share link")
+		return share, false
+	}
+	if roleRanks[role] < roleRanks[RoleEditor] {
+		apierror.Abort(c, apierror.NotFound, "Share link not found or expired")
+		return share, false
+	}
+
+	return share, true
+}
+
+// loadSharedLog fetches a log that is covered by a share link
+func loadSharedLog(c *gin.Context, share models.ShareLink, id string) (models.Log, bool) {
+	var log models.Log
+
+	logID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid log ID")
+		return log, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if share.TargetType == "log" {
+		if logID != share.TargetID {
+			apierror.Abort(c, apierror.NotFound, "Log not found")
+			return log, false
+		}
+	} else {
+		_, vaultIDs, err := loadVaultSubtree(ctx, share.TargetID)
+		if err != nil {
+			apierror.Abort(c, apierror.NotFound, "Shared content no longer exists")
+			return log, false
+		}
+		if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": logID}).Decode(&log); err != nil || !vaultIDs[log.VaultID] {
+			apierror.Abort(c, apierror.NotFound, "Log not found")
+			return log, false
+		}
+		return log, true
+	}
+
+	if err := db.Database.Collection("logs").FindOne(ctx, bson.M{"_id": logID}).Decode(&log); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Shared content no longer exists")
+		return log, false
+	}
+	return log, true
+}
+
+// loadVaultSubtree builds the tree below a vault and returns the IDs of every vault in it
+func loadVaultSubtree(ctx context.Context, rootID primitive.ObjectID) (api.TreeNode, map[primitive.ObjectID]bool, error) {
+	var root models.Vault
+	if err := db.Database.Collection("vaults").FindOne(ctx, bson.M{"_id": rootID}).Decode(&root); err != nil {
+		return api.TreeNode{}, nil, err
+	}
+
+	cursor, err := db.Database.Collection("vaults").Find(ctx, bson.M{"spaceId": root.SpaceID})
+	if err != nil {
+		return api.TreeNode{}, nil, err
+	}
+	var vaults []models.Vault
+	if err := cursor.All(ctx, &vaults); err != nil {
+		return api.TreeNode{}, nil, err
+	}
+
+	childVaults := make(map[primitive.ObjectID][]models.Vault)
+	for _, vault := range vaults {
+		if vault.ParentID != nil {
+			childVaults[*vault.ParentID] = append(childVaults[*vault.ParentID], vault)
+		}
+	}
+
+	// Walk down from the root to find every vault in the subtree
+	vaultIDs := map[primitive.ObjectID]bool{root.ID: true}
+	queue := []primitive.ObjectID{root.ID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range childVaults[id] {
+			if !vaultIDs[child.ID] {
+				vaultIDs[child.ID] = true
+				queue = append(queue, child.ID)
+			}
+		}
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(vaultIDs))
+	for id := range vaultIDs {
+		ids = append(ids, id)
+	}
+
+	opts := options.Find().SetProjection(bson.M{"code": 0})
+	cursor, err = db.Database.Collection("logs").Find(ctx, bson.M{"vaultId": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return api.TreeNode{}, nil, err
+	}
+	var logs []models.Log
+	if err := cursor.All(ctx, &logs); err != nil {
+		return api.TreeNode{}, nil, err
+	}
+
+	vaultLogs := make(map[primitive.ObjectID][]models.Log)
+	for _, log := range logs {
+		vaultLogs[log.VaultID] = append(vaultLogs[log.VaultID], log)
+	}
+
+	return buildVaultNode(root, childVaults, vaultLogs), vaultIDs, nil
+}
+
+// buildVaultNode recursively builds the tree node for a vault
+func buildVaultNode(vault models.Vault, childVaults map[primitive.ObjectID][]models.Vault, vaultLogs map[primitive.ObjectID][]models.Log) api.TreeNode {
+	node := api.TreeNode{
+		ID:       vault.ID.Hex(),
+		Name:     vault.Name,
+		Type:     "vault",
+		Path:     vault.Path,
+		Children: []api.TreeNode{},
+	}
+
+	for _, child := range childVaults[vault.ID] {
+		node.Children = append(node.Children, buildVaultNode(child, childVaults, vaultLogs))
+	}
+
+	for _, log := range vaultLogs[vault.ID] {
+		node.Children = append(node.Children, api.TreeNode{
+			ID:       log.ID.Hex(),
+			Name:     log.Name,
+			Type:     "log",
+			Language: log.Language,
+			Path:     log.Path,
+		})
+	}
+
+	return node
+}
+
+// shareSnapshot summarises a share link for the audit log, leaving out the token
+func shareSnapshot(share models.ShareLink) auditSnapshot {
+	snapshot := auditSnapshot{
+		"targetType": share.TargetType,
+		"targetId":   share.TargetID.Hex(),
+		"allowRun":   share.AllowRun,
+	}
+	if share.ExpiresAt != nil {
+		snapshot["expiresAt"] = *share.ExpiresAt
+	}
+	return snapshot
+}
+
+func newSharedLog(log models.Log) api.SharedLog {
+	return api.SharedLog{
+		ID:        log.ID.Hex(),
+		Name:      log.Name,
+		Path:      log.Path,
+		Language:  log.Language,
+		Code:      log.Code,
+		UpdatedAt: log.UpdatedAt,
+	}
+}
+
+// generateShareToken returns a random URL-safe token
+func generateShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newShareAPI() *testAPI {
+	a := newTestAPI()
+	a.api.GET("/shares", GetShareLinks)
+	a.api.POST("/shares", CreateShareLink)
+	a.api.DELETE("/spaces/:id/members/:userId", RemoveSpaceMember)
+	a.api.PUT("/spaces/:id/members/:userId", UpdateSpaceMember)
+	public := a.engine.Group("/api/public")
+	public.GET("/shares/:token", GetSharedContent)
+	public.GET("/shares/:token/logs/:logId", GetSharedLog)
+	public.POST("/shares/:token/run", RunSharedLog)
+	return a
+}
+
+// share creates a share link and returns it with its token
+func share(t *testing.T, a *testAPI, token string, req api.CreateShareLinkRequest) models.ShareLink {
+	t.Helper()
+	w := a.request("POST", "/api/shares", token, req)
+	expectStatus(t, w, http.StatusCreated)
+	link := decodeBody[models.ShareLink](t, w)
+	if link.Token == "" {
+		t.Fatalf("created link has no token: %s", w.Body.String())
+	}
+	return link
+}
+
+func TestShareTokensAreStoredHashed(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	log := createLog(t, createVault(t, space, "src", nil), "main.py", "print(1)")
+	a := newShareAPI()
+
+	link := share(t, a, token, api.CreateShareLinkRequest{TargetType: "log", TargetID: log.ID.Hex()})
+
+	ctx, cancel := testContext()
+	defer cancel()
+	var stored bson.M
+	if err := db.Database.Collection("shares").FindOne(ctx, bson.M{"_id": link.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range stored {
+		if value == link.Token {
+			t.Errorf("the token is stored in plaintext as %q", key)
+		}
+	}
+
+	w := a.request("GET", "/api/public/shares/"+link.Token, "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if content := decodeBody[api.SharedContent](t, w); content.Log == nil || content.Log.Code != "print(1)" {
+		t.Errorf("content = %+v", content)
+	}
+	expectError(t, a.request("GET", "/api/public/shares/"+link.TokenHash+"x", "", nil), http.StatusNotFound, "NOT_FOUND")
+
+	w = a.request("GET", "/api/shares", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if links := decodeBody[[]models.ShareLink](t, w); len(links) != 1 || links[0].Token != "" {
+		t.Errorf("listed links = %+v, want one without its token", links)
+	}
+}
+
+func TestShareLinksEndWithTheirCreatorsAccess(t *testing.T) {
+	setupDB(t)
+	owner, ownerToken := createUser(t, "ada@example.com")
+	editor, editorToken := createUser(t, "bob@example.com")
+	space := createSpace(t, owner.ID.Hex(), "Space")
+	addMember(t, space.ID, editor.ID.Hex(), RoleEditor)
+	log := createLog(t, createVault(t, space, "src", nil), "main.py", "print(1)")
+	a := newShareAPI()
+
+	link := share(t, a, editorToken, api.CreateShareLinkRequest{TargetType: "log", TargetID: log.ID.Hex()})
+	target := "/api/public/shares/" + link.Token
+	expectStatus(t, a.request("GET", target, "", nil), http.StatusOK)
+
+	member := "/api/spaces/" + space.ID.Hex() + "/members/" + editor.ID.Hex()
+	expectStatus(t, a.request("PUT", member, ownerToken, api.UpdateSpaceMemberRequest{Role: RoleViewer}), http.StatusOK)
+	expectError(t, a.request("GET", target, "", nil), http.StatusNotFound, "NOT_FOUND")
+
+	expectStatus(t, a.request("PUT", member, ownerToken, api.UpdateSpaceMemberRequest{Role: RoleEditor}), http.StatusOK)
+	expectStatus(t, a.request("GET", target, "", nil), http.StatusOK)
+
+	expectStatus(t, a.request("DELETE", member, ownerToken, nil), http.StatusOK)
+	expectError(t, a.request("GET", target, "", nil), http.StatusNotFound, "NOT_FOUND")
+
+	// Viewers can't create links in the first place
+	viewer, viewerToken := createUser(t, "carol@example.com")
+	addMember(t, space.ID, viewer.ID.Hex(), RoleViewer)
+	w := a.request("POST", "/api/shares", viewerToken, api.CreateShareLinkRequest{TargetType: "log", TargetID: log.ID.Hex()})
+	expectError(t, w, http.StatusForbidden, "FORBIDDEN")
+}
+
+func TestVaultSharesStayInsideTheVault(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	root := createVault(t, space, "src", nil)
+	nested := createLog(t, createVault(t, space, "lib", &root), "util.py", "x = 1")
+	outside := createLog(t, createVault(t, space, "private", nil), "keys.py", "KEY = 1")
+	a := newShareAPI()
+
+	link := share(t, a, token, api.CreateShareLinkRequest{TargetType: "vault", TargetID: root.ID.Hex()})
+	expectStatus(t, a.request("GET", "/api/public/shares/"+link.Token+"/logs/"+nested.ID.Hex(), "", nil), http.StatusOK)
+	expectError(t, a.request("GET", "/api/public/shares/"+link.Token+"/logs/"+outside.ID.Hex(), "", nil), http.StatusNotFound, "NOT_FOUND")
+
+	// Running needs the link to allow it
+	w := a.request("POST", "/api/public/shares/"+link.Token+"/run", "", api.RunSharedLogRequest{LogID: nested.ID.Hex()})
+	expectError(t, w, http.StatusForbidden, "FORBIDDEN")
+}
+
+func TestExpiredShareLinks(t *testing.T) {
+	setupDB(t)
+	user, _ := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	log := createLog(t, createVault(t, space, "src", nil), "main.py", "print(1)")
+
+	expired := time.Now().Add(-time.Minute)
+	insert(t, "shares", models.ShareLink{
+		ID:         log.ID,
+		TokenHash:  "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", // SHA-256 of "foo"
+		SpaceID:    space.ID,
+		TargetType: "log",
+		TargetID:   log.ID,
+		CreatedBy:  user.ID.Hex(),
+		ExpiresAt:  &expired,
+		CreatedAt:  time.Now(),
+	})
+
+	expectError(t, newShareAPI().request("GET", "/api/public/shares/foo", "", nil), http.StatusNotFound, "NOT_FOUND")
+}
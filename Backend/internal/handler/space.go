@@ -5,24 +5,27 @@ import (
 	"net/http"
 	"time"
 
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
 	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
 	"codeflow-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const userID = "admin" // Single-tenant admin mode
-
 // CreateSpace creates a new space
 func CreateSpace(c *gin.Context) {
-	var req struct {
-		Name string `json:"name" binding:"required"`
-	}
+	userID := currentUserID(c)
+
+	var req api.SpaceRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
 		return
 	}
 
@@ -30,8 +33,10 @@ func CreateSpace(c *gin.Context) {
 		ID:        primitive.NewObjectID(),
 		UserID:    userID,
 		Name:      req.Name,
+		Version:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		Role:      RoleOwner,
 	}
 
 	collection := db.Database.Collection("spaces")
@@ -40,30 +45,52 @@ func CreateSpace(c *gin.Context) {
 
 	_, err := collection.InsertOne(ctx, space)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create space"})
+		apierror.Abort(c, apierror.Internal, "Failed to create space")
 		return
 	}
 
+	recordAudit(c, models.AuditEntry{
+		Action:     "space.create",
+		SpaceID:    &space.ID,
+		TargetType: "space",
+		TargetID:   space.ID.Hex(),
+		After:      spaceSnapshot(space),
+	})
+
+	c.Header("ETag", spaceETag(space.Version, space.Role))
 	c.JSON(http.StatusCreated, space)
 }
 
-// GetSpaces retrieves all spaces for the admin user
+// GetSpaces retrieves a page of the spaces the user owns or is a member of
 func GetSpaces(c *gin.Context) {
+	userID := currentUserID(c)
+
 	collection := db.Database.Collection("spaces")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"userId": userID}
-	cursor, err := collection.Find(ctx, filter)
+	roles, err := accessibleSpaces(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch spaces"})
+		apierror.Abort(c, apierror.Internal, "Failed to fetch spaces")
+		return
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": spaceIDList(roles)}}
+	query, ok := parseListQuery(c, filter)
+	if !ok {
+		return
+	}
+
+	cursor, err := collection.Find(ctx, query.pageFilter(filter), query.findOptions())
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch spaces")
 		return
 	}
 	defer cursor.Close(ctx)
 
 	var spaces []models.Space
 	if err := cursor.All(ctx, &spaces); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode spaces"})
+		apierror.Abort(c, apierror.Internal, "Failed to decode spaces")
 		return
 	}
 
@@ -71,15 +98,27 @@ func GetSpaces(c *gin.Context) {
 		spaces = []models.Space{}
 	}
 
+	if len(spaces) > query.Limit {
+		spaces = spaces[:query.Limit]
+		last := spaces[len(spaces)-1]
+		query.setNextCursor(c, pageItem{ID: last.ID, Name: last.Name, CreatedAt: last.CreatedAt, UpdatedAt: last.UpdatedAt})
+	}
+
+	for i := range spaces {
+		spaces[i].Role = roles[spaces[i].ID]
+	}
+
 	c.JSON(http.StatusOK, spaces)
 }
 
 // GetSpace retrieves a single space by ID
 func GetSpace(c *gin.Context) {
+	userID := currentUserID(c)
+
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
 		return
 	}
 
@@ -87,14 +126,21 @@ func GetSpace(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var space models.Space
-	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&space)
+	role, err := spaceRole(ctx, objectID, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Space not found"})
+		apierror.Abort(c, apierror.Internal, "Failed to check space access")
 		return
 	}
 
-	c.JSON(http.StatusOK, space)
+	var space models.Space
+	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&space)
+	if role == "" || err != nil {
+		apierror.Abort(c, apierror.NotFound, "Space not found")
+		return
+	}
+
+	space.Role = role
+	respondSpace(c, space)
 }
 
 // UpdateSpace updates a space
@@ -102,16 +148,18 @@ func UpdateSpace(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
 		return
 	}
 
-	var req struct {
-		Name string `json:"name" binding:"required"`
-	}
+	var req api.SpaceRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	if !authorizeSpace(c, objectID, RoleOwner, "Space not found") {
 		return
 	}
 
@@ -119,26 +167,52 @@ func UpdateSpace(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	var before models.Space
+	if err := collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&before); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Space not found")
+		return
+	}
+
+	filter, ok := writeFilter(c, objectID, before.Version)
+	if !ok {
+		return
+	}
+
 	update := bson.M{
 		"$set": bson.M{
 			"name":      req.Name,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objectID, "userId": userID}, update)
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update space"})
+		apierror.Abort(c, apierror.Internal, "Failed to update space")
 		return
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Space not found"})
+		respondWriteMissed(c, ctx, collection, objectID, "Space not found")
 		return
 	}
 
 	var space models.Space
 	collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&space)
+	space.Role = RoleOwner
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "space.update",
+		SpaceID:    &objectID,
+		TargetType: "space",
+		TargetID:   id,
+		Before:     spaceSnapshot(before),
+		After:      spaceSnapshot(space),
+	})
+
+	events.Publish(events.ForSpace(events.SpaceUpdated, space))
+
+	c.Header("ETag", spaceETag(space.Version, space.Role))
 	c.JSON(http.StatusOK, space)
 }
 
@@ -147,33 +221,84 @@ func DeleteSpace(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	// Only owners may delete a space and its contents
+	if !authorizeSpace(c, objectID, RoleOwner, "Space not found") {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Delete all logs in this space
-	logsCollection := db.Database.Collection("logs")
-	logsCollection.DeleteMany(ctx, bson.M{"spaceId": objectID})
+	var before models.Space
+	db.Database.Collection("spaces").FindOne(ctx, bson.M{"_id": objectID}).Decode(&before)
 
-	// Delete all vaults in this space
-	vaultsCollection := db.Database.Collection("vaults")
-	vaultsCollection.DeleteMany(ctx, bson.M{"spaceId": objectID})
+	filter, ok := writeFilter(c, objectID, before.Version)
+	if !ok {
+		return
+	}
 
-	// Delete the space
-	spacesCollection := db.Database.Collection("spaces")
-	result, err := spacesCollection.DeleteOne(ctx, bson.M{"_id": objectID, "userId": userID})
+	result, err := deleteSpaceData(ctx, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete space"})
+		apierror.Abort(c, apierror.Internal, "Failed to delete space")
 		return
 	}
 
 	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Space not found"})
+		respondWriteMissed(c, ctx, db.Database.Collection("spaces"), objectID, "Space not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Space deleted successfully"})
+	recordAudit(c, models.AuditEntry{
+		Action:     "space.delete",
+		SpaceID:    &objectID,
+		TargetType: "space",
+		TargetID:   id,
+		Before:     spaceSnapshot(before),
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Space deleted successfully"})
+}
+
+// deleteSpaceData deletes the spaces matching filter together with everything stored in them
+func deleteSpaceData(ctx context.Context, filter bson.M) (*mongo.DeleteResult, error) {
+	var spaceIDs []primitive.ObjectID
+	cursor, err := db.Database.Collection("spaces").Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var spaces []models.Space
+	if err := cursor.All(ctx, &spaces); err != nil {
+		return nil, err
+	}
+	for _, space := range spaces {
+		spaceIDs = append(spaceIDs, space.ID)
+	}
+
+	inSpaces := bson.M{"spaceId": bson.M{"$in": spaceIDs}}
+
+	// Delete all logs, vaults and chat sessions in the spaces, then memberships,
+	// pending invitations, share links, secrets and webhooks
+	for _, collection := range []string{
+		"logs", "vaults", "chat_sessions",
+		"space_members", "space_invitations", "shares", "secrets", "webhooks", "webhook_deliveries",
+	} {
+		if _, err := db.Database.Collection(collection).DeleteMany(ctx, inSpaces); err != nil {
+			return nil, err
+		}
+	}
+
+	// Delete the spaces
+	result, err := db.Database.Collection("spaces").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": spaceIDs}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range spaceIDs {
+		events.Publish(events.Deleted(events.SpaceDeleted, id, id))
+	}
+	return result, nil
 }
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateAPIToken mints a personal access token; the plaintext is only returned here
+func CreateAPIToken(c *gin.Context) {
+	userID := currentUserID(c)
+
+	var req api.CreateAPITokenRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !auth.IsValidScope(scope) {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid scope: "+scope)
+			return
+		}
+	}
+
+	if req.ExpiresInDays < 0 {
+		apierror.Abort(c, apierror.InvalidRequest, "expiresInDays must not be negative")
+		return
+	}
+
+	plain, hash, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to generate token")
+		return
+	}
+
+	token := models.APIToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		TokenHash: hash,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	collection := db.Database.Collection("api_tokens")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, token); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create token")
+		return
+	}
+
+	c.JSON(http.StatusCreated, api.CreatedAPIToken{
+		Token:    plain,
+		APIToken: token,
+	})
+}
+
+// GetAPITokens lists the user's personal access tokens without their secrets
+func GetAPITokens(c *gin.Context) {
+	userID := currentUserID(c)
+
+	collection := db.Database.Collection("api_tokens")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch tokens")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []models.APIToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode tokens")
+		return
+	}
+
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// DeleteAPIToken revokes a personal access token
+func DeleteAPIToken(c *gin.Context) {
+	userID := currentUserID(c)
+
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid token ID")
+		return
+	}
+
+	collection := db.Database.Collection("api_tokens")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectID, "userId": userID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete token")
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		apierror.Abort(c, apierror.NotFound, "Token not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Token revoked successfully"})
+}
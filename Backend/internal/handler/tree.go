@@ -2,9 +2,10 @@ package handler
 
 import (
 	"context"
-	"net/http"
 	"time"
 
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
 	"codeflow-backend/internal/db"
 	"codeflow-backend/internal/models"
 
@@ -13,26 +14,22 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type TreeNode struct {
-	ID       string     `json:"id"`
-	Name     string     `json:"name"`
-	Type     string     `json:"type"` // "space", "vault", or "log"
-	Language string     `json:"language,omitempty"`
-	Path     string     `json:"path"`
-	Children []TreeNode `json:"children,omitempty"`
-}
-
-// GetTree returns the complete hierarchical tree structure
+// GetTree returns the complete hierarchical tree structure. The ETag is derived
+// from the tree itself, so If-None-Match answers 304 while nothing has changed.
 func GetTree(c *gin.Context) {
 	spaceID := c.Query("spaceId")
 	if spaceID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "spaceId query parameter required"})
+		apierror.Abort(c, apierror.InvalidRequest, "spaceId query parameter required")
 		return
 	}
 
 	objectID, err := primitive.ObjectIDFromHex(spaceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	if !authorizeSpace(c, objectID, RoleViewer, "Space not found") {
 		return
 	}
 
@@ -41,9 +38,9 @@ func GetTree(c *gin.Context) {
 
 	// Fetch all vaults in this space
 	vaultsCollection := db.Database.Collection("vaults")
-	vaultsCursor, err := vaultsCollection.Find(ctx, bson.M{"spaceId": objectID, "userId": userID})
+	vaultsCursor, err := vaultsCollection.Find(ctx, bson.M{"spaceId": objectID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vaults"})
+		apierror.Abort(c, apierror.Internal, "Failed to fetch vaults")
 		return
 	}
 	var vaults []models.Vault
@@ -51,9 +48,9 @@ func GetTree(c *gin.Context) {
 
 	// Fetch all logs in this space
 	logsCollection := db.Database.Collection("logs")
-	logsCursor, err := logsCollection.Find(ctx, bson.M{"spaceId": objectID, "userId": userID})
+	logsCursor, err := logsCollection.Find(ctx, bson.M{"spaceId": objectID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
+		apierror.Abort(c, apierror.Internal, "Failed to fetch logs")
 		return
 	}
 	var logs []models.Log
@@ -61,22 +58,22 @@ func GetTree(c *gin.Context) {
 
 	// Build tree structure
 	tree := buildTree(vaults, logs)
-	c.JSON(http.StatusOK, tree)
+	respondWithETag(c, tree)
 }
 
-func buildTree(vaults []models.Vault, logs []models.Log) []TreeNode {
+func buildTree(vaults []models.Vault, logs []models.Log) []api.TreeNode {
 	// Create maps for quick lookup
-	vaultMap := make(map[primitive.ObjectID]*TreeNode)
-	rootVaults := []TreeNode{}
+	vaultMap := make(map[primitive.ObjectID]*api.TreeNode)
+	rootVaults := []api.TreeNode{}
 
 	// Create vault nodes
 	for _, vault := range vaults {
-		node := TreeNode{
+		node := api.TreeNode{
 			ID:       vault.ID.Hex(),
 			Name:     vault.Name,
 			Type:     "vault",
 			Path:     vault.Path,
-			Children: []TreeNode{},
+			Children: []api.TreeNode{},
 		}
 		vaultMap[vault.ID] = &node
 
@@ -99,7 +96,7 @@ func buildTree(vaults []models.Vault, logs []models.Log) []TreeNode {
 
 	// Add logs to their respective vaults
 	for _, log := range logs {
-		logNode := TreeNode{
+		logNode := api.TreeNode{
 			ID:       log.ID.Hex(),
 			Name:     log.Name,
 			Type:     "log",
@@ -113,7 +110,7 @@ func buildTree(vaults []models.Vault, logs []models.Log) []TreeNode {
 	}
 
 	// Update rootVaults with the modified children
-	result := []TreeNode{}
+	result := []api.TreeNode{}
 	for i, rootVault := range rootVaults {
 		if node, exists := vaultMap[vaults[i].ID]; exists {
 			rootVault.Children = node.Children
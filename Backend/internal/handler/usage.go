@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// aiCall identifies who an AI request is made for, for usage accounting and budgets
+type aiCall struct {
+	UserID  string
+	SpaceID *primitive.ObjectID
+	Mode    string
+}
+
+// modelPrice is the USD price per million prompt and completion tokens
+type modelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// defaultModelPricing holds list prices for the default models; override with AI_PRICING
+var defaultModelPricing = map[string]modelPrice{
+	"gpt-4o-mini":          {Prompt: 0.15, Completion: 0.60},
+	"gpt-4o":               {Prompt: 2.50, Completion: 10.00},
+	"gemini-2.0-flash":     {Prompt: 0.10, Completion: 0.40},
+	"gemini-2.0-flash-exp": {Prompt: 0, Completion: 0},
+}
+
+// recordAIUsage stores one provider attempt in the ai_usage collection
+func recordAIUsage(call aiCall, provider string, result completion, latency time.Duration, callErr error) {
+	usage := models.AIUsage{
+		ID:               primitive.NewObjectID(),
+		UserID:           call.UserID,
+		SpaceID:          call.SpaceID,
+		Mode:             call.Mode,
+		Provider:         provider,
+		Model:            result.Model,
+		PromptTokens:     result.PromptTokens,
+		CompletionTokens: result.CompletionTokens,
+		TotalTokens:      result.PromptTokens + result.CompletionTokens,
+		CostUSD:          costFor(result.Model, result.PromptTokens, result.CompletionTokens),
+		LatencyMs:        latency.Milliseconds(),
+		Success:          callErr == nil && result.Text != "",
+		CreatedAt:        time.Now(),
+	}
+	if callErr != nil {
+		usage.Error = callErr.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Database.Collection("ai_usage").InsertOne(ctx, usage); err != nil {
+		log.Printf("Failed to record AI usage: %v", err)
+	}
+}
+
+// costFor returns the USD cost of a call using AI_PRICING or the default price table
+func costFor(model string, promptTokens, completionTokens int) float64 {
+	price, ok := modelPricing()[model]
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1_000_000
+}
+
+// modelPricing merges AI_PRICING ("model:prompt:completion,...") over the defaults
+func modelPricing() map[string]modelPrice {
+	pricing := make(map[string]modelPrice, len(defaultModelPricing))
+	for model, price := range defaultModelPricing {
+		pricing[model] = price
+	}
+
+	for _, entry := range strings.Split(os.Getenv("AI_PRICING"), ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 {
+			continue
+		}
+		prompt, err1 := strconv.ParseFloat(parts[1], 64)
+		completion, err2 := strconv.ParseFloat(parts[2], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		pricing[parts[0]] = modelPrice{Prompt: prompt, Completion: completion}
+	}
+
+	return pricing
+}
+
+// enforceAIBudget refuses the request if the user or space has exhausted its monthly budget
+// or daily AI call quota, and otherwise counts the call against the quota.
+// It returns false when a response has already been written.
+func enforceAIBudget(c *gin.Context, call aiCall) bool {
+	if err := checkAIBudget(call); err != nil {
+		apierror.Respond(c, err)
+		return false
+	}
+
+	_, ok := consumeDailyQuota(c, QuotaAICallsPerDay, call.UserID, call.SpaceID)
+	return ok
+}
+
+// chargeAIBudget is enforceAIBudget without writing a response, for calls made
+// partway through a request
+func chargeAIBudget(call aiCall) *apierror.Error {
+	if err := checkAIBudget(call); err != nil {
+		return err
+	}
+
+	_, err := takeDailyQuota(QuotaAICallsPerDay, call.UserID, call.SpaceID)
+	return err
+}
+
+// checkAIBudget returns an error if the user or space has exhausted its monthly budget
+func checkAIBudget(call aiCall) *apierror.Error {
+	userBudget, spaceBudget, err := currentBudgets(call)
+	if err != nil {
+		return apierror.New(apierror.Internal, "Failed to check AI budget")
+	}
+
+	if userBudget.Exceeded || (spaceBudget != nil && spaceBudget.Exceeded) {
+		scope := "user"
+		if !userBudget.Exceeded {
+			scope = "space"
+		}
+		return apierror.New(apierror.AIBudgetExceeded, fmt.Sprintf("Monthly AI budget for this %s has been exceeded", scope))
+	}
+	return nil
+}
+
+// currentBudgets returns this month's usage against the user budget and, if a space is set, the space budget
+func currentBudgets(call aiCall) (api.BudgetStatus, *api.BudgetStatus, error) {
+	monthStart := startOfMonth(time.Now())
+
+	totals, err := sumUsage(bson.M{"userId": call.UserID, "createdAt": bson.M{"$gte": monthStart}})
+	if err != nil {
+		return api.BudgetStatus{}, nil, err
+	}
+	user := newBudgetStatus(totals, envInt("AI_MONTHLY_TOKEN_BUDGET"), envFloat("AI_MONTHLY_COST_BUDGET_USD"))
+
+	if call.SpaceID == nil {
+		return user, nil, nil
+	}
+
+	totals, err = sumUsage(bson.M{"spaceId": *call.SpaceID, "createdAt": bson.M{"$gte": monthStart}})
+	if err != nil {
+		return api.BudgetStatus{}, nil, err
+	}
+	space := newBudgetStatus(totals, envInt("AI_SPACE_MONTHLY_TOKEN_BUDGET"), 0)
+
+	return user, &space, nil
+}
+
+func newBudgetStatus(totals api.UsageTotals, tokenBudget int, costBudget float64) api.BudgetStatus {
+	return api.BudgetStatus{
+		Tokens:      totals.TotalTokens,
+		CostUSD:     totals.CostUSD,
+		TokenBudget: tokenBudget,
+		CostBudget:  costBudget,
+		Exceeded: (tokenBudget > 0 && totals.TotalTokens >= tokenBudget) ||
+			(costBudget > 0 && totals.CostUSD >= costBudget),
+	}
+}
+
+// sumUsage totals all usage records matching filter
+func sumUsage(filter bson.M) (api.UsageTotals, error) {
+	rows, err := aggregateUsage(filter, nil)
+	if err != nil || len(rows) == 0 {
+		return api.UsageTotals{}, err
+	}
+	return rows[0], nil
+}
+
+// aggregateUsage groups usage records matching filter by groupKey (nil for a single total)
+func aggregateUsage(filter bson.M, groupKey interface{}) ([]api.UsageTotals, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{
+			"_id":              groupKey,
+			"calls":            bson.M{"$sum": 1},
+			"failures":         bson.M{"$sum": bson.M{"$cond": bson.A{"$success", 0, 1}}},
+			"promptTokens":     bson.M{"$sum": "$promptTokens"},
+			"completionTokens": bson.M{"$sum": "$completionTokens"},
+			"totalTokens":      bson.M{"$sum": "$totalTokens"},
+			"costUsd":          bson.M{"$sum": "$costUsd"},
+			"avgLatencyMs":     bson.M{"$avg": "$latencyMs"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := db.Database.Collection("ai_usage").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []api.UsageTotals
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	if rows == nil {
+		rows = []api.UsageTotals{}
+	}
+	return rows, nil
+}
+
+// GetAIUsage returns AI usage totals grouped by user, space or day.
+// Space owners see every member's usage in their space; everyone else sees their own.
+func GetAIUsage(c *gin.Context) {
+	userID := currentUserID(c)
+
+	filter := bson.M{"userId": userID}
+
+	if spaceID := c.Query("spaceId"); spaceID != "" {
+		objectID, err := primitive.ObjectIDFromHex(spaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		role, err := spaceRole(ctx, objectID, userID)
+		if err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to check space access")
+			return
+		}
+		if role == "" {
+			apierror.Abort(c, apierror.NotFound, "Space not found")
+			return
+		}
+		if role == RoleOwner {
+			delete(filter, "userId")
+		}
+		filter["spaceId"] = objectID
+	}
+
+	createdAt := bson.M{}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid from date, expected YYYY-MM-DD")
+			return
+		}
+		createdAt["$gte"] = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid to date, expected YYYY-MM-DD")
+			return
+		}
+		createdAt["$lt"] = t.AddDate(0, 0, 1)
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	var groupKey interface{}
+	switch c.DefaultQuery("groupBy", "day") {
+	case "user":
+		groupKey = "$userId"
+	case "space":
+		groupKey = bson.M{"$toString": "$spaceId"}
+	case "day":
+		groupKey = bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createdAt"}}
+	default:
+		apierror.Abort(c, apierror.InvalidRequest, "groupBy must be user, space or day")
+		return
+	}
+
+	rows, err := aggregateUsage(filter, groupKey)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to aggregate AI usage")
+		return
+	}
+
+	c.JSON(http.StatusOK, rows)
+}
+
+// GetAIBudget returns this month's AI usage against the configured budgets
+func GetAIBudget(c *gin.Context) {
+	userID := currentUserID(c)
+
+	call := aiCall{UserID: userID}
+
+	if spaceID := c.Query("spaceId"); spaceID != "" {
+		objectID, err := primitive.ObjectIDFromHex(spaceID)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+			return
+		}
+		call.SpaceID = &objectID
+	}
+
+	user, space, err := currentBudgets(call)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to check AI budget")
+		return
+	}
+
+	c.JSON(http.StatusOK, api.BudgetReport{
+		Month: startOfMonth(time.Now()).Format("2006-01"),
+		User:  user,
+		Space: space,
+	})
+}
+
+// startOfMonth returns midnight UTC on the first day of t's month
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// envInt reads a non-negative integer from the environment, defaulting to 0
+func envInt(key string) int {
+	if parsed, err := strconv.Atoi(os.Getenv(key)); err == nil && parsed > 0 {
+		return parsed
+	}
+	return 0
+}
+
+// envFloat reads a non-negative float from the environment, defaulting to 0
+func envFloat(key string) float64 {
+	if parsed, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && parsed > 0 {
+		return parsed
+	}
+	return 0
+}
@@ -4,32 +4,46 @@ import (
 	"context"
 	"net/http"
 	"path"
+	"strings"
 	"time"
 
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
 	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/events"
 	"codeflow-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // CreateVault creates a new vault
 func CreateVault(c *gin.Context) {
-	var req struct {
-		SpaceID  string  `json:"spaceId" binding:"required"`
-		Name     string  `json:"name" binding:"required"`
-		ParentID *string `json:"parentId,omitempty"`
-	}
+	userID := currentUserID(c)
+
+	var req api.CreateVaultRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
 		return
 	}
 
 	spaceID, err := primitive.ObjectIDFromHex(req.SpaceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	if !authorizeSpace(c, spaceID, RoleEditor, "Space not found") {
+		return
+	}
+
+	quotaCtx, quotaCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer quotaCancel()
+	if !enforceVaultQuota(c, quotaCtx, spaceID, userID) {
 		return
 	}
 
@@ -38,6 +52,7 @@ func CreateVault(c *gin.Context) {
 		SpaceID:   spaceID,
 		UserID:    userID,
 		Name:      req.Name,
+		Version:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -46,7 +61,7 @@ func CreateVault(c *gin.Context) {
 	if req.ParentID != nil && *req.ParentID != "" {
 		parentID, err := primitive.ObjectIDFromHex(*req.ParentID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent ID"})
+			apierror.Abort(c, apierror.InvalidID, "Invalid parent ID")
 			return
 		}
 		vault.ParentID = &parentID
@@ -55,9 +70,9 @@ func CreateVault(c *gin.Context) {
 		collection := db.Database.Collection("vaults")
 		ctx := context.Background()
 		var parentVault models.Vault
-		err = collection.FindOne(ctx, bson.M{"_id": parentID}).Decode(&parentVault)
+		err = collection.FindOne(ctx, bson.M{"_id": parentID, "spaceId": spaceID}).Decode(&parentVault)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Parent vault not found"})
+			apierror.Abort(c, apierror.NotFound, "Parent vault not found")
 			return
 		}
 		vault.Path = path.Join(parentVault.Path, req.Name)
@@ -71,24 +86,45 @@ func CreateVault(c *gin.Context) {
 
 	_, err = collection.InsertOne(ctx, vault)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vault"})
+		apierror.Abort(c, apierror.Internal, "Failed to create vault")
 		return
 	}
 
+	recordAudit(c, models.AuditEntry{
+		Action:     "vault.create",
+		SpaceID:    &vault.SpaceID,
+		TargetType: "vault",
+		TargetID:   vault.ID.Hex(),
+		After:      vaultSnapshot(vault),
+	})
+
+	events.Publish(events.ForVault(events.VaultCreated, vault))
+
+	c.Header("ETag", versionETag(vault.Version))
 	c.JSON(http.StatusCreated, vault)
 }
 
-// GetVaults retrieves all vaults for a space
+// GetVaults retrieves a page of the vaults in a space
 func GetVaults(c *gin.Context) {
 	spaceID := c.Query("spaceId")
 	if spaceID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "spaceId query parameter required"})
+		apierror.Abort(c, apierror.InvalidRequest, "spaceId query parameter required")
 		return
 	}
 
 	objectID, err := primitive.ObjectIDFromHex(spaceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid space ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return
+	}
+
+	if !authorizeSpace(c, objectID, RoleViewer, "Space not found") {
+		return
+	}
+
+	filter := bson.M{"spaceId": objectID}
+	query, ok := parseListQuery(c, filter)
+	if !ok {
 		return
 	}
 
@@ -96,17 +132,16 @@ func GetVaults(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"spaceId": objectID, "userId": userID}
-	cursor, err := collection.Find(ctx, filter)
+	cursor, err := collection.Find(ctx, query.pageFilter(filter), query.findOptions())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vaults"})
+		apierror.Abort(c, apierror.Internal, "Failed to fetch vaults")
 		return
 	}
 	defer cursor.Close(ctx)
 
 	var vaults []models.Vault
 	if err := cursor.All(ctx, &vaults); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode vaults"})
+		apierror.Abort(c, apierror.Internal, "Failed to decode vaults")
 		return
 	}
 
@@ -114,6 +149,12 @@ func GetVaults(c *gin.Context) {
 		vaults = []models.Vault{}
 	}
 
+	if len(vaults) > query.Limit {
+		vaults = vaults[:query.Limit]
+		last := vaults[len(vaults)-1]
+		query.setNextCursor(c, pageItem{ID: last.ID, Name: last.Name, CreatedAt: last.CreatedAt, UpdatedAt: last.UpdatedAt})
+	}
+
 	c.JSON(http.StatusOK, vaults)
 }
 
@@ -122,59 +163,61 @@ func GetVault(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vault ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid vault ID")
 		return
 	}
 
-	collection := db.Database.Collection("vaults")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var vault models.Vault
-	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&vault)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Vault not found"})
+	vault, ok := loadVault(c, objectID, RoleViewer)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, vault)
+	respondVersioned(c, vault.Version, vault)
 }
 
-// UpdateVault updates a vault
+// UpdateVault renames a vault. The paths of its nested vaults and logs follow the new name.
 func UpdateVault(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vault ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid vault ID")
 		return
 	}
 
-	var req struct {
-		Name string `json:"name" binding:"required"`
-	}
+	var req api.UpdateVaultRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
 		return
 	}
 
-	collection := db.Database.Collection("vaults")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	// Get current vault to update path
-	var currentVault models.Vault
-	err = collection.FindOne(ctx, bson.M{"_id": objectID, "userId": userID}).Decode(&currentVault)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Vault not found"})
+	currentVault, ok := loadVault(c, objectID, RoleEditor)
+	if !ok {
+		return
+	}
+
+	filter, ok := writeFilter(c, objectID, currentVault.Version)
+	if !ok {
 		return
 	}
 
+	collection := db.Database.Collection("vaults")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	// Update path
 	newPath := req.Name
 	if currentVault.ParentID != nil {
 		var parentVault models.Vault
-		collection.FindOne(ctx, bson.M{"_id": currentVault.ParentID}).Decode(&parentVault)
+		if err := collection.FindOne(ctx, bson.M{"_id": currentVault.ParentID}).Decode(&parentVault); err != nil {
+			if err == mongo.ErrNoDocuments {
+				apierror.Abort(c, apierror.NotFound, "Parent vault not found")
+			} else {
+				apierror.Abort(c, apierror.Internal, "Failed to fetch parent vault")
+			}
+			return
+		}
 		newPath = path.Join(parentVault.Path, req.Name)
 	}
 
@@ -184,56 +227,234 @@ func UpdateVault(c *gin.Context) {
 			"path":      newPath,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectID, "userId": userID}, update)
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vault"})
+		apierror.Abort(c, apierror.Internal, "Failed to update vault")
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		respondWriteMissed(c, ctx, collection, objectID, "Vault not found")
+		return
+	}
+
+	// Nested vaults and logs keep their paths in step with the vault's
+	nested, err := rewriteDescendantPaths(ctx, objectID, currentVault.Path, newPath)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to update nested paths")
 		return
 	}
 
 	var vault models.Vault
 	collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&vault)
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "vault.update",
+		SpaceID:    &currentVault.SpaceID,
+		TargetType: "vault",
+		TargetID:   id,
+		Before:     vaultSnapshot(currentVault),
+		After:      vaultSnapshot(vault),
+	})
+
+	events.Publish(events.ForVault(events.VaultUpdated, vault))
+	for _, event := range nested {
+		events.Publish(event)
+	}
+
+	c.Header("ETag", versionETag(vault.Version))
 	c.JSON(http.StatusOK, vault)
 }
 
-// DeleteVault deletes a vault and all its logs
+// DeleteVault deletes a vault along with its nested vaults and all their logs
 func DeleteVault(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vault ID"})
+		apierror.Abort(c, apierror.InvalidID, "Invalid vault ID")
+		return
+	}
+
+	// Make sure the caller may edit the space before deleting its contents
+	vault, ok := loadVault(c, objectID, RoleEditor)
+	if !ok {
+		return
+	}
+
+	filter, ok := writeFilter(c, objectID, vault.Version)
+	if !ok {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Delete all logs in this vault
-	logsCollection := db.Database.Collection("logs")
-	logsCollection.DeleteMany(ctx, bson.M{"vaultId": objectID})
-
-	// Delete child vaults recursively
 	vaultsCollection := db.Database.Collection("vaults")
-	cursor, _ := vaultsCollection.Find(ctx, bson.M{"parentId": objectID})
-	var childVaults []models.Vault
-	cursor.All(ctx, &childVaults)
-	for _, child := range childVaults {
-		logsCollection.DeleteMany(ctx, bson.M{"vaultId": child.ID})
-	}
-	vaultsCollection.DeleteMany(ctx, bson.M{"parentId": objectID})
 
-	// Delete the vault
-	result, err := vaultsCollection.DeleteOne(ctx, bson.M{"_id": objectID, "userId": userID})
+	// Delete the vault first so a stale If-Match leaves its contents alone
+	result, err := vaultsCollection.DeleteOne(ctx, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete vault"})
+		apierror.Abort(c, apierror.Internal, "Failed to delete vault")
 		return
 	}
 
 	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Vault not found"})
+		respondWriteMissed(c, ctx, vaultsCollection, objectID, "Vault not found")
+		return
+	}
+
+	contents, err := deleteVaultContents(ctx, vault)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete vault contents")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Vault deleted successfully"})
+	recordAudit(c, models.AuditEntry{
+		Action:     "vault.delete",
+		SpaceID:    &vault.SpaceID,
+		TargetType: "vault",
+		TargetID:   id,
+		Before:     vaultSnapshot(vault),
+	})
+
+	events.Publish(events.Deleted(events.VaultDeleted, vault.SpaceID, vault.ID))
+	for _, event := range contents {
+		events.Publish(event)
+	}
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Vault deleted successfully"})
+}
+
+// vaultTree returns a vault's ID followed by the IDs of every vault nested under it
+func vaultTree(ctx context.Context, vaultID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	collection := db.Database.Collection("vaults")
+	ids := []primitive.ObjectID{vaultID}
+	seen := map[primitive.ObjectID]bool{vaultID: true}
+
+	for level := ids; len(level) > 0; {
+		cursor, err := collection.Find(ctx, bson.M{"parentId": bson.M{"$in": level}}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var children []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &children); err != nil {
+			return nil, err
+		}
+
+		level = nil
+		for _, child := range children {
+			if !seen[child.ID] {
+				seen[child.ID] = true
+				ids = append(ids, child.ID)
+				level = append(level, child.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// deleteVaultContents deletes every vault nested under a vault and the logs in
+// all of them, including the vault's own logs, returning their delete events
+func deleteVaultContents(ctx context.Context, vault models.Vault) ([]events.Event, error) {
+	ids, err := vaultTree(ctx, vault.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	logsCollection := db.Database.Collection("logs")
+	inVaults := bson.M{"vaultId": bson.M{"$in": ids}}
+	cursor, err := logsCollection.Find(ctx, inVaults, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var logs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, err
+	}
+
+	if _, err := logsCollection.DeleteMany(ctx, inVaults); err != nil {
+		return nil, err
+	}
+	if _, err := db.Database.Collection("vaults").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids[1:]}}); err != nil {
+		return nil, err
+	}
+
+	var deleted []events.Event
+	for _, log := range logs {
+		deleted = append(deleted, events.Deleted(events.LogDeleted, vault.SpaceID, log.ID))
+	}
+	for _, id := range ids[1:] {
+		deleted = append(deleted, events.Deleted(events.VaultDeleted, vault.SpaceID, id))
+	}
+	return deleted, nil
+}
+
+// rewriteDescendantPaths updates the paths of everything under a vault after its
+// own path changed from oldPath to newPath, returning the update events
+func rewriteDescendantPaths(ctx context.Context, vaultID primitive.ObjectID, oldPath, newPath string) ([]events.Event, error) {
+	if oldPath == newPath {
+		return nil, nil
+	}
+
+	ids, err := vaultTree(ctx, vaultID)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []events.Event
+	for _, name := range []string{"vaults", "logs"} {
+		collection := db.Database.Collection(name)
+		filter := bson.M{"_id": bson.M{"$in": ids[1:]}}
+		if name == "logs" {
+			filter = bson.M{"vaultId": bson.M{"$in": ids}}
+		}
+
+		cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"path": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var docs []struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Path string             `bson:"path"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+
+		for _, doc := range docs {
+			if !strings.HasPrefix(doc.Path, oldPath+"/") {
+				continue
+			}
+			update := bson.M{
+				"$set": bson.M{"path": newPath + strings.TrimPrefix(doc.Path, oldPath), "updatedAt": time.Now()},
+				"$inc": bson.M{"version": 1},
+			}
+			result := collection.FindOneAndUpdate(ctx, bson.M{"_id": doc.ID}, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+			if result.Err() == mongo.ErrNoDocuments {
+				continue
+			}
+
+			if name == "vaults" {
+				var vault models.Vault
+				if err := result.Decode(&vault); err != nil {
+					return nil, err
+				}
+				updated = append(updated, events.ForVault(events.VaultUpdated, vault))
+			} else {
+				var log models.Log
+				if err := result.Decode(&log); err != nil {
+					return nil, err
+				}
+				updated = append(updated, events.ForLog(events.LogUpdated, log))
+			}
+		}
+	}
+	return updated, nil
 }
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newVaultAPI() *testAPI {
+	a := newTestAPI()
+	a.api.PUT("/vaults/:id", UpdateVault)
+	a.api.DELETE("/vaults/:id", DeleteVault)
+	a.api.POST("/batch", ExecuteBatch)
+	return a
+}
+
+func TestRenamingVaultMovesNestedPaths(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	src := createVault(t, space, "src", nil)
+	lib := createVault(t, space, "lib", &src)
+	util := createLog(t, lib, "util.py", "x = 1")
+	sibling := createLog(t, createVault(t, space, "srcs", nil), "main.py", "")
+
+	w := newVaultAPI().request("PUT", "/api/vaults/"+src.ID.Hex(), token, api.UpdateVaultRequest{Name: "app"})
+	expectStatus(t, w, http.StatusOK)
+
+	moved, _ := findLog(t, util)
+	if moved.Path != "app/lib/util.py" || moved.Version != util.Version+1 {
+		t.Errorf("nested log is at %q, version %d", moved.Path, moved.Version)
+	}
+	if unrelated, _ := findLog(t, sibling); unrelated.Path != "srcs/main.py" {
+		t.Errorf("a vault that only shares the prefix moved to %q", unrelated.Path)
+	}
+}
+
+func TestDeletingVaultDeletesNestedVaults(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	src := createVault(t, space, "src", nil)
+	lib := createVault(t, space, "lib", &src)
+	deep := createVault(t, space, "deep", &lib)
+	nested := createLog(t, deep, "util.py", "")
+	kept := createLog(t, createVault(t, space, "docs", nil), "readme.md", "")
+
+	expectStatus(t, newVaultAPI().request("DELETE", "/api/vaults/"+src.ID.Hex(), token, nil), http.StatusOK)
+
+	if _, found := findLog(t, nested); found {
+		t.Error("a log two vaults down survived")
+	}
+	if _, found := findLog(t, kept); !found {
+		t.Error("a log in another vault was deleted")
+	}
+	ctx, cancel := testContext()
+	defer cancel()
+	if n, _ := db.Database.Collection("vaults").CountDocuments(ctx, bson.M{"spaceId": space.ID}); n != 1 {
+		t.Errorf("%d vaults left, want only docs", n)
+	}
+}
+
+func TestBatchRenameWithMissingParent(t *testing.T) {
+	setupDB(t)
+	user, token := createUser(t, "ada@example.com")
+	space := createSpace(t, user.ID.Hex(), "Space")
+	src := createVault(t, space, "src", nil)
+	lib := createVault(t, space, "lib", &src)
+
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := db.Database.Collection("vaults").DeleteOne(ctx, bson.M{"_id": src.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "core"
+	a := newVaultAPI()
+	w := a.request("POST", "/api/batch", token, api.BatchRequest{Operations: []api.BatchOperation{{Op: "update", Type: "vault", ID: lib.ID.Hex(), Name: &name}}})
+	expectError(t, w, http.StatusNotFound, "NOT_FOUND")
+	expectError(t, a.request("PUT", "/api/vaults/"+lib.ID.Hex(), token, api.UpdateVaultRequest{Name: name}), http.StatusNotFound, "NOT_FOUND")
+}
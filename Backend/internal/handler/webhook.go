@@ -0,0 +1,458 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"codeflow-backend/api"
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/secrets"
+	"codeflow-backend/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	minWebhookSecretLength = 16
+	maxWebhookSecretLength = 256
+	maxWebhooksPerSpace    = 20
+
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 200
+)
+
+// GetWebhooks lists a space's webhooks. Only owners can manage webhooks.
+func GetWebhooks(c *gin.Context) {
+	spaceID, ok := webhookSpace(c)
+	if !ok {
+		return
+	}
+
+	collection := db.Database.Collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := collection.Find(ctx, bson.M{"spaceId": spaceID}, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch webhooks")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var list []models.Webhook
+	if err := cursor.All(ctx, &list); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode webhooks")
+		return
+	}
+
+	if list == nil {
+		list = []models.Webhook{}
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateWebhook subscribes a URL to a space's events; the secret is only returned here
+func CreateWebhook(c *gin.Context) {
+	spaceID, ok := webhookSpace(c)
+	if !ok {
+		return
+	}
+
+	var req api.WebhookRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	eventTypes, ok := validateWebhook(c, req)
+	if !ok {
+		return
+	}
+
+	if !secrets.Enabled() {
+		apierror.Abort(c, apierror.NotConfigured, secrets.ErrNotConfigured.Error())
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateShareToken(); err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to generate secret")
+			return
+		}
+	}
+
+	webhook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   spaceID,
+		URL:       req.URL,
+		Events:    eventTypes,
+		Active:    req.Active == nil || *req.Active,
+		CreatedBy: currentUserID(c),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	var err error
+	webhook.SecretCiphertext, webhook.SecretNonce, err = webhooks.SealSecret(webhook.ID, secret)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to encrypt secret")
+		return
+	}
+
+	collection := db.Database.Collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"spaceId": spaceID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create webhook")
+		return
+	}
+	if count >= maxWebhooksPerSpace {
+		apierror.Abort(c, apierror.Conflict, fmt.Sprintf("Spaces are limited to %d webhooks", maxWebhooksPerSpace))
+		return
+	}
+
+	if _, err := collection.InsertOne(ctx, webhook); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to create webhook")
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "webhook.create",
+		SpaceID:    &spaceID,
+		TargetType: "webhook",
+		TargetID:   webhook.ID.Hex(),
+		After:      webhookSnapshot(webhook),
+	})
+
+	c.JSON(http.StatusCreated, api.CreatedWebhook{Secret: secret, Webhook: webhook})
+}
+
+// UpdateWebhook replaces a webhook's URL and events, and its secret or active flag when given
+func UpdateWebhook(c *gin.Context) {
+	webhook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req api.WebhookRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+
+	eventTypes, ok := validateWebhook(c, req)
+	if !ok {
+		return
+	}
+
+	set := bson.M{
+		"url":       req.URL,
+		"events":    eventTypes,
+		"updatedAt": time.Now(),
+	}
+	if req.Active != nil {
+		set["active"] = *req.Active
+	}
+	if req.Secret != "" {
+		if !secrets.Enabled() {
+			apierror.Abort(c, apierror.NotConfigured, secrets.ErrNotConfigured.Error())
+			return
+		}
+		ciphertext, nonce, err := webhooks.SealSecret(webhook.ID, req.Secret)
+		if err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to encrypt secret")
+			return
+		}
+		set["secretCiphertext"] = ciphertext
+		set["secretNonce"] = nonce
+	}
+
+	collection := db.Database.Collection("webhooks")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var updated models.Webhook
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": webhook.ID, "spaceId": webhook.SpaceID}, bson.M{"$set": set}, opts).Decode(&updated)
+	if err != nil {
+		apierror.Abort(c, apierror.NotFound, "Webhook not found")
+		return
+	}
+
+	after := webhookSnapshot(updated)
+	if req.Secret != "" {
+		after["secretRotated"] = true
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     "webhook.update",
+		SpaceID:    &webhook.SpaceID,
+		TargetType: "webhook",
+		TargetID:   webhook.ID.Hex(),
+		Before:     webhookSnapshot(webhook),
+		After:      after,
+	})
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteWebhook deletes a webhook and its delivery log
+func DeleteWebhook(c *gin.Context) {
+	webhook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Database.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": webhook.ID, "spaceId": webhook.SpaceID})
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to delete webhook")
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		apierror.Abort(c, apierror.NotFound, "Webhook not found")
+		return
+	}
+
+	db.Database.Collection("webhook_deliveries").DeleteMany(ctx, bson.M{"webhookId": webhook.ID})
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "webhook.delete",
+		SpaceID:    &webhook.SpaceID,
+		TargetType: "webhook",
+		TargetID:   webhook.ID.Hex(),
+		Before:     webhookSnapshot(webhook),
+	})
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Webhook deleted successfully"})
+}
+
+// GetWebhookDeliveries lists a webhook's deliveries, newest first.
+// Query: ?status=pending|succeeded|failed&limit=50&before=deliveryId
+func GetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"webhookId": webhook.ID}
+	switch status := c.Query("status"); status {
+	case "":
+	case models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed:
+		filter["status"] = status
+	default:
+		apierror.Abort(c, apierror.InvalidRequest, "Invalid status")
+		return
+	}
+
+	// Page backwards through deliveries with the ID of the last delivery seen
+	if before := c.Query("before"); before != "" {
+		beforeID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid before cursor")
+			return
+		}
+		filter["_id"] = bson.M{"$lt": beforeID}
+	}
+
+	limit := defaultDeliveryPageSize
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			apierror.Abort(c, apierror.InvalidRequest, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxDeliveryPageSize)
+	}
+
+	collection := db.Database.Collection("webhook_deliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to fetch deliveries")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var deliveries []models.WebhookDelivery
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to decode deliveries")
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	// A full page means there may be more deliveries
+	var nextCursor string
+	if len(deliveries) == limit {
+		nextCursor = deliveries[len(deliveries)-1].ID.Hex()
+	}
+
+	c.JSON(http.StatusOK, api.WebhookDeliveryPage{
+		Deliveries: deliveries,
+		NextCursor: nextCursor,
+	})
+}
+
+// RedeliverWebhookDelivery queues a new delivery with the same payload and
+// event ID as an earlier one, whatever its outcome
+func RedeliverWebhookDelivery(c *gin.Context) {
+	webhook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(c.Param("deliveryId"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid delivery ID")
+		return
+	}
+
+	collection := db.Database.Collection("webhook_deliveries")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var original models.WebhookDelivery
+	if err := collection.FindOne(ctx, bson.M{"_id": deliveryID, "webhookId": webhook.ID}).Decode(&original); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Delivery not found")
+		return
+	}
+
+	delivery := webhooks.Redelivery(original)
+	if !queueWebhookDelivery(c, delivery) {
+		return
+	}
+
+	recordAudit(c, models.AuditEntry{
+		Action:     "webhook.redeliver",
+		SpaceID:    &webhook.SpaceID,
+		TargetType: "webhook",
+		TargetID:   webhook.ID.Hex(),
+		After:      auditSnapshot{"deliveryId": delivery.ID.Hex(), "redeliveryOf": original.ID.Hex()},
+	})
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// PingWebhook queues a ping delivery to check the receiver and its signature verification
+func PingWebhook(c *gin.Context) {
+	webhook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := webhooks.NewPing(webhook)
+	if err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to build ping")
+		return
+	}
+	if !queueWebhookDelivery(c, delivery) {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// queueWebhookDelivery stores a pending delivery and wakes the sender
+func queueWebhookDelivery(c *gin.Context, delivery models.WebhookDelivery) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Database.Collection("webhook_deliveries").InsertOne(ctx, delivery); err != nil {
+		apierror.Abort(c, apierror.Internal, "Failed to queue delivery")
+		return false
+	}
+	webhooks.Wake()
+	return true
+}
+
+// webhookSpace parses the :id space param and requires the owner role
+func webhookSpace(c *gin.Context) (primitive.ObjectID, bool) {
+	spaceID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid space ID")
+		return spaceID, false
+	}
+
+	return spaceID, authorizeSpace(c, spaceID, RoleOwner, "Space not found")
+}
+
+// loadWebhook fetches the :webhookId webhook of the :id space for an owner
+func loadWebhook(c *gin.Context) (models.Webhook, bool) {
+	var webhook models.Webhook
+
+	spaceID, ok := webhookSpace(c)
+	if !ok {
+		return webhook, false
+	}
+
+	webhookID, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	if err != nil {
+		apierror.Abort(c, apierror.InvalidID, "Invalid webhook ID")
+		return webhook, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.Database.Collection("webhooks").FindOne(ctx, bson.M{"_id": webhookID, "spaceId": spaceID}).Decode(&webhook); err != nil {
+		apierror.Abort(c, apierror.NotFound, "Webhook not found")
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// validateWebhook checks the URL, secret and event types, returning the event types without duplicates
+func validateWebhook(c *gin.Context, req api.WebhookRequest) ([]string, bool) {
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		apierror.Abort(c, apierror.InvalidRequest, err.Error())
+		return nil, false
+	}
+
+	if req.Secret != "" && (len(req.Secret) < minWebhookSecretLength || len(req.Secret) > maxWebhookSecretLength) {
+		apierror.Abort(c, apierror.InvalidRequest, fmt.Sprintf("Secrets must be %d to %d bytes long", minWebhookSecretLength, maxWebhookSecretLength))
+		return nil, false
+	}
+
+	seen := map[string]bool{}
+	eventTypes := make([]string, 0, len(req.Events))
+	for _, eventType := range req.Events {
+		if !webhooks.ValidEventType(eventType) {
+			apierror.Respond(c, apierror.New(apierror.InvalidRequest, "Invalid event type: "+eventType).
+				WithDetails(gin.H{"eventTypes": webhooks.EventTypes}))
+			return nil, false
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	return eventTypes, true
+}
+
+func webhookSnapshot(webhook models.Webhook) auditSnapshot {
+	return auditSnapshot{"url": webhook.URL, "events": webhook.Events, "active": webhook.Active}
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserIDKey is the Gin context key holding the authenticated user's ID
+const UserIDKey = "userId"
+
+// ScopesKey is the Gin context key holding the scopes of a personal access token.
+// It is unset for session and admin token requests, which have every scope.
+const ScopesKey = "scopes"
+
+// LegacyAdminUserID owns data created before multi-user accounts existed
+const LegacyAdminUserID = "admin"
+
+// AuthMiddleware requires a session token or personal access token from the Authorization
+// header (or a session cookie) and stores the user ID in the context. Sessions end when the
+// account is deleted or its session generation moves on. If ADMIN_TOKEN is set, presenting
+// it as a bearer token authenticates as the legacy admin account.
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		token := RequestToken(c)
+		if token == "" {
+			apierror.Abort(c, apierror.Unauthorized, "Authentication required")
+			return
+		}
+
+		if isAdminToken(token) {
+			c.Set(UserIDKey, LegacyAdminUserID)
+			c.Next()
+			return
+		}
+
+		if auth.IsAPIToken(token) {
+			apiToken, err := lookupAPIToken(token)
+			if err != nil {
+				apierror.Abort(c, apierror.Unauthorized, "Invalid or expired access token")
+				return
+			}
+
+			c.Set(UserIDKey, apiToken.UserID)
+			c.Set(ScopesKey, apiToken.Scopes)
+			c.Next()
+			return
+		}
+
+		userID, err := checkSession(token)
+		if err == errSessionEnded {
+			apierror.Abort(c, apierror.Unauthorized, "Invalid or expired session")
+			return
+		}
+		if err != nil {
+			apierror.Abort(c, apierror.Internal, "Failed to check session")
+			return
+		}
+
+		c.Set(UserIDKey, userID)
+		c.Next()
+	}
+}
+
+// RequestToken returns the bearer token from the Authorization header, or else the session cookie
+func RequestToken(c *gin.Context) string {
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		return token
+	}
+	token, _ := c.Cookie(auth.SessionCookieName)
+	return token
+}
+
+// isAdminToken reports whether token is the ADMIN_TOKEN, in constant time so the
+// comparison doesn't reveal how much of a guess was right
+func isAdminToken(token string) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// ErrTokenEnded is returned by CheckToken for a token that no longer authenticates
+var ErrTokenEnded = errors.New("token no longer valid")
+
+// CheckToken checks that a token which authenticated a request still does, for
+// connections that outlive the request. It returns ErrTokenEnded once the session
+// has ended or the access token has been revoked or has expired, and other errors
+// when the check itself failed.
+func CheckToken(token string) error {
+	if isAdminToken(token) {
+		return nil
+	}
+
+	if auth.IsAPIToken(token) {
+		_, err := findAPIToken(token)
+		if err == mongo.ErrNoDocuments || err == errTokenExpired {
+			return ErrTokenEnded
+		}
+		return err
+	}
+
+	_, err := checkSession(token)
+	if err == errSessionEnded {
+		return ErrTokenEnded
+	}
+	return err
+}
+
+// errSessionEnded is returned for session tokens that are invalid, expired, revoked
+// or belong to a deleted account
+var errSessionEnded = errors.New("session ended")
+
+// checkSession verifies a session token and that its user still exists at the
+// session generation the token was issued for
+func checkSession(token string) (string, error) {
+	userID, generation, err := auth.ParseSessionToken(token)
+	if err != nil {
+		return "", errSessionEnded
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", errSessionEnded
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err = db.Database.Collection("users").FindOne(ctx, bson.M{"_id": objectID},
+		options.FindOne().SetProjection(bson.M{"sessionGeneration": 1})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return "", errSessionEnded
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if user.SessionGeneration != generation {
+		return "", errSessionEnded
+	}
+	return userID, nil
+}
+
+// RequireScope rejects personal access tokens that don't carry scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScope(c, scope) {
+			apierror.Abort(c, apierror.InsufficientScope, "Access token is missing the "+scope+" scope")
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasScope reports whether the request may use scope; login sessions have every scope
+func HasScope(c *gin.Context, scope string) bool {
+	value, isToken := c.Get(ScopesKey)
+	if !isToken {
+		return true
+	}
+	for _, s := range value.([]string) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireSession rejects personal access tokens, for routes such as token management
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isToken := c.Get(ScopesKey); isToken {
+			apierror.Abort(c, apierror.Forbidden, "This endpoint requires a login session")
+			return
+		}
+		c.Next()
+	}
+}
+
+// errTokenExpired is returned for access tokens past their expiry
+var errTokenExpired = errors.New("access token expired")
+
+// lookupAPIToken finds an unexpired token by hash and records its use
+func lookupAPIToken(plain string) (*models.APIToken, error) {
+	token, err := findAPIToken(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db.Database.Collection("api_tokens").UpdateOne(ctx, bson.M{"_id": token.ID}, bson.M{"$set": bson.M{"lastUsedAt": time.Now()}})
+	return token, nil
+}
+
+// findAPIToken finds an unexpired token by hash
+func findAPIToken(plain string) (*models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var token models.APIToken
+	if err := db.Database.Collection("api_tokens").FindOne(ctx, bson.M{"tokenHash": auth.HashAPIToken(plain)}).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	if token.Expired() {
+		return nil, errTokenExpired
+	}
+	return &token, nil
+}
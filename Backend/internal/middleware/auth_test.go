@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/db/dbtest"
+	"codeflow-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SECRET", "middleware-test-secret")
+	auth.Init()
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// authenticate sends a request with token through AuthMiddleware and returns
+// the response and the user ID the middleware stored
+func authenticate(token string, cookie bool) (*httptest.ResponseRecorder, string) {
+	var userID string
+	r := gin.New()
+	r.Use(apierror.Recovery())
+	r.GET("/", AuthMiddleware(), func(c *gin.Context) {
+		userID = c.GetString(UserIDKey)
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if cookie {
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: token})
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, userID
+}
+
+func createUser(t *testing.T, generation int) models.User {
+	t.Helper()
+	user := models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", SessionGeneration: generation, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := db.Database.Collection("users").InsertOne(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func sessionToken(t *testing.T, userID string, generation int) string {
+	t.Helper()
+	token, _, err := auth.IssueSessionToken(userID, generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthMiddlewareSessions(t *testing.T) {
+	dbtest.Setup(t)
+	user := createUser(t, 2)
+
+	for _, cookie := range []bool{false, true} {
+		w, userID := authenticate(sessionToken(t, user.ID.Hex(), 2), cookie)
+		if w.Code != http.StatusNoContent || userID != user.ID.Hex() {
+			t.Errorf("cookie=%v: status %d, user %q; want the session accepted", cookie, w.Code, userID)
+		}
+	}
+
+	tests := map[string]string{
+		"no token":          "",
+		"malformed":         "not-a-jwt",
+		"ended generation":  sessionToken(t, user.ID.Hex(), 1),
+		"unknown user":      sessionToken(t, primitive.NewObjectID().Hex(), 0),
+		"non-ObjectID user": sessionToken(t, LegacyAdminUserID, 0),
+	}
+	for name, token := range tests {
+		if w, _ := authenticate(token, false); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, w.Code)
+		}
+	}
+}
+
+func TestAuthMiddlewareRejectsSessionsOfDeletedUsers(t *testing.T) {
+	dbtest.Setup(t)
+	user := createUser(t, 0)
+	token := sessionToken(t, user.ID.Hex(), 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := db.Database.Collection("users").DeleteOne(ctx, bson.M{"_id": user.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w, _ := authenticate(token, false); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d after the account was deleted, want 401", w.Code)
+	}
+}
+
+func TestAuthMiddlewareAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	w, userID := authenticate("admin-secret", false)
+	if w.Code != http.StatusNoContent || userID != LegacyAdminUserID {
+		t.Errorf("status %d, user %q; want the legacy admin", w.Code, userID)
+	}
+
+	for _, guess := range []string{"admin-secreT", "admin-secret ", "admin"} {
+		if w, _ := authenticate(guess, false); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d for %q, want 401", w.Code, guess)
+		}
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	dbtest.Setup(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	user := createUser(t, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	apiToken := func(expiresAt *time.Time) (string, primitive.ObjectID) {
+		t.Helper()
+		plain, hash, prefix, err := auth.GenerateAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := models.APIToken{ID: primitive.NewObjectID(), UserID: user.ID.Hex(), Prefix: prefix, TokenHash: hash, Scopes: []string{"read"}, ExpiresAt: expiresAt, CreatedAt: time.Now()}
+		if _, err := db.Database.Collection("api_tokens").InsertOne(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+		return plain, token.ID
+	}
+	session := sessionToken(t, user.ID.Hex(), 1)
+	revoked, revokedID := apiToken(nil)
+	active, _ := apiToken(nil)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	expired, _ := apiToken(&yesterday)
+
+	for _, token := range []string{"admin-secret", session, revoked, active} {
+		if err := CheckToken(token); err != nil {
+			t.Errorf("CheckToken(%.12s) = %v, want nil", token, err)
+		}
+	}
+
+	// Logging out everywhere moves the generation on, and revoking deletes the token
+	if _, err := db.Database.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$inc": bson.M{"sessionGeneration": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Database.Collection("api_tokens").DeleteOne(ctx, bson.M{"_id": revokedID}); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"ended session": session, "revoked token": revoked, "expired token": expired, "malformed": "not-a-jwt"} {
+		if err := CheckToken(token); err != ErrTokenEnded {
+			t.Errorf("%s: CheckToken = %v, want ErrTokenEnded", name, err)
+		}
+	}
+	if err := CheckToken(active); err != nil {
+		t.Errorf("another token of the same user: CheckToken = %v", err)
+	}
+}
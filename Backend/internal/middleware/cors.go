@@ -1,22 +1,221 @@
 package middleware
 
 import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+
 	"github.com/gin-gonic/gin"
 )
 
-// CORSMiddleware handles CORS for frontend access
-func CORSMiddleware() gin.HandlerFunc {
+const (
+	defaultCORSOrigins        = "http://localhost:3000"
+	defaultCORSMethods        = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	defaultCORSHeaders        = "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID, If-Match, If-None-Match"
+	defaultCORSExposedHeaders = "X-Request-ID, X-Next-Cursor, ETag, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After"
+	defaultCORSMaxAge         = 10 * time.Minute
+)
+
+// CORSConfig is the cross-origin policy applied to browser requests
+type CORSConfig struct {
+	// AllowedOrigins holds exact origins ("https://app.example.com"), wildcard
+	// subdomains ("https://*.example.com") or "*" for any origin
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORSConfigFromEnv reads the policy from the CORS_* variables
+func CORSConfigFromEnv() CORSConfig {
+	config := CORSConfig{
+		AllowedOrigins:   splitList(envOr("CORS_ALLOWED_ORIGINS", defaultCORSOrigins)),
+		AllowedMethods:   splitList(envOr("CORS_ALLOWED_METHODS", defaultCORSMethods)),
+		AllowedHeaders:   splitList(envOr("CORS_ALLOWED_HEADERS", defaultCORSHeaders)),
+		ExposedHeaders:   splitList(envOr("CORS_EXPOSED_HEADERS", defaultCORSExposedHeaders)),
+		AllowCredentials: os.Getenv("CORS_ALLOW_CREDENTIALS") != "false",
+		MaxAge:           defaultCORSMaxAge,
+	}
+
+	if maxAge := os.Getenv("CORS_MAX_AGE"); maxAge != "" {
+		if d, err := time.ParseDuration(maxAge); err == nil && d >= 0 {
+			config.MaxAge = d
+		}
+	}
+
+	return config
+}
+
+// CORSMiddleware handles CORS for frontend access. Allowed origins are reflected
+// back individually so credentials (the session cookie) can be sent; a "*" origin
+// is only ever answered with a literal "*" and never with credentials.
+func CORSMiddleware(config CORSConfig) gin.HandlerFunc {
+	allowAny := false
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+	}
+	originAllowed := config.originMatcher()
+
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		// Responses differ per origin, so caches must key on it
+		c.Writer.Header().Add("Vary", "Origin")
+		if preflight {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		// Not a cross-origin request
+		if origin == "" {
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			c.Next()
+			return
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		switch {
+		case originAllowed(origin):
+			c.Header("Access-Control-Allow-Origin", origin)
+			if config.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		case allowAny:
+			c.Header("Access-Control-Allow-Origin", "*")
+		default:
+			// Without CORS headers the browser blocks the response
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if exposed != "" {
+			c.Header("Access-Control-Expose-Headers", exposed)
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			if preflight {
+				c.Header("Access-Control-Allow-Methods", methods)
+				c.Header("Access-Control-Allow-Headers", headers)
+				if config.MaxAge > 0 {
+					c.Header("Access-Control-Max-Age", maxAge)
+				}
+			}
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
 		c.Next()
 	}
 }
+
+// WebSocketOriginMiddleware rejects WebSocket handshakes from browser pages on
+// origins outside the allowlist. Browsers send the session cookie with any
+// handshake and don't apply CORS to it, so without this check any site could
+// open a socket as the signed-in user. Requests without an Origin header, which
+// come from non-browser clients, and same-origin pages are allowed.
+func WebSocketOriginMiddleware(config CORSConfig) gin.HandlerFunc {
+	originAllowed := config.originMatcher()
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || originAllowed(origin) {
+			c.Next()
+			return
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, c.Request.Host) {
+			c.Next()
+			return
+		}
+		apierror.Abort(c, apierror.Forbidden, "Origin not allowed")
+	}
+}
+
+// originMatcher returns a function reporting whether an origin is listed in
+// AllowedOrigins, exactly or by a wildcard subdomain pattern; "*" is not a match
+func (config CORSConfig) originMatcher() func(origin string) bool {
+	var exact []string
+	var wildcards []string
+	for _, origin := range config.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+		case strings.Contains(origin, "://*."):
+			wildcards = append(wildcards, origin)
+		default:
+			exact = append(exact, origin)
+		}
+	}
+
+	return func(origin string) bool {
+		origin = strings.ToLower(origin)
+		for _, allowed := range exact {
+			if origin == allowed {
+				return true
+			}
+		}
+		for _, pattern := range wildcards {
+			if matchWildcardOrigin(pattern, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matchWildcardOrigin matches origins like "https://app.example.com" against
+// "https://*.example.com". The wildcard covers one or more subdomain labels
+// but not the bare domain, and the scheme and port must match exactly.
+func matchWildcardOrigin(pattern, origin string) bool {
+	star := strings.Index(pattern, "*")
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	for _, r := range subdomain {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return !strings.HasPrefix(subdomain, ".") && !strings.HasSuffix(subdomain, ".")
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envOr(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
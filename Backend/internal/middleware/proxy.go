@@ -0,0 +1,11 @@
+package middleware
+
+import "os"
+
+// TrustedProxiesFromEnv reads TRUSTED_PROXIES, a comma-separated list of proxy
+// IPs or CIDR ranges whose X-Forwarded-For and X-Real-IP headers are believed.
+// When it is unset no proxy is trusted, so the client IP used for rate limits
+// and the audit log is always the connecting address and can't be spoofed.
+func TrustedProxiesFromEnv() []string {
+	return splitList(os.Getenv("TRUSTED_PROXIES"))
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware applies a token bucket per caller, keyed by the
+// authenticated user when there is one and the client IP otherwise
+func RateLimitMiddleware(name string, limit ratelimit.Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		key := "ip:" + c.ClientIP()
+		if userID := c.GetString(UserIDKey); userID != "" {
+			key = "user:" + userID
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+
+		result, err := ratelimit.Default.Take(ctx, name+":"+key, limit)
+		if err != nil {
+			// Fail open so a rate limit backend outage doesn't take the API down
+			log.Printf("Rate limit check failed: %v", err)
+			c.Next()
+			return
+		}
+
+		ratelimit.WriteHeaders(c.Writer.Header(), result)
+		if !result.Allowed {
+			apierror.Abort(c, apierror.RateLimited, "Rate limit exceeded")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedRouter serves a rate limited route, authenticating as the X-User header if set
+func limitedRouter(t *testing.T, proxies []string) *gin.Engine {
+	t.Helper()
+	ratelimit.Default = ratelimit.NewMemoryStore()
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			c.Set(UserIDKey, user)
+		}
+	})
+	r.GET("/", RateLimitMiddleware("test", ratelimit.Limit{Capacity: 1, Per: time.Minute}), func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	})
+	return r
+}
+
+func get(r *gin.Engine, remoteAddr string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitKeysByUserThenIP(t *testing.T) {
+	r := limitedRouter(t, nil)
+
+	if w := get(r, "192.0.2.1:1000", "X-User", "ada"); w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	if w := get(r, "192.0.2.2:1000", "X-User", "ada"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("same user from another IP: %d, want 429", w.Code)
+	}
+	if w := get(r, "192.0.2.1:1000"); w.Code != http.StatusOK {
+		t.Errorf("anonymous request from the user's IP: %d, want its own bucket", w.Code)
+	}
+	if w := get(r, "192.0.2.1:1000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second anonymous request: %d, want 429", w.Code)
+	}
+}
+
+func TestForwardedForIsOnlyTrustedFromProxies(t *testing.T) {
+	r := limitedRouter(t, nil)
+
+	// Without trusted proxies a client can't dodge its limit by claiming other IPs
+	get(r, "192.0.2.1:1000", "X-Forwarded-For", "198.51.100.1")
+	w := get(r, "192.0.2.1:1000", "X-Forwarded-For", "198.51.100.2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For got a new bucket: %d %s", w.Code, w.Body.String())
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.9")
+	r = limitedRouter(t, TrustedProxiesFromEnv())
+	w = get(r, "10.1.2.3:1000", "X-Forwarded-For", "198.51.100.1")
+	if w.Body.String() != "198.51.100.1" {
+		t.Errorf("client IP behind a trusted proxy = %q", w.Body.String())
+	}
+	w = get(r, "192.0.2.1:1000", "X-Forwarded-For", "198.51.100.1")
+	if w.Body.String() != "192.0.2.1" {
+		t.Errorf("client IP from an untrusted address = %q", w.Body.String())
+	}
+}
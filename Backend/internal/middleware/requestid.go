@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDKey is the Gin context key holding the request ID
+const RequestIDKey = "requestId"
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware tags each request with an ID, reusing a well-formed
+// X-Request-ID from the client or proxy, and echoes it in the response
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			buf := make([]byte, 16)
+			rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AIUsage records a single call to an AI provider
+type AIUsage struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID           string              `bson:"userId" json:"userId"`
+	SpaceID          *primitive.ObjectID `bson:"spaceId,omitempty" json:"spaceId,omitempty"`
+	Mode             string              `bson:"mode" json:"mode"` // "generate", "explain", "review", "tests" or "chat"
+	Provider         string              `bson:"provider" json:"provider"`
+	Model            string              `bson:"model" json:"model"`
+	PromptTokens     int                 `bson:"promptTokens" json:"promptTokens"`
+	CompletionTokens int                 `bson:"completionTokens" json:"completionTokens"`
+	TotalTokens      int                 `bson:"totalTokens" json:"totalTokens"`
+	CostUSD          float64             `bson:"costUsd" json:"costUsd"`
+	LatencyMs        int64               `bson:"latencyMs" json:"latencyMs"`
+	Success          bool                `bson:"success" json:"success"`
+	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt        time.Time           `bson:"createdAt" json:"createdAt"`
+}
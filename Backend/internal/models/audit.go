@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a single mutation. Entries are append-only and are kept
+// when the space they belong to is deleted.
+type AuditEntry struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Actor      string                 `bson:"actor" json:"actor"`   // User ID, or "anonymous" for public share links
+	Action     string                 `bson:"action" json:"action"` // "<target>.<verb>", e.g. "log.update", "run.execute" or "ai.review"
+	SpaceID    *primitive.ObjectID    `bson:"spaceId,omitempty" json:"spaceId,omitempty"`
+	TargetType string                 `bson:"targetType" json:"targetType"` // "space", "vault", "log", "member", "invitation", "share", "chat" or "ai"
+	TargetID   string                 `bson:"targetId,omitempty" json:"targetId,omitempty"`
+	Before     map[string]interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After      map[string]interface{} `bson:"after,omitempty" json:"after,omitempty"`
+	IP         string                 `bson:"ip" json:"ip"`                               // Client address, taken from X-Forwarded-For only behind a trusted proxy
+	ProxyIP    string                 `bson:"proxyIp,omitempty" json:"proxyIp,omitempty"` // The trusted proxy that forwarded the request, if any
+	RequestID  string                 `bson:"requestId" json:"requestId"`
+	CreatedAt  time.Time              `bson:"createdAt" json:"createdAt"`
+}
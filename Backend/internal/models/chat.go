@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChatSession is a persisted AI conversation attached to a space or log
+type ChatSession struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	SpaceID   primitive.ObjectID  `bson:"spaceId" json:"spaceId"`
+	LogID     *primitive.ObjectID `bson:"logId,omitempty" json:"logId,omitempty"` // Set when the session is about a single log
+	UserID    string              `bson:"userId" json:"userId"`
+	Title     string              `bson:"title" json:"title"`
+	Messages  []ChatMessage       `bson:"messages" json:"messages,omitempty"` // Left out of session lists
+	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ChatMessage is a single turn in a chat session
+type ChatMessage struct {
+	Role      string    `bson:"role" json:"role"` // "user" or "assistant"
+	Content   string    `bson:"content" json:"content"`
+	Provider  string    `bson:"provider,omitempty" json:"provider,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
@@ -19,30 +19,40 @@ type Log struct {
 	Language  string             `bson:"language" json:"language"`
 	Code      string             `bson:"code" json:"code"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	Version   int64              `bson:"version" json:"version"` // Incremented on every change, used as the ETag
 	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
 // InferLanguageFromFilename determines language from file extension
 func InferLanguageFromFilename(filename string) string {
+	if language, ok := LanguageFromFilename(filename); ok {
+		return language
+	}
+	return "javascript" // default fallback
+}
+
+// LanguageFromFilename determines language from file extension, reporting
+// false for extensions it doesn't know
+func LanguageFromFilename(filename string) (string, bool) {
 	ext := strings.ToLower(filepath.Ext(filename))
 	switch ext {
 	case ".py":
-		return "python"
+		return "python", true
 	case ".js":
-		return "javascript"
+		return "javascript", true
 	case ".java":
-		return "java"
+		return "java", true
 	case ".c":
-		return "c"
+		return "c", true
 	case ".cpp", ".cc", ".cxx":
-		return "cpp"
+		return "cpp", true
 	case ".go":
-		return "go"
+		return "go", true
 	case ".ts":
-		return "typescript"
+		return "typescript", true
 	case ".rs":
-		return "rust"
+		return "rust", true
 	default:
-		return "javascript" // default fallback
+		return "", false
 	}
 }
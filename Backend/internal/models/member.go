@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SpaceMember grants a user a role in a space they don't own
+type SpaceMember struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SpaceID   primitive.ObjectID `bson:"spaceId" json:"spaceId"`
+	UserID    string             `bson:"userId" json:"userId"`
+	Role      string             `bson:"role" json:"role"` // "viewer", "editor" or "owner"
+	InvitedBy string             `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// SpaceInvitation is a pending offer of a role in a space, addressed by email.
+// Only someone holding its token can accept it; account emails aren't verified.
+type SpaceInvitation struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SpaceID   primitive.ObjectID `bson:"spaceId" json:"spaceId"`
+	SpaceName string             `bson:"spaceName" json:"spaceName"`
+	Email     string             `bson:"email" json:"email"`
+	Role      string             `bson:"role" json:"role"`
+	InvitedBy string             `bson:"invitedBy" json:"invitedBy"`
+	TokenHash string             `bson:"tokenHash" json:"-"` // SHA-256 of the token given to the invitee
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"` // Reset when the email is invited again
+}
+
+// Expired reports whether the invitation can no longer be accepted
+func (i *SpaceInvitation) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Secret is an encrypted value that can be injected into runs as an environment variable.
+// The value is never returned by the API.
+type Secret struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SpaceID    primitive.ObjectID `bson:"spaceId" json:"spaceId"`
+	Name       string             `bson:"name" json:"name"` // Environment variable name
+	Ciphertext []byte             `bson:"ciphertext" json:"-"`
+	Nonce      []byte             `bson:"nonce" json:"-"`
+	CreatedBy  string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareLink grants unauthenticated read-only access to a log or vault
+type ShareLink struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Token      string             `bson:"-" json:"token,omitempty"` // Only returned when the link is created
+	TokenHash  string             `bson:"tokenHash" json:"-"`       // SHA-256 of the token
+	SpaceID    primitive.ObjectID `bson:"spaceId" json:"spaceId"`
+	TargetType string             `bson:"targetType" json:"targetType"` // "log" or "vault"
+	TargetID   primitive.ObjectID `bson:"targetId" json:"targetId"`
+	CreatedBy  string             `bson:"createdBy" json:"createdBy"`
+	AllowRun   bool               `bson:"allowRun" json:"allowRun"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Expired reports whether the link has passed its expiry time
+func (s *ShareLink) Expired() bool {
+	return s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt)
+}
@@ -12,5 +12,9 @@ type Space struct {
 	UserID    string             `bson:"userId" json:"userId"`
 	Name      string             `bson:"name" json:"name"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	Version   int64              `bson:"version" json:"version"` // Incremented on every change, used as the ETag
 	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+
+	// Role is the requesting user's role in the space; it is computed, not stored
+	Role string `bson:"-" json:"role,omitempty"`
 }
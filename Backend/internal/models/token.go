@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIToken is a personal access token; only its hash is stored
+type APIToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     string             `bson:"userId" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // First characters of the token, for display
+	TokenHash  string             `bson:"tokenHash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Expired reports whether the token has passed its expiry time
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User is an account that owns spaces, vaults and logs
+type User struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email             string             `bson:"email" json:"email"`
+	Name              string             `bson:"name" json:"name"`
+	PasswordHash      string             `bson:"passwordHash,omitempty" json:"-"`
+	OIDCIssuer        string             `bson:"oidcIssuer,omitempty" json:"-"`        // Identity provider for single sign-on users
+	OIDCSubject       string             `bson:"oidcSubject,omitempty" json:"-"`       // Subject (user ID) at the identity provider
+	SessionGeneration int                `bson:"sessionGeneration,omitempty" json:"-"` // Stamped into session tokens; incrementing it ends every session
+	CreatedAt         time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt         time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
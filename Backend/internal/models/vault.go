@@ -15,5 +15,6 @@ type Vault struct {
 	Path      string             `bson:"path" json:"path"` // Full path like "vault1" or "vault1/subvault"
 	ParentID  *primitive.ObjectID `bson:"parentId,omitempty" json:"parentId,omitempty"` // For nested vaults
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	Version   int64              `bson:"version" json:"version"` // Incremented on every change, used as the ETag
 	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"   // Waiting for its first or next attempt
+	DeliverySucceeded = "succeeded" // The receiver answered 2xx
+	DeliveryFailed    = "failed"    // Out of attempts, or the webhook is disabled
+)
+
+// Webhook posts a space's events to a URL, signed with a secret that is never
+// returned after the webhook is created
+type Webhook struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SpaceID          primitive.ObjectID `bson:"spaceId" json:"spaceId"`
+	URL              string             `bson:"url" json:"url"`
+	Events           []string           `bson:"events" json:"events"` // Event types, or "*" for all
+	Active           bool               `bson:"active" json:"active"`
+	SecretCiphertext []byte             `bson:"secretCiphertext" json:"-"`
+	SecretNonce      []byte             `bson:"secretNonce" json:"-"`
+	CreatedBy        string             `bson:"createdBy" json:"createdBy"`
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	WebhookID     primitive.ObjectID  `bson:"webhookId" json:"webhookId"`
+	SpaceID       primitive.ObjectID  `bson:"spaceId" json:"spaceId"`
+	EventID       string              `bson:"eventId" json:"eventId"` // The same for redeliveries, so receivers can skip duplicates
+	EventType     string              `bson:"eventType" json:"eventType"`
+	Payload       json.RawMessage     `bson:"payload" json:"payload"` // The request body
+	Status        string              `bson:"status" json:"status"`
+	Attempts      []WebhookAttempt    `bson:"attempts" json:"attempts"`
+	ResponseCode  int                 `bson:"responseCode,omitempty" json:"responseCode,omitempty"` // Of the latest attempt
+	NextAttemptAt *time.Time          `bson:"nextAttemptAt,omitempty" json:"nextAttemptAt,omitempty"`
+	LeaseUntil    *time.Time          `bson:"leaseUntil,omitempty" json:"-"` // Set while an instance is sending it
+	RedeliveryOf  *primitive.ObjectID `bson:"redeliveryOf,omitempty" json:"redeliveryOf,omitempty"`
+	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// WebhookAttempt is the outcome of one request to a webhook
+type WebhookAttempt struct {
+	At           time.Time `bson:"at" json:"at"`
+	StatusCode   int       `bson:"statusCode,omitempty" json:"statusCode,omitempty"`     // Absent when no response arrived
+	ResponseBody string    `bson:"responseBody,omitempty" json:"responseBody,omitempty"` // The start of it
+	Error        string    `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs   int64     `bson:"durationMs" json:"durationMs"`
+}
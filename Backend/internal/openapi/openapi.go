@@ -0,0 +1,470 @@
+// Package openapi builds an OpenAPI 3 document from a table of operations and
+// the Go types their handlers bind and write. Schemas are derived from json tags
+// by reflection, so the document changes with the types it describes.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Operation describes one route
+type Operation struct {
+	Method  string
+	Path    string      // Gin syntax, e.g. /api/logs/:id
+	Handler interface{} // The handler function, checked against the router; nil for inline handlers
+	Summary string
+	Tag     string
+	Auth    string // "" for public routes, AuthAny, AuthSession, or the access token scope it needs
+
+	Params []Param // Query and header parameters; path parameters come from Path
+
+	Body        interface{}            // Value of the JSON request body type, nil for none
+	ExtraBodies map[string]interface{} // Request bodies for other media types
+
+	Status          int         // Success status, 200 when zero
+	Response        interface{} // Value of the JSON response type, nil for no body
+	ResponseType    string      // Media type of a non-JSON response, served as binary
+	ResponseHeaders []Param
+}
+
+// OneOf is a Body or Response that may be any of several types
+type OneOf []interface{}
+
+// SchemaProvider is implemented by types whose JSON form isn't their fields,
+// such as types with their own MarshalJSON
+type SchemaProvider interface {
+	OpenAPISchema() *Schema
+}
+
+// Auth requirements other than a token scope
+const (
+	AuthAny     = "any"     // Any session or access token
+	AuthSession = "session" // A login session; access tokens are refused
+)
+
+// Param is a query, header or response header parameter
+type Param struct {
+	Name        string
+	In          string // "query" or "header"
+	Description string
+	Required    bool
+	Type        string // JSON type, "string" when empty
+}
+
+// Info is the document's title and version
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+}
+
+// Schema is a JSON schema in the OpenAPI 3.0 dialect
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	Responses       map[string]response       `json:"responses"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+	Scope       string                `json:"x-scope,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Build describes ops. errorBody is the value of the error envelope type, used
+// as every operation's default response; sessionCookie is the session cookie name.
+func Build(info Info, ops []Operation, errorBody interface{}, sessionCookie string) *Document {
+	schemas := newSchemaSet()
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   map[string]map[string]*operation{},
+		Components: components{
+			Schemas: schemas.components,
+			Responses: map[string]response{
+				"Error": {
+					Description: "Error envelope; branch on code",
+					Content:     jsonContent(schemas.of(reflect.TypeOf(errorBody), false)),
+				},
+			},
+			SecuritySchemes: map[string]securityScheme{
+				"bearerAuth": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "A personal access token, or a session token from login",
+				},
+				"cookieAuth": {
+					Type: "apiKey",
+					In:   "cookie",
+					Name: sessionCookie,
+				},
+			},
+		},
+	}
+
+	for _, op := range ops {
+		path, pathParams := openAPIPath(op.Path)
+		item := doc.Paths[path]
+		if item == nil {
+			item = map[string]*operation{}
+			doc.Paths[path] = item
+		}
+
+		o := &operation{
+			OperationID: handlerName(op.Handler),
+			Summary:     op.Summary,
+			Responses:   map[string]response{"default": {Ref: "#/components/responses/Error"}},
+			Security:    []map[string][]string{},
+		}
+		if op.Tag != "" {
+			o.Tags = []string{op.Tag}
+		}
+
+		if op.Auth != "" {
+			o.Security = []map[string][]string{{"cookieAuth": {}}, {"bearerAuth": {}}}
+			if op.Auth != AuthAny && op.Auth != AuthSession {
+				o.Scope = op.Auth
+			}
+		}
+
+		for _, name := range pathParams {
+			o.Parameters = append(o.Parameters, parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+		for _, p := range op.Params {
+			o.Parameters = append(o.Parameters, parameter{Name: p.Name, In: p.In, Description: p.Description, Required: p.Required, Schema: paramSchema(p)})
+		}
+
+		if op.Body != nil || len(op.ExtraBodies) > 0 {
+			o.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{}}
+			if op.Body != nil {
+				o.RequestBody.Content["application/json"] = mediaType{Schema: schemas.value(op.Body, true)}
+			}
+			for contentType, body := range op.ExtraBodies {
+				o.RequestBody.Content[contentType] = mediaType{Schema: schemas.value(body, true)}
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := response{Description: http.StatusText(status)}
+		switch {
+		case op.ResponseType != "":
+			success.Content = map[string]mediaType{op.ResponseType: {Schema: &Schema{Type: "string", Format: "binary"}}}
+		case op.Response != nil:
+			success.Content = jsonContent(schemas.value(op.Response, false))
+		}
+		for _, h := range op.ResponseHeaders {
+			if success.Headers == nil {
+				success.Headers = map[string]header{}
+			}
+			success.Headers[h.Name] = header{Description: h.Description, Schema: paramSchema(h)}
+		}
+		o.Responses[strconv.Itoa(status)] = success
+
+		item[strings.ToLower(op.Method)] = o
+	}
+
+	return doc
+}
+
+// Route is a route registered on a router
+type Route struct {
+	Method  string
+	Path    string
+	Handler string // The handler's full function name
+}
+
+// CheckRoutes compares ops with the routes registered on a router. Every route
+// must be documented with the same handler, and every operation must have a route.
+func CheckRoutes(ops []Operation, routes []Route) error {
+	documented := map[string]Operation{}
+	for _, op := range ops {
+		documented[op.Method+" "+op.Path] = op
+	}
+
+	var problems []string
+	registered := map[string]bool{}
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+
+		op, ok := documented[key]
+		if !ok {
+			problems = append(problems, key+" is not documented")
+			continue
+		}
+		if op.Handler != nil && handlerFullName(op.Handler) != route.Handler {
+			problems = append(problems, fmt.Sprintf("%s is documented as %s but served by %s", key, handlerFullName(op.Handler), route.Handler))
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			problems = append(problems, key+" is documented but has no route")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// openAPIPath converts /logs/:id to /logs/{id} and returns the parameter names
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func handlerFullName(h interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+}
+
+// handlerName is the operation ID: the handler's function name
+func handlerName(h interface{}) string {
+	if h == nil {
+		return ""
+	}
+	name := handlerFullName(h)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func paramSchema(p Param) *Schema {
+	if p.Type == "" {
+		return &Schema{Type: "string"}
+	}
+	return &Schema{Type: p.Type}
+}
+
+func jsonContent(schema *Schema) map[string]mediaType {
+	return map[string]mediaType{"application/json": {Schema: schema}}
+}
+
+// schemaSet derives schemas from Go types, naming each struct type in components
+type schemaSet struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{components: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	rawJSONType  = reflect.TypeOf([]byte(nil))
+	providerType = reflect.TypeOf((*SchemaProvider)(nil)).Elem()
+)
+
+// value returns the schema for v's type, or for each alternative of a OneOf
+func (s *schemaSet) value(v interface{}, request bool) *Schema {
+	if alternatives, ok := v.(OneOf); ok {
+		schema := &Schema{}
+		for _, alternative := range alternatives {
+			schema.OneOf = append(schema.OneOf, s.value(alternative, request))
+		}
+		return schema
+	}
+	return s.of(reflect.TypeOf(v), request)
+}
+
+// of returns the schema for t. In request bodies a field is required when its
+// binding tag says so; in responses, when it is never omitted.
+func (s *schemaSet) of(t reflect.Type, request bool) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case objectIDType:
+		return &Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"}
+	case rawJSONType:
+		return &Schema{Type: "string", Format: "byte"}
+	}
+	if t.Kind() != reflect.Ptr && t.Implements(providerType) {
+		return reflect.Zero(t).Interface().(SchemaProvider).OpenAPISchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct && t.Elem() != timeType {
+			return s.of(t.Elem(), request)
+		}
+		schema := s.of(t.Elem(), request)
+		schema.Nullable = true
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// json.RawMessage and other byte slices hold arbitrary JSON
+			return &Schema{}
+		}
+		return &Schema{Type: "array", Items: s.of(t.Elem(), request)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.of(t.Elem(), request)}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		return s.object(t, request)
+	}
+	return &Schema{}
+}
+
+// object returns a reference to a named struct's schema, or an inline schema for anonymous structs
+func (s *schemaSet) object(t reflect.Type, request bool) *Schema {
+	if t.Name() == "" {
+		return s.properties(t, request)
+	}
+
+	if name, ok := s.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if _, taken := s.components[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+
+	// Register the name first so recursive types such as TreeNode refer to themselves
+	s.names[t] = name
+	s.components[name] = &Schema{}
+	*s.components[name] = *s.properties(t, request)
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (s *schemaSet) properties(t reflect.Type, request bool) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without a name contribute their fields
+		if field.Anonymous && name == "" {
+			embedded := s.properties(field.Type, request)
+			for key, value := range embedded.Properties {
+				schema.Properties[key] = value
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = s.of(field.Type, request)
+
+		var required bool
+		if request {
+			required = strings.Contains(field.Tag.Get("binding"), "required")
+		} else {
+			required = !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr
+		}
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
@@ -0,0 +1,140 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testItem struct {
+	ID        primitive.ObjectID `json:"id"`
+	Name      string             `json:"name"`
+	Tags      []string           `json:"tags,omitempty"`
+	Parent    *string            `json:"parent"`
+	Children  []testItem         `json:"children"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+type testRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Count int    `json:"count"`
+}
+
+type testError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func getItem(c *gin.Context)    {}
+func createItem(c *gin.Context) {}
+
+func testDocument() *Document {
+	return Build(Info{Title: "Test", Version: "1"}, []Operation{
+		{Method: "GET", Path: "/items/:id", Handler: getItem, Auth: "read", Response: testItem{}},
+		{Method: "POST", Path: "/items", Handler: createItem, Auth: "write", Body: testRequest{}, Status: http.StatusCreated, Response: OneOf{testItem{}, []testItem{}}},
+		{Method: "GET", Path: "/export", ResponseType: "application/zip"},
+		{Method: "GET", Path: "/login", Status: http.StatusFound},
+	}, testError{}, "session")
+}
+
+func TestBuildDescribesOperations(t *testing.T) {
+	doc := testDocument()
+
+	get := doc.Paths["/items/{id}"]["get"]
+	if get == nil || get.OperationID != "getItem" || get.Scope != "read" {
+		t.Fatalf("GET /items/{id} = %+v", get)
+	}
+	if len(get.Parameters) != 1 || get.Parameters[0].In != "path" || !get.Parameters[0].Required {
+		t.Errorf("parameters = %+v, want the id path parameter", get.Parameters)
+	}
+
+	item := doc.Components.Schemas["TestItem"]
+	if item == nil {
+		t.Fatal("testItem has no component schema")
+	}
+	if got := strings.Join(item.Required, ","); got != "children,createdAt,id,name" {
+		t.Errorf("required = %s; pointers and omitempty fields are optional", got)
+	}
+	if !item.Properties["parent"].Nullable || item.Properties["children"].Items.Ref != "#/components/schemas/TestItem" {
+		t.Errorf("properties = %+v", item.Properties)
+	}
+
+	post := doc.Paths["/items"]["post"]
+	body := post.RequestBody.Content["application/json"].Schema
+	if request := doc.Components.Schemas["TestRequest"]; body.Ref == "" || len(request.Required) != 1 || request.Required[0] != "name" {
+		t.Errorf("request body = %+v, required = %v; want only the binding:required field", body, request.Required)
+	}
+	if _, ok := post.Responses["201"]; !ok {
+		t.Errorf("responses = %v, want 201", post.Responses)
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	ops := []Operation{
+		{Method: "GET", Path: "/items/:id", Handler: getItem},
+		{Method: "POST", Path: "/items", Handler: createItem},
+	}
+	routes := []Route{
+		{Method: "GET", Path: "/items/:id", Handler: handlerFullName(getItem)},
+		{Method: "POST", Path: "/items", Handler: handlerFullName(getItem)},
+		{Method: "DELETE", Path: "/items/:id", Handler: handlerFullName(getItem)},
+	}
+
+	err := CheckRoutes(ops, routes)
+	if err == nil {
+		t.Fatal("CheckRoutes accepted a wrong handler and an undocumented route")
+	}
+	for _, want := range []string{"DELETE /items/:id is not documented", "POST /items is documented as"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+
+	if err := CheckRoutes(ops, routes[:1]); err == nil || !strings.Contains(err.Error(), "POST /items is documented but has no route") {
+		t.Errorf("error = %v, want the missing route", err)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	doc := testDocument()
+	jsonHeader := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	item := `{"id": "0123456789abcdef01234567", "name": "a", "parent": null, "children": [], "createdAt": "2024-01-02T03:04:05.123Z"}`
+
+	tests := []struct {
+		name, method, path string
+		status             int
+		header             http.Header
+		body               string
+		problem            string // Empty when the response matches
+	}{
+		{"item", "GET", "/items/:id", 200, jsonHeader, item, ""},
+		{"not modified", "GET", "/items/:id", 304, http.Header{}, "", ""},
+		{"error envelope", "GET", "/items/:id", 404, jsonHeader, `{"code": "NOT_FOUND", "message": "gone"}`, ""},
+		{"bad error", "GET", "/items/:id", 500, jsonHeader, `{"code": "INTERNAL", "message": "boom", "error": "boom"}`, "body.error is not documented"},
+		{"undocumented status", "GET", "/items/:id", 201, jsonHeader, item, "status 201 is not documented"},
+		{"missing field", "GET", "/items/:id", 200, jsonHeader, `{"id": "0123456789abcdef01234567"}`, "body.children is required"},
+		{"null array", "GET", "/items/:id", 200, jsonHeader, strings.Replace(item, `"children": []`, `"children": null`, 1), "body.children is null"},
+		{"bad object ID", "GET", "/items/:id", 200, jsonHeader, strings.Replace(item, "0123456789abcdef01234567", "x", 1), "want a match"},
+		{"bad time", "GET", "/items/:id", 200, jsonHeader, strings.Replace(item, "2024-01-02T03:04:05.123Z", "yesterday", 1), "want a date-time"},
+		{"nested", "GET", "/items/:id", 200, jsonHeader, strings.Replace(item, `"children": []`, `"children": [{"name": 1}]`, 1), "body.children[0]"},
+		{"one of array", "POST", "/items", 201, jsonHeader, "[" + item + "]", ""},
+		{"one of neither", "POST", "/items", 201, jsonHeader, `"item"`, "matches no alternative"},
+		{"redirect", "GET", "/login", 302, http.Header{"Content-Type": {"text/html"}}, `<a href="/">Found</a>.`, ""},
+		{"binary", "GET", "/export", 200, http.Header{"Content-Type": {"application/zip"}}, "PK", ""},
+		{"wrong media type", "GET", "/export", 200, jsonHeader, "{}", "not a documented media type"},
+		{"undocumented route", "GET", "/nothing", 200, jsonHeader, "{}", "is not documented"},
+	}
+	for _, tt := range tests {
+		err := doc.CheckResponse(tt.method, tt.path, tt.status, tt.header, []byte(tt.body))
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("%s: unexpected problem: %v", tt.name, err)
+		case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+			t.Errorf("%s: problem = %v, want %q", tt.name, err, tt.problem)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckResponse reports how a response differs from what the document says the
+// operation at method and path (Gin syntax) returns. Error statuses must carry
+// the error envelope; any other status must be the documented success status.
+func (d *Document) CheckResponse(method, path string, status int, header http.Header, body []byte) error {
+	openAPIPath, _ := openAPIPath(path)
+	op := d.Paths[openAPIPath][strings.ToLower(method)]
+	if op == nil {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok && status >= 400 {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok && status == http.StatusNotModified {
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+	if strings.HasPrefix(resp.Ref, "#/components/responses/") {
+		resp = d.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if len(resp.Content) == 0 {
+		// Redirects may carry a short link for clients that don't follow them
+		if len(body) > 0 && (status < 300 || status >= 400) {
+			return fmt.Errorf("status %d has a %s body but none is documented", status, mediaType)
+		}
+		return nil
+	}
+
+	content, ok := resp.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("status %d is %q, not a documented media type", status, mediaType)
+	}
+	if mediaType != "application/json" {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("status %d body isn't JSON: %v", status, err)
+	}
+	return d.checkValue(content.Schema, value, "body")
+}
+
+// checkValue validates a decoded JSON value against schema
+func (d *Document) checkValue(schema *Schema, value interface{}, at string) error {
+	for schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		resolved, ok := d.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, schema.Ref)
+		}
+		schema = resolved
+	}
+
+	if len(schema.OneOf) > 0 {
+		var problems []string
+		for _, alternative := range schema.OneOf {
+			err := d.checkValue(alternative, value, at)
+			if err == nil {
+				return nil
+			}
+			problems = append(problems, err.Error())
+		}
+		return fmt.Errorf("%s matches no alternative: %s", at, strings.Join(problems, "; "))
+	}
+
+	if value == nil {
+		if schema.Type == "" || schema.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s is null, want %s", at, schema.Type)
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is %T, want an object", at, value)
+		}
+		return d.checkObject(schema, object, at)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s is %T, want an array", at, value)
+		}
+		for i, item := range items {
+			if err := d.checkValue(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s is %T, want a string", at, value)
+		}
+		return checkString(schema, s, at)
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s is %v, want an integer", at, value)
+		}
+		return nil
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s is %T, want a number", at, value)
+		}
+		return nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s is %T, want a boolean", at, value)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: unknown schema type %s", at, schema.Type)
+}
+
+// checkObject checks required and documented properties. Properties the schema
+// doesn't list are refused unless it allows additional properties.
+func (d *Document) checkObject(schema *Schema, object map[string]interface{}, at string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s.%s is required", at, name)
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		switch {
+		case ok:
+		case schema.AdditionalProperties != nil:
+			property = schema.AdditionalProperties
+		case len(schema.Properties) > 0:
+			return fmt.Errorf("%s.%s is not documented", at, name)
+		default:
+			continue
+		}
+		if err := d.checkValue(property, object[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkString(schema *Schema, s, at string) error {
+	switch schema.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			return fmt.Errorf("%s is %q, want a date-time", at, s)
+		}
+	case "byte":
+		if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+			return fmt.Errorf("%s is %q, want base64", at, s)
+		}
+	}
+	if schema.Pattern != "" {
+		if matched, err := regexp.MatchString(schema.Pattern, s); err != nil || !matched {
+			return fmt.Errorf("%s is %q, want a match for %s", at, s, schema.Pattern)
+		}
+	}
+	return nil
+}
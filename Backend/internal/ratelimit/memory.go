@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Take looks for idle buckets to drop
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Buckets left alone for
+// idleBucketTTL are dropped, like the MongoDB store's TTL index does.
+type MemoryStore struct {
+	sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+// NewMemoryStore creates an empty in-process store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), sweptAt: time.Now()}
+}
+
+// Take refills the bucket for the elapsed time and takes one token if available
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Capacity), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(limit.Capacity), b.tokens+elapsed*limit.refillRate())
+	b.updatedAt = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return newResult(allowed, b.tokens, limit), nil
+}
+
+// sweep drops idle buckets, at most once per sweepInterval. Callers hold the lock.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < sweepInterval {
+		return
+	}
+	s.sweptAt = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updatedAt) >= idleBucketTTL {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTakesAndRefills(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Capacity: 2, Per: time.Minute}
+	ctx := context.Background()
+
+	for i, want := range []bool{true, true, false} {
+		result, err := store.Take(ctx, "k", limit)
+		if err != nil || result.Allowed != want {
+			t.Fatalf("take %d: allowed = %v, err = %v; want %v", i, result.Allowed, err, want)
+		}
+	}
+
+	result, _ := store.Take(ctx, "other", limit)
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("another key shared the bucket: %+v", result)
+	}
+
+	// Half a minute refills one of the two tokens
+	store.buckets["k"].updatedAt = time.Now().Add(-30 * time.Second)
+	if result, _ := store.Take(ctx, "k", limit); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after refilling: %+v", result)
+	}
+}
+
+func TestMemoryStoreDropsIdleBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Capacity: 5, Per: time.Minute}
+	ctx := context.Background()
+
+	store.Take(ctx, "idle", limit)
+	store.Take(ctx, "busy", limit)
+	store.buckets["idle"].updatedAt = time.Now().Add(-idleBucketTTL)
+
+	// Buckets are only swept once per interval
+	store.Take(ctx, "busy", limit)
+	if _, ok := store.buckets["idle"]; !ok {
+		t.Fatal("swept before the interval passed")
+	}
+
+	store.sweptAt = time.Now().Add(-sweepInterval)
+	store.Take(ctx, "busy", limit)
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("idle bucket was kept")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Error("busy bucket was dropped")
+	}
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept before MongoDB removes it
+const idleBucketTTL = time.Hour
+
+// MongoStore keeps buckets in a MongoDB collection so limits hold across instances
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoStore creates a store backed by collection and ensures its TTL index
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"updatedAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(idleBucketTTL.Seconds())),
+	})
+
+	return &MongoStore{collection: collection}
+}
+
+// Take refills and decrements the bucket atomically with a pipeline update
+func (s *MongoStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	capacity := float64(limit.Capacity)
+
+	// Milliseconds since the last update, treating a new bucket as just refilled
+	elapsedMs := bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updatedAt", now}}}}
+	refilled := bson.M{"$min": bson.A{
+		capacity,
+		bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$tokens", capacity}},
+			bson.M{"$multiply": bson.A{bson.M{"$divide": bson.A{elapsedMs, 1000}}, limit.refillRate()}},
+		}},
+	}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updatedAt": now}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}}},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var doc struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&doc)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(doc.Allowed, doc.Tokens, limit), nil
+}
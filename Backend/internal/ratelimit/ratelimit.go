@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Limit describes a token bucket: Capacity requests may be made at once,
+// and the bucket refills at Capacity tokens per Per.
+type Limit struct {
+	Capacity int
+	Per      time.Duration
+}
+
+// refillRate returns tokens added per second
+func (l Limit) refillRate() float64 {
+	return float64(l.Capacity) / l.Per.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // Time until the next token is available, zero if allowed
+	ResetAfter time.Duration // Time until the bucket is full again
+}
+
+// Store takes tokens from named buckets
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Default is the store used by the API, configured by Init
+var Default Store = NewMemoryStore()
+
+// Init selects the store from RATE_LIMIT_BACKEND ("memory" or "mongo").
+// The mongo backend shares limits across API instances.
+func Init(database *mongo.Database) {
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "mongo":
+		Default = NewMongoStore(database.Collection("rate_limits"))
+		log.Println("Rate limiting using MongoDB backend")
+	default:
+		Default = NewMemoryStore()
+	}
+}
+
+// FromEnv reads a per-minute limit from key, falling back to def
+func FromEnv(key string, def int) Limit {
+	capacity := def
+	if parsed, err := strconv.Atoi(os.Getenv(key)); err == nil && parsed > 0 {
+		capacity = parsed
+	}
+	return Limit{Capacity: capacity, Per: time.Minute}
+}
+
+// newResult builds a Result from the bucket level after the take
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	rate := limit.refillRate()
+	result := Result{
+		Allowed:    allowed,
+		Limit:      limit.Capacity,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(limit.Capacity) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// WriteHeaders sets X-RateLimit-* headers, and Retry-After when the request was refused.
+// X-RateLimit-Reset is the number of seconds until the bucket is full again.
+func WriteHeaders(h http.Header, r Result) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(r.ResetAfter)))
+	if !r.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(r.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
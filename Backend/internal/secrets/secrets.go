@@ -0,0 +1,92 @@
+// Package secrets encrypts space secrets at rest with AES-256-GCM under a
+// master key taken from SECRETS_MASTER_KEY.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+)
+
+// ErrNotConfigured is returned when no valid master key is set
+var ErrNotConfigured = errors.New("secrets store is not configured (set SECRETS_MASTER_KEY)")
+
+var aead cipher.AEAD
+
+// Init loads the master key from SECRETS_MASTER_KEY, a 32-byte key encoded as
+// base64 or hex. Without a valid key the secrets store is disabled.
+func Init() {
+	aead = nil
+	encoded := os.Getenv("SECRETS_MASTER_KEY")
+	if encoded == "" {
+		log.Println("SECRETS_MASTER_KEY not set, the secrets store is disabled")
+		return
+	}
+
+	key, err := decodeKey(encoded)
+	if err != nil {
+		log.Printf("Invalid SECRETS_MASTER_KEY, the secrets store is disabled: %v", err)
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Printf("Invalid SECRETS_MASTER_KEY, the secrets store is disabled: %v", err)
+		return
+	}
+
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		log.Printf("Failed to initialise the secrets store: %v", err)
+	}
+}
+
+// Enabled reports whether a master key is configured
+func Enabled() bool {
+	return aead != nil
+}
+
+// Seal encrypts a value. The associated data binds the ciphertext to where it is
+// stored, so it can't be copied to another space or name and still decrypt.
+func Seal(plaintext, associatedData string) (ciphertext, nonce []byte, err error) {
+	if aead == nil {
+		return nil, nil, ErrNotConfigured
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return aead.Seal(nil, nonce, []byte(plaintext), []byte(associatedData)), nonce, nil
+}
+
+// Open decrypts a value sealed with the same associated data
+func Open(ciphertext, nonce []byte, associatedData string) (string, error) {
+	if aead == nil {
+		return "", ErrNotConfigured
+	}
+	if len(nonce) != aead.NonceSize() {
+		return "", errors.New("invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("expected 32 bytes encoded as base64 or hex")
+}
@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+// initWith loads key as the master key, restoring the store afterwards
+func initWith(t *testing.T, key string) {
+	t.Helper()
+	t.Cleanup(func() { aead = nil })
+	t.Setenv("SECRETS_MASTER_KEY", key)
+	Init()
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name, key string
+		enabled   bool
+	}{
+		{"hex", hex.EncodeToString(testKey), true},
+		{"base64", base64.StdEncoding.EncodeToString(testKey), true},
+		{"unset", "", false},
+		{"short", hex.EncodeToString(testKey[:16]), false},
+		{"not encoded", "correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		initWith(t, tt.key)
+		if Enabled() != tt.enabled {
+			t.Errorf("%s key: Enabled() = %v", tt.name, Enabled())
+		}
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	initWith(t, hex.EncodeToString(testKey))
+
+	ciphertext, nonce, err := Seal("hunter2", "space/TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, []byte("hunter2")) {
+		t.Error("ciphertext contains the plaintext")
+	}
+	if got, err := Open(ciphertext, nonce, "space/TOKEN"); err != nil || got != "hunter2" {
+		t.Errorf("Open = %q, %v", got, err)
+	}
+
+	// Every seal uses a fresh nonce
+	again, nonce2, _ := Seal("hunter2", "space/TOKEN")
+	if bytes.Equal(nonce, nonce2) || bytes.Equal(ciphertext, again) {
+		t.Error("sealing twice gave the same nonce or ciphertext")
+	}
+
+	// The associated data binds the value to where it is stored
+	if _, err := Open(ciphertext, nonce, "space/OTHER"); err == nil {
+		t.Error("opened a value under another name")
+	}
+	tampered := append([]byte{}, ciphertext...)
+	tampered[0] ^= 1
+	if _, err := Open(tampered, nonce, "space/TOKEN"); err == nil {
+		t.Error("opened a tampered value")
+	}
+	if _, err := Open(ciphertext, nonce[:4], "space/TOKEN"); err == nil {
+		t.Error("opened a value with a short nonce")
+	}
+
+	// A different master key can't open it
+	initWith(t, hex.EncodeToString(bytes.Repeat([]byte{8}, 32)))
+	if _, err := Open(ciphertext, nonce, "space/TOKEN"); err == nil {
+		t.Error("opened a value under another master key")
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	initWith(t, "")
+	if _, _, err := Seal("hunter2", "space/TOKEN"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Seal: err = %v", err)
+	}
+	if _, err := Open([]byte("x"), make([]byte, 12), "space/TOKEN"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Open: err = %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Package server builds the API's router: the middleware, the route groups with
+// the access token scope each needs, and the handler behind every route.
+// The OpenAPI document in the handler package must list exactly these routes.
+package server
+
+import (
+	"fmt"
+
+	"codeflow-backend/internal/apierror"
+	"codeflow-backend/internal/auth"
+	"codeflow-backend/internal/handler"
+	"codeflow-backend/internal/middleware"
+	"codeflow-backend/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// New returns the router serving the API. CORS, trusted proxies and rate
+// limits are configured from the environment.
+func New() (*gin.Engine, error) {
+	// Setup Gin router; panics and unknown routes answer with the error envelope
+	r := gin.New()
+	if err := r.SetTrustedProxies(middleware.TrustedProxiesFromEnv()); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	r.Use(gin.Logger(), apierror.Recovery())
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(apierror.NoRoute)
+	r.NoMethod(apierror.NoMethod)
+
+	// CORS middleware
+	corsConfig := middleware.CORSConfigFromEnv()
+	r.Use(middleware.CORSMiddleware(corsConfig))
+
+	// Tag every request with an ID for logs and the audit trail
+	r.Use(middleware.RequestIDMiddleware())
+
+	// Health check
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	// OpenAPI document for every route below
+	r.GET("/api/openapi.json", handler.GetOpenAPI)
+
+	// Account routes that don't require a session; password attempts are rate limited per IP
+	authRoutes := r.Group("/api/auth")
+	{
+		authLimit := middleware.RateLimitMiddleware("auth", ratelimit.FromEnv("AUTH_RATE_LIMIT", 10))
+		authRoutes.POST("/register", authLimit, handler.Register)
+		authRoutes.POST("/login", authLimit, handler.Login)
+		authRoutes.POST("/logout", handler.Logout)
+		authRoutes.GET("/oidc/login", handler.OIDCLogin) // Query: ?returnTo=/path
+		authRoutes.GET("/oidc/callback", handler.OIDCCallback)
+	}
+
+	// Public share links, no account required but rate limited per IP
+	public := r.Group("/api/public", middleware.RateLimitMiddleware("public", ratelimit.FromEnv("PUBLIC_RATE_LIMIT", 60)))
+	{
+		public.GET("/shares/:token", handler.GetSharedContent)
+		public.GET("/shares/:token/logs/:logId", handler.GetSharedLog)
+		public.POST("/shares/:token/run", handler.RunSharedLog)
+	}
+
+	// API routes scoped to the authenticated user
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware())
+
+	// Current user
+	api.GET("/auth/me", handler.GetCurrentUser)
+
+	// GraphQL checks the read or write scope itself, as it depends on the operation
+	api.GET("/graphql", handler.GraphQL)
+	api.POST("/graphql", handler.GraphQL)
+
+	// Personal access tokens can only be managed from a login session
+	tokens := api.Group("/tokens", middleware.RequireSession())
+	{
+		tokens.GET("", handler.GetAPITokens)
+		tokens.POST("", handler.CreateAPIToken)
+		tokens.DELETE("/:id", handler.DeleteAPIToken)
+	}
+
+	// Account deletion needs the password (or a recent login), a confirmation step and a login session
+	account := api.Group("/account", middleware.RequireSession())
+	{
+		account.POST("/deletion", handler.RequestAccountDeletion) // Body: {"password": "..."}
+		account.DELETE("", handler.DeleteAccount)                 // Body: {"confirmationToken": "..."}
+	}
+
+	// Reads (access token scope: read)
+	read := api.Group("", middleware.RequireScope(auth.ScopeRead))
+	{
+		read.GET("/spaces", handler.GetSpaces) // List options: ?limit=&cursor=&sort=&namePrefix=&updatedSince=
+		read.GET("/spaces/:id", handler.GetSpace)
+		read.GET("/vaults", handler.GetVaults) // Query: ?spaceId=xxx plus list options
+		read.GET("/vaults/:id", handler.GetVault)
+		read.GET("/logs", handler.GetLogs) // Query: ?spaceId=xxx or ?vaultId=xxx, &language=&omitCode=true plus list options
+		read.GET("/logs/:id", handler.GetLog)
+		read.GET("/tree", handler.GetTree) // Query: ?spaceId=xxx
+		read.GET("/account/export", handler.ExportAccount)
+
+		// Live changes to a space over a WebSocket
+		read.GET("/spaces/:id/events", middleware.WebSocketOriginMiddleware(corsConfig), handler.StreamSpaceEvents)
+
+		// Collaborative editing of a log over a WebSocket; viewers can follow along
+		read.GET("/logs/:id/collab", middleware.WebSocketOriginMiddleware(corsConfig), handler.CollaborateOnLog)
+
+		// Sharing
+		read.GET("/spaces/:id/members", handler.GetSpaceMembers)
+		read.GET("/spaces/:id/invitations", handler.GetSpaceInvitations)
+		read.POST("/invitations/lookup", handler.GetMyInvitations) // Body: {"token": "..."}
+		read.GET("/spaces/:id/secrets", handler.GetSecrets)
+		read.GET("/shares", handler.GetShareLinks) // Query: ?spaceId=xxx
+		read.GET("/quotas", handler.GetQuotas)     // Query: ?spaceId=xxx
+
+		// Webhooks (space owners only)
+		read.GET("/spaces/:id/webhooks", handler.GetWebhooks)
+		read.GET("/spaces/:id/webhooks/:webhookId/deliveries", handler.GetWebhookDeliveries) // Query: ?status=&limit=&before=
+
+		// Audit log (space owners only)
+		read.GET("/audit", handler.GetAuditLog) // Query: ?spaceId=xxx&actor=&action=&targetId=&from=&to=&limit=&before=
+	}
+
+	// Writes (access token scope: write)
+	write := api.Group("", middleware.RequireScope(auth.ScopeWrite))
+	{
+		// Spaces
+		write.POST("/spaces", handler.CreateSpace)
+		write.PUT("/spaces/:id", handler.UpdateSpace)
+		write.DELETE("/spaces/:id", handler.DeleteSpace)
+
+		// Vaults
+		write.POST("/vaults", handler.CreateVault)
+		write.PUT("/vaults/:id", handler.UpdateVault)
+		write.DELETE("/vaults/:id", handler.DeleteVault)
+
+		// Logs
+		write.POST("/logs", handler.CreateLog)
+		write.PUT("/logs/:id", handler.UpdateLog)
+		write.PATCH("/logs/:id", handler.PatchLog) // JSON object with edits, or JSON Patch (application/json-patch+json)
+		write.DELETE("/logs/:id", handler.DeleteLog)
+
+		// Ordered vault and log operations, atomic on a replica set
+		write.POST("/batch", handler.ExecuteBatch)
+
+		// Sharing
+		write.PUT("/spaces/:id/members/:userId", handler.UpdateSpaceMember)
+		write.DELETE("/spaces/:id/members/:userId", handler.RemoveSpaceMember)
+		write.POST("/spaces/:id/invitations", handler.CreateSpaceInvitation)
+		write.DELETE("/spaces/:id/invitations/:invitationId", handler.DeleteSpaceInvitation)
+		write.POST("/invitations/:id/accept", handler.AcceptInvitation)
+		write.POST("/invitations/:id/decline", handler.DeclineInvitation)
+
+		// Secrets
+		write.POST("/spaces/:id/secrets", handler.CreateSecret)
+		write.PUT("/spaces/:id/secrets/:name", handler.UpdateSecret)
+		write.DELETE("/spaces/:id/secrets/:name", handler.DeleteSecret)
+		write.POST("/shares", handler.CreateShareLink)
+		write.DELETE("/shares/:id", handler.DeleteShareLink)
+
+		// Webhooks
+		write.POST("/spaces/:id/webhooks", handler.CreateWebhook)
+		write.PUT("/spaces/:id/webhooks/:webhookId", handler.UpdateWebhook)
+		write.DELETE("/spaces/:id/webhooks/:webhookId", handler.DeleteWebhook)
+		write.POST("/spaces/:id/webhooks/:webhookId/ping", handler.PingWebhook)
+		write.POST("/spaces/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", handler.RedeliverWebhookDelivery)
+	}
+
+	// Run code (access token scope: run)
+	run := api.Group("", middleware.RequireScope(auth.ScopeRun))
+	{
+		run.POST("/run", handler.RunCode)
+	}
+
+	// AI (access token scope: ai), generation rate limited per user
+	ai := api.Group("/ai", middleware.RequireScope(auth.ScopeAI))
+	{
+		aiLimit := middleware.RateLimitMiddleware("ai", ratelimit.FromEnv("AI_USER_RATE_LIMIT", 20))
+		ai.POST("/generate", aiLimit, handler.GenerateCode)
+		ai.GET("/usage", handler.GetAIUsage)   // Query: ?groupBy=user|space|day&from=YYYY-MM-DD&to=YYYY-MM-DD&spaceId=xxx
+		ai.GET("/budget", handler.GetAIBudget) // Query: ?spaceId=xxx
+
+		// Chat sessions
+		ai.GET("/sessions", handler.GetChatSessions) // Query: ?spaceId=xxx or ?logId=xxx
+		ai.GET("/sessions/:id", handler.GetChatSession)
+		ai.POST("/sessions", handler.CreateChatSession)
+		ai.POST("/sessions/:id/messages", aiLimit, handler.PostChatMessage)
+		ai.DELETE("/sessions/:id", handler.DeleteChatSession)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"codeflow-backend/internal/handler"
+	"codeflow-backend/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.CheckOpenAPIRoutes(r.Routes()); err != nil {
+		t.Errorf("the OpenAPI document is out of date: %v", err)
+	}
+}
+
+func TestInvalidTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "not-an-address")
+	if _, err := New(); err == nil {
+		t.Error("New accepted an invalid TRUSTED_PROXIES")
+	}
+}
+
+func TestPasswordRoutesAreRateLimitedPerIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("AUTH_RATE_LIMIT", "2")
+	ratelimit.Default = ratelimit.NewMemoryStore()
+	r, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid bodies are refused before the database is needed, but still count
+	statuses := []int{}
+	for _, path := range []string{"/api/auth/login", "/api/auth/register", "/api/auth/login"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		statuses = append(statuses, w.Code)
+	}
+	if statuses[0] != http.StatusBadRequest || statuses[1] != http.StatusBadRequest || statuses[2] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want 400, 400, 429", statuses)
+	}
+}
@@ -0,0 +1,244 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"codeflow-backend/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it fails
+	MaxAttempts = 8
+
+	retryBaseDelay = 10 * time.Second // Before the second attempt; doubled after each failure
+	retryMaxDelay  = time.Hour
+
+	pollInterval   = 5 * time.Second
+	requestTimeout = 10 * time.Second
+	leaseDuration  = time.Minute // Longer than a request, so only one instance sends a delivery at a time
+	maxConcurrent  = 8           // Requests in flight per instance
+
+	maxResponseBodyBytes = 1024 // Kept from each response for the delivery log
+)
+
+// httpClient sends deliveries. It doesn't follow redirects, so a 3xx is a
+// failure, and it refuses to connect to private addresses unless they're allowed.
+var httpClient = &http.Client{
+	Timeout: requestTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: guardDial}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// retryDelay is how long to wait after the given number of failed attempts
+func retryDelay(failures int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < failures && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// work sends due deliveries whenever woken, and every poll interval for retries
+// and deliveries queued by other instances
+func work() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, maxConcurrent)
+	for {
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+
+		for {
+			slots <- struct{}{}
+			delivery, ok := claim()
+			if !ok {
+				<-slots
+				break
+			}
+			go func() {
+				defer func() { <-slots }()
+				attempt(delivery)
+			}()
+		}
+	}
+}
+
+// claim leases the most overdue delivery to this instance
+func claim() (models.WebhookDelivery, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"status":        models.DeliveryPending,
+		"nextAttemptAt": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"leaseUntil": bson.M{"$exists": false}},
+			bson.M{"leaseUntil": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"leaseUntil": now.Add(leaseDuration)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After)
+
+	var delivery models.WebhookDelivery
+	err := database.Collection("webhook_deliveries").FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Failed to claim webhook delivery: %v", err)
+		}
+		return delivery, false
+	}
+	return delivery, true
+}
+
+// attempt sends a claimed delivery once and records the outcome
+func attempt(delivery models.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	var webhook models.Webhook
+	err := database.Collection("webhooks").FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&webhook)
+	cancel()
+
+	var result models.WebhookAttempt
+	retry := true
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		result = models.WebhookAttempt{At: time.Now(), Error: "Webhook was deleted"}
+		retry = false
+	case err != nil:
+		result = models.WebhookAttempt{At: time.Now(), Error: "Failed to load webhook"}
+	case !webhook.Active:
+		result = models.WebhookAttempt{At: time.Now(), Error: "Webhook is disabled"}
+		retry = false
+	default:
+		result = send(webhook, delivery)
+	}
+
+	record(delivery, result, retry)
+}
+
+// send posts the delivery's payload to the webhook
+func send(webhook models.Webhook, delivery models.WebhookDelivery) (result models.WebhookAttempt) {
+	result.At = time.Now()
+	defer func() { result.DurationMs = time.Since(result.At).Milliseconds() }()
+
+	secret, err := openSecret(webhook)
+	if err != nil {
+		result.Error = "Failed to decrypt the webhook secret: " + err.Error()
+		return result
+	}
+
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	timestamp := result.At.Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "CodeFlow-Webhooks/1")
+	request.Header.Set(EventHeader, delivery.EventType)
+	request.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(secret, timestamp, delivery.Payload))
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxResponseBodyBytes))
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024)) // Let the connection be reused
+	result.StatusCode = response.StatusCode
+	result.ResponseBody = strings.ToValidUTF8(string(body), "")
+	if !succeeded(result) {
+		result.Error = "Receiver answered " + response.Status
+	}
+	return result
+}
+
+// succeeded reports whether the receiver accepted the delivery
+func succeeded(result models.WebhookAttempt) bool {
+	return result.StatusCode >= 200 && result.StatusCode < 300
+}
+
+// record adds the attempt to the delivery, schedules the next one or settles
+// the delivery's status, and releases the lease
+func record(delivery models.WebhookDelivery, result models.WebhookAttempt, retry bool) {
+	attempts := len(delivery.Attempts) + 1
+	set := bson.M{"updatedAt": time.Now()}
+	unset := bson.M{"leaseUntil": ""}
+	if result.StatusCode != 0 {
+		set["responseCode"] = result.StatusCode
+	}
+
+	switch {
+	case succeeded(result):
+		set["status"] = models.DeliverySucceeded
+		unset["nextAttemptAt"] = ""
+	case !retry || attempts >= MaxAttempts:
+		set["status"] = models.DeliveryFailed
+		unset["nextAttemptAt"] = ""
+	default:
+		set["nextAttemptAt"] = time.Now().Add(retryDelay(attempts))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": set, "$unset": unset, "$push": bson.M{"attempts": result}}
+	if _, err := database.Collection("webhook_deliveries").UpdateByID(ctx, delivery.ID, update); err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// guardDial refuses connections to private addresses unless they're allowed.
+// It runs after name resolution, so a hostname can't resolve its way past it.
+func guardDial(network, address string, _ syscall.RawConn) error {
+	if allowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+		return fmt.Errorf("webhooks can't connect to private network address %s", host)
+	}
+	return nil
+}
+
+// blockedIP reports whether ip is loopback, private, link-local or otherwise not public
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, 100.64.0.0/10
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
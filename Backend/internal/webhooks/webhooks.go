@@ -0,0 +1,283 @@
+// Package webhooks posts space events to the URLs subscribed to them. Events
+// published by this instance are queued as deliveries in MongoDB, and a worker
+// on every instance sends due deliveries, retrying failures with exponential
+// backoff. Each request is signed with the webhook's secret:
+//
+//	X-CodeFlow-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// where timestamp is the X-CodeFlow-Timestamp header, in Unix seconds.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"codeflow-backend/internal/events"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/secrets"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Request headers
+const (
+	EventHeader     = "X-CodeFlow-Event"
+	DeliveryHeader  = "X-CodeFlow-Delivery"
+	TimestampHeader = "X-CodeFlow-Timestamp"
+	SignatureHeader = "X-CodeFlow-Signature"
+)
+
+// Ping is the event type of test deliveries, sent on request rather than subscribed to
+const Ping = "ping"
+
+// AllEvents subscribes a webhook to every event type
+const AllEvents = "*"
+
+// EventTypes are the event types a webhook can subscribe to. A space's webhooks
+// are deleted with it, so there is no space.deleted.
+var EventTypes = []string{
+	events.SpaceUpdated,
+	events.VaultCreated, events.VaultUpdated, events.VaultMoved, events.VaultDeleted,
+	events.LogCreated, events.LogUpdated, events.LogMoved, events.LogDeleted,
+	events.RunCompleted,
+}
+
+// maxURLLength bounds webhook URLs
+const maxURLLength = 2048
+
+// queueSize is how many events may wait to be matched with webhooks before new ones are dropped
+const queueSize = 1024
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID        string             `json:"id"` // The event's ID, the same for every webhook and redelivery
+	Type      string             `json:"type"`
+	SpaceID   primitive.ObjectID `json:"spaceId"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Data      events.Event       `json:"data"`
+}
+
+var (
+	database             *mongo.Database
+	queue                = make(chan events.Event, queueSize)
+	wake                 = make(chan struct{}, 1)
+	allowPrivateNetworks bool
+)
+
+// Start queues deliveries for the events this instance publishes and sends due
+// deliveries in the background. WEBHOOK_ALLOW_PRIVATE_NETWORKS=true lets
+// webhooks reach loopback and private addresses, e.g. a receiver on localhost.
+func Start(db *mongo.Database) {
+	configure(db)
+	if allowPrivateNetworks {
+		log.Println("Webhooks may post to private network addresses")
+	}
+
+	events.Listen(func(event events.Event) {
+		select {
+		case queue <- event:
+		default:
+			log.Printf("Webhook queue full, dropped %s event for space %s", event.Type, event.SpaceID.Hex())
+		}
+	})
+
+	go dispatch()
+	go work()
+}
+
+// configure sets the database deliveries are kept in and reads the environment
+func configure(db *mongo.Database) {
+	database = db
+	allowPrivateNetworks = os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+}
+
+// Wake makes the worker look for due deliveries now rather than at its next poll
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// ValidEventType reports whether a webhook can subscribe to eventType
+func ValidEventType(eventType string) bool {
+	if eventType == AllEvents {
+		return true
+	}
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL checks that a webhook URL is absolute http(s) and, unless private
+// networks are allowed, doesn't name a local address. Hostnames are checked
+// again when each request connects.
+func ValidateURL(raw string) error {
+	if len(raw) > maxURLLength {
+		return errors.New("url is too long")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if allowPrivateNetworks {
+		return nil
+	}
+
+	host := parsed.Hostname()
+	if host == "localhost" {
+		return errors.New("url must not point to a private network address")
+	}
+	if ip := net.ParseIP(host); ip != nil && blockedIP(ip) {
+		return errors.New("url must not point to a private network address")
+	}
+	return nil
+}
+
+// SealSecret encrypts a webhook's signing secret
+func SealSecret(webhookID primitive.ObjectID, secret string) (ciphertext, nonce []byte, err error) {
+	return secrets.Seal(secret, secretAssociatedData(webhookID))
+}
+
+// openSecret decrypts a webhook's signing secret
+func openSecret(webhook models.Webhook) (string, error) {
+	return secrets.Open(webhook.SecretCiphertext, webhook.SecretNonce, secretAssociatedData(webhook.ID))
+}
+
+// secretAssociatedData ties a ciphertext to its webhook
+func secretAssociatedData(webhookID primitive.ObjectID) string {
+	return "webhook/" + webhookID.Hex()
+}
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewDelivery builds a pending delivery of event to a webhook
+func NewDelivery(webhook models.Webhook, eventID string, event events.Event) (models.WebhookDelivery, error) {
+	body, err := json.Marshal(Payload{
+		ID:        eventID,
+		Type:      event.Type,
+		SpaceID:   event.SpaceID,
+		CreatedAt: event.At,
+		Data:      event,
+	})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	now := time.Now()
+	return models.WebhookDelivery{
+		ID:            primitive.NewObjectID(),
+		WebhookID:     webhook.ID,
+		SpaceID:       webhook.SpaceID,
+		EventID:       eventID,
+		EventType:     event.Type,
+		Payload:       body,
+		Status:        models.DeliveryPending,
+		Attempts:      []models.WebhookAttempt{},
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+// NewPing builds a ping delivery to a webhook
+func NewPing(webhook models.Webhook) (models.WebhookDelivery, error) {
+	event := events.Event{Type: Ping, SpaceID: webhook.SpaceID, ID: webhook.ID, At: time.Now()}
+	return NewDelivery(webhook, primitive.NewObjectID().Hex(), event)
+}
+
+// Redelivery builds a pending copy of a delivery with the same payload
+func Redelivery(original models.WebhookDelivery) models.WebhookDelivery {
+	now := time.Now()
+	return models.WebhookDelivery{
+		ID:            primitive.NewObjectID(),
+		WebhookID:     original.WebhookID,
+		SpaceID:       original.SpaceID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        models.DeliveryPending,
+		Attempts:      []models.WebhookAttempt{},
+		NextAttemptAt: &now,
+		RedeliveryOf:  &original.ID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// dispatch queues a delivery of each event to every active webhook subscribed to it
+func dispatch() {
+	for event := range queue {
+		if event.Type == events.SpaceDeleted {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		queued, err := enqueue(ctx, event)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to queue webhook deliveries for %s event in space %s: %v", event.Type, event.SpaceID.Hex(), err)
+			continue
+		}
+		if queued {
+			Wake()
+		}
+	}
+}
+
+// enqueue inserts the event's deliveries and reports whether there were any
+func enqueue(ctx context.Context, event events.Event) (bool, error) {
+	filter := bson.M{
+		"spaceId": event.SpaceID,
+		"active":  true,
+		"events":  bson.M{"$in": []string{event.Type, AllEvents}},
+	}
+	cursor, err := database.Collection("webhooks").Find(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	var webhooks []models.Webhook
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return false, err
+	}
+	if len(webhooks) == 0 {
+		return false, nil
+	}
+
+	eventID := primitive.NewObjectID().Hex()
+	deliveries := make([]interface{}, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		delivery, err := NewDelivery(webhook, eventID, event)
+		if err != nil {
+			return false, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if _, err := database.Collection("webhook_deliveries").InsertMany(ctx, deliveries); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,335 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeflow-backend/internal/db"
+	"codeflow-backend/internal/db/dbtest"
+	"codeflow-backend/internal/models"
+	"codeflow-backend/internal/secrets"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testSecret = "whsec_test"
+
+func TestMain(m *testing.M) {
+	os.Setenv("SECRETS_MASTER_KEY", strings.Repeat("cd", 32))
+	secrets.Init()
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// setup points the package at an empty database with private networks
+// allowed, so deliveries can reach a receiver on localhost
+func setup(t *testing.T) {
+	t.Helper()
+	dbtest.Setup(t)
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	configure(db.Database)
+	t.Cleanup(func() { allowPrivateNetworks = false })
+}
+
+// receivedRequest is what a receiver saw of one delivery
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver answers every request with status and body and keeps what it got
+func receiver(t *testing.T, status int, body string) (*httptest.Server, func() []receivedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []receivedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, receivedRequest{header: r.Header.Clone(), body: data})
+		mu.Unlock()
+		if status >= 300 && status < 400 {
+			w.Header().Set("Location", "/elsewhere")
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedRequest(nil), requests...)
+	}
+}
+
+// createWebhook stores an active webhook posting to url
+func createWebhook(t *testing.T, url string) models.Webhook {
+	t.Helper()
+	webhook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		SpaceID:   primitive.NewObjectID(),
+		URL:       url,
+		Events:    []string{AllEvents},
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	var err error
+	if webhook.SecretCiphertext, webhook.SecretNonce, err = SealSecret(webhook.ID, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	insert(t, "webhooks", webhook)
+	return webhook
+}
+
+func insert(t *testing.T, collection string, doc interface{}) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := db.Database.Collection(collection).InsertOne(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queuePing stores a due ping delivery with the given earlier attempts
+func queuePing(t *testing.T, webhook models.Webhook, earlierAttempts int) models.WebhookDelivery {
+	t.Helper()
+	delivery, err := NewPing(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < earlierAttempts; i++ {
+		delivery.Attempts = append(delivery.Attempts, models.WebhookAttempt{At: time.Now(), StatusCode: 500})
+	}
+	insert(t, "webhook_deliveries", delivery)
+	return delivery
+}
+
+// sendDue claims and attempts the next due delivery, as the worker does, and returns it afterwards
+func sendDue(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+	claimed, ok := claim()
+	if !ok {
+		t.Fatal("no delivery was due")
+	}
+	attempt(claimed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var delivery models.WebhookDelivery
+	if err := db.Database.Collection("webhook_deliveries").FindOne(ctx, bson.M{"_id": claimed.ID}).Decode(&delivery); err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{7, 640 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour}, // 5120s is over the cap
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.failures); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverySignedAndRecorded(t *testing.T) {
+	setup(t)
+	srv, requests := receiver(t, http.StatusOK, strings.Repeat("x", 2*maxResponseBodyBytes))
+	webhook := createWebhook(t, srv.URL)
+	queued := queuePing(t, webhook, 0)
+
+	delivery := sendDue(t)
+	if delivery.Status != models.DeliverySucceeded || delivery.ResponseCode != 200 || delivery.NextAttemptAt != nil || delivery.LeaseUntil != nil {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if len(delivery.Attempts) != 1 || len(delivery.Attempts[0].ResponseBody) != maxResponseBodyBytes || delivery.Attempts[0].Error != "" {
+		t.Errorf("attempts = %+v", delivery.Attempts)
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("receiver got %d requests", len(got))
+	}
+	header, body := got[0].header, got[0].body
+	if string(body) != string(queued.Payload) {
+		t.Errorf("body = %s, want the payload %s", body, queued.Payload)
+	}
+	if header.Get(EventHeader) != Ping || header.Get(DeliveryHeader) != queued.ID.Hex() {
+		t.Errorf("headers = %v", header)
+	}
+
+	// What a receiver does to check a delivery came from us
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Errorf("timestamp = %q", header.Get(TimestampHeader))
+	}
+	if signature := header.Get(SignatureHeader); signature != Sign(testSecret, timestamp, body) {
+		t.Errorf("signature %s doesn't verify", signature)
+	}
+	if Sign(testSecret, timestamp+1, body) == Sign(testSecret, timestamp, body) || Sign("other", timestamp, body) == Sign(testSecret, timestamp, body) {
+		t.Error("the signature doesn't cover the timestamp and secret")
+	}
+}
+
+func TestFailedAttemptsAreRetried(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{"server error", http.StatusServiceUnavailable, "Receiver answered 503 Service Unavailable"},
+		{"client error", http.StatusGone, "Receiver answered 410 Gone"},
+		{"redirect isn't followed", http.StatusFound, "Receiver answered 302 Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			srv, requests := receiver(t, tt.status, "nope")
+			queuePing(t, createWebhook(t, srv.URL), 2)
+
+			before := time.Now()
+			delivery := sendDue(t)
+			if delivery.Status != models.DeliveryPending || delivery.ResponseCode != tt.status || len(delivery.Attempts) != 3 {
+				t.Fatalf("delivery = %+v", delivery)
+			}
+			if last := delivery.Attempts[2]; last.Error != tt.want || last.StatusCode != tt.status || last.ResponseBody != "nope" {
+				t.Errorf("attempt = %+v", last)
+			}
+			// Third attempt failed, so the next is 40s away
+			if next := delivery.NextAttemptAt; next == nil || next.Sub(before) < 40*time.Second || next.Sub(before) > 45*time.Second {
+				t.Errorf("next attempt at %v, want 40s after %v", next, before)
+			}
+			if _, ok := claim(); ok {
+				t.Error("the delivery was due again straight away")
+			}
+			if len(requests()) != 1 {
+				t.Errorf("receiver got %d requests", len(requests()))
+			}
+		})
+	}
+}
+
+func TestDeliveryFailsAfterMaxAttempts(t *testing.T) {
+	setup(t)
+	srv, _ := receiver(t, http.StatusInternalServerError, "")
+	queuePing(t, createWebhook(t, srv.URL), MaxAttempts-1)
+
+	delivery := sendDue(t)
+	if delivery.Status != models.DeliveryFailed || len(delivery.Attempts) != MaxAttempts || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery = %+v", delivery)
+	}
+}
+
+func TestDeliveryToGoneWebhookFails(t *testing.T) {
+	setup(t)
+	srv, requests := receiver(t, http.StatusOK, "")
+
+	disabled := createWebhook(t, srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := db.Database.Collection("webhooks").UpdateByID(ctx, disabled.ID, bson.M{"$set": bson.M{"active": false}}); err != nil {
+		t.Fatal(err)
+	}
+	queuePing(t, disabled, 0)
+	if delivery := sendDue(t); delivery.Status != models.DeliveryFailed || delivery.Attempts[0].Error != "Webhook is disabled" {
+		t.Errorf("delivery to a disabled webhook = %+v", delivery)
+	}
+
+	deleted := createWebhook(t, srv.URL)
+	queuePing(t, deleted, 0)
+	if _, err := db.Database.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": deleted.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if delivery := sendDue(t); delivery.Status != models.DeliveryFailed || delivery.Attempts[0].Error != "Webhook was deleted" {
+		t.Errorf("delivery to a deleted webhook = %+v", delivery)
+	}
+
+	if len(requests()) != 0 {
+		t.Errorf("receiver got %d requests", len(requests()))
+	}
+}
+
+func TestRedeliveryCopiesPayload(t *testing.T) {
+	webhook := models.Webhook{ID: primitive.NewObjectID(), SpaceID: primitive.NewObjectID()}
+	original, err := NewPing(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original.Status = models.DeliveryFailed
+	original.Attempts = []models.WebhookAttempt{{StatusCode: 500}}
+	original.ResponseCode = 500
+
+	copied := Redelivery(original)
+	if copied.ID == original.ID || *copied.RedeliveryOf != original.ID {
+		t.Errorf("redelivery ID %s, of %v", copied.ID.Hex(), copied.RedeliveryOf)
+	}
+	if string(copied.Payload) != string(original.Payload) || copied.EventID != original.EventID || copied.EventType != Ping ||
+		copied.WebhookID != webhook.ID || copied.SpaceID != webhook.SpaceID {
+		t.Errorf("redelivery = %+v, want the original's event and payload", copied)
+	}
+	if copied.Status != models.DeliveryPending || len(copied.Attempts) != 0 || copied.ResponseCode != 0 || copied.NextAttemptAt == nil {
+		t.Errorf("redelivery isn't a fresh pending delivery: %+v", copied)
+	}
+}
+
+func TestPrivateNetworksRefused(t *testing.T) {
+	setup(t)
+	srv, requests := receiver(t, http.StatusOK, "")
+	queuePing(t, createWebhook(t, srv.URL), 0)
+	allowPrivateNetworks = false
+
+	delivery := sendDue(t)
+	if delivery.Status != models.DeliveryPending || !strings.Contains(delivery.Attempts[0].Error, "can't connect to private network address 127.0.0.1") {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if len(requests()) != 0 {
+		t.Error("the request reached a loopback receiver")
+	}
+
+	for address, blocked := range map[string]bool{
+		"127.0.0.1:80": true, "[::1]:80": true, "10.1.2.3:443": true, "192.168.0.1:80": true, "169.254.169.254:80": true,
+		"100.64.0.1:80": true, "0.0.0.0:80": true, "93.184.216.34:443": false, "[2606:4700::1111]:443": false,
+	} {
+		if err := guardDial("tcp", address, nil); (err != nil) != blocked {
+			t.Errorf("guardDial(%s) = %v, want blocked %v", address, err, blocked)
+		}
+	}
+
+	for raw, ok := range map[string]bool{
+		"https://example.com/hook": true, "http://localhost:8080/": false, "http://127.0.0.1/": false, "http://[::1]/": false,
+		"ftp://example.com/": false, "/relative": false, "https://" + strings.Repeat("a", maxURLLength) + ".com": false,
+	} {
+		if err := ValidateURL(raw); (err == nil) != ok {
+			t.Errorf("ValidateURL(%.40s) = %v", raw, err)
+		}
+	}
+
+	allowPrivateNetworks = true
+	if err := ValidateURL("http://localhost:8080/"); err != nil {
+		t.Errorf("with private networks allowed, ValidateURL(localhost) = %v", err)
+	}
+	if err := guardDial("tcp", net.JoinHostPort("127.0.0.1", "80"), nil); err != nil {
+		t.Errorf("with private networks allowed, guardDial(127.0.0.1) = %v", err)
+	}
+}
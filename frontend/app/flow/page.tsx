@@ -1,14 +1,15 @@
 "use client";
 
-import { useState, useEffect, useCallback } from "react";
+import { useState, useEffect, useCallback, useRef } from "react";
 import ExplorerSidebar from "@/components/ExplorerSidebar";
 import EditorHeader from "@/components/EditorHeader";
 import OutputPanel from "@/components/OutputPanel";
 import CommandPalette, { type Command } from "@/components/CommandPalette";
-import Editor from "@monaco-editor/react";
-import { getSpaces, getTree, getLog, updateLog, deleteLog, runCode, generateCode, ApiError } from "@/lib/api";
+import Editor, { type OnMount } from "@monaco-editor/react";
+import { getSpaces, getTree, getLog, patchLogCode, diffCode, deleteLog, runCode, generateCode, subscribeToSpace, openCollabSession, ApiError } from "@/lib/api";
+import { fromChanges, toChanges, type CollabSession, type CollabPeer } from "@/lib/collab";
 import { getLanguageFromExtension } from "@/lib/languages";
-import type { Space, TreeNode, Log, RunResult } from "@/lib/types";
+import type { Space, TreeNode, Log, RunResult, SpaceEvent } from "@/lib/types";
 import { useToast } from "@/components/Toast";
 
 export default function FlowPage() {
@@ -24,6 +25,13 @@ export default function FlowPage() {
   const [commandPaletteOpen, setCommandPaletteOpen] = useState(false);
   const [generatePrompt, setGeneratePrompt] = useState("");
   const [showGenerateInput, setShowGenerateInput] = useState(false);
+  const [remoteLogEvent, setRemoteLogEvent] = useState<SpaceEvent | null>(null);
+  const [editor, setEditor] = useState<Parameters<OnMount>[0] | null>(null);
+  const [collabConnected, setCollabConnected] = useState(false);
+  const [peers, setPeers] = useState<CollabPeer[]>([]);
+  const [canEdit, setCanEdit] = useState(true);
+  const collabRef = useRef<CollabSession | null>(null);
+  const saveRequestedRef = useRef(false);
 
   const { showToast } = useToast();
 
@@ -41,6 +49,186 @@ export default function FlowPage() {
     }
   }, [selectedSpace]);
 
+  // Keep the tree in sync with changes made in other sessions
+  useEffect(() => {
+    if (!selectedSpace) return;
+    const spaceId = selectedSpace.id;
+
+    let reloadTimer: ReturnType<typeof setTimeout> | undefined;
+    const reloadTree = () => {
+      clearTimeout(reloadTimer);
+      reloadTimer = setTimeout(() => loadTree(spaceId), 200);
+    };
+
+    const unsubscribe = subscribeToSpace(
+      spaceId,
+      (event) => {
+        if (event.type === "space.deleted") {
+          showToast("This space was deleted", "info");
+          setSelectedSpace(null);
+          loadSpaces();
+          return;
+        }
+        if (event.type === "space.updated" && event.space) {
+          const { name, version } = event.space;
+          setSpaces((prev) => prev.map((space) => (space.id === event.id ? { ...space, name, version } : space)));
+          setSelectedSpace((prev) => (prev && prev.id === event.id ? { ...prev, name, version } : prev));
+          return;
+        }
+        if (event.type === "run.completed") {
+          return;
+        }
+        reloadTree();
+        if (event.type.startsWith("log.")) {
+          setRemoteLogEvent(event);
+        }
+      },
+      reloadTree
+    );
+
+    return () => {
+      clearTimeout(reloadTimer);
+      unsubscribe();
+    };
+  }, [selectedSpace?.id]);
+
+  // Follow changes to the open log, unless that would throw away unsaved edits
+  useEffect(() => {
+    const event = remoteLogEvent;
+    if (!event || !selectedLog || event.id !== selectedLog.id || isSaving) return;
+
+    // The shared document merges outside changes itself
+    if (collabConnected && event.type !== "log.deleted") return;
+
+    if (event.type === "log.deleted") {
+      showToast("This file was deleted in another session", "info");
+      setSelectedLog(null);
+      setCode("");
+      setOutput(null);
+      return;
+    }
+
+    // Our own saves come back as events too
+    if ((event.version ?? 0) <= selectedLog.version) return;
+
+    if (code !== selectedLog.code) {
+      showToast("This file was changed elsewhere. Copy your edits and reopen it to get the latest version.", "info");
+      return;
+    }
+
+    getLog(event.id)
+      .then((log) => {
+        setSelectedLog(log);
+        setCode(log.code);
+      })
+      .catch((error) => console.error("Failed to reload log:", error));
+  }, [remoteLogEvent]);
+
+  // Edit the open log together with everyone else who has it open
+  useEffect(() => {
+    const logId = selectedLog?.id;
+    const model = editor?.getModel();
+    if (!logId || !editor || !model) return;
+
+    // Edits applied from the session mustn't be sent back to it
+    let applyingRemote = false;
+    const applyRemote = (apply: () => void) => {
+      applyingRemote = true;
+      try {
+        apply();
+      } finally {
+        applyingRemote = false;
+      }
+    };
+
+    const session = openCollabSession(logId, {
+      onReset: (text) => {
+        if (model.getValue() !== text) applyRemote(() => model.setValue(text));
+      },
+      onRemoteOp: (op) => {
+        const edits = toChanges(op).map((change) => {
+          const start = model.getPositionAt(change.rangeOffset);
+          const end = model.getPositionAt(change.rangeOffset + change.rangeLength);
+          return {
+            range: { startLineNumber: start.lineNumber, startColumn: start.column, endLineNumber: end.lineNumber, endColumn: end.column },
+            text: change.text,
+          };
+        });
+        applyRemote(() => model.applyEdits(edits));
+      },
+      onPeers: (others, self) => {
+        setPeers(others);
+        setCanEdit(self?.canEdit ?? true);
+      },
+      onSaved: (version) => {
+        setSelectedLog((prev) => (prev && prev.id === logId ? { ...prev, version } : prev));
+        if (saveRequestedRef.current) {
+          saveRequestedRef.current = false;
+          showToast("Saved successfully", "success");
+          setTimeout(() => setIsSaving(false), 500);
+        }
+      },
+      onError: (message, code) => {
+        if (code === "NOT_FOUND") {
+          // The log was deleted; the space feed closes the editor
+          return;
+        }
+        showToast(message, "error");
+        saveRequestedRef.current = false;
+        setIsSaving(false);
+      },
+      onStatus: setCollabConnected,
+    });
+    collabRef.current = session;
+
+    const contentListener = editor.onDidChangeModelContent((event) => {
+      if (!applyingRemote) session.applyLocal(fromChanges(event.changes, model.getValueLength()));
+    });
+    const cursorListener = editor.onDidChangeCursorSelection((event) => {
+      session.moveCursor({
+        position: model.getOffsetAt(event.selection.getPosition()),
+        selectionEnd: model.getOffsetAt(event.selection.getSelectionStart()),
+      });
+    });
+
+    return () => {
+      contentListener.dispose();
+      cursorListener.dispose();
+      session.close();
+      collabRef.current = null;
+      setCollabConnected(false);
+      setPeers([]);
+      setCanEdit(true);
+    };
+  }, [selectedLog?.id, editor]);
+
+  // Show where everyone else is in the open log
+  useEffect(() => {
+    const model = editor?.getModel();
+    if (!editor || !model) return;
+
+    const decorations = editor.createDecorationsCollection(
+      peers.flatMap((peer) => {
+        if (!peer.cursor) return [];
+        const color = peerColor(peer.clientId);
+        const caret = model.getPositionAt(peer.cursor.position);
+        const anchor = model.getPositionAt(peer.cursor.selectionEnd);
+        const [from, to] = peer.cursor.selectionEnd < peer.cursor.position ? [anchor, caret] : [caret, anchor];
+        return [
+          {
+            range: { startLineNumber: from.lineNumber, startColumn: from.column, endLineNumber: to.lineNumber, endColumn: to.column },
+            options: { className: `collab-selection-${color}` },
+          },
+          {
+            range: { startLineNumber: caret.lineNumber, startColumn: caret.column, endLineNumber: caret.lineNumber, endColumn: caret.column },
+            options: { afterContentClassName: `collab-cursor-${color}`, hoverMessage: { value: peer.name } },
+          },
+        ];
+      })
+    );
+    return () => decorations.clear();
+  }, [peers, editor]);
+
   const loadSpaces = async () => {
     try {
       const data = await getSpaces();
@@ -79,17 +267,32 @@ export default function FlowPage() {
   const handleSave = useCallback(async () => {
     if (!selectedLog) return;
 
+    // The shared document saves itself every few seconds; this writes it now
+    if (collabRef.current && collabConnected) {
+      setIsSaving(true);
+      saveRequestedRef.current = true;
+      collabRef.current.save();
+      return;
+    }
+
     setIsSaving(true);
     try {
-      await updateLog(selectedLog.id, { code });
+      const edit = diffCode(selectedLog.code, code);
+      if (edit) {
+        setSelectedLog(await patchLogCode(selectedLog.id, [edit], selectedLog.version));
+      }
       showToast("Saved successfully", "success");
       setTimeout(() => setIsSaving(false), 500);
     } catch (error) {
       const err = error as ApiError;
-      showToast(err.message || "Failed to save", "error");
+      if (err.code === "PRECONDITION_FAILED") {
+        showToast("This file was changed elsewhere. Copy your edits and reopen it to get the latest version.", "error");
+      } else {
+        showToast(err.message || "Failed to save", "error");
+      }
       setIsSaving(false);
     }
-  }, [selectedLog, code, showToast]);
+  }, [selectedLog, code, collabConnected, showToast]);
 
   const handleRun = async () => {
     if (!selectedLog) return;
@@ -354,8 +557,13 @@ export default function FlowPage() {
                 language={language}
                 value={code}
                 onChange={(value) => setCode(value || "")}
+                onMount={(mounted) => {
+                  setEditor(mounted);
+                  mounted.onDidDispose(() => setEditor(null));
+                }}
                 theme="vs"
                 options={{
+                  readOnly: !canEdit,
                   minimap: { enabled: false },
                   fontSize: 14,
                   fontFamily: "'Fira Code', 'Consolas', 'Monaco', monospace",
@@ -366,6 +574,24 @@ export default function FlowPage() {
                 }}
               />
 
+              {/* Who else is editing */}
+              {(peers.length > 0 || !canEdit) && (
+                <div className="absolute top-2 right-4 z-10 flex items-center gap-1.5">
+                  {!canEdit && (
+                    <span className="px-2 py-0.5 rounded text-xs bg-gray-100 text-gray-600 border border-gray-200">Read only</span>
+                  )}
+                  {peers.map((peer) => (
+                    <span
+                      key={peer.clientId}
+                      title={peer.canEdit ? `${peer.name} is editing` : `${peer.name} is viewing`}
+                      className={`px-2 py-0.5 rounded text-xs text-white collab-peer-${peerColor(peer.clientId)}`}
+                    >
+                      {peer.name}
+                    </span>
+                  ))}
+                </div>
+              )}
+
               {/* Generate Prompt Input - Floating */}
               {showGenerateInput && (
                 <div className="absolute inset-0 bg-black/60 backdrop-blur-sm flex items-center justify-center z-10">
@@ -433,3 +659,10 @@ export default function FlowPage() {
     </div>
   );
 }
+
+// peerColor picks one of the collab-* color classes in globals.css for a connection
+const peerColor = (clientId: string) => {
+  let hash = 0;
+  for (let i = 0; i < clientId.length; i++) hash = (hash * 31 + clientId.charCodeAt(i)) | 0;
+  return Math.abs(hash) % 6;
+};
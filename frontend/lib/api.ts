@@ -1,11 +1,14 @@
 import axios, { AxiosError } from "axios";
-import type { Space, Vault, Log, TreeNode, RunResult, GenerateResponse } from "./types";
+import type { Space, Vault, Log, TextEdit, BatchOperation, BatchResult, TreeNode, SpaceEvent, RunResult, GenerateResponse } from "./types";
+import { CollabSession, type CollabHandlers } from "./collab";
 
-// UPDATED: Added ApiError type for structured error handling
+// ApiError mirrors the backend error envelope; see Backend/internal/apierror for the code catalogue
 export type ApiError = {
   status: number;
   code?: string;
   message: string;
+  details?: Record<string, unknown>;
+  requestId?: string;
   provider?: "openai" | "gemini";
 };
 
@@ -26,38 +29,53 @@ const getAdminToken = () => {
 
 const api = axios.create({
   baseURL: getApiUrl(),
+  withCredentials: true, // Send the session cookie
   headers: {
     "Content-Type": "application/json",
   },
 });
 
-// Attach admin token on write operations
+// Attach the legacy admin token when configured; otherwise the session cookie authenticates
 api.interceptors.request.use((config) => {
   const token = getAdminToken();
-  if (token && config.method && ["post", "put", "patch", "delete"].includes(config.method.toLowerCase())) {
+  if (token) {
     config.headers.Authorization = `Bearer ${token}`;
   }
   return config;
 });
 
-// UPDATED: Error interceptor to transform errors into ApiError format
+// Error interceptor: every backend error uses the same envelope, network errors fall back to axios
 api.interceptors.response.use(
   (response) => response,
-  (error: AxiosError<any>) => {
+  (error: AxiosError<Partial<ApiError>>) => {
+    const data = error.response?.data;
     const apiError: ApiError = {
-      status: error.response?.status || 500,
-      code: error.response?.data?.code || error.code,
-      message: error.response?.data?.message || error.message || "An error occurred",
-      provider: error.response?.data?.provider,
+      status: data?.status || error.response?.status || 500,
+      code: data?.code || error.code,
+      message: data?.message || error.message || "An error occurred",
+      details: data?.details,
+      requestId: data?.requestId || error.response?.headers?.["x-request-id"],
+      provider: data?.details?.provider as ApiError["provider"],
     };
     return Promise.reject(apiError);
   }
 );
 
+// List endpoints return pages; the X-Next-Cursor header points at the next one
+const getAllPages = async <T>(url: string, params: Record<string, string | undefined> = {}): Promise<T[]> => {
+  const items: T[] = [];
+  let cursor: string | undefined;
+  do {
+    const response = await api.get<T[]>(url, { params: { ...params, cursor, limit: 500 } });
+    items.push(...response.data);
+    cursor = response.headers["x-next-cursor"] || undefined;
+  } while (cursor);
+  return items;
+};
+
 // Spaces
 export const getSpaces = async (): Promise<Space[]> => {
-  const { data } = await api.get("/api/spaces");
-  return data;
+  return getAllPages<Space>("/api/spaces");
 };
 
 export const getSpace = async (id: string): Promise<Space> => {
@@ -85,10 +103,49 @@ export const getTree = async (spaceId: string): Promise<TreeNode[]> => {
   return data;
 };
 
+// Live changes: opens the space's WebSocket feed and reconnects when it drops.
+// onConnect runs on every (re)connect; reload there, since missed events aren't replayed.
+// The socket authenticates with the session cookie. Returns a function that closes the feed.
+export const subscribeToSpace = (
+  spaceId: string,
+  onEvent: (event: SpaceEvent) => void,
+  onConnect?: () => void
+): (() => void) => {
+  const url = `${getApiUrl().replace(/^http/, "ws")}/api/spaces/${spaceId}/events`;
+  let socket: WebSocket | null = null;
+  let retryDelay = 1000;
+  let retryTimer: ReturnType<typeof setTimeout> | undefined;
+  let closed = false;
+
+  const connect = () => {
+    socket = new WebSocket(url);
+    socket.onopen = () => {
+      retryDelay = 1000;
+      onConnect?.();
+    };
+    socket.onmessage = (message) => onEvent(JSON.parse(message.data) as SpaceEvent);
+    socket.onclose = () => {
+      if (closed) return;
+      retryTimer = setTimeout(connect, retryDelay);
+      retryDelay = Math.min(retryDelay * 2, 30000);
+    };
+  };
+  connect();
+
+  return () => {
+    closed = true;
+    clearTimeout(retryTimer);
+    socket?.close();
+  };
+};
+
+// Collaborative editing: joins the log's shared document, authenticating with the session cookie
+export const openCollabSession = (logId: string, handlers: CollabHandlers): CollabSession =>
+  new CollabSession(`${getApiUrl().replace(/^http/, "ws")}/api/logs/${logId}/collab`, handlers);
+
 // Vaults
 export const getVaults = async (spaceId: string): Promise<Vault[]> => {
-  const { data } = await api.get("/api/vaults", { params: { spaceId } });
-  return data;
+  return getAllPages<Vault>("/api/vaults", { spaceId });
 };
 
 export const createVault = async (spaceId: string, name: string, parentId?: string): Promise<Vault> => {
@@ -107,8 +164,7 @@ export const deleteVault = async (id: string): Promise<void> => {
 
 // Logs
 export const getLogs = async (spaceId?: string, vaultId?: string): Promise<Log[]> => {
-  const { data } = await api.get("/api/logs", { params: { spaceId, vaultId } });
-  return data;
+  return getAllPages<Log>("/api/logs", { spaceId, vaultId });
 };
 
 export const getLog = async (id: string): Promise<Log> => {
@@ -121,15 +177,51 @@ export const createLog = async (spaceId: string, vaultId: string, name: string,
   return data;
 };
 
-export const updateLog = async (id: string, updates: { name?: string; code?: string }): Promise<Log> => {
-  const { data } = await api.put(`/api/logs/${id}`, updates);
+// Pass the version the edit was based on to get a 412 PRECONDITION_FAILED instead of overwriting newer changes
+export const updateLog = async (id: string, updates: { name?: string; code?: string }, version?: number): Promise<Log> => {
+  const headers = version !== undefined ? { "If-Match": `"${version}"` } : undefined;
+  const { data } = await api.put(`/api/logs/${id}`, updates, { headers });
   return data;
 };
 
+// Sends only the changed ranges; edits are relative to `version`, which the server requires
+export const patchLogCode = async (id: string, edits: TextEdit[], version: number): Promise<Log> => {
+  const { data } = await api.patch(`/api/logs/${id}`, { edits }, { headers: { "If-Match": `"${version}"` } });
+  return data;
+};
+
+// diffCode describes the change from one version of a file to another as a single edit
+export const diffCode = (before: string, after: string): TextEdit | null => {
+  if (before === after) return null;
+  let start = 0;
+  while (start < before.length && start < after.length && before[start] === after[start]) start++;
+  let endBefore = before.length;
+  let endAfter = after.length;
+  while (endBefore > start && endAfter > start && before[endBefore - 1] === after[endAfter - 1]) {
+    endBefore--;
+    endAfter--;
+  }
+  // Don't split surrogate pairs: the server rejects edits that cut a character in half
+  const isLowSurrogate = (s: string, i: number) => i < s.length && s.charCodeAt(i) >= 0xdc00 && s.charCodeAt(i) <= 0xdfff;
+  if (start > 0 && isLowSurrogate(before, start)) start--;
+  if (isLowSurrogate(before, endBefore)) {
+    endBefore++;
+    endAfter++;
+  }
+  return { start, end: endBefore, text: after.slice(start, endAfter) };
+};
+
 export const deleteLog = async (id: string): Promise<void> => {
   await api.delete(`/api/logs/${id}`);
 };
 
+// Batch: runs the operations in order as one request. On a replica set the batch is
+// atomic; otherwise operations before a failure stay applied (see error details.applied).
+export const runBatch = async (operations: BatchOperation[]): Promise<BatchResult[]> => {
+  const { data } = await api.post("/api/batch", { operations });
+  return data.results;
+};
+
 // Run
 export const runCode = async (language: string, code: string): Promise<RunResult> => {
   const { data } = await api.post("/api/run", { language, code });
@@ -0,0 +1,570 @@
+// Collaborative editing of a log over /api/logs/:id/collab. TextOperation is a
+// port of the backend's Backend/internal/collab, in the ot.js JSON format;
+// CollabSession keeps the client side of the protocol: at most one operation
+// waiting for the server's ack, later local edits buffered behind it, and
+// incoming operations transformed past both.
+
+// A positive number retains, a negative number deletes and a string inserts.
+// Lengths are UTF-16 code units, the same as JavaScript string indices.
+export type OperationJSON = (number | string)[];
+
+export class TextOperation {
+  ops: OperationJSON = [];
+  baseLength = 0;
+  targetLength = 0;
+
+  retain(n: number): this {
+    if (n <= 0) return this;
+    this.baseLength += n;
+    this.targetLength += n;
+    const last = this.ops[this.ops.length - 1];
+    if (isRetain(last)) {
+      this.ops[this.ops.length - 1] = last + n;
+    } else {
+      this.ops.push(n);
+    }
+    return this;
+  }
+
+  insert(text: string): this {
+    if (text === "") return this;
+    this.targetLength += text.length;
+    const ops = this.ops;
+    const last = ops[ops.length - 1];
+    if (isInsert(last)) {
+      ops[ops.length - 1] = last + text;
+    } else if (isDelete(last)) {
+      // Inserts go before deletes at the same position
+      const beforeLast = ops[ops.length - 2];
+      if (isInsert(beforeLast)) {
+        ops[ops.length - 2] = beforeLast + text;
+      } else {
+        ops[ops.length] = last;
+        ops[ops.length - 2] = text;
+      }
+    } else {
+      ops.push(text);
+    }
+    return this;
+  }
+
+  delete(n: number): this {
+    if (n <= 0) return this;
+    this.baseLength += n;
+    const last = this.ops[this.ops.length - 1];
+    if (isDelete(last)) {
+      this.ops[this.ops.length - 1] = last - n;
+    } else {
+      this.ops.push(-n);
+    }
+    return this;
+  }
+
+  isNoop(): boolean {
+    return this.ops.length === 0 || (this.ops.length === 1 && isRetain(this.ops[0]));
+  }
+
+  apply(doc: string): string {
+    if (doc.length !== this.baseLength) {
+      throw new Error(`Operation applies to a document of length ${this.baseLength}, not ${doc.length}`);
+    }
+    const parts: string[] = [];
+    let index = 0;
+    for (const op of this.ops) {
+      if (isRetain(op)) {
+        parts.push(doc.slice(index, index + op));
+        index += op;
+      } else if (isInsert(op)) {
+        parts.push(op);
+      } else {
+        index -= op;
+      }
+    }
+    return parts.join("");
+  }
+
+  toJSON(): OperationJSON {
+    return this.ops;
+  }
+
+  static fromJSON(ops: OperationJSON): TextOperation {
+    const operation = new TextOperation();
+    for (const op of ops) {
+      if (isRetain(op)) operation.retain(op);
+      else if (isInsert(op)) operation.insert(op);
+      else operation.delete(-op);
+    }
+    return operation;
+  }
+
+  // compose returns one operation with the effect of a followed by b
+  static compose(a: TextOperation, b: TextOperation): TextOperation {
+    if (a.targetLength !== b.baseLength) {
+      throw new Error("Can't compose operations: lengths don't line up");
+    }
+    const out = new TextOperation();
+    const ops1 = a.ops.slice();
+    const ops2 = b.ops.slice();
+    let i1 = 0;
+    let i2 = 0;
+    let op1 = ops1[i1++];
+    let op2 = ops2[i2++];
+
+    while (op1 !== undefined || op2 !== undefined) {
+      if (isDelete(op1)) {
+        out.delete(-op1);
+        op1 = ops1[i1++];
+        continue;
+      }
+      if (isInsert(op2)) {
+        out.insert(op2);
+        op2 = ops2[i2++];
+        continue;
+      }
+      if (op1 === undefined || op2 === undefined) {
+        throw new Error("Can't compose operations: lengths don't line up");
+      }
+
+      if (isRetain(op1) && isRetain(op2)) {
+        const n = Math.min(op1, op2);
+        out.retain(n);
+        op1 = op1 - n || ops1[i1++];
+        op2 = op2 - n || ops2[i2++];
+      } else if (isInsert(op1) && isDelete(op2)) {
+        const n = Math.min(op1.length, -op2);
+        op1 = op1.slice(n) || ops1[i1++];
+        op2 = op2 + n || ops2[i2++];
+      } else if (isInsert(op1) && isRetain(op2)) {
+        const n = Math.min(op1.length, op2);
+        out.insert(op1.slice(0, n));
+        op1 = op1.slice(n) || ops1[i1++];
+        op2 = op2 - n || ops2[i2++];
+      } else if (isRetain(op1) && isDelete(op2)) {
+        const n = Math.min(op1, -op2);
+        out.delete(n);
+        op1 = op1 - n || ops1[i1++];
+        op2 = op2 + n || ops2[i2++];
+      }
+    }
+    return out;
+  }
+
+  // transform takes concurrent operations a and b and returns [a', b'] such
+  // that a then b' equals b then a'. When both insert at one position, a's text comes first.
+  static transform(a: TextOperation, b: TextOperation): [TextOperation, TextOperation] {
+    if (a.baseLength !== b.baseLength) {
+      throw new Error("Can't transform operations on different documents");
+    }
+    const aPrime = new TextOperation();
+    const bPrime = new TextOperation();
+    const ops1 = a.ops.slice();
+    const ops2 = b.ops.slice();
+    let i1 = 0;
+    let i2 = 0;
+    let op1 = ops1[i1++];
+    let op2 = ops2[i2++];
+
+    while (op1 !== undefined || op2 !== undefined) {
+      if (isInsert(op1)) {
+        aPrime.insert(op1);
+        bPrime.retain(op1.length);
+        op1 = ops1[i1++];
+        continue;
+      }
+      if (isInsert(op2)) {
+        aPrime.retain(op2.length);
+        bPrime.insert(op2);
+        op2 = ops2[i2++];
+        continue;
+      }
+      if (op1 === undefined || op2 === undefined) {
+        throw new Error("Can't transform operations: lengths don't line up");
+      }
+
+      if (isRetain(op1) && isRetain(op2)) {
+        const n = Math.min(op1, op2);
+        aPrime.retain(n);
+        bPrime.retain(n);
+        op1 = op1 - n || ops1[i1++];
+        op2 = op2 - n || ops2[i2++];
+      } else if (isDelete(op1) && isDelete(op2)) {
+        const n = Math.min(-op1, -op2);
+        op1 = op1 + n || ops1[i1++];
+        op2 = op2 + n || ops2[i2++];
+      } else if (isDelete(op1) && isRetain(op2)) {
+        const n = Math.min(-op1, op2);
+        aPrime.delete(n);
+        op1 = op1 + n || ops1[i1++];
+        op2 = op2 - n || ops2[i2++];
+      } else if (isRetain(op1) && isDelete(op2)) {
+        const n = Math.min(op1, -op2);
+        bPrime.delete(n);
+        op1 = op1 - n || ops1[i1++];
+        op2 = op2 + n || ops2[i2++];
+      }
+    }
+    return [aPrime, bPrime];
+  }
+
+  // transformIndex moves a position past the operation; text inserted at it pushes it along
+  static transformIndex(operation: TextOperation, index: number): number {
+    let moved = index;
+    let pos = 0;
+    for (const op of operation.ops) {
+      if (pos > index) break;
+      if (isRetain(op)) {
+        pos += op;
+      } else if (isInsert(op)) {
+        moved += op.length;
+      } else {
+        moved -= Math.min(-op, index - pos);
+        pos -= op;
+      }
+    }
+    return moved;
+  }
+
+  // diff is a single-range operation turning before into after
+  static diff(before: string, after: string): TextOperation {
+    let start = 0;
+    while (start < before.length && start < after.length && before[start] === after[start]) start++;
+    let endBefore = before.length;
+    let endAfter = after.length;
+    while (endBefore > start && endAfter > start && before[endBefore - 1] === after[endAfter - 1]) {
+      endBefore--;
+      endAfter--;
+    }
+    // Keep surrogate pairs whole
+    if (isLowSurrogate(before, start)) start--;
+    if (isLowSurrogate(before, endBefore)) {
+      endBefore++;
+      endAfter++;
+    }
+    return new TextOperation()
+      .retain(start)
+      .insert(after.slice(start, endAfter))
+      .delete(endBefore - start)
+      .retain(before.length - endBefore);
+  }
+}
+
+const isRetain = (op: number | string | undefined): op is number => typeof op === "number" && op > 0;
+const isDelete = (op: number | string | undefined): op is number => typeof op === "number" && op < 0;
+const isInsert = (op: number | string | undefined): op is string => typeof op === "string";
+
+const isLowSurrogate = (text: string, offset: number) => {
+  if (offset <= 0 || offset >= text.length) return false;
+  const unit = text.charCodeAt(offset);
+  return unit >= 0xdc00 && unit <= 0xdfff;
+};
+
+// A range replacement in offsets of the document before the edit, as editors report them
+export interface TextChange {
+  rangeOffset: number;
+  rangeLength: number;
+  text: string;
+}
+
+// fromChanges builds the operation for an editor event. The changes in one
+// event don't overlap and all refer to the document before the event.
+export const fromChanges = (changes: readonly TextChange[], lengthAfter: number): TextOperation => {
+  const sorted = [...changes].sort((a, b) => a.rangeOffset - b.rangeOffset);
+  const lengthBefore = lengthAfter - sorted.reduce((sum, change) => sum + change.text.length - change.rangeLength, 0);
+  const op = new TextOperation();
+  let pos = 0;
+  for (const change of sorted) {
+    op.retain(change.rangeOffset - pos).insert(change.text).delete(change.rangeLength);
+    pos = change.rangeOffset + change.rangeLength;
+  }
+  return op.retain(lengthBefore - pos);
+};
+
+// toChanges lists an operation's edits as range replacements for an editor
+export const toChanges = (op: TextOperation): TextChange[] => {
+  const changes: TextChange[] = [];
+  let index = 0;
+  for (const component of op.ops) {
+    if (isRetain(component)) {
+      index += component;
+    } else if (isInsert(component)) {
+      changes.push({ rangeOffset: index, rangeLength: 0, text: component });
+    } else {
+      changes.push({ rangeOffset: index, rangeLength: -component, text: "" });
+      index -= component;
+    }
+  }
+  return changes;
+};
+
+export interface CollabCursor {
+  position: number;
+  selectionEnd: number;
+}
+
+export interface CollabPeer {
+  clientId: string;
+  userId: string;
+  name: string;
+  canEdit: boolean;
+  cursor?: CollabCursor;
+}
+
+interface CollabEvent {
+  type: "init" | "resume" | "ack" | "op" | "cursor" | "join" | "leave" | "saved" | "error";
+  sessionId?: string;
+  revision: number;
+  clientId?: string;
+  opId?: string;
+  op?: OperationJSON;
+  code?: string;
+  version?: number;
+  peers?: CollabPeer[];
+  peer?: CollabPeer;
+  cursor?: CollabCursor;
+  error?: { status: number; code: string; message: string };
+}
+
+export interface CollabHandlers {
+  // The document was (re)loaded; replace the editor's text
+  onReset: (code: string) => void;
+  // Apply another editor's change to the editor's text
+  onRemoteOp: (op: TextOperation) => void;
+  onPeers: (peers: CollabPeer[], self: CollabPeer | undefined) => void;
+  onSaved: (version: number) => void;
+  onError: (message: string, code: string) => void;
+  onStatus: (connected: boolean) => void;
+}
+
+interface PendingOp {
+  op: TextOperation;
+  opId: string;
+  revision: number;
+}
+
+// CollabSession connects to a log's shared document and reconnects when the
+// connection drops. Within the same server session it resumes where it left
+// off; otherwise unacknowledged local edits are merged into the fresh text.
+export class CollabSession {
+  private socket: WebSocket | null = null;
+  private closed = false;
+  private retryDelay = 1000;
+  private retryTimer: ReturnType<typeof setTimeout> | undefined;
+
+  private sessionId = "";
+  private clientId = "";
+  private revision = 0;
+  private serverText: string | null = null; // The document at revision
+  private outstanding: PendingOp | null = null;
+  private buffer: TextOperation | null = null;
+  private peers = new Map<string, CollabPeer>();
+
+  constructor(private url: string, private handlers: CollabHandlers) {
+    this.connect();
+  }
+
+  // applyLocal sends an edit made in the editor
+  applyLocal(op: TextOperation) {
+    if (op.isNoop()) return;
+    if (this.outstanding) {
+      this.buffer = this.buffer ? TextOperation.compose(this.buffer, op) : op;
+    } else {
+      this.sendOp(op);
+    }
+  }
+
+  moveCursor(cursor: CollabCursor) {
+    this.send({ type: "cursor", cursor });
+  }
+
+  save() {
+    this.send({ type: "save" });
+  }
+
+  close() {
+    this.closed = true;
+    clearTimeout(this.retryTimer);
+    this.socket?.close();
+  }
+
+  private connect() {
+    const socket = new WebSocket(this.url);
+    this.socket = socket;
+    socket.onopen = () => {
+      this.retryDelay = 1000;
+      socket.send(JSON.stringify({ type: "join", sessionId: this.sessionId || undefined, revision: this.revision }));
+    };
+    socket.onmessage = (message) => this.receive(JSON.parse(message.data) as CollabEvent);
+    socket.onclose = () => {
+      this.handlers.onStatus(false);
+      if (this.closed) return;
+      this.retryTimer = setTimeout(() => this.connect(), this.retryDelay);
+      this.retryDelay = Math.min(this.retryDelay * 2, 30000);
+    };
+  }
+
+  private receive(event: CollabEvent) {
+    switch (event.type) {
+      case "init":
+        this.init(event);
+        break;
+
+      case "resume":
+        this.clientId = event.clientId || "";
+        this.revision = event.revision;
+        this.setPeers(event.peers || []);
+        this.handlers.onStatus(true);
+        // The server acks it again if it was applied before the connection dropped
+        if (this.outstanding) this.send({ type: "op", ...this.outstanding, op: this.outstanding.op.toJSON() });
+        break;
+
+      case "ack":
+        if (this.outstanding && event.opId === this.outstanding.opId) this.acknowledge(event.revision);
+        break;
+
+      case "op":
+        if (this.outstanding && event.opId === this.outstanding.opId) {
+          // Our own operation, replayed after a reconnect
+          this.acknowledge(event.revision);
+        } else if (event.op) {
+          this.applyServer(TextOperation.fromJSON(event.op), event.revision);
+        }
+        break;
+
+      case "cursor": {
+        const peer = event.clientId ? this.peers.get(event.clientId) : undefined;
+        if (peer) {
+          this.peers.set(peer.clientId, { ...peer, cursor: event.cursor });
+          this.emitPeers();
+        }
+        break;
+      }
+
+      case "join":
+        if (event.peer) {
+          this.peers.set(event.peer.clientId, event.peer);
+          this.emitPeers();
+        }
+        break;
+
+      case "leave":
+        if (event.clientId) {
+          this.peers.delete(event.clientId);
+          this.emitPeers();
+        }
+        break;
+
+      case "saved":
+        if (event.version) this.handlers.onSaved(event.version);
+        break;
+
+      case "error":
+        if (event.error?.code === "PRECONDITION_FAILED" && event.opId) {
+          // Our revision fell out of the server's history; start over and merge
+          this.sessionId = "";
+          this.socket?.close();
+          break;
+        }
+        this.handlers.onError(event.error?.message || "Collaboration error", event.error?.code || "");
+        break;
+    }
+  }
+
+  // init loads the server's text. Local edits the server never acknowledged
+  // are rebased onto it with a three-way merge and sent again.
+  private init(event: CollabEvent) {
+    const code = event.code ?? "";
+    let local: TextOperation | null = null;
+    if (this.serverText !== null && (this.outstanding || this.buffer)) {
+      local = this.outstanding?.op || null;
+      if (this.buffer) local = local ? TextOperation.compose(local, this.buffer) : this.buffer;
+    }
+
+    let text = code;
+    let rebased: TextOperation | null = null;
+    if (local && this.serverText !== null) {
+      const remote = TextOperation.diff(this.serverText, code);
+      [rebased] = TextOperation.transform(local, remote);
+      text = rebased.apply(code);
+    }
+
+    this.sessionId = event.sessionId || "";
+    this.clientId = event.clientId || "";
+    this.revision = event.revision;
+    this.serverText = code;
+    this.outstanding = null;
+    this.buffer = null;
+    this.setPeers(event.peers || []);
+
+    this.handlers.onReset(text);
+    this.handlers.onStatus(true);
+    if (rebased) this.applyLocal(rebased);
+  }
+
+  private acknowledge(revision: number) {
+    if (!this.outstanding || this.serverText === null) return;
+    this.serverText = this.outstanding.op.apply(this.serverText);
+    this.revision = revision;
+    this.outstanding = null;
+    if (this.buffer) {
+      const next = this.buffer;
+      this.buffer = null;
+      this.sendOp(next);
+    }
+  }
+
+  private applyServer(op: TextOperation, revision: number) {
+    if (this.serverText !== null) this.serverText = op.apply(this.serverText);
+    this.revision = revision;
+
+    if (this.outstanding) {
+      const [outstanding, transformed] = TextOperation.transform(this.outstanding.op, op);
+      this.outstanding = { ...this.outstanding, op: outstanding };
+      op = transformed;
+    }
+    if (this.buffer) {
+      const [buffer, transformed] = TextOperation.transform(this.buffer, op);
+      this.buffer = buffer;
+      op = transformed;
+    }
+
+    for (const peer of this.peers.values()) {
+      if (peer.cursor) {
+        peer.cursor = {
+          position: TextOperation.transformIndex(op, peer.cursor.position),
+          selectionEnd: TextOperation.transformIndex(op, peer.cursor.selectionEnd),
+        };
+      }
+    }
+    this.handlers.onRemoteOp(op);
+    this.emitPeers();
+  }
+
+  private sendOp(op: TextOperation) {
+    this.outstanding = { op, opId: newOpId(), revision: this.revision };
+    this.send({ type: "op", ...this.outstanding, op: op.toJSON() });
+  }
+
+  private send(message: Record<string, unknown>) {
+    if (this.socket?.readyState === WebSocket.OPEN) {
+      this.socket.send(JSON.stringify(message));
+    }
+  }
+
+  private setPeers(peers: CollabPeer[]) {
+    this.peers = new Map(peers.map((peer) => [peer.clientId, peer]));
+    this.emitPeers();
+  }
+
+  private emitPeers() {
+    const self = this.peers.get(this.clientId);
+    this.handlers.onPeers(
+      [...this.peers.values()].filter((peer) => peer.clientId !== this.clientId),
+      self
+    );
+  }
+}
+
+const newOpId = () =>
+  typeof crypto !== "undefined" && "randomUUID" in crypto
+    ? crypto.randomUUID()
+    : `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
@@ -2,6 +2,7 @@ export interface Space {
   id: string;
   userId: string;
   name: string;
+  version: number;
   createdAt: string;
   updatedAt: string;
 }
@@ -13,6 +14,7 @@ export interface Vault {
   name: string;
   path: string;
   parentId?: string;
+  version: number;
   createdAt: string;
   updatedAt: string;
 }
@@ -26,10 +28,39 @@ export interface Log {
   path: string;
   language: string;
   code: string;
+  version: number;
   createdAt: string;
   updatedAt: string;
 }
 
+// Replaces code[start:end] with text; offsets are JavaScript string indices
+export interface TextEdit {
+  start: number;
+  end: number;
+  text: string;
+}
+
+// One step of POST /api/batch; ids may be "$N" to refer to the item created by operation N
+export interface BatchOperation {
+  op: "create" | "update" | "move" | "delete";
+  type: "vault" | "log";
+  id?: string;
+  spaceId?: string;
+  vaultId?: string;
+  parentId?: string;
+  name?: string;
+  language?: string;
+  code?: string;
+  ifVersion?: number;
+}
+
+export interface BatchResult {
+  index: number;
+  status: number;
+  id: string;
+  data?: Vault | Log;
+}
+
 export interface TreeNode {
   id: string;
   name: string;
@@ -39,6 +70,39 @@ export interface TreeNode {
   children?: TreeNode[];
 }
 
+// A change pushed over the space's WebSocket feed. Logs arrive without their code;
+// fetch the log when the event's version is newer than the one you have.
+export interface SpaceEvent {
+  type:
+    | "space.updated"
+    | "space.deleted"
+    | "vault.created"
+    | "vault.updated"
+    | "vault.moved"
+    | "vault.deleted"
+    | "log.created"
+    | "log.updated"
+    | "log.moved"
+    | "log.deleted"
+    | "run.completed";
+  spaceId: string;
+  id: string;
+  version?: number;
+  space?: Space;
+  vault?: Vault;
+  log?: Log;
+  run?: RunSummary;
+  at: string;
+}
+
+// A finished run in a space, reported by run.completed events
+export interface RunSummary {
+  logId?: string;
+  userId?: string;
+  language: string;
+  exitCode: number;
+}
+
 export interface RunResult {
   stdout: string;
   stderr: string;